@@ -31,7 +31,6 @@ import (
 	"encoding/binary"
 	"flag"
 	"fmt"
-	"github.com/u-root/u-root/pkg/kexec"
 	"io"
 	"io/ioutil"
 	"log"
@@ -40,6 +39,9 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+
+	"github.com/u-root/u-root/pkg/diskboot"
+	"github.com/u-root/u-root/pkg/kexec"
 )
 
 const (
@@ -276,44 +278,6 @@ func getBootEntries(lines []string) (map[string]*bootEntry, map[string]string, e
 
 }
 
-func copyLocal(path string) (string, error) {
-	var dest string
-	var err error
-	result := strings.Split(path, "/")
-	for _, entry := range result {
-		dest = entry
-	}
-	dest = "/tmp/" + dest
-	srcFile, err := os.Open(path)
-	if err != nil {
-		return dest, err
-	}
-
-	destFile, err := os.Create(dest) // creates if file doesn't exist
-	if err != nil {
-		return dest, err
-	}
-
-	_, err = io.Copy(destFile, srcFile) // check first var for number of bytes copied
-	if err != nil {
-		return dest, err
-	}
-
-	err = destFile.Sync()
-	if err != nil {
-		return dest, err
-	}
-	err = destFile.Close()
-	if err != nil {
-		return dest, err
-	}
-	err = srcFile.Close()
-	if err != nil {
-		return dest, err
-	}
-	return dest, nil
-}
-
 // kexecLoad Loads a new kernel and initrd.
 func kexecLoad(grubConfPath string, grub []string, mountPoint string) error {
 	verbose("kexecEntry: boot from %v", grubConfPath)
@@ -333,60 +297,27 @@ func kexecLoad(grubConfPath string, grub []string, mountPoint string) error {
 	}
 
 	verbose("Boot params: %q", be)
-	localKernelPath, err := copyLocal(filepath.Join(mountPoint, be.kernel))
-	if err != nil {
-		verbose("copyLocal(%v, %v): %v", filepath.Join(mountPoint, be.kernel), err)
-		return err
+	e := &diskboot.Entry{
+		Name:    entry,
+		Type:    diskboot.Elf,
+		Modules: []diskboot.Module{{Path: be.kernel, Params: be.cmdline}},
 	}
-	localInitrdPath, err := copyLocal(filepath.Join(mountPoint, be.initrd))
-	if err != nil {
-		verbose("copyLocal(%v, %v): %v", filepath.Join(mountPoint, be.initrd), err)
-		return err
-	}
-	verbose(localKernelPath)
-
-	// We can kexec the kernel with localKernelPath as kernel entry, kernelParameter as parameter and initrd as initrd !
-	log.Printf("Loading %s for kernel\n", localKernelPath)
-
-	kernelDesc, err := os.OpenFile(localKernelPath, os.O_RDONLY, 0)
-	if err != nil {
-		verbose("%v", err)
-		return err
-	}
-	// defer kernelDesc.Close()
-
-	log.Printf("Loading %s for initramfs", localInitrdPath)
-	ramfs, err := os.OpenFile(localInitrdPath, os.O_RDONLY, 0)
-	if err != nil {
-		verbose("%v", err)
-		return err
+	if be.initrd != "" {
+		e.Modules = append(e.Modules, diskboot.Module{Path: be.initrd})
 	}
-	// defer ramfs.Close()
 
-	// if /tmp/rsdp file exist we must get the value and add it to the be.cmdline parameter
+	// if /tmp/rsdp file exist we must get the value and append it to the command line
 	// this will allow the kernel to properly read the ACPI table
-
-	rsdp, err := ioutil.ReadFile("/tmp/rsdp")
-	if err == nil {
-		be.cmdline = be.cmdline + string(rsdp)
+	var rsdp string
+	if b, err := ioutil.ReadFile("/tmp/rsdp"); err == nil {
+		rsdp = string(b)
 	}
 
-	log.Printf("Kernel cmdline %s", be.cmdline)
-
-	if err := kexec.FileLoad(kernelDesc, ramfs, be.cmdline); err != nil {
-		verbose("%v", err)
-		return err
-	}
-	if err = ramfs.Close(); err != nil {
-		verbose("%v", err)
-		return err
-	}
-	if err = kernelDesc.Close(); err != nil {
+	if err := e.KexecLoad(mountPoint, rsdp, *dryrun, nil, nil); err != nil {
 		verbose("%v", err)
 		return err
 	}
-	return err
-
+	return nil
 }
 
 func main() {
@@ -8,12 +8,18 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
 
+	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/diskboot"
+	"github.com/u-root/u-root/pkg/gpgv"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/termios"
+	"github.com/u-root/u-root/pkg/tpm"
 )
 
 var (
@@ -26,15 +32,102 @@ var (
 	sConfigIndex  = flag.String("c", "", "Config index")
 	sEntryIndex   = flag.String("n", "", "Entry index")
 	appendCmdline = flag.String("append", "", "Additional kernel params")
+	showMenu      = flag.Bool("menu", false, "Always show the boot menu")
+	bootEnvPath   = flag.String("bootenv", "", "GRUB environment block with the next-boot state (default: the grubenv on each device)")
+	setNext       = flag.String("set-next", "", "Make the entry of this index or name boot once in the -bootenv environment, and exit")
+	setTry        = flag.String("set-try", "", "Make the entry of this index or name boot for the next -tries boots in the -bootenv environment, and exit")
+	tries         = flag.Int("tries", 3, "Number of boot attempts for -set-try")
+	commit        = flag.Bool("commit", false, "Mark the boot of the -set-try entry successful, making it the default in the -bootenv environment, and exit")
+	timeout       = flag.Int("timeout", 10, "Seconds before the boot menu boots the default entry, -1 to wait forever")
+	tpmDevice     = flag.String("tpm", tpm.DefaultDevice, "TPM to measure the boot into, empty to not measure")
+	keyringPath   = flag.String("keyring", "/etc/boot2/keyring.pem", "PEM file of public keys trusted for raw signatures of boot files")
+	pgpKeysPath   = flag.String("gpgkeys", "/etc/boot2/keys.gpg", "OpenPGP public keys trusted for signatures of boot files")
+	verifyPolicy  = flag.String("verify", "enforce", "If -keyring or -gpgkeys exists: enforce to only boot entries whose files and config are all signed, without -append or edited command lines, which are not signed; warn to boot unsigned ones too")
 
-	devices []*diskboot.Device
+	devices  []*diskboot.Device
+	verifier *diskboot.Verifier
+
+	// bootEnvs are the boot environments that selected the default entry
+	// of configs. They are saved only when that entry is booted.
+	bootEnvs = make(map[*diskboot.Config]bootEnv)
 )
 
+// bootEnv is a boot environment with the boot attempt of entry recorded in
+// it, but not yet saved.
+type bootEnv struct {
+	env   *diskboot.BootEnv
+	entry string
+}
+
+// loadVerifier returns the verifier of the keys of -keyring and -gpgkeys,
+// or nil if neither exists.
+func loadVerifier() (*diskboot.Verifier, error) {
+	v := &diskboot.Verifier{}
+	switch *verifyPolicy {
+	case "enforce":
+	case "warn":
+		v.Warn = true
+	default:
+		return nil, fmt.Errorf("invalid -verify policy %q", *verifyPolicy)
+	}
+	if _, err := os.Stat(*keyringPath); err == nil {
+		if v.Keyring, err = boot.LoadKeyring(*keyringPath); err != nil {
+			return nil, err
+		}
+	}
+	if f, err := os.Open(*pgpKeysPath); err == nil {
+		v.PGPKeys, err = gpgv.ReadPublicSigningKeys(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", *pgpKeysPath, err)
+		}
+	}
+	if v.Keyring == nil && v.PGPKeys == nil {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// status returns whether the files and config of entry are verified, for
+// listings. It is empty if there is no verifier.
+func status(config *diskboot.Config, entry *diskboot.Entry) string {
+	if verifier == nil {
+		return ""
+	}
+	err := verifier.VerifyEntry(config.MountPath, entry)
+	if err == nil {
+		err = verifier.VerifyConfig(config, entry)
+	}
+	if err != nil {
+		verbose("Entry %q is not verified: %v", entry.Name, err)
+		return "unverified"
+	}
+	return "verified"
+}
+
+// enforcing returns whether only signed entries are booted.
+func enforcing() bool {
+	return verifier != nil && !verifier.Warn
+}
+
+// ambiguousError is returned when the flags do not select a single entry.
+// The boot menu lets the user pick one instead.
+type ambiguousError string
+
+func (e ambiguousError) Error() string {
+	return string(e)
+}
+
 func getDevice() (*diskboot.Device, error) {
 	devices = diskboot.FindDevices(*devGlob)
 	if len(devices) == 0 {
 		return nil, errors.New("No devices found")
 	}
+	for _, device := range devices {
+		if err := applyBootEnv(device); err != nil {
+			log.Printf("Boot environment of %v: %v", device.DevPath, err)
+		}
+	}
 
 	verbose("Got devices: %#v", devices)
 	var err error
@@ -45,7 +138,7 @@ func getDevice() (*diskboot.Device, error) {
 				log.Printf("Device #%v: path: %v type: %v",
 					i, device.DevPath, device.Fstype)
 			}
-			return nil, errors.New("Multiple devices found - must specify a device index")
+			return nil, ambiguousError("Multiple devices found - must specify a device index")
 		}
 		if deviceIndex, err = strconv.Atoi(*sDeviceIndex); err != nil ||
 			deviceIndex < 0 || deviceIndex >= len(devices) {
@@ -55,6 +148,76 @@ func getDevice() (*diskboot.Device, error) {
 	return devices[deviceIndex], nil
 }
 
+// applyBootEnv makes the entry selected by the boot environment the default
+// of the device's configs. The boot attempt is recorded by commitBootEnv
+// once the entry is chosen.
+func applyBootEnv(device *diskboot.Device) error {
+	path := *bootEnvPath
+	if path == "" {
+		if path = diskboot.FindBootEnv(device.MountPath); path == "" {
+			return nil
+		}
+	}
+	env, err := diskboot.LoadBootEnv(path)
+	if err != nil {
+		return err
+	}
+	id := env.Next()
+	if id == "" {
+		return nil
+	}
+	verbose("Boot environment %v selects entry %q", path, id)
+	for _, config := range device.Configs {
+		if i := config.FindEntry(id); i >= 0 {
+			config.DefaultEntry = i
+			bootEnvs[config] = bootEnv{env: env, entry: id}
+		}
+	}
+	return nil
+}
+
+// commitBootEnv saves the boot attempt in the boot environment of config if
+// it selected entry: a one-shot next entry is consumed, and the tries of a
+// tried entry are counted down.
+func commitBootEnv(config *diskboot.Config, entry *diskboot.Entry) error {
+	be, ok := bootEnvs[config]
+	if !ok {
+		return nil
+	}
+	if i := config.FindEntry(be.entry); i < 0 || config.Entries[i].Name != entry.Name {
+		return nil
+	}
+	return be.env.Save()
+}
+
+// editBootEnv changes the -bootenv environment as -set-next, -set-try and
+// -commit ask. It returns false if none of them is set.
+func editBootEnv() (bool, error) {
+	if *setNext == "" && *setTry == "" && !*commit {
+		return false, nil
+	}
+	if *bootEnvPath == "" {
+		return true, errors.New("-set-next, -set-try and -commit need -bootenv")
+	}
+	env, err := diskboot.LoadBootEnv(*bootEnvPath)
+	if err != nil {
+		return true, err
+	}
+	if *commit {
+		env.Commit()
+	}
+	if *setTry != "" {
+		if *tries <= 0 {
+			return true, fmt.Errorf("invalid -tries %d", *tries)
+		}
+		env.SetTry(*setTry, *tries)
+	}
+	if *setNext != "" {
+		env.SetNext(*setNext)
+	}
+	return true, env.Save()
+}
+
 func getConfig(device *diskboot.Device) (*diskboot.Config, error) {
 	configs := device.Configs
 	if len(configs) == 0 {
@@ -69,7 +232,7 @@ func getConfig(device *diskboot.Device) (*diskboot.Config, error) {
 			for i, config := range configs {
 				log.Printf("Config #%v: path: %v", i, config.ConfigPath)
 			}
-			return nil, errors.New("Multiple configs found - must specify a config index")
+			return nil, ambiguousError("Multiple configs found - must specify a config index")
 		}
 		if configIndex, err = strconv.Atoi(*sConfigIndex); err != nil ||
 			configIndex < 0 || configIndex >= len(configs) {
@@ -91,17 +254,62 @@ func getEntry(config *diskboot.Config) (*diskboot.Entry, error) {
 	} else if config.DefaultEntry >= 0 {
 		entryIndex = config.DefaultEntry
 	} else {
-		for i, entry := range config.Entries {
-			log.Printf("Entry #%v: %#v", i, entry)
+		for i := range config.Entries {
+			entry := &config.Entries[i]
+			if st := status(config, entry); st != "" {
+				log.Printf("Entry #%v (%s): %#v", i, st, *entry)
+			} else {
+				log.Printf("Entry #%v: %#v", i, *entry)
+			}
 		}
-		return nil, errors.New("No entry specified")
+		return nil, ambiguousError("No entry specified")
 	}
 	return &config.Entries[entryIndex], nil
 }
 
-func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
+// measurer returns a measurer for the TPM of -tpm, with the boot config
+// already measured. It returns nil if there is no TPM.
+func measurer(config *diskboot.Config) *tpm.Measurer {
+	if *tpmDevice == "" || *dryrun {
+		return nil
+	}
+	t, err := tpm.Open(*tpmDevice)
+	if err != nil {
+		verbose("Not measuring the boot: %v", err)
+		return nil
+	}
+	m := tpm.NewMeasurer(t)
+	// BLS configs are directories; their entries are measured as they
+	// are loaded.
+	if fi, err := os.Stat(config.ConfigPath); err == nil && fi.Mode().IsRegular() {
+		content, err := ioutil.ReadFile(config.ConfigPath)
+		if err == nil {
+			err = boot.MeasureConfig(m, config.ConfigPath, content)
+		}
+		if err != nil {
+			log.Printf("Measuring %v: %v", config.ConfigPath, err)
+		}
+	}
+	return m
+}
+
+func bootEntry(config *diskboot.Config, entry *diskboot.Entry, appendCmdline string) error {
 	verbose("Booting entry: %v", entry)
-	err := entry.KexecLoad(config.MountPath, *appendCmdline, *dryrun)
+	if verifier != nil {
+		// The command line is in the config, not in the files
+		// VerifiedOSImage checks.
+		if err := verifier.VerifyConfig(config, entry); err != nil {
+			if !verifier.Warn {
+				return err
+			}
+			log.Printf("Booting entry %q anyway: %v", entry.Name, err)
+		}
+	}
+	m := measurer(config)
+	if m != nil {
+		defer m.TPM.Close()
+	}
+	err := entry.KexecLoad(config.MountPath, appendCmdline, *dryrun, m, verifier)
 	if err != nil {
 		return fmt.Errorf("wrror doing kexec load: %v", err)
 	}
@@ -110,6 +318,10 @@ func bootEntry(config *diskboot.Config, entry *diskboot.Entry) error {
 		return nil
 	}
 
+	if err := commitBootEnv(config, entry); err != nil {
+		return fmt.Errorf("error saving the boot environment: %v", err)
+	}
+
 	err = kexec.Reboot()
 	if err != nil {
 		return fmt.Errorf("error doing kexec reboot: %v", err)
@@ -125,6 +337,51 @@ func cleanDevices() {
 	}
 }
 
+// selectEntry picks the entry to boot according to the flags.
+func selectEntry() (*diskboot.Config, *diskboot.Entry, error) {
+	device, err := getDevice()
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := getConfig(device)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, err := getEntry(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, entry, nil
+}
+
+// menuEntry lets the user pick the entry to boot on the terminal.
+func menuEntry() (*diskboot.Config, *diskboot.Entry, error) {
+	tty, err := termios.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	restorer, err := tty.Raw()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tty.Set(restorer)
+
+	m := newMenu(devices, *appendCmdline, *timeout, status, tty, tty)
+	m.noEdit = enforcing()
+	item, err := m.run()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The edited command line replaces the entry's kernel parameters,
+	// including anything added with -append.
+	entry := *item.entry
+	entry.Modules = append([]diskboot.Module(nil), entry.Modules...)
+	if len(entry.Modules) > 0 {
+		entry.Modules[0].Params = item.cmdline
+	}
+	return item.config, &entry, nil
+}
+
 func main() {
 	flag.Parse()
 	if *v {
@@ -132,19 +389,43 @@ func main() {
 	}
 	defer cleanDevices()
 
-	device, err := getDevice()
-	if err != nil {
-		log.Panic(err)
+	if ok, err := editBootEnv(); ok {
+		if err != nil {
+			log.Panic(err)
+		}
+		return
 	}
-	config, err := getConfig(device)
-	if err != nil {
+
+	var err error
+	if verifier, err = loadVerifier(); err != nil {
 		log.Panic(err)
 	}
-	entry, err := getEntry(config)
+	if verifier != nil && verifier.Warn {
+		log.Printf("Booting unsigned files with a warning")
+	}
+	if enforcing() && *appendCmdline != "" {
+		log.Panicf("-append with -verify=enforce: %v", diskboot.ErrUnsignedCmdline)
+	}
+
+	config, entry, err := selectEntry()
+	if _, ok := err.(ambiguousError); ok || (*showMenu && len(devices) > 0) {
+		var merr error
+		if config, entry, merr = menuEntry(); merr != nil {
+			if err == nil {
+				err = merr
+			} else {
+				err = fmt.Errorf("%v; boot menu: %v", err, merr)
+			}
+		} else {
+			err = nil
+			// The menu already applied -append.
+			*appendCmdline = ""
+		}
+	}
 	if err != nil {
 		log.Panic(err)
 	}
-	if err := bootEntry(config, entry); err != nil {
+	if err := bootEntry(config, entry, *appendCmdline); err != nil {
 		log.Panic(err)
 	}
 }
@@ -0,0 +1,110 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/diskboot"
+)
+
+func TestBootEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boot2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grubenv")
+	*bootEnvPath = path
+	defer func() { *bootEnvPath = "" }()
+
+	env, err := diskboot.LoadBootEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetNext("C")
+	if err := env.Save(); err != nil {
+		t.Fatal(err)
+	}
+	next := func() string {
+		env, err := diskboot.LoadBootEnv(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env.Vars[diskboot.NextEntryVar]
+	}
+
+	devs := testDevices()
+	for _, d := range devs {
+		if err := applyBootEnv(d); err != nil {
+			t.Fatalf("applyBootEnv(%v) = %v", d.DevPath, err)
+		}
+	}
+	config := devs[1].Configs[0]
+	if config.DefaultEntry != 1 {
+		t.Errorf("default entry is %d, want 1", config.DefaultEntry)
+	}
+	if got := next(); got != "C" {
+		t.Fatalf("applyBootEnv() changed next_entry to %q before an entry was booted", got)
+	}
+
+	// Another entry is picked in the menu.
+	other := config.Entries[0]
+	if err := commitBootEnv(config, &other); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != "C" {
+		t.Errorf("booting another entry changed next_entry to %q", got)
+	}
+
+	entry := config.Entries[1]
+	if err := commitBootEnv(config, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != "" {
+		t.Errorf("next_entry is %q after booting it, want it consumed", got)
+	}
+}
+
+func TestEditBootEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boot2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grubenv")
+	*bootEnvPath = path
+	defer func() { *bootEnvPath, *setTry, *commit = "", "", false }()
+
+	if ok, err := editBootEnv(); ok || err != nil {
+		t.Fatalf("editBootEnv() without flags = %v, %v, want false, nil", ok, err)
+	}
+
+	*setTry = "new"
+	if ok, err := editBootEnv(); !ok || err != nil {
+		t.Fatalf("editBootEnv() with -set-try = %v, %v", ok, err)
+	}
+	env, err := diskboot.LoadBootEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Vars[diskboot.TryEntryVar] != "new" || env.Vars[diskboot.TryCountVar] != "3" {
+		t.Errorf("-set-try gave %v, want 3 tries of new", env.Vars)
+	}
+
+	*setTry, *commit = "", true
+	if ok, err := editBootEnv(); !ok || err != nil {
+		t.Fatalf("editBootEnv() with -commit = %v, %v", ok, err)
+	}
+	if env, err = diskboot.LoadBootEnv(path); err != nil {
+		t.Fatal(err)
+	}
+	if env.Vars[diskboot.SavedEntryVar] != "new" || env.Vars[diskboot.TryEntryVar] != "" {
+		t.Errorf("-commit gave %v, want new saved", env.Vars)
+	}
+}
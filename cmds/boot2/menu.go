@@ -0,0 +1,369 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/diskboot"
+)
+
+// escTimeout is how long to wait for the rest of an escape sequence before
+// treating ESC as a key by itself.
+const escTimeout = 50 * time.Millisecond
+
+// errMenuQuit is returned when the user leaves the menu without booting.
+var errMenuQuit = errors.New("boot menu closed without booting")
+
+// key is a key press: a byte, or one of the special keys below.
+type key int
+
+const (
+	keyCtrlC     key = 0x03
+	keyBackspace key = 0x08
+	keyCtrlU     key = 0x15
+	keyEsc       key = 0x1b
+	keyDelete    key = 0x7f
+
+	keyUp key = 0x100 + iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+
+	// keyDeleteChar is the Delete key, ESC [ 3 ~. keyDelete is the byte
+	// most terminals send for Backspace.
+	keyDeleteChar
+)
+
+// menuItem is a bootable entry of the menu.
+type menuItem struct {
+	config *diskboot.Config
+	entry  *diskboot.Entry
+	label  string
+
+	// cmdline is the kernel command line to boot with, which the user
+	// can edit.
+	cmdline string
+}
+
+// menu is an interactive text menu of boot entries.
+type menu struct {
+	items []*menuItem
+	def   int
+	sel   int
+
+	// timeout is the number of seconds left before booting the default
+	// entry. A negative value waits forever.
+	timeout int
+
+	// noEdit disables editing command lines, which are not signed.
+	noEdit bool
+
+	keys <-chan byte
+	out  io.Writer
+
+	// unread holds a byte read after a lone ESC, which is not part of an
+	// escape sequence.
+	unread []byte
+}
+
+// newMenu returns a menu of all entries of all configs on devices. The
+// default entry of the first config that has one is selected. appendCmdline
+// is appended to every command line. If status is not nil, the label of
+// every entry shows its status.
+func newMenu(devices []*diskboot.Device, appendCmdline string, timeout int, status func(*diskboot.Config, *diskboot.Entry) string, in io.Reader, out io.Writer) *menu {
+	m := &menu{
+		def:     -1,
+		timeout: timeout,
+		keys:    readBytes(in),
+		out:     out,
+	}
+	for _, device := range devices {
+		for _, config := range device.Configs {
+			configPath, err := filepath.Rel(config.MountPath, config.ConfigPath)
+			if err != nil {
+				configPath = config.ConfigPath
+			}
+			for i := range config.Entries {
+				entry := &config.Entries[i]
+				if m.def < 0 && i == config.DefaultEntry {
+					m.def = len(m.items)
+				}
+				var cmdline string
+				if len(entry.Modules) > 0 {
+					cmdline = entry.Modules[0].Params
+				}
+				if appendCmdline != "" {
+					cmdline = strings.TrimSpace(cmdline + " " + appendCmdline)
+				}
+				label := fmt.Sprintf("%s %s: %s", device.DevPath, configPath, entry.Name)
+				if status != nil {
+					if st := status(config, entry); st != "" {
+						label += " [" + st + "]"
+					}
+				}
+				m.items = append(m.items, &menuItem{
+					config:  config,
+					entry:   entry,
+					label:   label,
+					cmdline: cmdline,
+				})
+			}
+		}
+	}
+	if m.def < 0 {
+		m.def = 0
+	}
+	m.sel = m.def
+	return m
+}
+
+// readBytes sends the bytes read from r to the returned channel, which is
+// closed when r returns an error.
+func readBytes(r io.Reader) <-chan byte {
+	c := make(chan byte)
+	go func() {
+		defer close(c)
+		b := make([]byte, 1)
+		for {
+			if _, err := r.Read(b); err != nil {
+				return
+			}
+			c <- b[0]
+		}
+	}()
+	return c
+}
+
+// nextByte returns the next input byte, or false if there is none within
+// escTimeout.
+func (m *menu) nextByte() (byte, bool) {
+	select {
+	case b, ok := <-m.keys:
+		return b, ok
+	case <-time.After(escTimeout):
+		return 0, false
+	}
+}
+
+// decodeKey turns the input starting with b into a key, reading the rest of
+// an ANSI escape sequence if there is one.
+func (m *menu) decodeKey(b byte) key {
+	if b != byte(keyEsc) {
+		return key(b)
+	}
+	b, ok := m.nextByte()
+	if !ok {
+		return keyEsc
+	}
+	if b != '[' && b != 'O' {
+		m.unread = append(m.unread, b)
+		return keyEsc
+	}
+	b, ok = m.nextByte()
+	if !ok {
+		return keyEsc
+	}
+	switch b {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '1', '3', '4', '7', '8':
+		// VT220 style: ESC [ <n> ~
+		if t, ok := m.nextByte(); ok && t == '~' {
+			switch b {
+			case '1', '7':
+				return keyHome
+			case '3':
+				return keyDeleteChar
+			case '4', '8':
+				return keyEnd
+			}
+		}
+	}
+	return keyEsc
+}
+
+// readByte waits for the next input byte. It returns false if the input is
+// closed.
+func (m *menu) readByte() (byte, bool) {
+	if len(m.unread) > 0 {
+		b := m.unread[0]
+		m.unread = m.unread[1:]
+		return b, true
+	}
+	b, ok := <-m.keys
+	return b, ok
+}
+
+// readKey waits for the next key. It returns false if the input is closed.
+func (m *menu) readKey() (key, bool) {
+	b, ok := m.readByte()
+	if !ok {
+		return 0, false
+	}
+	return m.decodeKey(b), true
+}
+
+// printf writes to the terminal, which is in raw mode, so "\n" must be
+// written as "\r\n".
+func (m *menu) printf(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	fmt.Fprint(m.out, strings.Replace(s, "\n", "\r\n", -1))
+}
+
+func (m *menu) draw() {
+	m.printf("\033[1;1H\033[2J")
+	m.printf("Boot menu\n\n")
+	for i, item := range m.items {
+		mark := " "
+		if i == m.def {
+			mark = "*"
+		}
+		if i == m.sel {
+			m.printf("\033[7m%s %2d. %s\033[0m\n", mark, i, item.label)
+		} else {
+			m.printf("%s %2d. %s\n", mark, i, item.label)
+		}
+	}
+	if m.noEdit {
+		m.printf("\nUp/Down to select, Enter to boot, 'q' to quit.\n")
+	} else {
+		m.printf("\nUp/Down to select, Enter to boot, 'e' to edit the command line, 'q' to quit.\n")
+	}
+	if m.timeout >= 0 {
+		m.printf("Booting the default entry in %d seconds.\n", m.timeout)
+	}
+}
+
+// run shows the menu until the user picks an entry or the timeout expires,
+// and returns the entry to boot.
+func (m *menu) run() (*menuItem, error) {
+	if len(m.items) == 0 {
+		return nil, errors.New("no entries to boot")
+	}
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		m.draw()
+		if m.timeout == 0 {
+			return m.items[m.def], nil
+		}
+
+		var b byte
+		var ok bool
+		// Bytes left over from decoding a key come before new input.
+		if m.timeout > 0 && len(m.unread) == 0 {
+			select {
+			case <-tick.C:
+				m.timeout--
+				continue
+			case b, ok = <-m.keys:
+			}
+		} else {
+			b, ok = m.readByte()
+		}
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		// Any key stops the countdown.
+		m.timeout = -1
+
+		switch m.decodeKey(b) {
+		case keyUp, 'k':
+			if m.sel > 0 {
+				m.sel--
+			}
+		case keyDown, 'j':
+			if m.sel < len(m.items)-1 {
+				m.sel++
+			}
+		case keyHome:
+			m.sel = 0
+		case keyEnd:
+			m.sel = len(m.items) - 1
+		case '\r', '\n':
+			return m.items[m.sel], nil
+		case 'e':
+			if m.noEdit {
+				break
+			}
+			item := m.items[m.sel]
+			if cmdline, ok := m.edit(item.cmdline); ok {
+				item.cmdline = cmdline
+			}
+		case 'q', keyCtrlC:
+			return nil, errMenuQuit
+		}
+	}
+}
+
+// edit lets the user edit line. It returns false if the edit is cancelled
+// with ESC.
+func (m *menu) edit(line string) (string, bool) {
+	const prompt = "cmdline: "
+	buf := []rune(line)
+	pos := len(buf)
+	for {
+		m.printf("\r\033[K%s%s\r\033[%dC", prompt, string(buf), len(prompt)+pos)
+
+		k, ok := m.readKey()
+		if !ok {
+			return "", false
+		}
+		switch k {
+		case '\r', '\n':
+			return string(buf), true
+		case keyEsc, keyCtrlC:
+			return "", false
+		case keyLeft:
+			if pos > 0 {
+				pos--
+			}
+		case keyRight:
+			if pos < len(buf) {
+				pos++
+			}
+		case keyHome:
+			pos = 0
+		case keyEnd:
+			pos = len(buf)
+		case keyBackspace, keyDelete:
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case keyDeleteChar:
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case keyCtrlU:
+			buf = buf[pos:]
+			pos = 0
+		default:
+			if k >= ' ' && k < 0x7f {
+				buf = append(buf[:pos], append([]rune{rune(k)}, buf[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
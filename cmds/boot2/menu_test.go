@@ -0,0 +1,198 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/diskboot"
+)
+
+func testDevices() []*diskboot.Device {
+	return []*diskboot.Device{
+		{
+			DevPath: "/dev/sda1",
+			Configs: []*diskboot.Config{
+				{
+					MountPath:    "/mnt/sda1",
+					ConfigPath:   "/mnt/sda1/grub/grub.cfg",
+					DefaultEntry: -1,
+					Entries: []diskboot.Entry{
+						{Name: "A", Modules: []diskboot.Module{{Path: "/a", Params: "ro"}}},
+					},
+				},
+			},
+		},
+		{
+			DevPath: "/dev/sdb1",
+			Configs: []*diskboot.Config{
+				{
+					MountPath:    "/mnt/sdb1",
+					ConfigPath:   "/mnt/sdb1/syslinux.cfg",
+					DefaultEntry: 1,
+					Entries: []diskboot.Entry{
+						{Name: "B", Modules: []diskboot.Module{{Path: "/b"}}},
+						{Name: "C", Modules: []diskboot.Module{{Path: "/c", Params: "quiet"}}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMenu(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		input       string
+		timeout     int
+		wantEntry   string
+		wantCmdline string
+		wantErr     error
+	}{
+		{
+			name:        "enter boots the default",
+			input:       "\r",
+			timeout:     -1,
+			wantEntry:   "C",
+			wantCmdline: "quiet extra",
+		},
+		{
+			name:        "zero timeout boots the default",
+			timeout:     0,
+			wantEntry:   "C",
+			wantCmdline: "quiet extra",
+		},
+		{
+			name:        "arrow keys",
+			input:       "\033[A\033[A\033[A\033[B\r",
+			timeout:     5,
+			wantEntry:   "B",
+			wantCmdline: "extra",
+		},
+		{
+			name:        "vi keys",
+			input:       "kk\r",
+			timeout:     -1,
+			wantEntry:   "A",
+			wantCmdline: "ro extra",
+		},
+		{
+			name:        "edit command line",
+			input:       "e\033[D\033[D\033[D\033[D\033[D\x7fX\033[Fy\r\r",
+			timeout:     -1,
+			wantEntry:   "C",
+			wantCmdline: "quietXextray",
+		},
+		{
+			name:        "delete key",
+			input:       "e\033[H\033[3~\033[3~\r\r",
+			timeout:     -1,
+			wantEntry:   "C",
+			wantCmdline: "iet extra",
+		},
+		{
+			name:        "lone escape stops the countdown",
+			input:       "\033k\r",
+			timeout:     5,
+			wantEntry:   "B",
+			wantCmdline: "extra",
+		},
+		{
+			name:        "cancelled edit",
+			input:       "e\x15abc\033\r",
+			timeout:     -1,
+			wantEntry:   "C",
+			wantCmdline: "quiet extra",
+		},
+		{
+			name:    "quit",
+			input:   "jq",
+			timeout: -1,
+			wantErr: errMenuQuit,
+		},
+		{
+			name:    "closed input",
+			input:   "j",
+			timeout: -1,
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMenu(testDevices(), "extra", tt.timeout, nil, strings.NewReader(tt.input), ioutil.Discard)
+			item, err := m.run()
+			if err != tt.wantErr {
+				t.Fatalf("run() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if item.entry.Name != tt.wantEntry {
+				t.Errorf("run() chose %q, want %q", item.entry.Name, tt.wantEntry)
+			}
+			if item.cmdline != tt.wantCmdline {
+				t.Errorf("cmdline = %q, want %q", item.cmdline, tt.wantCmdline)
+			}
+		})
+	}
+}
+
+func TestMenuNoEdit(t *testing.T) {
+	m := newMenu(testDevices(), "", -1, nil, strings.NewReader("e\x15abc\r\r"), ioutil.Discard)
+	m.noEdit = true
+	item, err := m.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.cmdline != "quiet" {
+		t.Errorf("command line is %q with editing disabled, want quiet", item.cmdline)
+	}
+}
+
+func TestMenuTimeout(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	m := newMenu(testDevices(), "", 1, nil, r, ioutil.Discard)
+	item, err := m.run()
+	if err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	if item.entry.Name != "C" {
+		t.Errorf("run() chose %q after the timeout, want the default %q", item.entry.Name, "C")
+	}
+}
+
+func TestMenuLabels(t *testing.T) {
+	m := newMenu(testDevices(), "", -1, nil, strings.NewReader(""), ioutil.Discard)
+	var labels []string
+	for _, item := range m.items {
+		labels = append(labels, item.label)
+	}
+	want := "/dev/sda1 grub/grub.cfg: A|/dev/sdb1 syslinux.cfg: B|/dev/sdb1 syslinux.cfg: C"
+	if got := strings.Join(labels, "|"); got != want {
+		t.Errorf("labels = %q, want %q", got, want)
+	}
+}
+
+func TestMenuStatus(t *testing.T) {
+	status := func(_ *diskboot.Config, e *diskboot.Entry) string {
+		if e.Name == "B" {
+			return "verified"
+		}
+		return "unverified"
+	}
+	m := newMenu(testDevices(), "", -1, status, strings.NewReader(""), ioutil.Discard)
+	var labels []string
+	for _, item := range m.items {
+		labels = append(labels, item.label)
+	}
+	want := "/dev/sda1 grub/grub.cfg: A [unverified]|/dev/sdb1 syslinux.cfg: B [verified]|/dev/sdb1 syslinux.cfg: C [unverified]"
+	if got := strings.Join(labels, "|"); got != want {
+		t.Errorf("labels = %q, want %q", got, want)
+	}
+}
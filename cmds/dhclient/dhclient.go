@@ -10,19 +10,27 @@
 // Options:
 //     -timeout:  lease timeout in seconds
 //     -renewals: number of DHCP renewals before exiting
+//     -leases:   directory to persist DHCPv4 leases in
 //     -verbose:  verbose output
+//     -first:    configure only the first interface that gets a lease
+//     -boot:     with -first, only accept leases that carry a boot file
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/u-root/dhcp4"
 	"github.com/u-root/dhcp4/dhcp4client"
 	"github.com/u-root/u-root/pkg/dhclient"
 	"github.com/u-root/u-root/pkg/dhcp6client"
@@ -36,16 +44,21 @@ const (
 )
 
 var (
-	ifName         = "^e.*"
-	leasetimeout   = flag.Int("timeout", 15, "Lease timeout in seconds")
-	retry          = flag.Int("retry", 5, "Max number of attempts for DHCP clients to send requests. -1 means infinity")
-	renewals       = flag.Int("renewals", 0, "Number of DHCP renewals before exiting. -1 means infinity")
-	renewalTimeout = flag.Int("renewal timeout", 3600, "How long to wait before renewing in seconds")
-	verbose        = flag.Bool("verbose", false, "Verbose output")
-	ipv4           = flag.Bool("ipv4", true, "use IPV4")
-	ipv6           = flag.Bool("ipv6", true, "use IPV6")
-	test           = flag.Bool("test", false, "Test mode")
-	debug          = func(string, ...interface{}) {}
+	ifName           = "^e.*"
+	leasetimeout     = flag.Int("timeout", 15, "Lease timeout in seconds")
+	retry            = flag.Int("retry", 5, "Max number of attempts for DHCP clients to send requests. -1 means infinity")
+	renewals         = flag.Int("renewals", 0, "Number of DHCP renewals before exiting. -1 means infinity")
+	renewalTimeout   = flag.Int("renewal timeout", 0, "How long to wait before renewing in seconds (default: the T1 the server sends)")
+	defaultLeaseTime = flag.Int("default-lease-time", 3600, "Lease time to assume in seconds if the DHCPv4 server sends none")
+	leaseDir         = flag.String("leases", "/var/lib/dhclient", "Directory to persist DHCPv4 leases in, to ask for the same address on the next run; empty to disable")
+	verbose          = flag.Bool("verbose", false, "Verbose output")
+	ipv4             = flag.Bool("ipv4", true, "use IPV4")
+	ipv6             = flag.Bool("ipv6", true, "use IPV6")
+	prefixDelegation = flag.Bool("pd", false, "Request a delegated IPv6 prefix")
+	test             = flag.Bool("test", false, "Test mode")
+	first            = flag.Bool("first", false, "Request leases on all interfaces in parallel and configure only the first interface that gets one")
+	needBoot         = flag.Bool("boot", false, "With -first, only accept leases that carry a boot file")
+	debug            = func(string, ...interface{}) {}
 )
 
 func ifup(ifname string) (netlink.Link, error) {
@@ -74,55 +87,64 @@ func ifup(ifname string) (netlink.Link, error) {
 	return nil, fmt.Errorf("Link %v still down after %d seconds", ifname, linkUpAttempt)
 }
 
-func dhclient4(iface netlink.Link, timeout time.Duration, retry int, numRenewals int) error {
+func dhclient4(ctx context.Context, iface netlink.Link, timeout time.Duration, retry int, numRenewals int) error {
 	client, err := dhcp4client.New(iface,
 		dhcp4client.WithTimeout(timeout),
 		dhcp4client.WithRetry(retry))
 	if err != nil {
 		return err
 	}
+	defer client.Close()
 
-	for i := 0; numRenewals < 0 || i <= numRenewals; i++ {
-		var packet *dhcp4.Packet
-		var err error
-		if i == 0 {
-			packet, err = client.Request()
-		} else {
-			time.Sleep(time.Duration(*renewalTimeout) * time.Second)
-			packet, err = client.Renew(packet)
-		}
-		if err != nil {
-			return err
-		}
-
-		if err := dhclient.Configure4(iface, packet); err != nil {
-			return err
-		}
+	c := dhclient.NewClient4(iface, client)
+	c.Renewals = numRenewals
+	c.RenewAfter = time.Duration(*renewalTimeout) * time.Second
+	c.DefaultLeaseTime = time.Duration(*defaultLeaseTime) * time.Second
+	if *leaseDir != "" {
+		c.LeaseFile = filepath.Join(*leaseDir, iface.Attrs().Name+".lease")
 	}
-	return nil
+	return c.Run(ctx)
 }
 
-func dhclient6(iface netlink.Link, timeout time.Duration, retry int, numRenewals int) error {
+func dhclient6(ctx context.Context, iface netlink.Link, timeout time.Duration, retry int, numRenewals int) error {
 	client, err := dhcp6client.New(iface,
 		dhcp6client.WithTimeout(timeout),
 		dhcp6client.WithRetry(retry))
 	if err != nil {
 		return err
 	}
+	defer client.Close()
 
-	for i := 0; numRenewals < 0 || i <= numRenewals; i++ {
-		if i != 0 {
-			time.Sleep(time.Duration(*renewalTimeout) * time.Second)
-		}
-		iana, packet, err := client.RapidSolicit()
-		if err != nil {
-			return err
+	c := dhclient.NewClient6(iface, client)
+	c.Renewals = numRenewals
+	c.RenewAfter = time.Duration(*renewalTimeout) * time.Second
+	c.PD = *prefixDelegation
+	c.Delegated = func(prefixes []*net.IPNet) {
+		for _, p := range prefixes {
+			fmt.Printf("%s: delegated prefix %v\n", iface.Attrs().Name, p)
 		}
+	}
+	return c.Run(ctx)
+}
 
-		if err := dhclient.Configure6(iface, packet, iana); err != nil {
-			return err
-		}
+// firstLease requests leases on all links in parallel and configures the
+// first link to get a usable one.
+func firstLease(links []netlink.Link, timeout time.Duration) error {
+	lease, err := dhclient.RequestAny(context.Background(), links, dhclient.Config{
+		Timeout:  timeout,
+		Retries:  *retry,
+		IPv4:     *ipv4,
+		IPv6:     *ipv6,
+		NeedBoot: *needBoot,
+	})
+	if err != nil {
+		return err
+	}
+	debug("Got %v", lease)
+	if err := lease.Configure(); err != nil {
+		return fmt.Errorf("%v: %v", lease, err)
 	}
+	fmt.Printf("Configured %v\n", lease)
 	return nil
 }
 
@@ -169,6 +191,31 @@ func main() {
 		log.Printf("increased lease timeout to %s", timeout)
 	}
 
+	if *first {
+		var links []netlink.Link
+		for _, i := range ifnames {
+			if ifRE.MatchString(i.Attrs().Name) {
+				links = append(links, i)
+			}
+		}
+		if len(links) == 0 {
+			log.Fatalf("No interfaces match %v\n", ifName)
+		}
+		if err := firstLease(links, timeout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// On SIGTERM, leases are released before exiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
 	var wg sync.WaitGroup
 	done := make(chan error)
 	for _, i := range ifnames {
@@ -187,14 +234,14 @@ func main() {
 				wg.Add(1)
 				go func() {
 					defer wg.Done()
-					done <- dhclient4(iface, timeout, *retry, *renewals)
+					done <- dhclient4(ctx, iface, timeout, *retry, *renewals)
 				}()
 			}
 			if *ipv6 {
 				wg.Add(1)
 				go func() {
 					defer wg.Done()
-					done <- dhclient6(iface, timeout, *retry, *renewals)
+					done <- dhclient6(ctx, iface, timeout, *retry, *renewals)
 				}()
 			}
 			debug("Done dhclient for %v", ifname)
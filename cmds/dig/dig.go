@@ -0,0 +1,197 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dig looks up DNS records.
+//
+// Synopsis:
+//
+//	dig [OPTIONS...] [@SERVER] NAME [TYPE]
+//	dig [OPTIONS...] [@SERVER] -x ADDRESS
+//
+// Description:
+//
+//	dig sends a query for the TYPE records of NAME, A by default, to
+//	SERVER, the first nameserver of /etc/resolv.conf by default, and
+//	prints the response and how long it took. TYPE is one of A, AAAA,
+//	CNAME, MX, NS, PTR, SOA, SRV, TXT, ANY or TYPEnnn.
+//
+// Options:
+//
+//	-t:       record type
+//	-x:       look up the PTR record of ADDRESS
+//	-p:       server port (default 53)
+//	-tcp:     query over TCP instead of UDP
+//	-timeout: timeout of each attempt (default 5s)
+//	-retries: number of retries over UDP (default 2)
+//	-short:   print only the data of the answers
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dns"
+)
+
+var (
+	typ     = flag.String("t", "", "record type")
+	reverse = flag.String("x", "", "look up the PTR record of this address")
+	port    = flag.Int("p", 53, "server port")
+	tcp     = flag.Bool("tcp", false, "query over TCP instead of UDP")
+	timeout = flag.Duration("timeout", dns.DefaultTimeout, "timeout of each attempt")
+	retries = flag.Int("retries", 2, "number of retries over UDP")
+	short   = flag.Bool("short", false, "print only the data of the answers")
+)
+
+const resolvConf = "/etc/resolv.conf"
+
+// parseArgs parses the arguments that are not flags. Like with dig, a type
+// may come before or after the name.
+func parseArgs(args []string) (server, name string, t dns.Type, err error) {
+	t = dns.TypeA
+	var typeSet bool
+	if *typ != "" {
+		if t, err = dns.ParseType(*typ); err != nil {
+			return "", "", 0, err
+		}
+		typeSet = true
+	}
+	if *reverse != "" {
+		ip := net.ParseIP(*reverse)
+		if ip == nil {
+			return "", "", 0, fmt.Errorf("invalid address %q", *reverse)
+		}
+		if name, err = dns.ReverseName(ip); err != nil {
+			return "", "", 0, err
+		}
+		t, typeSet = dns.TypePTR, true
+	}
+
+	for _, a := range args {
+		switch tt, err := dns.ParseType(a); {
+		case strings.HasPrefix(a, "@"):
+			server = a[1:]
+		case err == nil && !typeSet:
+			t, typeSet = tt, true
+		case name == "":
+			name = a
+		default:
+			return "", "", 0, fmt.Errorf("unexpected argument %q", a)
+		}
+	}
+	if name == "" {
+		return "", "", 0, fmt.Errorf("no name given")
+	}
+	return server, name, t, nil
+}
+
+// defaultServer returns the first nameserver of resolv.conf.
+func defaultServer() string {
+	if rc, err := dns.ReadResolvConf(resolvConf); err == nil && len(rc.Nameservers) > 0 {
+		return rc.Nameservers[0]
+	}
+	return "127.0.0.1"
+}
+
+func flags(h dns.Header) string {
+	var f []string
+	for _, b := range []struct {
+		set  bool
+		name string
+	}{
+		{h.Response, "qr"},
+		{h.Authoritative, "aa"},
+		{h.Truncated, "tc"},
+		{h.RecursionDesired, "rd"},
+		{h.RecursionAvailable, "ra"},
+	} {
+		if b.set {
+			f = append(f, b.name)
+		}
+	}
+	return strings.Join(f, " ")
+}
+
+// printMessage prints m like dig.
+func printMessage(w io.Writer, m *dns.Message) {
+	opcode := strconv.Itoa(int(m.Opcode))
+	if m.Opcode == 0 {
+		opcode = "QUERY"
+	}
+	fmt.Fprintf(w, ";; ->>HEADER<<- opcode: %s, status: %v, id: %d\n", opcode, m.RCode, m.ID)
+	fmt.Fprintf(w, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		flags(m.Header), len(m.Questions), len(m.Answers), len(m.Authorities), len(m.Additionals))
+
+	fmt.Fprintf(w, "\n;; QUESTION SECTION:\n")
+	for _, q := range m.Questions {
+		fmt.Fprintf(w, ";%v\n", q)
+	}
+	for _, s := range []struct {
+		name string
+		rs   []dns.Resource
+	}{
+		{"ANSWER", m.Answers},
+		{"AUTHORITY", m.Authorities},
+		{"ADDITIONAL", m.Additionals},
+	} {
+		if len(s.rs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n;; %s SECTION:\n", s.name)
+		for i := range s.rs {
+			fmt.Fprintln(w, &s.rs[i])
+		}
+	}
+}
+
+func main() {
+	// Flags may come after the name, as with dig.
+	var args []string
+	for rest := os.Args[1:]; ; {
+		flag.CommandLine.Parse(rest)
+		if rest = flag.Args(); len(rest) == 0 {
+			break
+		}
+		args, rest = append(args, rest[0]), rest[1:]
+	}
+
+	server, name, t, err := parseArgs(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if server == "" {
+		server = defaultServer()
+	}
+	server = net.JoinHostPort(strings.Trim(server, "[]"), strconv.Itoa(*port))
+
+	c := &dns.Client{Net: "udp", Timeout: *timeout, Retries: *retries}
+	if *tcp {
+		c.Net = "tcp"
+	}
+	q := dns.NewQuery(dns.NewID(), name, t)
+	r, rtt, err := c.Exchange(context.Background(), q, server)
+	if err != nil {
+		log.Fatalf("%s: %v", server, err)
+	}
+
+	if *short {
+		for _, a := range r.Answers {
+			fmt.Println(a.Data)
+		}
+		return
+	}
+	fmt.Printf("; <<>> dig <<>> %s %v\n", name, t)
+	printMessage(os.Stdout, r)
+	fmt.Printf("\n;; Query time: %d msec\n", rtt/time.Millisecond)
+	fmt.Printf(";; SERVER: %s(%s)\n", server, c.Net)
+	fmt.Printf(";; WHEN: %s\n", time.Now().Format(time.UnixDate))
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dns"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args         []string
+		typ, reverse string
+		server, name string
+		want         dns.Type
+	}{
+		{args: []string{"example.com"}, name: "example.com", want: dns.TypeA},
+		{args: []string{"@10.0.0.53", "example.com", "aaaa"}, server: "10.0.0.53", name: "example.com", want: dns.TypeAAAA},
+		{args: []string{"mx", "example.com"}, name: "example.com", want: dns.TypeMX},
+		{args: []string{"@[2001:db8::53]", "a"}, typ: "txt", server: "[2001:db8::53]", name: "a", want: dns.TypeTXT},
+		{reverse: "10.1.2.3", name: "3.2.1.10.in-addr.arpa.", want: dns.TypePTR},
+	} {
+		*typ, *reverse = tt.typ, tt.reverse
+		server, name, got, err := parseArgs(tt.args)
+		if err != nil || server != tt.server || name != tt.name || got != tt.want {
+			t.Errorf("parseArgs(%q) = %q, %q, %v, %v, want %q, %q, %v", tt.args, server, name, got, err, tt.server, tt.name, tt.want)
+		}
+	}
+
+	*typ, *reverse = "", ""
+	for _, args := range [][]string{
+		nil,
+		{"a", "b", "c"},
+		{"example.com", "bogus"},
+	} {
+		if _, _, _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%q) succeeded, want error", args)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dnscache is a caching DNS stub resolver.
+//
+// Synopsis:
+//
+//	dnscache [OPTIONS...]
+//
+// Description:
+//
+//	dnscache answers DNS queries on -listen by forwarding them to the
+//	upstream servers in order and caching the responses. If no upstream
+//	server answers, it returns expired responses from the cache, which
+//	helps on flaky networks.
+//
+//	With -resolvconf, /etc/resolv.conf is rewritten to point at dnscache
+//	once it listens, so that the Go resolver of all programs uses it.
+//
+// Options:
+//
+//	-listen:     address to listen on (default 127.0.0.1:53)
+//	-upstream:   comma-separated upstream servers (default: the
+//	             nameservers of /etc/resolv.conf)
+//	-size:       maximum number of cached responses (default 1024)
+//	-timeout:    timeout of each upstream attempt (default 2s)
+//	-retries:    number of retries of each upstream server (default 1)
+//	-resolvconf: point /etc/resolv.conf at dnscache
+//	-v:          log failed upstream queries
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dns"
+)
+
+var (
+	listen        = flag.String("listen", "127.0.0.1:53", "address to listen on")
+	upstream      = flag.String("upstream", "", "comma-separated upstream servers (default: the nameservers of /etc/resolv.conf)")
+	size          = flag.Int("size", 1024, "maximum number of cached responses")
+	timeout       = flag.Duration("timeout", 2*time.Second, "timeout of each upstream attempt")
+	retries       = flag.Int("retries", 1, "number of retries of each upstream server")
+	setResolvConf = flag.Bool("resolvconf", false, "point /etc/resolv.conf at dnscache")
+	verbose       = flag.Bool("v", false, "log failed upstream queries")
+)
+
+const resolvConf = "/etc/resolv.conf"
+
+// upstreams returns the servers in list, or the nameservers of rc, except
+// for the address dnscache listens on.
+func upstreams(list string, rc *dns.ResolvConf, listen string) []string {
+	var servers []string
+	if list != "" {
+		servers = strings.Split(list, ",")
+	} else if rc != nil {
+		servers = rc.Nameservers
+	}
+
+	self := dns.ServerAddr(listen)
+	var out []string
+	for _, s := range servers {
+		if s = dns.ServerAddr(s); s != self {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// newResolvConf returns resolv.conf pointing at host, with the search
+// domains of rc.
+func newResolvConf(host string, rc *dns.ResolvConf) []byte {
+	var b bytes.Buffer
+	if rc != nil && len(rc.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(rc.Search, " "))
+	}
+	fmt.Fprintf(&b, "nameserver %s\n", host)
+	return b.Bytes()
+}
+
+func main() {
+	flag.Parse()
+
+	rc, err := dns.ReadResolvConf(resolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	s := &dns.Server{
+		Upstreams: upstreams(*upstream, rc, *listen),
+		Client:    dns.Client{Timeout: *timeout, Retries: *retries},
+		Cache:     dns.NewCache(*size),
+	}
+	if len(s.Upstreams) == 0 {
+		log.Fatal("no upstream servers")
+	}
+	if *verbose {
+		s.Log = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	pc, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *setResolvConf {
+		// resolv.conf has no ports, so this only works on port 53.
+		host, _, err := net.SplitHostPort(*listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(resolvConf, newResolvConf(host, rc), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(ctx, pc) }()
+	go func() { errs <- s.ServeTCP(ctx, l) }()
+	log.Fatal(<-errs)
+}
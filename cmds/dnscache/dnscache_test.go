@@ -0,0 +1,32 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dns"
+)
+
+func TestUpstreams(t *testing.T) {
+	rc := &dns.ResolvConf{Nameservers: []string{"127.0.0.1", "10.0.0.53", "2001:db8::53"}}
+	want := []string{"10.0.0.53:53", "[2001:db8::53]:53"}
+	if got := upstreams("", rc, "127.0.0.1:53"); !reflect.DeepEqual(got, want) {
+		t.Errorf("upstreams() = %q, want %q", got, want)
+	}
+	want = []string{"8.8.8.8:53", "10.0.0.53:5353"}
+	if got := upstreams("8.8.8.8,10.0.0.53:5353", rc, "127.0.0.1:53"); !reflect.DeepEqual(got, want) {
+		t.Errorf("upstreams() = %q, want %q", got, want)
+	}
+}
+
+func TestNewResolvConf(t *testing.T) {
+	rc := &dns.ResolvConf{Nameservers: []string{"10.0.0.53"}, Search: []string{"example.com"}}
+	want := "search example.com\nnameserver 127.0.0.1\n"
+	if got := string(newResolvConf("127.0.0.1", rc)); got != want {
+		t.Errorf("newResolvConf() = %q, want %q", got, want)
+	}
+}
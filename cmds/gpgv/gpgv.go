@@ -23,15 +23,12 @@
 package main
 
 import (
-	"crypto"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 
-	"golang.org/x/crypto/openpgp/errors"
-	"golang.org/x/crypto/openpgp/packet"
+	"github.com/u-root/u-root/pkg/gpgv"
 )
 
 var (
@@ -62,61 +59,14 @@ func main() {
 		log.Fatal(err)
 	}
 
-	key, err := readPublicSigningKey(keyf)
+	key, err := gpgv.ReadPublicSigningKey(keyf)
 	if err != nil {
 		log.Fatal("key ", err)
 	}
+	debug("key: ", key)
 
-	if err = verifyDetachedSignature(key, contentf, sigf); err != nil {
+	if err = gpgv.VerifyDetachedSignature(key, contentf, sigf); err != nil {
 		log.Fatal("verify: ", err)
 	}
 	fmt.Printf("OK")
 }
-
-func readPublicSigningKey(keyf io.Reader) (*packet.PublicKey, error) {
-	keypackets := packet.NewReader(keyf)
-	p, err := keypackets.Next()
-	if err != nil {
-		return nil, err
-	}
-	switch pkt := p.(type) {
-	case *packet.PublicKey:
-		debug("key: ", pkt)
-		return pkt, nil
-	default:
-		log.Printf("ReadPublicSigningKey: got %T, want *packet.PublicKey", pkt)
-	}
-	return nil, errors.StructuralError("expected first packet to be PublicKey")
-}
-
-func verifyDetachedSignature(key *packet.PublicKey, contentf, sigf io.Reader) error {
-	var hashFunc crypto.Hash
-
-	packets := packet.NewReader(sigf)
-	p, err := packets.Next()
-	if err != nil {
-		return err
-	}
-	switch sig := p.(type) {
-	case *packet.Signature:
-		hashFunc = sig.Hash
-	case *packet.SignatureV3:
-		hashFunc = sig.Hash
-	default:
-		return errors.UnsupportedError("unrecognized signature")
-	}
-
-	h := hashFunc.New()
-	if _, err := io.Copy(h, contentf); err != nil && err != io.EOF {
-		return err
-	}
-	switch sig := p.(type) {
-	case *packet.Signature:
-		err = key.VerifySignature(h, sig)
-	case *packet.SignatureV3:
-		err = key.VerifySignatureV3(h, sig)
-	default:
-		panic("unreachable")
-	}
-	return err
-}
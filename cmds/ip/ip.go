@@ -2,20 +2,52 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// ip manipulates network devices, addresses, routes, rules and network
+// namespaces.
+//
+// Synopsis:
+//     ip [OPTIONS] OBJECT [COMMAND [ARGUMENTS]]
+//
+// Options:
+//     -4:          only IPv4
+//     -6:          only IPv6
+//     -j, -json:   JSON output
+//     -n, -netns:  run in the named network namespace
+//
+// Objects:
+//     addr  { show [dev] IFNAME | add | del | replace ADDR [brd ADDR] [label LABEL]
+//             [scope SCOPE] [valid_lft LFT] [preferred_lft LFT] dev IFNAME |
+//             flush [dev] IFNAME }
+//     link  { show [dev] [IFNAME] | del [dev] IFNAME |
+//             set [dev] IFNAME { up | down | address MAC | mtu MTU | name NAME |
+//             alias ALIAS | txqueuelen N | master DEV | nomaster |
+//             netns { NAME | PID } | promisc { on | off } | arp { on | off } } ... |
+//             add [link DEV] [name] NAME [address MAC] [mtu MTU] type TYPE [ARGS] }
+//             TYPE is vlan id ID, bridge, bond [mode MODE] [miimon MS],
+//             veth peer [name] NAME, macvlan [mode MODE] or dummy.
+//     route { show [table TABLE] [dev IFNAME] | get ADDR |
+//             add | del | replace { PREFIX | default } [via GW] [dev IFNAME]
+//             [src ADDR] [metric N] [table TABLE] [scope SCOPE] [proto PROTO] [mtu MTU] }
+//     rule  { show | add | del [not] [from PREFIX] [to PREFIX] [iif IFNAME]
+//             [oif IFNAME] [fwmark MARK[/MASK]] [priority N] [table TABLE] }
+//     neigh show
+//     netns { list | add NAME | delete NAME | exec NAME COMMAND [ARGS] }
 package main
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	l "log"
+	"math"
 	"net"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
 
 	flag "github.com/spf13/pflag"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 )
 
 // The language implemented by the standard 'ip' is not super consistent
@@ -45,6 +77,14 @@ var (
 	whatIWant []string
 	log       = l.New(os.Stdout, "ip: ", 0)
 
+	inet4   = flag.BoolP("inet4", "4", false, "only IPv4")
+	inet6   = flag.BoolP("inet6", "6", false, "only IPv6")
+	jsonOut = flag.BoolP("json", "j", false, "JSON output")
+	nsName  = flag.StringP("netns", "n", "", "run in the named network namespace")
+
+	// family is the address family selected with -4 or -6.
+	family = netlink.FAMILY_ALL
+
 	addrScopes = map[netlink.Scope]string{
 		netlink.SCOPE_UNIVERSE: "global",
 		netlink.SCOPE_HOST:     "host",
@@ -63,20 +103,28 @@ func usage() error {
 		arg[0:cursor], arg[cursor:], arg[cursor], whatIWant)
 }
 
+// one returns the command in cmds that cmd is an abbreviation of. Like
+// iproute2, abbreviations match the first command in cmds, so that "r" is
+// route and not rule.
 func one(cmd string, cmds []string) string {
-	var x, n int
-	for i, v := range cmds {
-		if strings.HasPrefix(v, cmd) {
-			n++
-			x = i
+	for _, v := range cmds {
+		if v == cmd {
+			return v
 		}
 	}
-	if n == 1 {
-		return cmds[x]
+	for _, v := range cmds {
+		if strings.HasPrefix(v, cmd) {
+			return v
+		}
 	}
 	return ""
 }
 
+// more returns whether there are arguments after the cursor.
+func more() bool {
+	return cursor+1 < len(arg)
+}
+
 // in the ip command, turns out 'dev' is a noise word.
 // The BNF it shows is not right in that case.
 // Always make 'dev' optional.
@@ -90,218 +138,315 @@ func dev() (netlink.Link, error) {
 	return netlink.LinkByName(arg[cursor])
 }
 
-func addrip() error {
-	var err error
-	var addr *netlink.Addr
-	if len(arg) == 1 {
-		return showLinks(os.Stdout, true)
+// integer parses the next argument as a non-negative integer.
+func integer(what string) (int, error) {
+	cursor++
+	whatIWant = []string{what}
+	n, err := strconv.ParseUint(arg[cursor], 0, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", what, arg[cursor], err)
 	}
+	return int(n), nil
+}
+
+// onOff parses the next argument as on or off.
+func onOff() (bool, error) {
 	cursor++
-	whatIWant = []string{"add", "del"}
-	cmd := arg[cursor]
+	whatIWant = []string{"on", "off"}
+	switch arg[cursor] {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, usage()
+}
 
-	c := one(cmd, whatIWant)
-	switch c {
-	case "add", "del":
-		cursor++
-		whatIWant = []string{"CIDR format address"}
-		addr, err = netlink.ParseAddr(arg[cursor])
-		if err != nil {
-			return err
+// scope parses the next argument as an address or route scope.
+func scope() (netlink.Scope, error) {
+	cursor++
+	whatIWant = []string{"global", "site", "link", "host", "nowhere", "number"}
+	for s, name := range addrScopes {
+		if name == arg[cursor] {
+			return s, nil
 		}
-	default:
-		return usage()
 	}
-	iface, err := dev()
+	n, err := strconv.ParseUint(arg[cursor], 0, 8)
 	if err != nil {
-		return err
+		return 0, usage()
 	}
-	switch c {
-	case "add":
-		if err := netlink.AddrAdd(iface, addr); err != nil {
-			return fmt.Errorf("Adding %v to %v failed: %v", arg[1], arg[2], err)
+	return netlink.Scope(n), nil
+}
+
+// parseIP parses s as an address, ignoring any prefix length.
+func parseIP(s string) (net.IP, error) {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
+// prefix parses s as a prefix. A plain address is a host prefix, and "all"
+// and "default" are the default prefix of the selected family.
+func prefix(s string) (*net.IPNet, error) {
+	if s == "all" || s == "default" {
+		if family == netlink.FAMILY_V6 {
+			return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
 		}
-	case "del":
-		if err := netlink.AddrDel(iface, addr); err != nil {
-			return fmt.Errorf("Deleting %v from %v failed: %v", arg[1], arg[2], err)
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, nil
+	}
+	if !strings.Contains(s, "/") {
+		ip, err := parseIP(s)
+		if err != nil {
+			return nil, err
 		}
-	default:
-		return fmt.Errorf("devip: arg[0] changed: can't happen")
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}, nil
 	}
-	return nil
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip4 := n.IP.To4(); ip4 != nil {
+		n.IP = ip4
+	}
+	return n, nil
 }
 
-func neigh() error {
-	if len(arg) != 1 {
-		return errors.New("neigh subcommands not supported yet")
+// broadcast parses s as the broadcast address of addr. "+" is the address
+// with all host bits set.
+func broadcast(addr *netlink.Addr, s string) (net.IP, error) {
+	if s != "+" {
+		return parseIP(s)
 	}
-	return showNeighbours(os.Stdout, true)
+	ip := addr.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("no broadcast address for %v", addr.IPNet)
+	}
+	brd := make(net.IP, net.IPv4len)
+	for i := range ip {
+		brd[i] = ip[i] | ^addr.Mask[len(addr.Mask)-net.IPv4len+i]
+	}
+	return brd, nil
 }
 
-func linkshow() error {
-	cursor++
-	whatIWant = []string{"<nothing>", "<device name>"}
-	if len(arg[cursor:]) == 0 {
-		return showLinks(os.Stdout, false)
+// forever is the lifetime of addresses that do not expire.
+var forever uint32 = math.MaxUint32
+
+// lifetime parses the next argument as an address lifetime.
+func lifetime() (int, error) {
+	if arg[cursor+1] == "forever" {
+		cursor++
+		return int(forever), nil
 	}
-	return nil
+	return integer("lifetime in seconds or forever")
 }
 
-func setHardwareAddress(iface netlink.Link) error {
+// parseAddr parses an address and its attributes, and returns them along
+// with the name of the device.
+func parseAddr() (*netlink.Addr, string, error) {
 	cursor++
-	hwAddr, err := net.ParseMAC(arg[cursor])
+	whatIWant = []string{"CIDR format address"}
+	addr, err := netlink.ParseAddr(arg[cursor])
 	if err != nil {
+		return nil, "", err
+	}
 
-		return fmt.Errorf("%v cant parse mac addr %v: %v", iface, hwAddr, err)
+	var name string
+	for more() {
+		cursor++
+		whatIWant = []string{"dev", "broadcast", "label", "scope", "valid_lft", "preferred_lft"}
+		switch one(arg[cursor], whatIWant) {
+		case "dev":
+			cursor++
+			whatIWant = []string{"device name"}
+			name = arg[cursor]
+		case "broadcast":
+			cursor++
+			whatIWant = []string{"broadcast address", "+"}
+			if addr.Broadcast, err = broadcast(addr, arg[cursor]); err != nil {
+				return nil, "", err
+			}
+		case "label":
+			cursor++
+			whatIWant = []string{"label"}
+			addr.Label = arg[cursor]
+		case "scope":
+			s, err := scope()
+			if err != nil {
+				return nil, "", err
+			}
+			addr.Scope = int(s)
+		case "valid_lft":
+			if addr.ValidLft, err = lifetime(); err != nil {
+				return nil, "", err
+			}
+		case "preferred_lft":
+			if addr.PreferedLft, err = lifetime(); err != nil {
+				return nil, "", err
+			}
+		default:
+			if arg[cursor] != "brd" {
+				// A bare device name.
+				name = arg[cursor]
+				continue
+			}
+			cursor++
+			whatIWant = []string{"broadcast address", "+"}
+			if addr.Broadcast, err = broadcast(addr, arg[cursor]); err != nil {
+				return nil, "", err
+			}
+		}
 	}
-	err = netlink.LinkSetHardwareAddr(iface, hwAddr)
-	if err != nil {
-		return fmt.Errorf("%v cant set mac addr %v: %v", iface, hwAddr, err)
+	// Like iproute2, the preferred lifetime defaults to the valid one, and
+	// the valid lifetime to forever.
+	switch {
+	case addr.ValidLft != 0 && addr.PreferedLft == 0:
+		addr.PreferedLft = addr.ValidLft
+	case addr.ValidLft == 0 && addr.PreferedLft != 0:
+		addr.ValidLft = int(forever)
 	}
-	return nil
+	if name == "" {
+		whatIWant = []string{"dev"}
+		return nil, "", fmt.Errorf("no device given for %v", addr)
+	}
+	return addr, name, nil
 }
 
-func linkset() error {
-	iface, err := dev()
+// addrFlush deletes all addresses of the selected family from iface.
+func addrFlush(iface netlink.Link) error {
+	addrs, err := netlink.AddrList(iface, family)
 	if err != nil {
-		return err
+		return fmt.Errorf("Can't enumerate addresses: %v", err)
 	}
-
-	cursor++
-	whatIWant = []string{"address", "up", "down"}
-	switch one(arg[cursor], whatIWant) {
-	case "address":
-		return setHardwareAddress(iface)
-	case "up":
-		if err := netlink.LinkSetUp(iface); err != nil {
-			return fmt.Errorf("%v can't make it up: %v", iface, err)
+	// Secondary addresses go away with their primary address, so delete
+	// them first.
+	for i := len(addrs) - 1; i >= 0; i-- {
+		a := addrs[i]
+		if err := netlink.AddrDel(iface, &a); err != nil {
+			return fmt.Errorf("Deleting %v from %v failed: %v", a.IPNet, iface.Attrs().Name, err)
 		}
-	case "down":
-		if err := netlink.LinkSetDown(iface); err != nil {
-			return fmt.Errorf("%v can't make it down: %v", iface, err)
-		}
-	default:
-		return usage()
 	}
 	return nil
 }
 
-func link() error {
+func addrip() error {
 	if len(arg) == 1 {
-		return linkshow()
+		return showLinks(os.Stdout, true, nil)
 	}
-
 	cursor++
-	whatIWant = []string{"show", "set"}
+	whatIWant = []string{"add", "del", "replace", "flush", "show", "list"}
 	cmd := arg[cursor]
 
-	switch one(cmd, whatIWant) {
-	case "show":
-		return linkshow()
-	case "set":
-		return linkset()
-	}
-	return usage()
-}
-
-func routeshow() error {
-	b, err := ioutil.ReadFile("/proc/net/route")
-	if err != nil {
-		return fmt.Errorf("Route show failed: %v", err)
-	}
-	log.Printf("%s", string(b))
-	return nil
-}
-
-func nodespec() string {
-	cursor++
-	whatIWant = []string{"default", "CIDR"}
-	return arg[cursor]
-}
-
-func nexthop() (string, *netlink.Addr, error) {
-	cursor++
-	whatIWant = []string{"via"}
-	if arg[cursor] != "via" {
-		return "", nil, usage()
-	}
-	nh := arg[cursor]
-	cursor++
-	whatIWant = []string{"Gateway CIDR"}
-	addr, err := netlink.ParseAddr(arg[cursor])
-	if err != nil {
-		return "", nil, fmt.Errorf("Gateway CIDR: %v", err)
+	c := one(cmd, whatIWant)
+	switch c {
+	case "show", "list":
+		if !more() {
+			return showLinks(os.Stdout, true, nil)
+		}
+		iface, err := dev()
+		if err != nil {
+			return err
+		}
+		return showLinks(os.Stdout, true, []netlink.Link{iface})
+	case "flush":
+		iface, err := dev()
+		if err != nil {
+			return err
+		}
+		return addrFlush(iface)
+	case "add", "del", "replace":
+	default:
+		return usage()
 	}
-	return nh, addr, nil
-}
 
-func routeadddefault() error {
-	nh, nhval, err := nexthop()
+	addr, name, err := parseAddr()
 	if err != nil {
 		return err
 	}
-	// TODO: NHFLAGS.
-	l, err := dev()
+	iface, err := netlink.LinkByName(name)
 	if err != nil {
 		return err
 	}
-	switch nh {
-	case "via":
-		log.Printf("Add default route %v via %v", nhval, l)
-		r := &netlink.Route{LinkIndex: l.Attrs().Index, Gw: nhval.IPNet.IP}
-		if err := netlink.RouteAdd(r); err != nil {
-			return fmt.Errorf("Add default route: %v", err)
+	switch c {
+	case "add":
+		if err := netlink.AddrAdd(iface, addr); err != nil {
+			return fmt.Errorf("Adding %v to %v failed: %v", addr.IPNet, name, err)
+		}
+	case "del":
+		if err := netlink.AddrDel(iface, addr); err != nil {
+			return fmt.Errorf("Deleting %v from %v failed: %v", addr.IPNet, name, err)
+		}
+	case "replace":
+		if err := netlink.AddrReplace(iface, addr); err != nil {
+			return fmt.Errorf("Replacing %v on %v failed: %v", addr.IPNet, name, err)
 		}
-		return nil
 	}
-	return usage()
+	return nil
 }
 
-func routeadd() error {
-	ns := nodespec()
-	switch ns {
-	case "default":
-		return routeadddefault()
-	default:
-		addr, err := netlink.ParseAddr(arg[cursor])
-		if err != nil {
-			return usage()
-		}
-		d, err := dev()
-		if err != nil {
+func neigh() error {
+	if len(arg) != 1 {
+		cursor++
+		whatIWant = []string{"show", "list"}
+		if one(arg[cursor], whatIWant) == "" {
 			return usage()
 		}
-		r := &netlink.Route{LinkIndex: d.Attrs().Index, Dst: addr.IPNet}
-		if err := netlink.RouteAdd(r); err != nil {
-			return fmt.Errorf("error adding route %s -> %s: %v", addr, d, err)
-		}
-		return nil
 	}
+	return showNeighbours(os.Stdout)
 }
 
-func route() error {
-	cursor++
-	if len(arg[cursor:]) == 0 {
-		return routeshow()
-	}
-
-	whatIWant = []string{"show", "add"}
-	switch one(arg[cursor], whatIWant) {
-	case "show":
-		return routeshow()
-	case "add":
-		return routeadd()
+// fixArgs lets long options be given with a single dash, as iproute2 takes
+// them.
+func fixArgs(args []string) []string {
+	for i, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			break
+		}
+		if len(a) > 2 && a[1] != '-' && flag.Lookup(a[1:]) != nil {
+			args[i] = "-" + a
+		}
 	}
-	return usage()
+	return args
 }
 
 func main() {
 	// When this is embedded in busybox we need to reinit some things.
-	whatIWant = []string{"addr", "route", "link", "neigh"}
+	whatIWant = []string{"addr", "route", "link", "neigh", "rule", "netns"}
 	cursor = 0
-	flag.Parse()
+	// Arguments after the object are ip's, not options.
+	flag.CommandLine.SetInterspersed(false)
+	flag.CommandLine.Parse(fixArgs(os.Args[1:]))
 	arg = flag.Args()
 
+	switch {
+	case *inet4 && *inet6:
+		log.Fatalf("-4 and -6 are mutually exclusive")
+	case *inet4:
+		family = netlink.FAMILY_V4
+	case *inet6:
+		family = netlink.FAMILY_V6
+	}
+
+	// Network namespaces belong to threads.
+	runtime.LockOSThread()
+	if *nsName != "" {
+		ns, err := netns.GetFromName(*nsName)
+		if err != nil {
+			log.Fatalf("Can't open network namespace %q: %v", *nsName, err)
+		}
+		if err := netns.Set(ns); err != nil {
+			log.Fatalf("Can't enter network namespace %q: %v", *nsName, err)
+		}
+		ns.Close()
+	}
+
 	defer func() {
 		switch err := recover().(type) {
 		case nil:
@@ -329,8 +474,12 @@ func main() {
 		err = route()
 	case "neigh":
 		err = neigh()
+	case "rule":
+		err = rule()
+	case "netns":
+		err = netnsCmd()
 	default:
-		usage()
+		err = usage()
 	}
 	if err != nil {
 		log.Fatal(err)
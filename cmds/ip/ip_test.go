@@ -0,0 +1,283 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// setArgs makes the parser start on args, as if args[0] was just parsed.
+func setArgs(args string) {
+	arg = strings.Fields(args)
+	cursor = 0
+	family = netlink.FAMILY_ALL
+}
+
+// fails returns whether f returns an error or, like on too few arguments,
+// panics.
+func fails(f func() error) (failed bool) {
+	defer func() {
+		if recover() != nil {
+			failed = true
+		}
+	}()
+	return f() != nil
+}
+
+func mustPrefix(t *testing.T, s string) *net.IPNet {
+	p, err := prefix(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestOne(t *testing.T) {
+	cmds := []string{"addr", "route", "link", "neigh", "rule", "netns"}
+	for _, tt := range []struct {
+		cmd, want string
+	}{
+		{"a", "addr"},
+		{"r", "route"},
+		{"ru", "rule"},
+		{"n", "neigh"},
+		{"netns", "netns"},
+		{"x", ""},
+	} {
+		if got := one(tt.cmd, cmds); got != tt.want {
+			t.Errorf("one(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		v6   bool
+		want string
+	}{
+		{s: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{s: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{s: "10.1.2.3", want: "10.1.2.3/32"},
+		{s: "2001:db8::1", want: "2001:db8::1/128"},
+		{s: "default", want: "0.0.0.0/0"},
+		{s: "default", v6: true, want: "::/0"},
+	} {
+		family = netlink.FAMILY_ALL
+		if tt.v6 {
+			family = netlink.FAMILY_V6
+		}
+		if got := mustPrefix(t, tt.s); got.String() != tt.want {
+			t.Errorf("prefix(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	family = netlink.FAMILY_ALL
+}
+
+func TestParseAddr(t *testing.T) {
+	setArgs("add 10.0.0.5/24 brd + label eth0:1 valid_lft 100 dev eth0")
+	addr, name, err := parseAddr()
+	if err != nil {
+		t.Fatalf("parseAddr() = %v", err)
+	}
+	if name != "eth0" {
+		t.Errorf("parseAddr() device = %q, want eth0", name)
+	}
+	if !addr.Broadcast.Equal(net.IP{10, 0, 0, 255}) || addr.Label != "eth0:1" || addr.ValidLft != 100 || addr.PreferedLft != 100 {
+		t.Errorf("parseAddr() = %+v, want broadcast 10.0.0.255, label eth0:1 and lifetimes 100", addr)
+	}
+
+	// The old syntax without dev.
+	setArgs("add 10.0.0.5/24 eth0")
+	if _, name, err := parseAddr(); err != nil || name != "eth0" {
+		t.Errorf("parseAddr() = %q, %v, want eth0", name, err)
+	}
+
+	setArgs("add 10.0.0.5/24")
+	if _, _, err := parseAddr(); err == nil {
+		t.Errorf("parseAddr() without a device succeeded")
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		want *netlink.Route
+	}{
+		{
+			args: "add 10.2.0.0/16 via 10.1.0.2 metric 5 table 100 proto static src 10.1.0.1",
+			want: &netlink.Route{
+				Dst:      mustPrefix(t, "10.2.0.0/16"),
+				Gw:       net.IP{10, 1, 0, 2},
+				Src:      net.IP{10, 1, 0, 1},
+				Priority: 5,
+				Table:    100,
+				Protocol: unix.RTPROT_STATIC,
+			},
+		},
+		{
+			// The old syntax with a gateway prefix length.
+			args: "add default via 10.1.0.254/24 table main",
+			want: &netlink.Route{
+				Dst:   mustPrefix(t, "0.0.0.0/0"),
+				Gw:    net.IP{10, 1, 0, 254},
+				Table: unix.RT_TABLE_MAIN,
+			},
+		},
+		{
+			args: "add default via fe80::1",
+			want: &netlink.Route{
+				Dst: mustPrefix(t, "::/0"),
+				Gw:  net.ParseIP("fe80::1"),
+			},
+		},
+		{
+			args: "del 10.3.0.0/16",
+			want: &netlink.Route{
+				Dst:   mustPrefix(t, "10.3.0.0/16"),
+				Scope: netlink.SCOPE_NOWHERE,
+			},
+		},
+		{
+			args: "add blackhole 10.4.0.0/16 scope host",
+			want: &netlink.Route{
+				Dst:   mustPrefix(t, "10.4.0.0/16"),
+				Type:  unix.RTN_BLACKHOLE,
+				Scope: netlink.SCOPE_HOST,
+			},
+		},
+	} {
+		setArgs(tt.args)
+		got, err := parseRoute(arg[0])
+		if err != nil {
+			t.Errorf("parseRoute(%q) = %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRoute(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+	family = netlink.FAMILY_ALL
+}
+
+func TestRouteInfo(t *testing.T) {
+	names := func(i int) string { return "eth" + string('0'+rune(i)) }
+	for _, tt := range []struct {
+		r    netlink.Route
+		want string
+	}{
+		{
+			r:    netlink.Route{Type: unix.RTN_UNICAST, Gw: net.IP{10, 0, 0, 1}, LinkIndex: 1, Protocol: unix.RTPROT_DHCP, Priority: 100, Src: net.IP{10, 0, 0, 5}},
+			want: "default via 10.0.0.1 dev eth1 proto dhcp src 10.0.0.5 metric 100",
+		},
+		{
+			r:    netlink.Route{Type: unix.RTN_UNICAST, Dst: mustPrefix(t, "10.0.0.0/24"), LinkIndex: 2, Protocol: unix.RTPROT_KERNEL, Scope: netlink.SCOPE_LINK, Table: 100},
+			want: "10.0.0.0/24 dev eth2 table 100 proto kernel scope link",
+		},
+		{
+			r:    netlink.Route{Type: unix.RTN_LOCAL, Dst: mustPrefix(t, "127.0.0.1"), LinkIndex: 1, Table: unix.RT_TABLE_LOCAL, Scope: netlink.SCOPE_HOST},
+			want: "local 127.0.0.1 dev eth1 table local scope host",
+		},
+	} {
+		if got := newRouteInfo(&tt.r, names).String(); got != tt.want {
+			t.Errorf("route %v = %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	setArgs("add not from 10.0.0.0/8 iif eth0 fwmark 0x10/0xff pref 100 lookup 200")
+	r, err := parseRule()
+	if err != nil {
+		t.Fatalf("parseRule() = %v", err)
+	}
+	want := netlink.NewRule()
+	want.Family = netlink.FAMILY_V4
+	want.Invert = true
+	want.Src = mustPrefix(t, "10.0.0.0/8")
+	want.IifName = "eth0"
+	want.Mark = 0x10
+	want.Mask = 0xff
+	want.Priority = 100
+	want.Table = 200
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("parseRule() = %+v, want %+v", r, want)
+	}
+
+	if got, want := newRuleInfo(r).String(), "100:\tnot from 10.0.0.0/8 fwmark 0x10/0xff iif eth0 lookup 200"; got != want {
+		t.Errorf("rule = %q, want %q", got, want)
+	}
+
+	setArgs("add from 2001:db8::/32 table main")
+	if r, err := parseRule(); err != nil || r.Family != netlink.FAMILY_V6 || r.Table != unix.RT_TABLE_MAIN {
+		t.Errorf("parseRule() = %+v, %v, want IPv6 rule for table main", r, err)
+	}
+}
+
+func TestParseLinkAdd(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		want netlink.Link
+	}{
+		{
+			args: "add br0 type bridge",
+			want: &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0", TxQLen: -1}},
+		},
+		{
+			args: "add name d0 mtu 9000 address 02:00:00:00:00:01 type dummy",
+			want: &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{
+				Name:         "d0",
+				MTU:          9000,
+				TxQLen:       -1,
+				HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1},
+			}},
+		},
+		{
+			args: "add v0 type veth peer name v1",
+			want: &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "v0", TxQLen: -1}, PeerName: "v1"},
+		},
+	} {
+		setArgs(tt.args)
+		got, err := parseLinkAdd()
+		if err != nil {
+			t.Errorf("parseLinkAdd(%q) = %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLinkAdd(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+
+	setArgs("add bond0 type bond mode active-backup miimon 100")
+	l, err := parseLinkAdd()
+	if err != nil {
+		t.Fatalf("parseLinkAdd() = %v", err)
+	}
+	if b, ok := l.(*netlink.Bond); !ok || b.Mode != netlink.BOND_MODE_ACTIVE_BACKUP || b.Miimon != 100 {
+		t.Errorf("parseLinkAdd() = %+v, want active-backup bond with miimon 100", l)
+	}
+
+	for _, args := range []string{
+		"add type bridge",
+		"add v0 type veth",
+		"add vl0 type vlan id 10",
+		"add bond0 type bond mode bogus",
+		"add x type bogus",
+	} {
+		setArgs(args)
+		if !fails(func() error {
+			_, err := parseLinkAdd()
+			return err
+		}) {
+			t.Errorf("parseLinkAdd(%q) succeeded, want error", args)
+		}
+	}
+}
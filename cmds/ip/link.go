@@ -0,0 +1,315 @@
+// Copyright 2012-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	"source":   netlink.MACVLAN_MODE_SOURCE,
+}
+
+func linkshow() error {
+	cursor++
+	whatIWant = []string{"<nothing>", "<device name>"}
+	if len(arg[cursor:]) == 0 {
+		return showLinks(os.Stdout, false, nil)
+	}
+	cursor--
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	return showLinks(os.Stdout, false, []netlink.Link{iface})
+}
+
+func setHardwareAddress(iface netlink.Link) error {
+	cursor++
+	hwAddr, err := net.ParseMAC(arg[cursor])
+	if err != nil {
+
+		return fmt.Errorf("%v cant parse mac addr %v: %v", iface, hwAddr, err)
+	}
+	err = netlink.LinkSetHardwareAddr(iface, hwAddr)
+	if err != nil {
+		return fmt.Errorf("%v cant set mac addr %v: %v", iface, hwAddr, err)
+	}
+	return nil
+}
+
+// setNetns moves iface to the network namespace named by the next argument,
+// or to that of the process with that pid.
+func setNetns(iface netlink.Link) error {
+	cursor++
+	whatIWant = []string{"network namespace name", "pid"}
+	if pid, err := strconv.Atoi(arg[cursor]); err == nil {
+		return netlink.LinkSetNsPid(iface, pid)
+	}
+	ns, err := netns.GetFromName(arg[cursor])
+	if err != nil {
+		return fmt.Errorf("Can't open network namespace %q: %v", arg[cursor], err)
+	}
+	defer ns.Close()
+	return netlink.LinkSetNsFd(iface, int(ns))
+}
+
+func linkset() error {
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	name := iface.Attrs().Name
+
+	for more() {
+		cursor++
+		whatIWant = []string{"address", "up", "down", "mtu", "name", "alias", "txqueuelen", "master", "nomaster", "netns", "promisc", "arp"}
+		switch one(arg[cursor], whatIWant) {
+		case "address":
+			err = setHardwareAddress(iface)
+		case "up":
+			if err := netlink.LinkSetUp(iface); err != nil {
+				return fmt.Errorf("%v can't make it up: %v", name, err)
+			}
+		case "down":
+			if err := netlink.LinkSetDown(iface); err != nil {
+				return fmt.Errorf("%v can't make it down: %v", name, err)
+			}
+		case "mtu":
+			var mtu int
+			if mtu, err = integer("MTU"); err == nil {
+				err = netlink.LinkSetMTU(iface, mtu)
+			}
+		case "name":
+			cursor++
+			whatIWant = []string{"new device name"}
+			err = netlink.LinkSetName(iface, arg[cursor])
+		case "alias":
+			cursor++
+			whatIWant = []string{"alias"}
+			err = netlink.LinkSetAlias(iface, arg[cursor])
+		case "txqueuelen":
+			var qlen int
+			if qlen, err = integer("queue length"); err == nil {
+				err = netlink.LinkSetTxQLen(iface, qlen)
+			}
+		case "master":
+			var master netlink.Link
+			if master, err = dev(); err == nil {
+				err = netlink.LinkSetMasterByIndex(iface, master.Attrs().Index)
+			}
+		case "nomaster":
+			err = netlink.LinkSetNoMaster(iface)
+		case "netns":
+			err = setNetns(iface)
+		case "promisc":
+			var on bool
+			if on, err = onOff(); err == nil && on {
+				err = netlink.SetPromiscOn(iface)
+			} else if err == nil {
+				err = netlink.SetPromiscOff(iface)
+			}
+		case "arp":
+			var on bool
+			if on, err = onOff(); err == nil && on {
+				err = netlink.LinkSetARPOn(iface)
+			} else if err == nil {
+				err = netlink.LinkSetARPOff(iface)
+			}
+		default:
+			return usage()
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %s: %v", name, arg[cursor], err)
+		}
+	}
+	return nil
+}
+
+// parseLinkAdd parses the arguments of link add and returns the link to
+// create.
+func parseLinkAdd() (netlink.Link, error) {
+	attrs := netlink.NewLinkAttrs()
+	for {
+		cursor++
+		whatIWant = []string{"link", "name", "address", "mtu", "txqueuelen", "type"}
+		c := one(arg[cursor], whatIWant)
+		if c == "type" {
+			break
+		}
+		var err error
+		switch c {
+		case "link":
+			var parent netlink.Link
+			if parent, err = dev(); err == nil {
+				attrs.ParentIndex = parent.Attrs().Index
+			}
+		case "name":
+			cursor++
+			whatIWant = []string{"device name"}
+			attrs.Name = arg[cursor]
+		case "address":
+			cursor++
+			whatIWant = []string{"MAC address"}
+			attrs.HardwareAddr, err = net.ParseMAC(arg[cursor])
+		case "mtu":
+			attrs.MTU, err = integer("MTU")
+		case "txqueuelen":
+			attrs.TxQLen, err = integer("queue length")
+		default:
+			// The name is a noise word, too.
+			attrs.Name = arg[cursor]
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if attrs.Name == "" {
+		whatIWant = []string{"name"}
+		return nil, fmt.Errorf("no device name given")
+	}
+
+	cursor++
+	whatIWant = []string{"vlan", "bridge", "bond", "veth", "macvlan", "dummy"}
+	switch one(arg[cursor], whatIWant) {
+	case "vlan":
+		cursor++
+		whatIWant = []string{"id"}
+		if arg[cursor] != "id" {
+			return nil, usage()
+		}
+		id, err := integer("VLAN ID")
+		if err != nil {
+			return nil, err
+		}
+		if attrs.ParentIndex == 0 {
+			return nil, fmt.Errorf("vlan needs a link")
+		}
+		return &netlink.Vlan{LinkAttrs: attrs, VlanId: id}, nil
+
+	case "bridge":
+		return &netlink.Bridge{LinkAttrs: attrs}, nil
+
+	case "bond":
+		bond := netlink.NewLinkBond(attrs)
+		for more() {
+			cursor++
+			whatIWant = []string{"mode", "miimon"}
+			switch one(arg[cursor], whatIWant) {
+			case "mode":
+				cursor++
+				whatIWant = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+				if bond.Mode = netlink.StringToBondMode(arg[cursor]); bond.Mode == netlink.BOND_MODE_UNKNOWN {
+					return nil, usage()
+				}
+			case "miimon":
+				var err error
+				if bond.Miimon, err = integer("milliseconds"); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, usage()
+			}
+		}
+		return bond, nil
+
+	case "veth":
+		cursor++
+		whatIWant = []string{"peer"}
+		if arg[cursor] != "peer" {
+			return nil, usage()
+		}
+		cursor++
+		whatIWant = []string{"name", "peer device name"}
+		if arg[cursor] == "name" {
+			cursor++
+		}
+		return &netlink.Veth{LinkAttrs: attrs, PeerName: arg[cursor]}, nil
+
+	case "macvlan":
+		mode := netlink.MACVLAN_MODE_DEFAULT
+		if more() {
+			cursor++
+			whatIWant = []string{"mode"}
+			if arg[cursor] != "mode" {
+				return nil, usage()
+			}
+			cursor++
+			whatIWant = []string{"private", "vepa", "bridge", "passthru", "source"}
+			m, ok := macvlanModes[arg[cursor]]
+			if !ok {
+				return nil, usage()
+			}
+			mode = m
+		}
+		if attrs.ParentIndex == 0 {
+			return nil, fmt.Errorf("macvlan needs a link")
+		}
+		return &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}, nil
+
+	case "dummy":
+		return &netlink.Dummy{LinkAttrs: attrs}, nil
+	}
+	return nil, usage()
+}
+
+func linkadd() error {
+	l, err := parseLinkAdd()
+	if err != nil {
+		return err
+	}
+	if more() {
+		cursor++
+		whatIWant = []string{"<nothing>"}
+		return usage()
+	}
+	if err := netlink.LinkAdd(l); err != nil {
+		return fmt.Errorf("Adding %s link %s failed: %v", l.Type(), l.Attrs().Name, err)
+	}
+	return nil
+}
+
+func linkdel() error {
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkDel(iface); err != nil {
+		return fmt.Errorf("Deleting %s failed: %v", iface.Attrs().Name, err)
+	}
+	return nil
+}
+
+func link() error {
+	if len(arg) == 1 {
+		return linkshow()
+	}
+
+	cursor++
+	whatIWant = []string{"show", "list", "set", "add", "del", "delete"}
+	cmd := arg[cursor]
+
+	switch one(cmd, whatIWant) {
+	case "show", "list":
+		return linkshow()
+	case "set":
+		return linkset()
+	case "add":
+		return linkadd()
+	case "del", "delete":
+		return linkdel()
+	}
+	return usage()
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vishvananda/netns"
+)
+
+// netnsDir is where named network namespaces are bind mounted, as with
+// iproute2.
+const netnsDir = "/var/run/netns"
+
+type netnsInfo struct {
+	Name string `json:"name"`
+}
+
+func netnsList(w io.Writer) error {
+	files, err := ioutil.ReadDir(netnsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	infos := []netnsInfo{}
+	for _, f := range files {
+		infos = append(infos, netnsInfo{Name: f.Name()})
+	}
+	if *jsonOut {
+		return printJSON(w, infos)
+	}
+	for _, ns := range infos {
+		fmt.Fprintln(w, ns.Name)
+	}
+	return nil
+}
+
+// netnsAdd creates a network namespace and bind mounts it at
+// netnsDir/name. The calling thread must be locked.
+func netnsAdd(name string) error {
+	if err := os.MkdirAll(netnsDir, 0755); err != nil {
+		return err
+	}
+	p := filepath.Join(netnsDir, name)
+	f, err := os.OpenFile(p, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	orig, err := netns.Get()
+	if err != nil {
+		os.Remove(p)
+		return err
+	}
+	defer orig.Close()
+
+	// New moves this thread to the new namespace.
+	ns, err := netns.New()
+	if err != nil {
+		os.Remove(p)
+		return err
+	}
+	defer ns.Close()
+	defer netns.Set(orig)
+
+	self := fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid())
+	if err := syscall.Mount(self, p, "none", syscall.MS_BIND, ""); err != nil {
+		os.Remove(p)
+		return fmt.Errorf("bind mounting %s on %s: %v", self, p, err)
+	}
+	return nil
+}
+
+func netnsDelete(name string) error {
+	p := filepath.Join(netnsDir, name)
+	if err := syscall.Unmount(p, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmounting %s: %v", p, err)
+	}
+	return os.Remove(p)
+}
+
+// netnsExec runs a command in the named network namespace. The calling
+// thread must be locked, as the command inherits its namespace.
+func netnsExec(name string, args []string) error {
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		return fmt.Errorf("Can't open network namespace %q: %v", name, err)
+	}
+	defer ns.Close()
+	if err := netns.Set(ns); err != nil {
+		return fmt.Errorf("Can't enter network namespace %q: %v", name, err)
+	}
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			if s, ok := e.Sys().(syscall.WaitStatus); ok {
+				os.Exit(s.ExitStatus())
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func netnsCmd() error {
+	cursor++
+	if len(arg[cursor:]) == 0 {
+		return netnsList(os.Stdout)
+	}
+
+	whatIWant = []string{"list", "add", "delete", "exec"}
+	c := one(arg[cursor], whatIWant)
+	switch c {
+	case "list":
+		return netnsList(os.Stdout)
+	case "add", "delete", "exec":
+	default:
+		return usage()
+	}
+
+	cursor++
+	whatIWant = []string{"network namespace name"}
+	name := arg[cursor]
+	switch c {
+	case "add":
+		return netnsAdd(name)
+	case "delete":
+		return netnsDelete(name)
+	}
+	cursor++
+	whatIWant = []string{"command"}
+	return netnsExec(name, arg[cursor:])
+}
@@ -5,45 +5,144 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"math"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/vishvananda/netlink"
 )
 
-func showLinks(w io.Writer, withAddresses bool) error {
-	ifaces, err := netlink.LinkList()
+// The show functions collect what they show in these types, and print them
+// either as text or, with -json, in the JSON format of iproute2.
+
+type addrInfo struct {
+	Family            string `json:"family"`
+	Local             string `json:"local"`
+	PrefixLen         int    `json:"prefixlen"`
+	Broadcast         string `json:"broadcast,omitempty"`
+	Scope             string `json:"scope"`
+	Label             string `json:"label,omitempty"`
+	ValidLifeTime     uint32 `json:"valid_life_time"`
+	PreferredLifeTime uint32 `json:"preferred_life_time"`
+}
+
+type linkInfo struct {
+	IfIndex   int        `json:"ifindex"`
+	IfName    string     `json:"ifname"`
+	Flags     []string   `json:"flags"`
+	MTU       int        `json:"mtu"`
+	Master    string     `json:"master,omitempty"`
+	OperState string     `json:"operstate"`
+	LinkType  string     `json:"link_type"`
+	Address   string     `json:"address,omitempty"`
+	AddrInfo  []addrInfo `json:"addr_info,omitempty"`
+}
+
+type neighInfo struct {
+	Dst    string   `json:"dst"`
+	Dev    string   `json:"dev"`
+	LLAddr string   `json:"lladdr,omitempty"`
+	Router bool     `json:"router,omitempty"`
+	State  []string `json:"state"`
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// linkName returns the name of the link with index i.
+func linkName(i int) string {
+	l, err := netlink.LinkByIndex(i)
 	if err != nil {
-		return fmt.Errorf("Can't enumerate interfaces? %v", err)
+		return fmt.Sprintf("if%d", i)
 	}
+	return l.Attrs().Name
+}
 
-	for _, v := range ifaces {
-		l := v.Attrs()
+func lifetimeString(lft uint32) string {
+	if lft == forever {
+		return "forever"
+	}
+	return fmt.Sprintf("%dsec", lft)
+}
 
-		fmt.Fprintf(w, "%d: %s: <%s> mtu %d state %s\n", l.Index, l.Name,
-			strings.Replace(strings.ToUpper(fmt.Sprintf("%s", l.Flags)), "|", ",", -1),
-			l.MTU, strings.ToUpper(l.OperState.String()))
+// showLinks shows links, all of them if links is nil.
+func showLinks(w io.Writer, withAddresses bool, links []netlink.Link) error {
+	if links == nil {
+		var err error
+		if links, err = netlink.LinkList(); err != nil {
+			return fmt.Errorf("Can't enumerate interfaces? %v", err)
+		}
+	}
 
-		fmt.Fprintf(w, "    link/%s %s\n", l.EncapType, l.HardwareAddr)
+	infos := []linkInfo{}
+	for _, v := range links {
+		l := v.Attrs()
+		info := linkInfo{
+			IfIndex:   l.Index,
+			IfName:    l.Name,
+			Flags:     strings.Split(strings.ToUpper(l.Flags.String()), "|"),
+			MTU:       l.MTU,
+			OperState: strings.ToUpper(l.OperState.String()),
+			LinkType:  l.EncapType,
+			Address:   l.HardwareAddr.String(),
+		}
+		if l.Flags == 0 {
+			info.Flags = []string{}
+		}
+		if l.MasterIndex != 0 {
+			info.Master = linkName(l.MasterIndex)
+		}
 
 		if withAddresses {
-			showLinkAddresses(w, v)
+			addrs, err := linkAddresses(v)
+			if err != nil {
+				return err
+			}
+			// Like iproute2, only show links with addresses of
+			// the selected family.
+			if family != netlink.FAMILY_ALL && len(addrs) == 0 {
+				continue
+			}
+			info.AddrInfo = addrs
+		}
+		infos = append(infos, info)
+	}
+
+	if *jsonOut {
+		return printJSON(w, infos)
+	}
+	for _, l := range infos {
+		fmt.Fprintf(w, "%d: %s: <%s> mtu %d", l.IfIndex, l.IfName, strings.Join(l.Flags, ","), l.MTU)
+		if l.Master != "" {
+			fmt.Fprintf(w, " master %s", l.Master)
+		}
+		fmt.Fprintf(w, " state %s\n", l.OperState)
+		fmt.Fprintf(w, "    link/%s %s\n", l.LinkType, l.Address)
+
+		for _, a := range l.AddrInfo {
+			fmt.Fprintf(w, "    %s %s/%d", a.Family, a.Local, a.PrefixLen)
+			if a.Broadcast != "" {
+				fmt.Fprintf(w, " brd %s", a.Broadcast)
+			}
+			fmt.Fprintf(w, " scope %s %s\n", a.Scope, a.Label)
+			fmt.Fprintf(w, "       valid_lft %s preferred_lft %s\n", lifetimeString(a.ValidLifeTime), lifetimeString(a.PreferredLifeTime))
 		}
 	}
 	return nil
 }
 
-func showLinkAddresses(w io.Writer, link netlink.Link) error {
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+func linkAddresses(link netlink.Link) ([]addrInfo, error) {
+	addrs, err := netlink.AddrList(link, family)
 	if err != nil {
-		return fmt.Errorf("Can't enumerate addresses: %v", err)
+		return nil, fmt.Errorf("Can't enumerate addresses: %v", err)
 	}
 
+	var infos []addrInfo
 	for _, addr := range addrs {
-
 		var inet string
 		switch len(addr.IPNet.IP) {
 		case 4:
@@ -51,30 +150,26 @@ func showLinkAddresses(w io.Writer, link netlink.Link) error {
 		case 16:
 			inet = "inet6"
 		default:
-			return fmt.Errorf("Can't figure out IP protocol version")
+			return nil, fmt.Errorf("Can't figure out IP protocol version")
 		}
 
-		fmt.Fprintf(w, "    %s %s", inet, addr.IP)
-		if addr.Broadcast != nil {
-			fmt.Fprintf(w, " brd %s", addr.Broadcast)
+		ones, _ := addr.Mask.Size()
+		info := addrInfo{
+			Family:    inet,
+			Local:     addr.IP.String(),
+			PrefixLen: ones,
+			Scope:     addrScopes[netlink.Scope(addr.Scope)],
+			Label:     addr.Label,
+			// TODO: fix vishnavanda/netlink. *Lft should be uint32, not int.
+			ValidLifeTime:     uint32(addr.ValidLft),
+			PreferredLifeTime: uint32(addr.PreferedLft),
 		}
-		fmt.Fprintf(w, " scope %s %s\n", addrScopes[netlink.Scope(addr.Scope)], addr.Label)
-
-		var validLft, preferredLft string
-		// TODO: fix vishnavanda/netlink. *Lft should be uint32, not int.
-		if uint32(addr.PreferedLft) == math.MaxUint32 {
-			preferredLft = "forever"
-		} else {
-			preferredLft = fmt.Sprintf("%dsec", addr.PreferedLft)
-		}
-		if uint32(addr.ValidLft) == math.MaxUint32 {
-			validLft = "forever"
-		} else {
-			validLft = fmt.Sprintf("%dsec", addr.ValidLft)
+		if addr.Broadcast != nil {
+			info.Broadcast = addr.Broadcast.String()
 		}
-		fmt.Fprintf(w, "       valid_lft %s preferred_lft %s\n", validLft, preferredLft)
+		infos = append(infos, info)
 	}
-	return nil
+	return infos, nil
 }
 
 var neighStates = map[int]string{
@@ -89,7 +184,7 @@ var neighStates = map[int]string{
 	netlink.NUD_PERMANENT:  "PERMANENT",
 }
 
-func getState(state int) string {
+func getState(state int) []string {
 	ret := make([]string, 0)
 	for st, name := range neighStates {
 		if state&st != 0 {
@@ -97,18 +192,20 @@ func getState(state int) string {
 		}
 	}
 	if len(ret) == 0 {
-		return "UNKNOWN"
+		return []string{"UNKNOWN"}
 	}
-	return strings.Join(ret, ",")
+	sort.Strings(ret)
+	return ret
 }
 
-func showNeighbours(w io.Writer, withAddresses bool) error {
+func showNeighbours(w io.Writer) error {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return err
 	}
+	infos := []neighInfo{}
 	for _, iface := range ifaces {
-		neighs, err := netlink.NeighList(iface.Index, 0)
+		neighs, err := netlink.NeighList(iface.Index, family)
 		if err != nil {
 			return fmt.Errorf("Can't list neighbours? %v", err)
 		}
@@ -117,16 +214,32 @@ func showNeighbours(w io.Writer, withAddresses bool) error {
 			if v.State&netlink.NUD_NOARP != 0 {
 				continue
 			}
-			entry := fmt.Sprintf("%s dev %s", v.IP.String(), iface.Name)
-			if v.HardwareAddr != nil {
-				entry += fmt.Sprintf(" lladdr %s", v.HardwareAddr)
+			info := neighInfo{
+				Dst:    v.IP.String(),
+				Dev:    iface.Name,
+				Router: v.Flags&netlink.NTF_ROUTER != 0,
+				State:  getState(v.State),
 			}
-			if v.Flags&netlink.NTF_ROUTER != 0 {
-				entry += " router"
+			if v.HardwareAddr != nil {
+				info.LLAddr = v.HardwareAddr.String()
 			}
-			entry += " " + getState(v.State)
-			fmt.Println(entry)
+			infos = append(infos, info)
+		}
+	}
+
+	if *jsonOut {
+		return printJSON(w, infos)
+	}
+	for _, v := range infos {
+		entry := fmt.Sprintf("%s dev %s", v.Dst, v.Dev)
+		if v.LLAddr != "" {
+			entry += fmt.Sprintf(" lladdr %s", v.LLAddr)
+		}
+		if v.Router {
+			entry += " router"
 		}
+		entry += " " + strings.Join(v.State, ",")
+		fmt.Fprintln(w, entry)
 	}
 	return nil
 }
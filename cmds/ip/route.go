@@ -0,0 +1,342 @@
+// Copyright 2012-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	routeTables = map[int]string{
+		unix.RT_TABLE_DEFAULT: "default",
+		unix.RT_TABLE_MAIN:    "main",
+		unix.RT_TABLE_LOCAL:   "local",
+	}
+
+	routeProtocols = map[int]string{
+		unix.RTPROT_REDIRECT: "redirect",
+		unix.RTPROT_KERNEL:   "kernel",
+		unix.RTPROT_BOOT:     "boot",
+		unix.RTPROT_STATIC:   "static",
+		unix.RTPROT_RA:       "ra",
+		unix.RTPROT_DHCP:     "dhcp",
+	}
+
+	routeTypes = map[int]string{
+		unix.RTN_LOCAL:       "local",
+		unix.RTN_BROADCAST:   "broadcast",
+		unix.RTN_ANYCAST:     "anycast",
+		unix.RTN_MULTICAST:   "multicast",
+		unix.RTN_BLACKHOLE:   "blackhole",
+		unix.RTN_UNREACHABLE: "unreachable",
+		unix.RTN_PROHIBIT:    "prohibit",
+	}
+)
+
+type routeInfo struct {
+	Type     string `json:"type,omitempty"`
+	Dst      string `json:"dst"`
+	Gateway  string `json:"gateway,omitempty"`
+	Dev      string `json:"dev,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	PrefSrc  string `json:"prefsrc,omitempty"`
+	Metric   int    `json:"metric,omitempty"`
+	MTU      int    `json:"mtu,omitempty"`
+}
+
+// name returns the name of n in names, or n itself.
+func name(names map[int]string, n int) string {
+	if s, ok := names[n]; ok {
+		return s
+	}
+	return strconv.Itoa(n)
+}
+
+// number parses the next argument as a name in names or a number.
+func number(names map[int]string, what string) (int, error) {
+	cursor++
+	whatIWant = []string{what}
+	for n, s := range names {
+		if s == arg[cursor] {
+			return n, nil
+		}
+		whatIWant = append(whatIWant, s)
+	}
+	n, err := strconv.ParseUint(arg[cursor], 0, 32)
+	if err != nil {
+		return 0, usage()
+	}
+	return int(n), nil
+}
+
+// dstString formats the destination of a route like iproute2: host routes
+// have no prefix length.
+func dstString(dst *net.IPNet) string {
+	if dst == nil {
+		return "default"
+	}
+	ones, bits := dst.Mask.Size()
+	switch ones {
+	case 0:
+		return "default"
+	case bits:
+		return dst.IP.String()
+	}
+	return dst.String()
+}
+
+func newRouteInfo(r *netlink.Route, linkName func(int) string) routeInfo {
+	info := routeInfo{
+		Dst:    dstString(r.Dst),
+		Metric: r.Priority,
+		MTU:    r.MTU,
+	}
+	if r.Type != unix.RTN_UNICAST {
+		info.Type = name(routeTypes, r.Type)
+	}
+	if r.Gw != nil {
+		info.Gateway = r.Gw.String()
+	}
+	if r.LinkIndex != 0 {
+		info.Dev = linkName(r.LinkIndex)
+	}
+	if r.Table != 0 && r.Table != unix.RT_TABLE_MAIN {
+		info.Table = name(routeTables, r.Table)
+	}
+	if r.Protocol != 0 && r.Protocol != unix.RTPROT_BOOT {
+		info.Protocol = name(routeProtocols, r.Protocol)
+	}
+	if r.Scope != netlink.SCOPE_UNIVERSE {
+		info.Scope = addrScopes[r.Scope]
+	}
+	if r.Src != nil {
+		info.PrefSrc = r.Src.String()
+	}
+	return info
+}
+
+func (r routeInfo) String() string {
+	var b strings.Builder
+	if r.Type != "" {
+		b.WriteString(r.Type + " ")
+	}
+	b.WriteString(r.Dst)
+	for _, f := range []struct{ key, value string }{
+		{"via", r.Gateway},
+		{"dev", r.Dev},
+		{"table", r.Table},
+		{"proto", r.Protocol},
+		{"scope", r.Scope},
+		{"src", r.PrefSrc},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&b, " %s %s", f.key, f.value)
+		}
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&b, " metric %d", r.Metric)
+	}
+	if r.MTU != 0 {
+		fmt.Fprintf(&b, " mtu %d", r.MTU)
+	}
+	return b.String()
+}
+
+func showRoutes(w io.Writer, routes []netlink.Route) error {
+	infos := []routeInfo{}
+	for i := range routes {
+		infos = append(infos, newRouteInfo(&routes[i], linkName))
+	}
+	if *jsonOut {
+		return printJSON(w, infos)
+	}
+	for _, r := range infos {
+		fmt.Fprintln(w, r)
+	}
+	return nil
+}
+
+// routeFamily is the family routes are shown for. Like iproute2, it is
+// IPv4 unless -6 is given.
+func routeFamily() int {
+	if family == netlink.FAMILY_ALL {
+		return netlink.FAMILY_V4
+	}
+	return family
+}
+
+func routeshow() error {
+	filter := &netlink.Route{}
+	var mask uint64
+	for more() {
+		cursor++
+		whatIWant = []string{"table", "dev"}
+		switch one(arg[cursor], whatIWant) {
+		case "table":
+			if more() && arg[cursor+1] == "all" {
+				cursor++
+				filter.Table = unix.RT_TABLE_UNSPEC
+			} else {
+				t, err := number(routeTables, "table")
+				if err != nil {
+					return err
+				}
+				filter.Table = t
+			}
+			mask |= netlink.RT_FILTER_TABLE
+		case "dev":
+			cursor--
+			d, err := dev()
+			if err != nil {
+				return err
+			}
+			filter.LinkIndex = d.Attrs().Index
+			mask |= netlink.RT_FILTER_OIF
+		default:
+			return usage()
+		}
+	}
+	routes, err := netlink.RouteListFiltered(routeFamily(), filter, mask)
+	if err != nil {
+		return fmt.Errorf("Route show failed: %v", err)
+	}
+	return showRoutes(os.Stdout, routes)
+}
+
+// parseRoute parses a route specification for command c.
+func parseRoute(c string) (*netlink.Route, error) {
+	r := &netlink.Route{}
+	var scopeGiven bool
+	cursor++
+	whatIWant = []string{"default", "CIDR"}
+	dst := arg[cursor]
+	if t, ok := map[string]int{
+		"blackhole":   unix.RTN_BLACKHOLE,
+		"unreachable": unix.RTN_UNREACHABLE,
+		"prohibit":    unix.RTN_PROHIBIT,
+	}[dst]; ok {
+		r.Type = t
+		cursor++
+		dst = arg[cursor]
+	}
+
+	for more() {
+		cursor++
+		whatIWant = []string{"via", "dev", "src", "metric", "priority", "table", "scope", "proto", "mtu"}
+		var err error
+		switch one(arg[cursor], whatIWant) {
+		case "via":
+			cursor++
+			whatIWant = []string{"gateway address"}
+			r.Gw, err = parseIP(arg[cursor])
+		case "dev":
+			cursor--
+			var d netlink.Link
+			if d, err = dev(); err == nil {
+				r.LinkIndex = d.Attrs().Index
+			}
+		case "src":
+			cursor++
+			whatIWant = []string{"source address"}
+			r.Src, err = parseIP(arg[cursor])
+		case "metric", "priority":
+			r.Priority, err = integer("metric")
+		case "table":
+			r.Table, err = number(routeTables, "table")
+		case "scope":
+			r.Scope, err = scope()
+			scopeGiven = true
+		case "proto":
+			r.Protocol, err = number(routeProtocols, "protocol")
+		case "mtu":
+			r.MTU, err = integer("MTU")
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The default route is of the family of the gateway.
+	if dst == "default" && r.Gw != nil && r.Gw.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	var err error
+	if r.Dst, err = prefix(dst); err != nil {
+		return nil, err
+	}
+	switch {
+	case scopeGiven:
+	case c == "del" || c == "delete":
+		// Delete routes of any scope.
+		r.Scope = netlink.SCOPE_NOWHERE
+	case r.Gw == nil && r.LinkIndex != 0 && r.Type == 0:
+		// Like iproute2, routes without gateway are on the link.
+		r.Scope = netlink.SCOPE_LINK
+	}
+	return r, nil
+}
+
+func routeget() error {
+	cursor++
+	whatIWant = []string{"address"}
+	ip, err := parseIP(arg[cursor])
+	if err != nil {
+		return err
+	}
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return fmt.Errorf("Route get %v failed: %v", ip, err)
+	}
+	return showRoutes(os.Stdout, routes)
+}
+
+func route() error {
+	cursor++
+	if len(arg[cursor:]) == 0 {
+		cursor--
+		return routeshow()
+	}
+
+	whatIWant = []string{"show", "list", "add", "del", "delete", "replace", "get"}
+	c := one(arg[cursor], whatIWant)
+	switch c {
+	case "show", "list":
+		return routeshow()
+	case "get":
+		return routeget()
+	case "add", "del", "delete", "replace":
+	default:
+		return usage()
+	}
+
+	r, err := parseRoute(c)
+	if err != nil {
+		return err
+	}
+	switch c {
+	case "add":
+		err = netlink.RouteAdd(r)
+	case "del", "delete":
+		err = netlink.RouteDel(r)
+	case "replace":
+		err = netlink.RouteReplace(r)
+	}
+	if err != nil {
+		return fmt.Errorf("Route %s %s failed: %v", c, dstString(r.Dst), err)
+	}
+	return nil
+}
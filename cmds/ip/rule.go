@@ -0,0 +1,201 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+type ruleInfo struct {
+	Priority int    `json:"priority"`
+	Not      bool   `json:"not,omitempty"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst,omitempty"`
+	IifName  string `json:"iif,omitempty"`
+	OifName  string `json:"oif,omitempty"`
+	FwMark   string `json:"fwmark,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Goto     int    `json:"goto,omitempty"`
+}
+
+func newRuleInfo(r *netlink.Rule) ruleInfo {
+	info := ruleInfo{
+		Priority: r.Priority,
+		Not:      r.Invert,
+		Src:      "all",
+		IifName:  r.IifName,
+		OifName:  r.OifName,
+	}
+	// The kernel leaves out priority 0.
+	if info.Priority < 0 {
+		info.Priority = 0
+	}
+	if r.Src != nil {
+		info.Src = dstString(r.Src)
+		if info.Src == "default" {
+			info.Src = "all"
+		}
+	}
+	if r.Dst != nil {
+		info.Dst = dstString(r.Dst)
+	}
+	if r.Mark >= 0 {
+		info.FwMark = fmt.Sprintf("%#x", r.Mark)
+		if r.Mask >= 0 && uint32(r.Mask) != 0xffffffff {
+			info.FwMark += fmt.Sprintf("/%#x", r.Mask)
+		}
+	}
+	if r.Goto >= 0 {
+		info.Goto = r.Goto
+	} else if r.Table > 0 {
+		info.Table = name(routeTables, r.Table)
+	}
+	return info
+}
+
+func (r ruleInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:\t", r.Priority)
+	if r.Not {
+		b.WriteString("not ")
+	}
+	fmt.Fprintf(&b, "from %s", r.Src)
+	for _, f := range []struct{ key, value string }{
+		{"to", r.Dst},
+		{"fwmark", r.FwMark},
+		{"iif", r.IifName},
+		{"oif", r.OifName},
+		{"lookup", r.Table},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&b, " %s %s", f.key, f.value)
+		}
+	}
+	if r.Goto != 0 {
+		fmt.Fprintf(&b, " goto %d", r.Goto)
+	}
+	return b.String()
+}
+
+func showRules(w io.Writer) error {
+	rules, err := netlink.RuleList(routeFamily())
+	if err != nil {
+		return fmt.Errorf("Rule show failed: %v", err)
+	}
+	infos := []ruleInfo{}
+	for i := range rules {
+		infos = append(infos, newRuleInfo(&rules[i]))
+	}
+	if *jsonOut {
+		return printJSON(w, infos)
+	}
+	for _, r := range infos {
+		fmt.Fprintln(w, r)
+	}
+	return nil
+}
+
+// parseRule parses a rule specification.
+func parseRule() (*netlink.Rule, error) {
+	r := netlink.NewRule()
+	r.Family = routeFamily()
+	for more() {
+		cursor++
+		whatIWant = []string{"not", "from", "to", "iif", "oif", "fwmark", "priority", "preference", "table", "lookup"}
+		var err error
+		switch one(arg[cursor], whatIWant) {
+		case "not":
+			r.Invert = true
+		case "from":
+			cursor++
+			whatIWant = []string{"all", "prefix"}
+			if arg[cursor] != "all" {
+				r.Src, err = prefix(arg[cursor])
+			}
+		case "to":
+			cursor++
+			whatIWant = []string{"all", "prefix"}
+			if arg[cursor] != "all" {
+				r.Dst, err = prefix(arg[cursor])
+			}
+		case "iif":
+			cursor++
+			whatIWant = []string{"device name"}
+			r.IifName = arg[cursor]
+		case "oif":
+			cursor++
+			whatIWant = []string{"device name"}
+			r.OifName = arg[cursor]
+		case "fwmark":
+			cursor++
+			whatIWant = []string{"mark[/mask]"}
+			mark := strings.SplitN(arg[cursor], "/", 2)
+			var n uint64
+			if n, err = strconv.ParseUint(mark[0], 0, 32); err != nil {
+				break
+			}
+			r.Mark = int(n)
+			if len(mark) == 2 {
+				if n, err = strconv.ParseUint(mark[1], 0, 32); err == nil {
+					r.Mask = int(n)
+				}
+			}
+		case "priority", "preference":
+			r.Priority, err = integer("priority")
+		case "table", "lookup":
+			r.Table, err = number(routeTables, "table")
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Src != nil && r.Src.IP.To4() == nil || r.Dst != nil && r.Dst.IP.To4() == nil {
+		r.Family = netlink.FAMILY_V6
+	}
+	return r, nil
+}
+
+func rule() error {
+	cursor++
+	if len(arg[cursor:]) == 0 {
+		return showRules(os.Stdout)
+	}
+
+	whatIWant = []string{"show", "list", "add", "del", "delete"}
+	c := one(arg[cursor], whatIWant)
+	switch c {
+	case "show", "list":
+		return showRules(os.Stdout)
+	case "add", "del", "delete":
+	default:
+		return usage()
+	}
+
+	r, err := parseRule()
+	if err != nil {
+		return err
+	}
+	if c == "add" {
+		if r.Table <= 0 {
+			whatIWant = []string{"table"}
+			return fmt.Errorf("rule add needs a table")
+		}
+		err = netlink.RuleAdd(r)
+	} else {
+		err = netlink.RuleDel(r)
+	}
+	if err != nil {
+		return fmt.Errorf("Rule %s failed: %v", c, err)
+	}
+	return nil
+}
@@ -3,72 +3,520 @@
 // license that can be found in the LICENSE file.
 
 // Netcat pipes over the network.
+//
+// Synopsis:
+//     netcat [OPTIONS] HOST PORT
+//     netcat [OPTIONS] HOST:PORT
+//     netcat -l [OPTIONS] [HOST] [PORT]
+//     netcat -U [OPTIONS] PATH
+//     netcat -z [OPTIONS] HOST PORT[-PORT]...
+//
+// Description:
+//     netcat connects to HOST PORT, or with -l waits for a connection, and
+//     copies stdin to it and what it receives to stdout. When stdin ends,
+//     netcat closes its side of the connection and keeps reading until the
+//     peer closes its side.
+//
+//     With -e or -c, a program's stdin and stdout are connected instead.
+//     With -k, netcat keeps listening after a connection closes; with -e
+//     or -c, it serves many connections at once, each with its own program.
+//
+//     With -z, netcat reports which of the TCP ports are open.
+//
+// Options:
+//     -l:               listen for connections
+//     -k:               keep listening after a connection closes
+//     -p PORT:          port to listen on
+//     -u:               use UDP
+//     -U:               use UNIX domain sockets
+//     -4, -6:           use IPv4 or IPv6 only
+//     -e PROG:          run PROG, split at spaces, with stdin and stdout on the connection
+//     -c CMD:           run CMD with /bin/sh, with stdin and stdout on the connection
+//     -w SECS:          time out connecting and idle connections after SECS
+//     -z:               scan for open ports
+//     -ssl:             use TLS
+//     -ssl-cert FILE:   certificate to listen with; without, one is made up
+//     -ssl-key FILE:    key of the certificate
+//     -ssl-verify:      verify the server's certificate
+//     -ssl-trustfile FILE: trust the CAs in FILE; implies -ssl-verify
+//     -net NET:         Go network to use, e.g. tcp, unix
+//     -v:               verbose output
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/u-root/u-root/pkg/uroot/util"
 )
 
-const usage = "netcat [go-style network address]"
+const usage = "netcat [options] host port | netcat -l [options] [host] [port] | netcat -U [options] path"
 
 var (
 	netType = flag.String("net", "tcp", "What net type to use, e.g. tcp, unix, etc.")
 	listen  = flag.Bool("l", false, "Listen for connections.")
 	verbose = flag.Bool("v", false, "Verbose output.")
+
+	keepOpen = flag.Bool("k", false, "Keep listening after a connection closes.")
+	port     = flag.String("p", "", "Port to listen on.")
+	udp      = flag.Bool("u", false, "Use UDP.")
+	unixSock = flag.Bool("U", false, "Use UNIX domain sockets.")
+	inet4    = flag.Bool("4", false, "Use IPv4 only.")
+	inet6    = flag.Bool("6", false, "Use IPv6 only.")
+	execProg = flag.String("e", "", "Run this program, split at spaces, with stdin and stdout on the connection.")
+	execCmd  = flag.String("c", "", "Run this command with /bin/sh, with stdin and stdout on the connection.")
+	timeout  = flag.Int("w", 0, "Time out connecting and idle connections after this many seconds.")
+	scan     = flag.Bool("z", false, "Scan for open ports.")
+
+	useTLS    = flag.Bool("ssl", false, "Use TLS.")
+	tlsCert   = flag.String("ssl-cert", "", "Certificate to listen with; without, one is made up.")
+	tlsKey    = flag.String("ssl-key", "", "Key of the certificate.")
+	tlsVerify = flag.Bool("ssl-verify", false, "Verify the server's certificate.")
+	tlsTrust  = flag.String("ssl-trustfile", "", "Trust the CAs in this file; implies -ssl-verify.")
 )
 
 func init() {
 	util.Usage(usage)
 }
 
-func main() {
-	var c net.Conn
-	var err error
-	if flag.Parse(); len(flag.Args()) != 1 {
-		flag.Usage()
-		os.Exit(1)
+func logf(format string, v ...interface{}) {
+	if *verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", v...)
 	}
+}
 
-	addr := flag.Args()[0]
+// network returns the Go network the flags ask for.
+func network() string {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "net"
+	})
+	if explicit {
+		return *netType
+	}
 
-	if *listen {
-		ln, err := net.Listen(*netType, addr)
-		if err != nil {
-			log.Fatalln(err)
+	n := "tcp"
+	if *udp {
+		n = "udp"
+	}
+	switch {
+	case *unixSock && *udp:
+		return "unixgram"
+	case *unixSock:
+		return "unix"
+	case *inet4:
+		return n + "4"
+	case *inet6:
+		return n + "6"
+	}
+	return n
+}
+
+func isUnix(network string) bool {
+	return strings.HasPrefix(network, "unix")
+}
+
+func isPacket(network string) bool {
+	return strings.HasPrefix(network, "udp") || network == "unixgram"
+}
+
+// address returns the address in args, which are HOST PORT, HOST:PORT, or
+// a path for UNIX sockets. When listening, the host may be left out, and
+// the port may be given with -p instead.
+func address(network string, args []string, listen bool, port string) (string, error) {
+	if isUnix(network) {
+		if len(args) != 1 {
+			return "", errors.New("want a path")
 		}
-		if *verbose {
-			fmt.Fprintln(os.Stderr, "Listening on", ln.Addr())
+		return args[0], nil
+	}
+	switch {
+	case len(args) == 2 && port == "":
+		return net.JoinHostPort(args[0], args[1]), nil
+	case len(args) == 1 && port != "":
+		return net.JoinHostPort(args[0], port), nil
+	case len(args) == 0 && port != "" && listen:
+		return net.JoinHostPort("", port), nil
+	case len(args) == 1:
+		if _, _, err := net.SplitHostPort(args[0]); err == nil {
+			return args[0], nil
 		}
+		if _, err := strconv.ParseUint(args[0], 10, 16); err == nil && listen {
+			return net.JoinHostPort("", args[0]), nil
+		}
+	}
+	return "", errors.New("want a host and a port")
+}
 
-		c, err = ln.Accept()
+// ports parses ports and port ranges, like 22 or 8000-8080.
+func ports(args []string) ([]int, error) {
+	var ps []int
+	for _, a := range args {
+		r := strings.SplitN(a, "-", 2)
+		lo, err := strconv.ParseUint(r[0], 10, 16)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, fmt.Errorf("invalid port %q", a)
 		}
-	} else {
-		if c, err = net.Dial(*netType, addr); err != nil {
-			log.Fatalln(err)
+		hi := lo
+		if len(r) == 2 {
+			if hi, err = strconv.ParseUint(r[1], 10, 16); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid port range %q", a)
+			}
+		}
+		for p := lo; p <= hi; p++ {
+			ps = append(ps, int(p))
 		}
 	}
-	if *verbose {
-		fmt.Fprintln(os.Stderr, "Connected to", c.RemoteAddr())
+	return ps, nil
+}
+
+// scanPorts reports which ports of host are open. It returns false if none
+// are.
+func scanPorts(network, host string, ps []int, d time.Duration) bool {
+	open := false
+	for _, p := range ps {
+		addr := net.JoinHostPort(host, strconv.Itoa(p))
+		c, err := net.DialTimeout(network, addr, d)
+		if err != nil {
+			logf("nc: connect to %s port %d (%s) failed: %v", host, p, network, err)
+			continue
+		}
+		c.Close()
+		open = true
+		fmt.Fprintf(os.Stderr, "Connection to %s %d port [%s] succeeded!\n", host, p, network)
 	}
+	return open
+}
 
+// closeWriter is a connection that can be half closed.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// idleConn is a connection whose reads time out when it is idle.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// input is stdin, shared by the connections of -k one after the other.
+type input struct {
+	chunks chan []byte
+}
+
+func newInput(r io.Reader) *input {
+	in := &input{chunks: make(chan []byte)}
 	go func() {
-		if _, err := io.Copy(c, os.Stdin); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+		defer close(in.chunks)
+		for {
+			b := make([]byte, 32*1024)
+			n, err := r.Read(b)
+			if n > 0 {
+				in.chunks <- b[:n]
+			}
+			if err != nil {
+				return
+			}
 		}
 	}()
-	if _, err = io.Copy(os.Stdout, c); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	return in
+}
+
+// copyTo copies input to c until input ends or done is closed. When input
+// ends, c is half closed.
+func (in *input) copyTo(c net.Conn, done <-chan struct{}) {
+	for {
+		select {
+		case b, ok := <-in.chunks:
+			if !ok {
+				if cw, ok := c.(closeWriter); ok {
+					cw.CloseWrite()
+				}
+				return
+			}
+			if _, err := c.Write(b); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
 	}
-	if *verbose {
-		fmt.Fprintln(os.Stderr, "Disconnected")
+}
+
+// closeWait is how long pipe keeps copying input after c has no more to
+// read.
+const closeWait = 500 * time.Millisecond
+
+// pipe copies in to c and c to out, until c has no more to read.
+func pipe(c net.Conn, in *input, out io.Writer) error {
+	done := make(chan struct{})
+	defer close(done)
+	sent := make(chan struct{})
+	go func() {
+		in.copyTo(c, done)
+		close(sent)
+	}()
+	_, err := io.Copy(out, c)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = nil
+	}
+	// The peer may have closed before reading all input.
+	select {
+	case <-sent:
+	case <-time.After(closeWait):
+	}
+	return err
+}
+
+// command returns the program -e or -c asks for, or nil.
+func command() *exec.Cmd {
+	switch {
+	case *execProg != "":
+		args := strings.Fields(*execProg)
+		return exec.Command(args[0], args[1:]...)
+	case *execCmd != "":
+		return exec.Command("/bin/sh", "-c", *execCmd)
+	}
+	return nil
+}
+
+// run runs cmd with its stdin and stdout on c.
+func run(cmd *exec.Cmd, c net.Conn) error {
+	cmd.Stdout = c
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Wait does not wait for this copy, which only ends when c does.
+	go func() {
+		io.Copy(stdin, c)
+		stdin.Close()
+	}()
+	err = cmd.Wait()
+	if cw, ok := c.(closeWriter); ok {
+		cw.CloseWrite()
+	}
+	return err
+}
+
+// handle serves the connection c with the program of -e or -c, or stdio.
+func handle(c net.Conn, in *input) error {
+	logf("Connected to %v", c.RemoteAddr())
+	defer logf("Disconnected")
+	if *timeout > 0 {
+		c = &idleConn{Conn: c, timeout: time.Duration(*timeout) * time.Second}
+	}
+	if cmd := command(); cmd != nil {
+		return run(cmd, c)
+	}
+	return pipe(c, in, os.Stdout)
+}
+
+// packetConn is the exchange of datagrams with the first peer that sent
+// one to a listening socket.
+type packetConn struct {
+	net.PacketConn
+	peer  net.Addr
+	first []byte
+}
+
+func acceptPacket(pc net.PacketConn) (*packetConn, error) {
+	b := make([]byte, 65536)
+	n, peer, err := pc.ReadFrom(b)
+	if err != nil {
+		return nil, err
+	}
+	return &packetConn{PacketConn: pc, peer: peer, first: b[:n]}, nil
+}
+
+func (c *packetConn) Read(p []byte) (int, error) {
+	if c.first != nil {
+		n := copy(p, c.first)
+		c.first = nil
+		return n, nil
+	}
+	for {
+		n, peer, err := c.ReadFrom(p)
+		if err != nil || peer.String() == c.peer.String() {
+			return n, err
+		}
+	}
+}
+
+func (c *packetConn) Write(p []byte) (int, error) {
+	return c.WriteTo(p, c.peer)
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+// serve listens on addr and handles connections.
+func serve(network, addr string, in *input) error {
+	if isPacket(network) {
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		defer pc.Close()
+		logf("Listening on %v", pc.LocalAddr())
+		c, err := acceptPacket(pc)
+		if err != nil {
+			return err
+		}
+		return handle(c, in)
+	}
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	if *useTLS {
+		if ln, err = tlsListener(ln); err != nil {
+			return err
+		}
+	}
+	logf("Listening on %v", ln.Addr())
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		if !*keepOpen {
+			defer c.Close()
+			return handle(c, in)
+		}
+		if command() == nil {
+			// There is one stdin, so take turns.
+			if err := handle(c, in); err != nil {
+				log.Print(err)
+			}
+			c.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.Close()
+			if err := handle(c, in); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+}
+
+// dial connects to addr.
+func dial(network, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: time.Duration(*timeout) * time.Second}
+	c, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if *useTLS {
+		return tlsClient(c, addr)
+	}
+	return c, nil
+}
+
+// parse parses the flags and returns the other arguments. Unlike with
+// flag.Parse, flags may follow them.
+func parse(args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := flag.CommandLine.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flag.Args()
+		if len(args) == 0 {
+			return rest, nil
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+func main() {
+	args, err := parse(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	network := network()
+	if *useTLS && isPacket(network) {
+		log.Fatal("TLS needs a stream socket")
+	}
+	if *execProg != "" && *execCmd != "" {
+		log.Fatal("only one of -e and -c may be given")
+	}
+
+	if *scan {
+		if len(args) < 2 || isUnix(network) || isPacket(network) {
+			flag.Usage()
+			os.Exit(1)
+		}
+		ps, err := ports(args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		d := time.Duration(*timeout) * time.Second
+		if d == 0 {
+			d = 5 * time.Second
+		}
+		if !scanPorts(network, args[0], ps, d) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	addr, err := address(network, args, *listen, *port)
+	if err != nil {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	in := newInput(os.Stdin)
+	if *listen {
+		err = serve(network, addr, in)
+	} else {
+		var c net.Conn
+		if c, err = dial(network, addr); err != nil {
+			log.Fatalln(err)
+		}
+		err = handle(c, in)
+		c.Close()
+	}
+	if err != nil {
+		log.Fatalln(err)
 	}
 }
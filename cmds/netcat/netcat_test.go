@@ -0,0 +1,228 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	for _, tt := range []struct {
+		network string
+		args    []string
+		listen  bool
+		port    string
+		want    string
+	}{
+		{"tcp", []string{"localhost", "80"}, false, "", "localhost:80"},
+		{"tcp", []string{"localhost:80"}, false, "", "localhost:80"},
+		{"tcp6", []string{"::1", "80"}, false, "", "[::1]:80"},
+		{"tcp", []string{"[::1]:80"}, false, "", "[::1]:80"},
+		{"tcp", nil, true, "8080", ":8080"},
+		{"tcp", []string{"127.0.0.1"}, true, "8080", "127.0.0.1:8080"},
+		{"tcp", []string{"8080"}, true, "", ":8080"},
+		{"tcp", []string{":8080"}, true, "", ":8080"},
+		{"unix", []string{"/tmp/sock"}, false, "", "/tmp/sock"},
+	} {
+		got, err := address(tt.network, tt.args, tt.listen, tt.port)
+		if err != nil || got != tt.want {
+			t.Errorf("address(%q, %q, %t, %q) = %q, %v, want %q", tt.network, tt.args, tt.listen, tt.port, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		network string
+		args    []string
+		listen  bool
+	}{
+		{"tcp", nil, false},
+		{"tcp", []string{"localhost"}, false},
+		{"tcp", []string{"8080"}, false},
+		{"tcp", []string{"a", "b", "c"}, false},
+		{"unix", nil, true},
+	} {
+		if got, err := address(tt.network, tt.args, tt.listen, ""); err == nil {
+			t.Errorf("address(%q, %q, %t) = %q, want error", tt.network, tt.args, tt.listen, got)
+		}
+	}
+}
+
+func TestPorts(t *testing.T) {
+	got, err := ports([]string{"22", "80-82"})
+	if want := []int{22, 80, 81, 82}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ports = %v, %v, want %v", got, err, want)
+	}
+	for _, bad := range []string{"x", "82-80", "1-x", "65536"} {
+		if _, err := ports([]string{bad}); err == nil {
+			t.Errorf("ports(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+// serveOne accepts one connection on a new listener, reads all of it, and
+// answers with what it read in upper case.
+func serveOne(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		c, err := ln.Accept()
+		if err != nil {
+			read <- err.Error()
+			return
+		}
+		defer c.Close()
+		b, _ := ioutil.ReadAll(c)
+		read <- string(b)
+		c.Write(bytes.ToUpper(b))
+	}()
+	return ln.Addr().String(), read
+}
+
+func TestPipeHalfClose(t *testing.T) {
+	addr, read := serveOne(t)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The server only answers once the input has ended.
+	var out bytes.Buffer
+	if err := pipe(c, newInput(strings.NewReader("hello")), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-read; got != "hello" {
+		t.Errorf("server read %q, want %q", got, "hello")
+	}
+	if out.String() != "HELLO" {
+		t.Errorf("pipe wrote %q, want %q", out.String(), "HELLO")
+	}
+}
+
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("no cat")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer c.Close()
+		errc <- run(exec.Command("cat"), c)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "meow")
+	c.(*net.TCPConn).CloseWrite()
+	b, err := ioutil.ReadAll(c)
+	if err != nil || string(b) != "meow" {
+		t.Errorf("cat answered %q, %v, want %q", b, err, "meow")
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("run = %v", err)
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	peer, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	other, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	io.WriteString(peer, "first")
+	c, err := acceptPacket(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteAddr().String() != peer.LocalAddr().String() {
+		t.Errorf("peer is %v, want %v", c.RemoteAddr(), peer.LocalAddr())
+	}
+
+	// Datagrams of others are dropped.
+	io.WriteString(other, "other")
+	io.WriteString(peer, "second")
+	b := make([]byte, 100)
+	for _, want := range []string{"first", "second"} {
+		n, err := c.Read(b)
+		if err != nil || string(b[:n]) != want {
+			t.Errorf("Read = %q, %v, want %q", b[:n], err, want)
+		}
+	}
+
+	io.WriteString(c, "answer")
+	n, err := peer.Read(b)
+	if err != nil || string(b[:n]) != "answer" {
+		t.Errorf("peer read %q, %v, want %q", b[:n], err, "answer")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tln, err := tlsListener(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tln.Close()
+	go func() {
+		c, err := tln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc, err := tlsClient(c, ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+	io.WriteString(tc, "secret")
+	b := make([]byte, 6)
+	if _, err := io.ReadFull(tc, b); err != nil || string(b) != "secret" {
+		t.Errorf("echo = %q, %v, want %q", b, err, "secret")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// netconf configures networking from the kernel command line and files.
+//
+// Synopsis:
+//
+//	netconf [OPTIONS...]
+//
+// Description:
+//
+//	netconf applies the ip=, nameserver=, bond=, vlan= and bridge=
+//	parameters of the kernel command line, like dracut, and then the
+//	YAML or JSON configuration files given with -config.
+//
+// Options:
+//
+//	-cmdline: apply the kernel command line (default true)
+//	-config:  configuration file; may be given more than once
+//	-n:       print the configuration instead of applying it
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/netconfig"
+)
+
+type files []string
+
+func (f *files) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *files) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+var (
+	useCmdline = flag.Bool("cmdline", true, "apply the kernel command line")
+	dryRun     = flag.Bool("n", false, "print the configuration instead of applying it")
+	configs    files
+)
+
+func init() {
+	flag.Var(&configs, "config", "configuration file; may be given more than once")
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := &netconfig.Config{}
+	if *useCmdline {
+		cc, err := netconfig.FromCmdline()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Merge(cc)
+	}
+	for _, path := range configs {
+		fc, err := netconfig.Load(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Merge(fc)
+	}
+
+	if *dryRun {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(c); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := c.Apply(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+// From linux/errqueue.h.
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+
+	// sizeofSockExtendedErr is the size of struct sock_extended_err. The
+	// address of the node that sent the error follows it.
+	sizeofSockExtendedErr = 16
+)
+
+// conn is an ICMP socket. Raw sockets need CAP_NET_RAW, so unprivileged
+// users get a ping socket, a datagram socket that only sends echo
+// requests and only receives their replies. Errors about them arrive on
+// the socket's error queue.
+type conn struct {
+	c   net.PacketConn
+	rc  syscall.RawConn
+	v6  bool
+	raw bool
+
+	// id is the identifier of the requests. The kernel replaces it with
+	// the port of ping sockets, and only passes them their replies.
+	id uint16
+}
+
+// listen opens a raw ICMP socket, or a ping socket if that is not
+// permitted.
+func listen(v6 bool) (*conn, error) {
+	network, family, proto := "ip4:icmp", syscall.AF_INET, syscall.IPPROTO_ICMP
+	if v6 {
+		network, family, proto = "ip6:ipv6-icmp", syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+
+	c := &conn{v6: v6, raw: true, id: uint16(os.Getpid())}
+	pc, rawErr := net.ListenPacket(network, "")
+	if rawErr != nil {
+		fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+		if err != nil {
+			return nil, fmt.Errorf("%v; and no ping socket: %v", rawErr, os.NewSyscallError("socket", err))
+		}
+		f := os.NewFile(uintptr(fd), "ping")
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.raw = false
+	}
+	c.c = pc
+
+	var err error
+	if c.rc, err = pc.(syscall.Conn).SyscallConn(); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	opts := [][2]int{{syscall.IPPROTO_IP, syscall.IP_RECVTTL}}
+	if !c.raw {
+		opts = append(opts, [2]int{syscall.IPPROTO_IP, syscall.IP_RECVERR})
+	}
+	if v6 {
+		opts = [][2]int{{syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT}}
+		if !c.raw {
+			opts = append(opts, [2]int{syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR})
+		}
+	}
+	for _, o := range opts {
+		if err := c.setsockopt(o[0], o[1], 1); err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *conn) setsockopt(level, opt, value int) error {
+	var serr error
+	if err := c.rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+// setTTL sets the TTL or hop limit of the requests.
+func (c *conn) setTTL(ttl int) error {
+	if c.v6 {
+		return c.setsockopt(syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return c.setsockopt(syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+func (c *conn) send(b []byte, dst *net.IPAddr) error {
+	var to net.Addr = dst
+	if !c.raw {
+		to = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	}
+	_, err := c.c.WriteTo(b, to)
+	return err
+}
+
+func (c *conn) setDeadline(t time.Time) error {
+	return c.c.SetReadDeadline(t)
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// recv returns the next reply or error about an echo request. Replies to
+// other pings are skipped. Raw sockets see those too.
+func (c *conn) recv() (*reply, error) {
+	buf := make([]byte, 65536)
+	oob := make([]byte, 512)
+	for {
+		var (
+			n, oobn int
+			from    syscall.Sockaddr
+			errq    bool
+			rerr    error
+		)
+		err := c.rc.Read(func(fd uintptr) bool {
+			// A pending error makes recvmsg fail once; the details
+			// are on the error queue.
+			for i := 0; i < 2; i++ {
+				if !c.raw {
+					n, oobn, _, from, rerr = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+					if rerr == nil {
+						errq = true
+						return true
+					}
+				}
+				n, oobn, _, from, rerr = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_DONTWAIT)
+				if rerr == syscall.EAGAIN {
+					return false
+				}
+				if rerr == nil || c.raw {
+					return true
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rerr != nil && !c.raw {
+			// The error queue had the details already.
+			continue
+		}
+		if rerr != nil {
+			return nil, os.NewSyscallError("recvmsg", rerr)
+		}
+
+		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, err
+		}
+		var r *reply
+		var ok bool
+		if errq {
+			r, ok = c.queuedError(buf[:n], cmsgs)
+		} else {
+			b := buf[:n]
+			if c.raw && !c.v6 {
+				// Raw IPv4 sockets get the IP header.
+				if len(b) < net.IPv4len {
+					continue
+				}
+				hl := int(b[0]&0x0f) * 4
+				if len(b) < hl {
+					continue
+				}
+				b = b[hl:]
+			}
+			if r, ok = parseICMP(c.v6, b); ok {
+				r.from = sockaddrIP(from)
+			}
+		}
+		if !ok || c.raw && r.id != c.id {
+			continue
+		}
+		for _, m := range cmsgs {
+			if len(m.Data) < 4 {
+				continue
+			}
+			if m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TTL ||
+				m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_HOPLIMIT {
+				r.ttl = int(int32(ubinary.NativeEndian.Uint32(m.Data)))
+			}
+		}
+		return r, nil
+	}
+}
+
+// queuedError returns the error about the echo request b from the error
+// queue.
+func (c *conn) queuedError(b []byte, cmsgs []syscall.SocketControlMessage) (*reply, bool) {
+	if len(b) < icmpHeaderLen {
+		return nil, false
+	}
+	for _, m := range cmsgs {
+		if !(m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR ||
+			m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) {
+			continue
+		}
+		if len(m.Data) < sizeofSockExtendedErr {
+			continue
+		}
+		origin, typ, code := m.Data[4], m.Data[5], m.Data[6]
+		if origin != soEEOriginICMP && origin != soEEOriginICMP6 {
+			continue
+		}
+		// Make up the ICMP error with the echo request it quotes, as
+		// it arrived.
+		var msg []byte
+		if c.v6 {
+			msg = make([]byte, icmpHeaderLen+ipv6HeaderLen)
+			msg[icmpHeaderLen+ipv6NextHdrOffset] = protocolICMPv6
+		} else {
+			msg = make([]byte, icmpHeaderLen+net.IPv4len*5)
+			msg[icmpHeaderLen] = 0x45
+			msg[icmpHeaderLen+ipv4ProtocolOffset] = protocolICMP
+		}
+		msg[0], msg[1] = typ, code
+		r, ok := parseICMP(c.v6, append(msg, b...))
+		if !ok {
+			return nil, false
+		}
+		r.len = len(b)
+		r.from = offender(m.Data[sizeofSockExtendedErr:])
+		return r, true
+	}
+	return nil, false
+}
+
+// offender returns the address of the node that sent an error, a struct
+// sockaddr_in or sockaddr_in6.
+func offender(b []byte) net.IP {
+	if len(b) < 2 {
+		return nil
+	}
+	switch ubinary.NativeEndian.Uint16(b) {
+	case syscall.AF_INET:
+		if len(b) >= syscall.SizeofSockaddrInet4 {
+			return net.IP(append([]byte{}, b[4:8]...))
+		}
+	case syscall.AF_INET6:
+		if len(b) >= syscall.SizeofSockaddrInet6 {
+			return net.IP(append([]byte{}, b[8:24]...))
+		}
+	}
+	return nil
+}
+
+func sockaddrIP(sa syscall.Sockaddr) net.IP {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(append([]byte{}, sa.Addr[:]...))
+	case *syscall.SockaddrInet6:
+		return net.IP(append([]byte{}, sa.Addr[:]...))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ICMP types, RFC 792 and RFC 4443.
+const (
+	icmpEchoReply    = 0
+	icmpDestUnreach  = 3
+	icmpEchoRequest  = 8
+	icmpTimeExceeded = 11
+
+	icmp6DestUnreach  = 1
+	icmp6PacketTooBig = 2
+	icmp6TimeExceeded = 3
+	icmp6EchoRequest  = 128
+	icmp6EchoReply    = 129
+)
+
+const (
+	icmpHeaderLen = 8
+	ipv6HeaderLen = 40
+
+	// Offsets of the protocol and next header fields.
+	ipv4ProtocolOffset = 9
+	ipv6NextHdrOffset  = 6
+
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// kind is what an ICMP message tells about an echo request.
+type kind int
+
+const (
+	echoReply kind = iota
+	timeExceeded
+	unreachable
+)
+
+// reply is an answer to an echo request.
+type reply struct {
+	kind kind
+	typ  uint8
+	code uint8
+	v6   bool
+
+	from net.IP
+	// ttl is the TTL or hop limit the reply arrived with, or -1.
+	ttl int
+	// len is the length of the ICMP message.
+	len int
+
+	id  uint16
+	seq uint16
+}
+
+func cksum(bs []byte) uint16 {
+	sum := uint32(0)
+
+	for k := 0; k < len(bs)/2; k++ {
+		sum += uint32(bs[k*2]) << 8
+		sum += uint32(bs[k*2+1])
+	}
+	if len(bs)%2 != 0 {
+		sum += uint32(bs[len(bs)-1]) << 8
+	}
+	sum = (sum >> 16) + (sum & 0xffff)
+	sum = (sum >> 16) + (sum & 0xffff)
+	if sum == 0xffff {
+		sum = 0
+	}
+
+	return ^uint16(sum)
+}
+
+// echoRequest returns an echo request with data. The ICMPv6 checksum
+// covers a pseudo header, so the kernel computes it.
+func echoRequest(v6 bool, id, seq uint16, data []byte) []byte {
+	msg := make([]byte, icmpHeaderLen+len(data))
+	msg[0] = icmpEchoRequest
+	if v6 {
+		msg[0] = icmp6EchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[icmpHeaderLen:], data)
+	if !v6 {
+		binary.BigEndian.PutUint16(msg[2:], cksum(msg))
+	}
+	return msg
+}
+
+// parseICMP parses the ICMP message b. It returns false if b is not about
+// an echo request: errors carry the start of the request that caused
+// them.
+func parseICMP(v6 bool, b []byte) (*reply, bool) {
+	if len(b) < icmpHeaderLen {
+		return nil, false
+	}
+	r := &reply{typ: b[0], code: b[1], v6: v6, ttl: -1, len: len(b)}
+	switch {
+	case !v6 && r.typ == icmpEchoReply, v6 && r.typ == icmp6EchoReply:
+		r.kind = echoReply
+		r.id = binary.BigEndian.Uint16(b[4:])
+		r.seq = binary.BigEndian.Uint16(b[6:])
+		return r, true
+	case !v6 && r.typ == icmpTimeExceeded, v6 && r.typ == icmp6TimeExceeded:
+		r.kind = timeExceeded
+	case !v6 && r.typ == icmpDestUnreach, v6 && (r.typ == icmp6DestUnreach || r.typ == icmp6PacketTooBig):
+		r.kind = unreachable
+	default:
+		return nil, false
+	}
+
+	req, ok := quotedRequest(v6, b[icmpHeaderLen:])
+	if !ok {
+		return nil, false
+	}
+	r.id = binary.BigEndian.Uint16(req[4:])
+	r.seq = binary.BigEndian.Uint16(req[6:])
+	return r, true
+}
+
+// quotedRequest returns the echo request in the packet quoted by an ICMP
+// error.
+func quotedRequest(v6 bool, b []byte) ([]byte, bool) {
+	if v6 {
+		if len(b) < ipv6HeaderLen+icmpHeaderLen || b[ipv6NextHdrOffset] != protocolICMPv6 {
+			return nil, false
+		}
+		b = b[ipv6HeaderLen:]
+		return b, b[0] == icmp6EchoRequest
+	}
+	if len(b) < net.IPv4len {
+		return nil, false
+	}
+	hl := int(b[0]&0x0f) * 4
+	if len(b) < hl+icmpHeaderLen || b[ipv4ProtocolOffset] != protocolICMP {
+		return nil, false
+	}
+	b = b[hl:]
+	return b, b[0] == icmpEchoRequest
+}
+
+// String describes an error as ping does.
+func (r *reply) String() string {
+	switch {
+	case r.kind == echoReply:
+		return "Echo Reply"
+	case r.kind == timeExceeded:
+		return "Time to live exceeded"
+	case r.v6 && r.typ == icmp6PacketTooBig:
+		return "Packet too big"
+	case r.v6:
+		switch r.code {
+		case 0:
+			return "Destination unreachable: No route"
+		case 1:
+			return "Destination unreachable: Administratively prohibited"
+		case 3:
+			return "Destination unreachable: Address unreachable"
+		case 4:
+			return "Destination unreachable: Port unreachable"
+		}
+	default:
+		switch r.code {
+		case 0:
+			return "Destination Net Unreachable"
+		case 1:
+			return "Destination Host Unreachable"
+		case 2:
+			return "Destination Protocol Unreachable"
+		case 3:
+			return "Destination Port Unreachable"
+		case 4:
+			return "Frag needed and DF set"
+		case 13:
+			return "Communication prohibited by filter"
+		}
+	}
+	return fmt.Sprintf("Destination unreachable: Unknown code %d", r.code)
+}
+
+// mark is the traceroute annotation of an unreachable hop.
+func (r *reply) mark() string {
+	if r.kind != unreachable {
+		return ""
+	}
+	if r.v6 {
+		switch {
+		case r.typ == icmp6PacketTooBig:
+			return "!F"
+		case r.code == 0:
+			return "!N"
+		case r.code == 1:
+			return "!X"
+		case r.code == 3:
+			return "!H"
+		case r.code == 4:
+			return "!P"
+		}
+	} else {
+		switch r.code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!H"
+		case 2:
+			return "!P"
+		case 4:
+			return "!F"
+		case 13:
+			return "!X"
+		}
+	}
+	return fmt.Sprintf("!<%d>", r.code)
+}
@@ -5,47 +5,72 @@
 // Send icmp packets to a server to test network connectivity.
 //
 // Synopsis:
-//     ping [-hV] [-c COUNT] [-i INTERVAL] [-s PACKETSIZE] [-w DEADLINE] DESTINATION
+//     ping [-46fhTV] [-c COUNT] [-i INTERVAL] [-m MAXTTL] [-p PATTERN] [-s PACKETSIZE] [-t TTL] [-w DEADLINE] [-W WAIT] DESTINATION
+//
+// Description:
+//     ping sends ICMP or ICMPv6 echo requests to DESTINATION and shows the
+//     replies. When interrupted or done, it shows statistics.
+//
+//     Without CAP_NET_RAW, ping uses an unprivileged ping socket. Their use
+//     is limited to the groups in /proc/sys/net/ipv4/ping_group_range.
+//
+//     With -T, ping traces the route to DESTINATION instead: it sends
+//     requests with increasing TTLs and shows the routers that report
+//     them expired.
 //
 // Options:
-//     -6: use ipv6 (ip6:ipv6-icmp)
-//     -s: data size (default: 64)
+//     -4: use IPv4
+//     -6: use IPv6
+//     -s: data size (default: 56)
+//     -p: up to 16 bytes in hex to fill the data with
 //     -c: # iterations, 0 to run forever (default)
 //     -i: interval in milliseconds (default: 1000)
+//     -f: flood: send a request as soon as a reply arrives, or 100 per second
+//     -t: TTL or hop limit of the requests
+//     -w: stop after this many seconds
+//     -W: wait time for a reply in milliseconds (default: 1000)
+//     -T: trace the route
+//     -m: maximum TTL for -T (default: 30)
 //     -V: version
-//     -w: wait time in milliseconds (default: 100)
 //     -h: help
 package main
 
 import (
-	"encoding/binary"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
 	"time"
 )
 
 var (
-	net6       = flag.Bool("6", false, "use ipv4 (means ip4:icmp) or 6 (ip6:ipv6-icmp)")
-	packetSize = flag.Int("s", 64, "Data size")
+	net4       = flag.Bool("4", false, "use IPv4")
+	net6       = flag.Bool("6", false, "use IPv6")
+	packetSize = flag.Int("s", 56, "Data size")
+	pattern    = flag.String("p", "", "up to 16 bytes in hex to fill the data with")
 	iter       = flag.Uint64("c", 0, "# iterations")
 	intv       = flag.Int("i", 1000, "interval in milliseconds")
+	flood      = flag.Bool("f", false, "flood: send a request as soon as a reply arrives, or 100 per second")
+	ttl        = flag.Int("t", 0, "TTL or hop limit of the requests")
+	deadline   = flag.Int("w", 0, "stop after this many seconds")
+	wtf        = flag.Int("W", 1000, "wait time for a reply in milliseconds")
+	traceroute = flag.Bool("T", false, "trace the route")
+	maxTTL     = flag.Int("m", 30, "maximum TTL for -T")
 	version    = flag.Bool("V", false, "version")
-	wtf        = flag.Int("w", 100, "wait time in milliseconds")
 	help       = flag.Bool("h", false, "help")
 )
 
-const (
-	ICMP_TYPE_ECHO_REQUEST             = 8
-	ICMP_TYPE_ECHO_REPLY               = 0
-	ICMP_ECHO_REPLY_HEADER_IPV4_OFFSET = 20
-	ICMP_ECHO_REPLY_HEADER_IPV6_OFFSET = 40
-)
+// floodInterval is the interval of -f when replies are late.
+const floodInterval = 10 * time.Millisecond
 
 func usage() {
-	fmt.Fprintf(os.Stdout, "ping [-hV] [-c count] [-i interval] [-s packetsize] [-w deadline] destination\n")
+	fmt.Fprintf(os.Stdout, "ping [-46fhTV] [-c count] [-i interval] [-m maxttl] [-p pattern] [-s packetsize] [-t ttl] [-w deadline] [-W wait] destination\n")
 	os.Exit(0)
 }
 
@@ -62,104 +87,299 @@ func optwithoutparam() {
 	usage()
 }
 
-func cksum(bs []byte) uint16 {
-	sum := uint32(0)
+// payload returns size bytes of data, repeating pattern.
+func payload(size int, pattern []byte) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		if len(pattern) > 0 {
+			data[i] = pattern[i%len(pattern)]
+		} else {
+			data[i] = byte(i)
+		}
+	}
+	return data
+}
+
+// stats are the statistics of a ping.
+type stats struct {
+	transmitted int
+	received    int
+	duplicates  int
+	errors      int
 
-	for k := 0; k < len(bs)/2; k++ {
-		sum += uint32(bs[k*2]) << 8
-		sum += uint32(bs[k*2+1])
+	min, max time.Duration
+	// sum and sum2 are the sums of the RTTs and their squares, in
+	// milliseconds.
+	sum, sum2 float64
+}
+
+func (s *stats) add(rtt time.Duration) {
+	if s.received == 0 || rtt < s.min {
+		s.min = rtt
 	}
-	if len(bs)%2 != 0 {
-		sum += uint32(bs[len(bs)-1]) << 8
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.received++
+	ms := millis(rtt)
+	s.sum += ms
+	s.sum2 += ms * ms
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// write writes the statistics of a ping of host that took elapsed.
+func (s *stats) write(w io.Writer, host string, elapsed time.Duration) {
+	fmt.Fprintf(w, "--- %s ping statistics ---\n", host)
+	fmt.Fprintf(w, "%d packets transmitted, %d received, ", s.transmitted, s.received)
+	if s.duplicates > 0 {
+		fmt.Fprintf(w, "+%d duplicates, ", s.duplicates)
 	}
-	sum = (sum >> 16) + (sum & 0xffff)
-	sum = (sum >> 16) + (sum & 0xffff)
-	if sum == 0xffff {
-		sum = 0
+	if s.errors > 0 {
+		fmt.Fprintf(w, "+%d errors, ", s.errors)
 	}
+	loss := 0.0
+	if s.transmitted > 0 {
+		loss = 100 * float64(s.transmitted-s.received) / float64(s.transmitted)
+	}
+	fmt.Fprintf(w, "%g%% packet loss, time %dms\n", math.Round(loss*1000)/1000, elapsed/time.Millisecond)
+	if s.received > 0 {
+		avg := s.sum / float64(s.received)
+		mdev := math.Sqrt(math.Max(s.sum2/float64(s.received)-avg*avg, 0))
+		fmt.Fprintf(w, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", millis(s.min), avg, millis(s.max), mdev)
+	}
+}
+
+// pinger sends echo requests and waits for their replies.
+type pinger struct {
+	c    *conn
+	dst  *net.IPAddr
+	data []byte
+	out  io.Writer
 
-	return ^uint16(sum)
+	count    uint64
+	interval time.Duration
+	wait     time.Duration
+	flood    bool
+
+	stats
+	// sent are the times the requests were sent, by sequence number.
+	sent map[uint16]time.Time
+	// seen are the sequence numbers that had replies.
+	seen map[uint16]bool
 }
 
-func ping1(netname string, host string, i uint64) (string, error) {
-	c, derr := net.Dial(netname, host)
-	if derr != nil {
-		return "", fmt.Errorf("net.Dial(%v %v) failed: %v", netname, host, derr)
+func (p *pinger) send(seq uint16) error {
+	p.sent[seq] = time.Now()
+	delete(p.seen, seq)
+	if err := p.c.send(echoRequest(p.c.v6, p.c.id, seq, p.data), p.dst); err != nil {
+		return err
 	}
-	defer c.Close()
+	p.transmitted++
+	if p.flood {
+		fmt.Fprint(p.out, ".")
+	}
+	return nil
+}
 
-	// Send ICMP Echo Request
-	waitFor := time.Duration(*wtf) * time.Millisecond
-	c.SetDeadline(time.Now().Add(waitFor))
-	msg := make([]byte, *packetSize)
-	msg[0] = ICMP_TYPE_ECHO_REQUEST
-	msg[1] = 0
-	binary.BigEndian.PutUint16(msg[6:], uint16(i))
-	binary.BigEndian.PutUint16(msg[4:], uint16(i>>16))
-	binary.BigEndian.PutUint16(msg[2:], cksum(msg))
-	if _, err := c.Write(msg[:]); err != nil {
-		return "", fmt.Errorf("Write failed: %v", err)
-	}
-
-	// Get ICMP Echo Reply
-	c.SetDeadline(time.Now().Add(waitFor))
-	rmsg := make([]byte, *packetSize+256)
-	before := time.Now()
-	amt, rerr := c.Read(rmsg[:])
-	if rerr != nil {
-		return "", fmt.Errorf("Read failed: %v", rerr)
-	}
-	latency := time.Since(before)
-	if (rmsg[0] & 0x0F) == 6 {
-		rmsg = rmsg[ICMP_ECHO_REPLY_HEADER_IPV6_OFFSET:]
+func (p *pinger) handle(r *reply) {
+	sent, ok := p.sent[r.seq]
+	if !ok {
+		return
+	}
+	if r.kind != echoReply {
+		p.errors++
+		if p.flood {
+			fmt.Fprint(p.out, "\bE")
+		} else {
+			fmt.Fprintf(p.out, "From %v icmp_seq=%d %v\n", r.from, r.seq, r)
+		}
+		return
+	}
+
+	rtt := time.Since(sent)
+	dup := p.seen[r.seq]
+	if dup {
+		p.duplicates++
 	} else {
-		rmsg = rmsg[ICMP_ECHO_REPLY_HEADER_IPV4_OFFSET:]
+		p.seen[r.seq] = true
+		p.add(rtt)
 	}
-	if rmsg[0] != ICMP_TYPE_ECHO_REPLY {
-		return "", fmt.Errorf("Bad ICMP echo reply type: %v", msg[0])
+	if p.flood {
+		fmt.Fprint(p.out, "\b \b")
+		return
 	}
-	cks := binary.BigEndian.Uint16(rmsg[2:])
-	binary.BigEndian.PutUint16(rmsg[2:], 0)
-	if cks != cksum(rmsg) {
-		return "", fmt.Errorf("Bad ICMP checksum: %v (expected %v)", cks, cksum(rmsg))
+	ttl := ""
+	if r.ttl >= 0 {
+		ttl = fmt.Sprintf(" ttl=%d", r.ttl)
 	}
-	id := binary.BigEndian.Uint16(rmsg[4:])
-	seq := binary.BigEndian.Uint16(rmsg[6:])
-	rseq := uint64(id)<<16 + uint64(seq)
-	if rseq != i {
-		return "", fmt.Errorf("wrong sequence number %v (expected %v)", rseq, i)
+	d := ""
+	if dup {
+		d = " (DUP!)"
 	}
+	fmt.Fprintf(p.out, "%d bytes from %v: icmp_seq=%d%s time=%.3f ms%s\n", r.len, r.from, r.seq, ttl, millis(rtt), d)
+}
+
+// run pings until count requests had replies or timed out, until end if
+// not zero, or until stop is closed.
+func (p *pinger) run(end time.Time, stop <-chan struct{}) error {
+	var seq uint16
+	next := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		now := time.Now()
+		if !end.IsZero() && !now.Before(end) {
+			return nil
+		}
+		done := p.count != 0 && uint64(p.transmitted) >= p.count
+		if !done && !now.Before(next) {
+			seq++
+			if err := p.send(seq); err != nil {
+				return err
+			}
+			next = now.Add(p.interval)
+			done = p.count != 0 && uint64(p.transmitted) >= p.count
+		}
+		wake := next
+		if done {
+			if p.received >= p.transmitted {
+				return nil
+			}
+			wake = p.sent[seq].Add(p.wait)
+			if !now.Before(wake) {
+				return nil
+			}
+		}
+		if !end.IsZero() && end.Before(wake) {
+			wake = end
+		}
 
-	return fmt.Sprintf("%d bytes from %v: icmp_seq=%v, time=%v", amt, host, i, latency), nil
+		if err := p.c.setDeadline(wake); err != nil {
+			return err
+		}
+		r, err := p.c.recv()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			select {
+			case <-stop:
+				// recv failed because the conn was closed.
+				return nil
+			default:
+			}
+			return err
+		}
+		p.handle(r)
+		if p.flood && r.kind == echoReply && r.seq == seq {
+			next = time.Now()
+		}
+	}
 }
 
 func main() {
 	flag.Parse()
 
 	// options without parameters (right now just: -hV)
-	if flag.NArg() < 1 {
+	if flag.NArg() < 1 || *help || *version {
 		optwithoutparam()
 	}
-	if *packetSize < 8 {
-		log.Fatalf("packet size too small (must be >= 8): %v", *packetSize)
+	if *packetSize < 0 || *packetSize > 65507 {
+		log.Fatalf("invalid packet size %v", *packetSize)
+	}
+	pat, err := hex.DecodeString(*pattern)
+	if err != nil || len(pat) > 16 {
+		log.Fatalf("invalid pattern %q: up to 16 bytes in hex", *pattern)
 	}
 
-	netname := "ip4:icmp"
-	interval := time.Duration(*intv)
-	host := flag.Args()[0]
-	// todo: just figure out if it's an ip6 address and go from there.
-	if *net6 {
-		netname = "ip6:ipv6-icmp"
+	network := "ip"
+	switch {
+	case *net4 && *net6:
+		log.Fatal("only one of -4 and -6 may be given")
+	case *net4:
+		network = "ip4"
+	case *net6:
+		network = "ip6"
 	}
+	host := flag.Arg(0)
+	dst, err := net.ResolveIPAddr(network, host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	v6 := dst.IP.To4() == nil
 
-	// ping needs to run forever, except if '*iter' is not zero
-	var i uint64
-	for i = 1; *iter == 0 || i < *iter; i++ {
-		msg, err := ping1(netname, host, i)
-		if err != nil {
-			log.Fatalf("ping failed: %v", err)
+	c, err := listen(v6)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if *ttl != 0 {
+		if err := c.setTTL(*ttl); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	wait := time.Duration(*wtf) * time.Millisecond
+	if *traceroute {
+		if err := trace(os.Stdout, c, host, dst, payload(*packetSize, pat), *maxTTL, wait); err != nil {
+			log.Fatal(err)
 		}
-		log.Print(msg)
-		time.Sleep(time.Millisecond * interval)
+		return
+	}
+
+	p := &pinger{
+		c:        c,
+		dst:      dst,
+		data:     payload(*packetSize, pat),
+		out:      os.Stdout,
+		count:    *iter,
+		interval: time.Duration(*intv) * time.Millisecond,
+		wait:     wait,
+		flood:    *flood,
+		sent:     make(map[uint16]time.Time),
+		seen:     make(map[uint16]bool),
+	}
+	if p.flood {
+		p.interval = floodInterval
+	}
+
+	// Stop on ^C, and still show the statistics.
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		close(stop)
+		c.Close()
+	}()
+
+	name := host
+	if dst.String() != host {
+		name = fmt.Sprintf("%s (%v)", host, dst)
+	}
+	fmt.Printf("PING %s: %d data bytes\n", name, len(p.data))
+	start := time.Now()
+	var end time.Time
+	if *deadline > 0 {
+		end = start.Add(time.Duration(*deadline) * time.Second)
+	}
+	err = p.run(end, stop)
+	if p.flood {
+		fmt.Println()
+	}
+	fmt.Println()
+	p.write(os.Stdout, strings.TrimSuffix(host, "."), time.Since(start))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if p.received == 0 {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,181 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestEchoRequest(t *testing.T) {
+	req := echoRequest(false, 0x1234, 7, []byte{1, 2, 3})
+	want := []byte{icmpEchoRequest, 0, 0, 0, 0x12, 0x34, 0, 7, 1, 2, 3}
+	sum := binary.BigEndian.Uint16(req[2:])
+	req[2], req[3] = 0, 0
+	if sum != cksum(req) {
+		t.Errorf("checksum = %#x, want %#x", sum, cksum(req))
+	}
+	if !bytes.Equal(req, want) {
+		t.Errorf("echoRequest = %x, want %x", req, want)
+	}
+
+	req = echoRequest(true, 0x1234, 7, nil)
+	if want := []byte{icmp6EchoRequest, 0, 0, 0, 0x12, 0x34, 0, 7}; !bytes.Equal(req, want) {
+		t.Errorf("echoRequest = %x, want %x", req, want)
+	}
+}
+
+// quote returns an ICMP error of typ and code quoting req in an IP
+// packet.
+func quote(v6 bool, typ, code byte, req []byte) []byte {
+	msg := []byte{typ, code, 0, 0, 0, 0, 0, 0}
+	var ip []byte
+	if v6 {
+		ip = make([]byte, ipv6HeaderLen)
+		ip[0] = 0x60
+		ip[ipv6NextHdrOffset] = protocolICMPv6
+	} else {
+		// With options.
+		ip = make([]byte, 24)
+		ip[0] = 0x46
+		ip[ipv4ProtocolOffset] = protocolICMP
+	}
+	return append(append(msg, ip...), req...)
+}
+
+func TestParseICMP(t *testing.T) {
+	req4 := echoRequest(false, 1, 2, make([]byte, 56))
+	req6 := echoRequest(true, 1, 2, make([]byte, 56))
+	for _, tt := range []struct {
+		name string
+		v6   bool
+		msg  []byte
+		kind kind
+		desc string
+		mark string
+	}{
+		{
+			name: "echo reply",
+			msg:  []byte{icmpEchoReply, 0, 0, 0, 0, 1, 0, 2},
+			kind: echoReply,
+			desc: "Echo Reply",
+		},
+		{
+			name: "echo reply v6",
+			v6:   true,
+			msg:  []byte{icmp6EchoReply, 0, 0, 0, 0, 1, 0, 2},
+			kind: echoReply,
+			desc: "Echo Reply",
+		},
+		{
+			name: "time exceeded",
+			msg:  quote(false, icmpTimeExceeded, 0, req4),
+			kind: timeExceeded,
+			desc: "Time to live exceeded",
+		},
+		{
+			name: "time exceeded v6",
+			v6:   true,
+			msg:  quote(true, icmp6TimeExceeded, 0, req6[:8]),
+			kind: timeExceeded,
+			desc: "Time to live exceeded",
+		},
+		{
+			name: "host unreachable",
+			msg:  quote(false, icmpDestUnreach, 1, req4[:8]),
+			kind: unreachable,
+			desc: "Destination Host Unreachable",
+			mark: "!H",
+		},
+		{
+			name: "address unreachable v6",
+			v6:   true,
+			msg:  quote(true, icmp6DestUnreach, 3, req6),
+			kind: unreachable,
+			desc: "Destination unreachable: Address unreachable",
+			mark: "!H",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := parseICMP(tt.v6, tt.msg)
+			if !ok {
+				t.Fatalf("parseICMP(%x) failed", tt.msg)
+			}
+			if r.kind != tt.kind || r.id != 1 || r.seq != 2 {
+				t.Errorf("parseICMP = kind %d, id %d, seq %d; want kind %d, id 1, seq 2", r.kind, r.id, r.seq, tt.kind)
+			}
+			if r.len != len(tt.msg) {
+				t.Errorf("len = %d, want %d", r.len, len(tt.msg))
+			}
+			if s := r.String(); s != tt.desc {
+				t.Errorf("String() = %q, want %q", s, tt.desc)
+			}
+			if m := r.mark(); m != tt.mark {
+				t.Errorf("mark() = %q, want %q", m, tt.mark)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name string
+		v6   bool
+		msg  []byte
+	}{
+		{"short", false, []byte{icmpEchoReply, 0, 0, 0}},
+		{"echo request", false, req4},
+		{"v4 reply on v6", true, []byte{icmpEchoReply, 0, 0, 0, 0, 1, 0, 2}},
+		{"short quote", false, quote(false, icmpTimeExceeded, 0, req4[:4])},
+		{"quoted reply", false, quote(false, icmpTimeExceeded, 0, []byte{icmpEchoReply, 0, 0, 0, 0, 1, 0, 2})},
+		{"quoted udp", true, func() []byte {
+			b := quote(true, icmp6DestUnreach, 4, req6)
+			b[icmpHeaderLen+ipv6NextHdrOffset] = 17
+			return b
+		}()},
+	} {
+		if _, ok := parseICMP(tt.v6, tt.msg); ok {
+			t.Errorf("%s: parseICMP(%x) succeeded, want failure", tt.name, tt.msg)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	if got, want := payload(5, []byte{0xab, 0xcd}), []byte{0xab, 0xcd, 0xab, 0xcd, 0xab}; !bytes.Equal(got, want) {
+		t.Errorf("payload = %x, want %x", got, want)
+	}
+	if got, want := payload(3, nil), []byte{0, 1, 2}; !bytes.Equal(got, want) {
+		t.Errorf("payload = %x, want %x", got, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var s stats
+	s.transmitted = 4
+	for _, ms := range []time.Duration{1, 2, 3} {
+		s.add(ms * time.Millisecond)
+	}
+	s.duplicates = 1
+
+	var b bytes.Buffer
+	s.write(&b, "host", 3005*time.Millisecond)
+	want := `--- host ping statistics ---
+4 packets transmitted, 3 received, +1 duplicates, 25% packet loss, time 3005ms
+rtt min/avg/max/mdev = 1.000/2.000/3.000/0.816 ms
+`
+	if b.String() != want {
+		t.Errorf("write =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	s = stats{transmitted: 3, errors: 3}
+	s.write(&b, "host", time.Second)
+	want = `--- host ping statistics ---
+3 packets transmitted, 0 received, +3 errors, 100% packet loss, time 1000ms
+`
+	if b.String() != want {
+		t.Errorf("write =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// probes is the number of requests sent to each hop.
+const probes = 3
+
+// trace traces the route to dst with echo requests of data. It sends
+// probes requests with each TTL from 1 to maxTTL, and shows who reports
+// them expired, until dst replies or is reported unreachable.
+func trace(w io.Writer, c *conn, host string, dst *net.IPAddr, data []byte, maxTTL int, wait time.Duration) error {
+	fmt.Fprintf(w, "traceroute to %s (%v), %d hops max, %d byte packets\n", host, dst, maxTTL, icmpHeaderLen+len(data))
+	var seq uint16
+	for ttl := 1; ttl <= maxTTL; ttl++ {
+		if err := c.setTTL(ttl); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%2d ", ttl)
+		var last net.IP
+		done := false
+		for i := 0; i < probes; i++ {
+			seq++
+			r, rtt, err := probe(c, dst, seq, data, wait)
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				fmt.Fprint(w, " *")
+				continue
+			}
+			if !r.from.Equal(last) {
+				fmt.Fprintf(w, " %v", r.from)
+				last = r.from
+			}
+			fmt.Fprintf(w, "  %.3f ms", millis(rtt))
+			if m := r.mark(); m != "" {
+				fmt.Fprintf(w, " %s", m)
+			}
+			done = done || r.kind != timeExceeded
+		}
+		fmt.Fprintln(w)
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// probe sends an echo request and returns the reply or error about it, or
+// nil if there is none within wait.
+func probe(c *conn, dst *net.IPAddr, seq uint16, data []byte, wait time.Duration) (*reply, time.Duration, error) {
+	start := time.Now()
+	if err := c.send(echoRequest(c.v6, c.id, seq, data), dst); err != nil {
+		return nil, 0, err
+	}
+	if err := c.setDeadline(start.Add(wait)); err != nil {
+		return nil, 0, err
+	}
+	for {
+		r, err := c.recv()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, 0, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		// Late answers to earlier probes are dropped.
+		if r.seq == seq {
+			return r, time.Since(start), nil
+		}
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+
+	"github.com/u-root/u-root/pkg/procfs"
 )
 
 const (
@@ -37,80 +39,19 @@ type Process struct {
 	uid     int
 }
 
-// table content of stat file defined by:
-// https://www.kernel.org/doc/Documentation/filesystems/proc.txt (2009)
-// Section (ctrl + f) : Table 1-4: Contents of the stat files (as of 2.6.30-rc7)
+// process is the content of /proc/PID/stat, and what ps makes of it.
 type process struct {
-	Pid         string // process id name
-	Cmd         string // filename of the executable
-	State       string // state (R is running, S is sleeping, D is sleeping in an uninterruptible wait, Z is zombie, T is traced or stopped)
-	Ppid        string // process id of the parent process
-	Pgrp        string // pgrp of the process
-	Sid         string // session id
-	TTYNr       string // tty the process uses
-	TTYPgrp     string // pgrp of the tty
-	Flags       string // task flags
-	MinFlt      string // number of minor faults
-	CminFlt     string // number of minor faults with child's
-	MajFlt      string // number of major faults
-	CmajFlt     string // number of major faults with child's
-	Utime       string // user mode jiffies
-	Stime       string // kernel mode jiffies
-	Cutime      string // user mode jiffies with child's
-	Cstime      string // kernel mode jiffies with child's
-	Priority    string // priority level
-	Nice        string // nice level
-	NumThreads  string // number of threads
-	ItRealValue string // (obsolete, always 0)
-	StartTime   string // time the process started after system boot
-	Vsize       string // virtual memory size
-	Rss         string // resident set memory size
-	Rsslim      string // current limit in bytes on the rss
-	StartCode   string // address above which program text can run
-	EndCode     string // address below which program text can run
-	StartStack  string // address of the start of the main process stack
-	Esp         string // current value of ESP
-	Eip         string // current value of EIP
-	Pending     string // bitmap of pending signals
-	Blocked     string // bitmap of blocked signals
-	Sigign      string // bitmap of ignored signals
-	Sigcatch    string // bitmap of caught signals
-	Wchan       string // place holder, used to be the wchan address, use /proc/PID/wchan
-	Zero1       string // ignored
-	Zero2       string // ignored
-	ExitSignal  string // signal to send to parent thread on exit
-	TaskCPU     string // which CPU the task is scheduled on
-	RtPriority  string // realtime priority
-	Policy      string // scheduling policy (man sched_setscheduler)
-	BlkioTicks  string // time spent waiting for block IO
-	Gtime       string // guest time of the task in jiffies
-	Cgtime      string // guest time of the task children in jiffies
-	StartData   string // address above which program data+bss is placed
-	EndData     string // address below which program data+bss is placed
-	StartBrk    string // address above which program heap can be expanded with brk()
-	ArgStart    string // address above which program command line is placed
-	ArgEnd      string // address below which program command line is placed
-	EnvStart    string // address above which program environment is placed
-	EnvEnd      string // address below which program environment is placed
-	ExitCode    string // the thread's exit_code in the form reported by the waitpid system call (end of stat)
-	Ctty        string // extra member (don't parsed from stat)
-	Time        string // extra member (don't parsed from stat)
+	procfs.Stat
+	Ctty string // extra member (don't parsed from stat)
+	Time string // extra member (don't parsed from stat)
 }
 
 // Parse all content of stat to a Process Struct
 // by gived the pid (linux)
 func (p *Process) readStat(s string) error {
-	fields := strings.Split(s, " ")
-	// set struct fields from stat file data
-	v := reflect.ValueOf(&p.process).Elem()
-	for i := 0; i < len(fields); i++ {
-		fieldVal := v.Field(i)
-		fieldVal.Set(reflect.ValueOf(fields[i]))
-	}
-
+	p.Stat = procfs.ParseStat(s)
 	p.Time = p.getTime()
 	p.Ctty = p.getCtty()
-	p.Cmd = strings.TrimSuffix(strings.TrimPrefix(p.Cmd, "("), ")")
 	if flags.x && p.cmdline != "" {
 		p.Cmd = p.cmdline
 	}
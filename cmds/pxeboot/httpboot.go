@@ -0,0 +1,113 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4client"
+	"github.com/u-root/dhcp4/dhcp4opts"
+)
+
+// optionClientArch is the client system architecture option, RFC 4578.
+const optionClientArch dhcp4.OptionCode = 93
+
+// clientArchs are the UEFI architecture types used by HTTP boot clients.
+var clientArchs = map[string]uint16{
+	"386":   15,
+	"amd64": 16,
+	"arm":   18,
+	"arm64": 19,
+}
+
+// vendorClass returns the vendor class identifier to send for class. For
+// HTTPClient, it is completed with the architecture, as UEFI HTTP boot
+// clients do, so that servers can pick a boot file for it.
+func vendorClass(class string) []byte {
+	if arch, ok := clientArchs[runtime.GOARCH]; ok && class == "HTTPClient" {
+		class = fmt.Sprintf("%s:Arch:%05d:UNDI:003001", class, arch)
+	}
+	return []byte(class)
+}
+
+// addVendorClass adds the vendor class and, for HTTP boot, the client
+// architecture to p.
+func addVendorClass(p *dhcp4.Packet, class string) {
+	if class == "" {
+		return
+	}
+	p.Options.AddRaw(dhcp4.OptionVendorClassIdentifier, vendorClass(class))
+	// The architecture types are those of HTTP boot clients, which PXE
+	// servers would take for other machines.
+	if arch, ok := clientArchs[runtime.GOARCH]; ok && class == "HTTPClient" {
+		p.Options.AddRaw(optionClientArch, []byte{byte(arch >> 8), byte(arch)})
+	}
+}
+
+// requestWithClass is dhcp4client.Client.Request, but identifies the client
+// with the vendor class class.
+func requestWithClass(client *dhcp4client.Client, class string) (*dhcp4.Packet, error) {
+	discover := client.DiscoverPacket()
+	addVendorClass(discover, class)
+
+	offer, err := func() (*dhcp4.Packet, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		wg, out, errCh := client.SimpleSendAndRead(ctx, dhcp4client.DefaultServers, discover)
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+
+		for packet := range out {
+			if dhcp4opts.GetDHCPMessageType(packet.Packet.Options) == dhcp4opts.DHCPOffer {
+				return packet.Packet, nil
+			}
+		}
+		if err, ok := <-errCh; ok && err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("didn't get a packet")
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	request := client.RequestPacket(offer)
+	addVendorClass(request, class)
+	return client.SendAndReadOne(request)
+}
+
+// httpClient returns an HTTP client that trusts the CAs in the PEM file
+// caFile. If caFile does not exist, the system roots are used.
+func httpClient(caFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	pem, err := ioutil.ReadFile(caFile)
+	if err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// The default CheckRedirect follows up to 10 redirects, including
+	// from HTTP to HTTPS.
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
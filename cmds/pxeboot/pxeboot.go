@@ -5,26 +5,47 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"time"
 
 	"github.com/u-root/dhcp4/dhcp4client"
+	"github.com/u-root/dhcp4/dhcp4opts"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/cpio"
 	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/diskboot"
+	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/pxe"
+	"github.com/u-root/u-root/pkg/tpm"
 	"github.com/vishvananda/netlink"
 )
 
 var (
 	verbose = flag.Bool("v", true, "print all kinds of things out, more than Chris wants")
 	dryRun  = flag.Bool("dry-run", false, "download kernel, but don't kexec it")
+	bootEnv = flag.String("bootenv", "", "GRUB environment block on local storage with the next-boot state")
+	class   = flag.String("vendor-class", "", "DHCP vendor class identifier to send, e.g. PXEClient, or HTTPClient for UEFI-style HTTP boot; empty to send none")
+	caCerts = flag.String("cacerts", "/etc/pxeboot/ca.pem", "PEM bundle of CAs trusted for HTTPS; the system roots are used if it does not exist")
+	ifName  = flag.String("ifname", "^e", "regular expression matching the interfaces to boot from")
+	tries   = flag.Int("tries", 5, "number of times to try all interfaces")
+	backoff = flag.Duration("backoff", time.Second, "delay before trying all interfaces again; doubles with every try")
+	keyPath = flag.String("keyring", "/etc/pxeboot/keyring.pem", "PEM file of public keys trusted to sign boot packages; if it exists, only signed boot packages boot")
+	tpmPath = flag.String("tpm", tpm.DefaultDevice, "TPM to measure the boot into, empty to not measure")
 	debug   = func(string, ...interface{}) {}
 )
 
+// maxBackoff is the maximum delay between tries.
+const maxBackoff = 30 * time.Second
+
 func attemptDHCPLease(iface netlink.Link, timeout time.Duration, retry int) (*dhclient.Packet4, error) {
 	if _, err := dhclient.IfUp(iface.Attrs().Name); err != nil {
 		return nil, err
@@ -36,75 +57,287 @@ func attemptDHCPLease(iface netlink.Link, timeout time.Duration, retry int) (*dh
 	if err != nil {
 		return nil, err
 	}
+	defer client.Close()
 
-	p, err := client.Request()
+	p, err := requestWithClass(client, *class)
 	if err != nil {
 		return nil, err
 	}
 	return dhclient.NewPacket4(p), nil
 }
 
-func Netboot() error {
-	ifs, err := netlink.LinkList()
+// nextLabel returns the label selected by the boot environment, if it
+// exists in pc, and the environment with the boot attempt recorded. The
+// environment must be saved only once that label boots.
+func nextLabel(pc *pxe.Config) (string, *diskboot.BootEnv, error) {
+	env, err := diskboot.LoadBootEnv(*bootEnv)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	name, ok := pc.FindLabel(env.Next())
+	if !ok {
+		return "", nil, nil
 	}
+	debug("Boot environment selects label %q", name)
+	return name, env, nil
+}
 
-	for _, iface := range ifs {
-		// TODO: Do 'em all in parallel.
-		if iface.Attrs().Name != "eth0" {
-			continue
-		}
+// commitBootEnv saves env, if it is not nil, as the label it selected
+// boots.
+func commitBootEnv(env *diskboot.BootEnv) {
+	if env == nil {
+		return
+	}
+	if err := env.Save(); err != nil {
+		log.Printf("Boot environment %v: %v", *bootEnv, err)
+	}
+}
 
-		log.Printf("Attempting to get DHCP lease on %s", iface.Attrs().Name)
-		packet, err := attemptDHCPLease(iface, 30*time.Second, 5)
-		if err != nil {
-			log.Printf("No lease on %s: %v", iface.Attrs().Name, err)
-			continue
+// bootFile fetches the boot file at u, and returns it and its start. It
+// returns nil if the boot file cannot be fetched, which is not an error if
+// u is the directory of a pxelinux config.
+func bootFile(u *url.URL) (io.ReaderAt, []byte) {
+	r, err := pxe.GetFile(u)
+	if err != nil {
+		debug("Fetching boot file: %v", err)
+		return nil, nil
+	}
+	head := make([]byte, 16)
+	n, _ := r.ReadAt(head, 0)
+	return r, head[:n]
+}
+
+// isPackage returns whether head is the start of a boot package, which is a
+// newc cpio archive.
+func isPackage(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("070701"))
+}
+
+// loadPackage returns the OS image of the boot package r. If keyring is
+// not nil, the package must be signed by one of its keys.
+func loadPackage(r io.ReaderAt, keyring *boot.Keyring) (boot.OSImage, error) {
+	var p boot.Package
+	if err := p.Unpack(cpio.Newc.Reader(r), keyring); err != nil {
+		return nil, err
+	}
+	for name, content := range p.Metadata {
+		debug("Package metadata %s: %q", name, content)
+	}
+	return p.OSImage, nil
+}
+
+// bootIface boots from the boot file offered by DHCP on iface. It returns
+// nil if it was told to boot from the local disk, or in dry-run mode.
+//
+// With a keyring, the boot file must be a boot package signed by one of its
+// keys. With a measurer, the configuration and the image are measured
+// before loading.
+func bootIface(iface netlink.Link, keyring *boot.Keyring, m *tpm.Measurer) error {
+	log.Printf("Attempting to get DHCP lease on %s", iface.Attrs().Name)
+	packet, err := attemptDHCPLease(iface, 10*time.Second, 1)
+	if err != nil {
+		return fmt.Errorf("no lease: %v", err)
+	}
+	log.Printf("Got lease on %s", iface.Attrs().Name)
+	if err := dhclient.Configure4(iface, packet.P); err != nil {
+		return fmt.Errorf("failed to configure lease: %v", err)
+	}
+
+	// We may have to make this DHCPv6 and DHCPv4-specific anyway.
+	// Only tested with v4 right now; and assuming the uri points
+	// to a pxelinux.0, an iPXE script, or the directory of a
+	// pxelinux config.
+	//
+	// Or rather, we need to make this option-specific. DHCPv6 has
+	// options for passing a kernel and cmdline directly. v4
+	// usually just passes a pxelinux.0. But what about an initrd?
+	uri, err := packet.Boot()
+	if err != nil {
+		return fmt.Errorf("got DHCP lease, but no valid PXE information")
+	}
+
+	log.Printf("Boot URI: %v", uri)
+
+	wd := &url.URL{
+		Scheme: uri.Scheme,
+		Host:   uri.Host,
+		Path:   path.Dir(uri.Path),
+	}
+	lease := packet.Lease()
+	netif := &pxe.Interface{
+		MAC:     iface.Attrs().HardwareAddr,
+		IP:      lease.IP,
+		Netmask: lease.Mask,
+		Gateway: packet.Gateway(),
+		Server:  net.ParseIP(uri.Hostname()),
+
+		BootFile: uri.String(),
+		DNS:      packet.DNS(),
+		Hostname: dhcp4opts.GetHostName(packet.P.Options),
+		Domain:   dhcp4opts.GetDomainName(packet.P.Options),
+	}
+
+	var (
+		img     boot.OSImage
+		configs []pxe.File
+		env     *diskboot.BootEnv
+	)
+	r, head := bootFile(&uri)
+	switch {
+	case isPackage(head):
+		if img, err = loadPackage(r, keyring); err != nil {
+			return fmt.Errorf("failed to load boot package: %v", err)
 		}
-		log.Printf("Got lease on %s", iface.Attrs().Name)
-		if err := dhclient.Configure4(iface, packet.P); err != nil {
-			log.Printf("shit: %v", err)
-			continue
+		if keyring != nil {
+			log.Printf("Boot package signature verified")
 		}
 
-		// We may have to make this DHCPv6 and DHCPv4-specific anyway.
-		// Only tested with v4 right now; and assuming the uri points
-		// to a pxelinux.0.
-		//
-		// Or rather, we need to make this option-specific. DHCPv6 has
-		// options for passing a kernel and cmdline directly. v4
-		// usually just passes a pxelinux.0. But what about an initrd?
-		uri, err := packet.Boot()
+	case keyring != nil:
+		return fmt.Errorf("boot file is not a boot package, and only signed boot packages may boot")
+
+	case pxe.IsIPXEScript(head):
+		x := pxe.NewIPXE(wd, pxe.DefaultSchemes)
+		x.SetInterface(netif)
+		label, err := x.RunScript(&uri, r)
 		if err != nil {
-			log.Printf("Got DHCP lease, but no valid PXE information.")
-			continue
+			return fmt.Errorf("failed to run iPXE script: %v", err)
 		}
+		img, configs = label, x.Scripts
 
-		log.Printf("Boot URI: %v", uri)
-
-		wd := &url.URL{
-			Scheme: uri.Scheme,
-			Host:   uri.Host,
-			Path:   path.Dir(uri.Path),
-		}
+	default:
 		pc := pxe.NewConfig(wd)
-		if err := pc.FindConfigFile(iface.Attrs().HardwareAddr, packet.Lease().IP); err != nil {
+		pc.Interface = netif
+		if err := pc.FindConfigFile(iface.Attrs().HardwareAddr, lease.IP); err != nil {
 			return fmt.Errorf("failed to parse pxelinux config: %v", err)
 		}
 
-		label := pc.Entries[pc.DefaultEntry]
-		log.Printf("Got configuration: %v", label)
+		for _, msg := range pc.Say {
+			log.Print(msg)
+		}
 
-		if *dryRun {
-			label.ExecutionInfo(log.New(os.Stderr, "", log.LstdFlags))
-		} else if err := label.Execute(); err != nil {
-			log.Printf("Kexec error: %v", err)
+		labelName := pc.BootEntry()
+		if *bootEnv != "" {
+			if l, e, err := nextLabel(pc); err != nil {
+				log.Printf("Boot environment %v: %v", *bootEnv, err)
+			} else if l != "" {
+				labelName, env = l, e
+			}
+		}
+		if pc.LocalBoot[labelName] {
+			log.Printf("Label %q boots from the local disk", labelName)
+			if !*dryRun {
+				commitBootEnv(env)
+			}
+			return nil
 		}
+		label := pc.Entries[labelName]
+		if label == nil {
+			return fmt.Errorf("no label %q in pxelinux config", labelName)
+		}
+		img, configs = label, pc.Files
+	}
+	log.Printf("Got configuration: %v", img)
+
+	if *dryRun {
+		img.ExecutionInfo(log.New(os.Stderr, "", log.LstdFlags))
+		return nil
+	}
+	measureConfigs(m, configs)
+	if err := boot.MeasureAndLoad(m, img); err != nil {
+		return fmt.Errorf("kexec load error: %v", err)
+	}
+	commitBootEnv(env)
+	if err := kexec.Reboot(); err != nil {
+		return fmt.Errorf("kexec error: %v", err)
 	}
 	return nil
 }
 
+// measurer returns a measurer for the TPM of -tpm. It returns nil if there
+// is no TPM.
+//
+// All boot attempts share the measurer: an attempt that fails after
+// measuring has extended the PCRs, and the event log the next kernel gets
+// must have those events too.
+func measurer() *tpm.Measurer {
+	if *tpmPath == "" || *dryRun {
+		return nil
+	}
+	t, err := tpm.Open(*tpmPath)
+	if err != nil {
+		debug("Not measuring the boot: %v", err)
+		return nil
+	}
+	return tpm.NewMeasurer(t)
+}
+
+// measureConfigs measures configs with m, if it is not nil.
+func measureConfigs(m *tpm.Measurer, configs []pxe.File) {
+	if m == nil {
+		return
+	}
+	for _, f := range configs {
+		if err := boot.MeasureConfig(m, f.URL, f.Content); err != nil {
+			log.Printf("Measuring %s: %v", f.URL, err)
+		}
+	}
+}
+
+func Netboot() error {
+	ifRE, err := regexp.Compile(*ifName)
+	if err != nil {
+		return err
+	}
+	c, err := httpClient(*caCerts)
+	if err != nil {
+		return err
+	}
+	var keyring *boot.Keyring
+	if _, err := os.Stat(*keyPath); err == nil {
+		if keyring, err = boot.LoadKeyring(*keyPath); err != nil {
+			return err
+		}
+		log.Printf("Only booting packages signed by the %d keys in %s", keyring.Len(), *keyPath)
+	}
+	hc := pxe.NewHTTPClient(c)
+	pxe.RegisterScheme("http", hc)
+	pxe.RegisterScheme("https", hc)
+
+	ifs, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+
+	m := measurer()
+	if m != nil {
+		defer m.TPM.Close()
+	}
+
+	delay := *backoff
+	for try := 0; try < *tries; try++ {
+		if try > 0 {
+			log.Printf("Trying again in %v", delay)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxBackoff {
+				delay = maxBackoff
+			}
+		}
+
+		// TODO: Do 'em all in parallel.
+		for _, iface := range ifs {
+			if !ifRE.MatchString(iface.Attrs().Name) {
+				continue
+			}
+			if err := bootIface(iface, keyring, m); err != nil {
+				log.Printf("Booting from %s failed: %v", iface.Attrs().Name, err)
+				continue
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no interface matching %q could boot after %d tries", *ifName, *tries)
+}
+
 func main() {
 	flag.Parse()
 	if *verbose {
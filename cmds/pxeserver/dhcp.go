@@ -0,0 +1,317 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4opts"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+
+	// maxBootFileLen is the size of the boot file field of a DHCP packet.
+	// Longer boot file names are sent in an option.
+	maxBootFileLen = 127
+
+	// offerTime is how long an offered address is kept for the client to
+	// request it.
+	offerTime = time.Minute
+)
+
+// lease is an address leased or offered to a client.
+type lease struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// dhcpServer is a DHCPv4 server that hands out addresses from a subnet
+// along with boot files, RFC 2131.
+type dhcpServer struct {
+	// ip is the server's own address.
+	ip net.IP
+
+	// subnet is where addresses are assigned from.
+	subnet *net.IPNet
+
+	// router and dns are sent to clients if set.
+	router net.IP
+	dns    []net.IP
+
+	leaseTime time.Duration
+
+	// bootFile is the boot file for PXE clients, served by TFTP.
+	bootFile string
+
+	// httpBootFile is the boot file URL for UEFI HTTP boot clients.
+	// Empty means HTTP boot is not offered.
+	httpBootFile string
+
+	// now returns the current time.
+	now func() time.Time
+
+	mu sync.Mutex
+	// leases maps client hardware addresses to their leases. Expired
+	// leases are kept until their address is given to another client, so
+	// that a client coming back gets the same address.
+	leases map[string]*lease
+	// owners maps addresses to the hardware addresses they are leased to.
+	owners map[string]string
+}
+
+func newDHCPServer(ip net.IP, subnet *net.IPNet) *dhcpServer {
+	return &dhcpServer{
+		ip:        ip.To4(),
+		subnet:    subnet,
+		leaseTime: time.Hour,
+		now:       time.Now,
+		leases:    make(map[string]*lease),
+		owners:    make(map[string]string),
+	}
+}
+
+// usable returns whether ip may be leased to mac. An address whose lease to
+// another client has expired is taken back.
+func (s *dhcpServer) usable(ip net.IP, mac string) bool {
+	ip = ip.To4()
+	if ip == nil || !s.subnet.Contains(ip) || ip.Equal(s.ip) {
+		return false
+	}
+	// Neither the network nor the broadcast address.
+	ones, bits := s.subnet.Mask.Size()
+	if bits-ones >= 2 {
+		host := binary.BigEndian.Uint32(ip) &^ binary.BigEndian.Uint32(net.IP(s.subnet.Mask).To4())
+		if host == 0 || host == 1<<uint(bits-ones)-1 {
+			return false
+		}
+	}
+	owner, ok := s.owners[ip.String()]
+	if !ok || owner == mac {
+		return true
+	}
+	if s.now().After(s.leases[owner].expires) {
+		s.release(owner)
+		return true
+	}
+	return false
+}
+
+// lease returns the address leased to mac, preferring requested, and makes
+// the lease last at least d from now. It returns nil if the pool is
+// exhausted.
+func (s *dhcpServer) lease(mac string, requested net.IP, d time.Duration) net.IP {
+	expires := s.now().Add(d)
+	if l, ok := s.leases[mac]; ok {
+		if expires.After(l.expires) {
+			l.expires = expires
+		}
+		return l.ip
+	}
+	ip := requested.To4()
+	if !s.usable(ip, mac) {
+		ip = nil
+		for n := binary.BigEndian.Uint32(s.subnet.IP.To4()); ip == nil; n++ {
+			try := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(try, n)
+			if !s.subnet.Contains(try) {
+				return nil
+			}
+			if s.usable(try, mac) {
+				ip = try
+			}
+		}
+	}
+	s.leases[mac] = &lease{ip: ip, expires: expires}
+	s.owners[ip.String()] = mac
+	return ip
+}
+
+// release frees the address leased to mac.
+func (s *dhcpServer) release(mac string) {
+	if l, ok := s.leases[mac]; ok {
+		delete(s.owners, l.ip.String())
+		delete(s.leases, mac)
+	}
+}
+
+// reply returns a reply of type typ to req, RFC 2131 Section 4.3.1.
+func (s *dhcpServer) reply(req *dhcp4.Packet, typ dhcp4opts.DHCPMessageType) *dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootReply)
+	p.HType = req.HType
+	p.TransactionID = req.TransactionID
+	p.CHAddr = req.CHAddr
+	p.Broadcast = req.Broadcast
+	p.GIAddr = req.GIAddr
+	p.Options.Add(dhcp4.OptionDHCPMessageType, typ)
+	p.Options.Add(dhcp4.OptionServerIdentifier, dhcp4opts.IP(s.ip))
+	return p
+}
+
+// configure adds the network configuration and boot file to p.
+func (s *dhcpServer) configure(req, p *dhcp4.Packet) {
+	p.SIAddr = s.ip
+	p.Options.Add(dhcp4.OptionSubnetMask, dhcp4opts.SubnetMask(s.subnet.Mask))
+	if s.router != nil {
+		p.Options.Add(dhcp4.OptionRouters, dhcp4opts.IPs{s.router})
+	}
+	if len(s.dns) > 0 {
+		p.Options.Add(dhcp4.OptionDomainNameServers, dhcp4opts.IPs(s.dns))
+	}
+
+	bootFile := s.bootFile
+	class := string(req.Options.Get(dhcp4.OptionVendorClassIdentifier))
+	if strings.HasPrefix(class, "HTTPClient") && s.httpBootFile != "" {
+		// UEFI HTTP boot clients only accept offers that identify
+		// as HTTP boot offers.
+		bootFile = s.httpBootFile
+		p.Options.AddRaw(dhcp4.OptionVendorClassIdentifier, []byte("HTTPClient"))
+	}
+	if len(bootFile) > maxBootFileLen {
+		p.Options.AddRaw(dhcp4.OptionBootFileName, []byte(bootFile))
+	} else {
+		p.BootFile = bootFile
+	}
+}
+
+// addLeaseTime adds the lease timers to p.
+func (s *dhcpServer) addLeaseTime(p *dhcp4.Packet) {
+	secs := func(d time.Duration) dhcp4opts.Uint32 {
+		return dhcp4opts.Uint32(d / time.Second)
+	}
+	p.Options.Add(dhcp4.OptionIPAddressLeaseTime, secs(s.leaseTime))
+	p.Options.Add(dhcp4.OptionRenewalTimeValue, secs(s.leaseTime/2))
+	p.Options.Add(dhcp4.OptionRebindingTimeValue, secs(s.leaseTime*7/8))
+}
+
+// handle returns the reply to req, or nil if there is none.
+func (s *dhcpServer) handle(req *dhcp4.Packet) *dhcp4.Packet {
+	if req.Op != dhcp4.BootRequest {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mac := req.CHAddr.String()
+	switch dhcp4opts.GetDHCPMessageType(req.Options) {
+	case dhcp4opts.DHCPDiscover:
+		ip := s.lease(mac, net.IP(dhcp4opts.GetRequestedIPAddress(req.Options)), offerTime)
+		if ip == nil {
+			log.Printf("DHCP: no address left for %s", mac)
+			return nil
+		}
+		p := s.reply(req, dhcp4opts.DHCPOffer)
+		p.YIAddr = ip
+		s.configure(req, p)
+		s.addLeaseTime(p)
+		return p
+
+	case dhcp4opts.DHCPRequest:
+		if sid := dhcp4opts.GetServerIdentifier(req.Options); sid != nil && !net.IP(sid).Equal(s.ip) {
+			// The client chose another server.
+			s.release(mac)
+			return nil
+		}
+		ip := net.IP(dhcp4opts.GetRequestedIPAddress(req.Options))
+		if ip == nil {
+			// Renewing or rebinding.
+			ip = req.CIAddr
+		}
+		if ip == nil || !s.usable(ip, mac) {
+			return s.reply(req, dhcp4opts.DHCPNAK)
+		}
+		if l, ok := s.leases[mac]; ok && !l.ip.Equal(ip) {
+			s.release(mac)
+		}
+		ip = s.lease(mac, ip, s.leaseTime)
+		p := s.reply(req, dhcp4opts.DHCPACK)
+		p.YIAddr = ip
+		p.CIAddr = req.CIAddr
+		s.configure(req, p)
+		s.addLeaseTime(p)
+		log.Printf("DHCP: leased %v to %s", ip, mac)
+		return p
+
+	case dhcp4opts.DHCPRelease, dhcp4opts.DHCPDecline:
+		s.release(mac)
+		return nil
+
+	case dhcp4opts.DHCPInform:
+		p := s.reply(req, dhcp4opts.DHCPACK)
+		p.CIAddr = req.CIAddr
+		s.configure(req, p)
+		return p
+	}
+	return nil
+}
+
+// dest returns where to send the reply to req, RFC 2131 Section 4.1.
+func dest(req *dhcp4.Packet) *net.UDPAddr {
+	switch {
+	case req.GIAddr != nil && !req.GIAddr.Equal(net.IPv4zero):
+		return &net.UDPAddr{IP: req.GIAddr, Port: dhcpServerPort}
+	case req.CIAddr != nil && !req.CIAddr.Equal(net.IPv4zero):
+		return &net.UDPAddr{IP: req.CIAddr, Port: dhcpClientPort}
+	}
+	// The client has no address yet.
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpClientPort}
+}
+
+// listenDHCP listens on the DHCP server port. With a non-empty iface, the
+// socket is bound to that interface, so only its clients are served.
+func listenDHCP(iface string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if len(iface) == 0 {
+				return nil
+			}
+			var serr error
+			if err := c.Control(func(fd uintptr) {
+				serr = syscall.BindToDevice(int(fd), iface)
+			}); err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf(":%d", dhcpServerPort))
+}
+
+// serve answers DHCP requests on conn.
+func (s *dhcpServer) serve(conn net.PacketConn) error {
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+		req, err := dhcp4.ParsePacket(b[:n])
+		if err != nil {
+			log.Printf("DHCP: invalid packet from %v: %v", addr, err)
+			continue
+		}
+		p := s.handle(req)
+		if p == nil {
+			continue
+		}
+		out, err := p.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("DHCP: %v", err)
+		}
+		if _, err := conn.WriteTo(out, dest(req)); err != nil {
+			log.Printf("DHCP: sending reply to %s: %v", req.CHAddr, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4opts"
+)
+
+func request(mac net.HardwareAddr, typ dhcp4opts.DHCPMessageType, opts map[dhcp4.OptionCode]encoding.BinaryMarshaler) *dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootRequest)
+	p.CHAddr = mac
+	p.TransactionID = [4]byte{1, 2, 3, 4}
+	p.Options.Add(dhcp4.OptionDHCPMessageType, typ)
+	for code, v := range opts {
+		p.Options.Add(code, v)
+	}
+	return p
+}
+
+func newTestServer(t *testing.T, cidr string) *dhcpServer {
+	_, sn, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newDHCPServer(net.IP{192, 168, 0, 1}, sn)
+	s.bootFile = "pxelinux.0"
+	s.httpBootFile = "http://192.168.0.1/boot.efi"
+	return s
+}
+
+func msgType(p *dhcp4.Packet) dhcp4opts.DHCPMessageType {
+	return dhcp4opts.GetDHCPMessageType(p.Options)
+}
+
+func TestDHCPDiscoverRequest(t *testing.T) {
+	s := newTestServer(t, "192.168.1.0/24")
+	s.router = net.IP{192, 168, 1, 254}
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+
+	offer := s.handle(request(mac, dhcp4opts.DHCPDiscover, nil))
+	if offer == nil || msgType(offer) != dhcp4opts.DHCPOffer {
+		t.Fatalf("handle(DISCOVER) = %v, want offer", offer)
+	}
+	if want := (net.IP{192, 168, 1, 1}); !offer.YIAddr.Equal(want) {
+		t.Errorf("offer address = %v, want %v", offer.YIAddr, want)
+	}
+	if offer.TransactionID != [4]byte{1, 2, 3, 4} {
+		t.Errorf("offer transaction ID = %v, want 01020304", offer.TransactionID)
+	}
+	if offer.BootFile != "pxelinux.0" || !offer.SIAddr.Equal(s.ip) {
+		t.Errorf("offer boot file = %q from %v, want pxelinux.0 from %v", offer.BootFile, offer.SIAddr, s.ip)
+	}
+	if got := net.IP(dhcp4opts.GetServerIdentifier(offer.Options)); !got.Equal(s.ip) {
+		t.Errorf("offer server identifier = %v, want %v", got, s.ip)
+	}
+	if got := net.IPMask(offer.Options.Get(dhcp4.OptionSubnetMask)); got.String() != "ffffff00" {
+		t.Errorf("offer subnet mask = %v, want ffffff00", got)
+	}
+	if got := net.IP(offer.Options.Get(dhcp4.OptionRouters)); !got.Equal(s.router) {
+		t.Errorf("offer router = %v, want %v", got, s.router)
+	}
+	if offer.Options.Get(dhcp4.OptionIPAddressLeaseTime) == nil {
+		t.Errorf("offer has no lease time")
+	}
+
+	ack := s.handle(request(mac, dhcp4opts.DHCPRequest, map[dhcp4.OptionCode]encoding.BinaryMarshaler{
+		dhcp4.OptionRequestedIPAddress: dhcp4opts.IP(offer.YIAddr),
+		dhcp4.OptionServerIdentifier:   dhcp4opts.IP(s.ip),
+	}))
+	if ack == nil || msgType(ack) != dhcp4opts.DHCPACK || !ack.YIAddr.Equal(offer.YIAddr) {
+		t.Fatalf("handle(REQUEST) = %v, want ACK of %v", ack, offer.YIAddr)
+	}
+
+	// Another client gets another address.
+	other := net.HardwareAddr{1, 2, 3, 4, 5, 7}
+	nak := s.handle(request(other, dhcp4opts.DHCPRequest, map[dhcp4.OptionCode]encoding.BinaryMarshaler{
+		dhcp4.OptionRequestedIPAddress: dhcp4opts.IP(offer.YIAddr),
+	}))
+	if nak == nil || msgType(nak) != dhcp4opts.DHCPNAK {
+		t.Errorf("handle(REQUEST) for a taken address = %v, want NAK", nak)
+	}
+	if offer := s.handle(request(other, dhcp4opts.DHCPDiscover, nil)); offer == nil || !offer.YIAddr.Equal(net.IP{192, 168, 1, 2}) {
+		t.Errorf("handle(DISCOVER) for another client = %v, want offer of 192.168.1.2", offer)
+	}
+
+	// After a release, the address is free again.
+	s.handle(request(mac, dhcp4opts.DHCPRelease, nil))
+	s.release(other.String())
+	if offer := s.handle(request(other, dhcp4opts.DHCPDiscover, nil)); offer == nil || !offer.YIAddr.Equal(net.IP{192, 168, 1, 1}) {
+		t.Errorf("handle(DISCOVER) after release = %v, want offer of 192.168.1.1", offer)
+	}
+}
+
+func TestDHCPRequestOtherServer(t *testing.T) {
+	s := newTestServer(t, "192.168.1.0/24")
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	offer := s.handle(request(mac, dhcp4opts.DHCPDiscover, nil))
+
+	if p := s.handle(request(mac, dhcp4opts.DHCPRequest, map[dhcp4.OptionCode]encoding.BinaryMarshaler{
+		dhcp4.OptionRequestedIPAddress: dhcp4opts.IP(offer.YIAddr),
+		dhcp4.OptionServerIdentifier:   dhcp4opts.IP(net.IP{192, 168, 0, 2}),
+	})); p != nil {
+		t.Errorf("handle(REQUEST) for another server = %v, want nil", p)
+	}
+	if _, ok := s.leases[mac.String()]; ok {
+		t.Errorf("offered address is still leased after the client chose another server")
+	}
+}
+
+func TestDHCPHTTPBoot(t *testing.T) {
+	s := newTestServer(t, "192.168.1.0/24")
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+
+	req := request(mac, dhcp4opts.DHCPDiscover, nil)
+	req.Options.AddRaw(dhcp4.OptionVendorClassIdentifier, []byte("HTTPClient:Arch:00016:UNDI:003001"))
+	offer := s.handle(req)
+	if offer == nil {
+		t.Fatalf("handle(DISCOVER) = nil, want offer")
+	}
+	if offer.BootFile != s.httpBootFile {
+		t.Errorf("offer boot file = %q, want %q", offer.BootFile, s.httpBootFile)
+	}
+	if got := string(offer.Options.Get(dhcp4.OptionVendorClassIdentifier)); got != "HTTPClient" {
+		t.Errorf("offer vendor class = %q, want HTTPClient", got)
+	}
+
+	// Boot file names that do not fit in the packet go in an option.
+	s.httpBootFile = "http://192.168.0.1/" + strings.Repeat("a", maxBootFileLen)
+	offer = s.handle(req)
+	if offer.BootFile != "" || string(offer.Options.Get(dhcp4.OptionBootFileName)) != s.httpBootFile {
+		t.Errorf("offer boot file = %q, option = %q, want option %q", offer.BootFile, offer.Options.Get(dhcp4.OptionBootFileName), s.httpBootFile)
+	}
+}
+
+func TestDHCPPool(t *testing.T) {
+	// 192.168.0.0 is the network, 192.168.0.1 is the server and
+	// 192.168.0.3 is the broadcast address.
+	s := newTestServer(t, "192.168.0.0/30")
+
+	offer := s.handle(request(net.HardwareAddr{1, 2, 3, 4, 5, 6}, dhcp4opts.DHCPDiscover, nil))
+	if offer == nil || !offer.YIAddr.Equal(net.IP{192, 168, 0, 2}) {
+		t.Fatalf("handle(DISCOVER) = %v, want offer of 192.168.0.2", offer)
+	}
+	if p := s.handle(request(net.HardwareAddr{1, 2, 3, 4, 5, 7}, dhcp4opts.DHCPDiscover, nil)); p != nil {
+		t.Errorf("handle(DISCOVER) with an exhausted pool = %v, want nil", p)
+	}
+}
+
+func TestDest(t *testing.T) {
+	for _, tt := range []struct {
+		giaddr, ciaddr net.IP
+		want           string
+	}{
+		{want: "255.255.255.255:68"},
+		{ciaddr: net.IP{192, 168, 1, 5}, want: "192.168.1.5:68"},
+		{giaddr: net.IP{10, 0, 0, 1}, ciaddr: net.IP{192, 168, 1, 5}, want: "10.0.0.1:67"},
+	} {
+		req := dhcp4.NewPacket(dhcp4.BootRequest)
+		req.GIAddr = tt.giaddr
+		req.CIAddr = tt.ciaddr
+		if got := dest(req).String(); got != tt.want {
+			t.Errorf("dest(giaddr %v, ciaddr %v) = %s, want %s", tt.giaddr, tt.ciaddr, got, tt.want)
+		}
+	}
+}
+
+func TestDHCPLeaseExpiry(t *testing.T) {
+	// 192.168.0.2 is the only address of the pool.
+	s := newTestServer(t, "192.168.0.0/30")
+	now := time.Unix(1000000, 0)
+	s.now = func() time.Time { return now }
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	other := net.HardwareAddr{1, 2, 3, 4, 5, 7}
+
+	// An offer that is never requested is given to another client once
+	// it runs out.
+	s.handle(request(mac, dhcp4opts.DHCPDiscover, nil))
+	if p := s.handle(request(other, dhcp4opts.DHCPDiscover, nil)); p != nil {
+		t.Fatalf("handle(DISCOVER) for an offered address = %v, want nil", p)
+	}
+	now = now.Add(offerTime + time.Second)
+	offer := s.handle(request(other, dhcp4opts.DHCPDiscover, nil))
+	if offer == nil || !offer.YIAddr.Equal(net.IP{192, 168, 0, 2}) {
+		t.Fatalf("handle(DISCOVER) after the offer expired = %v, want offer of 192.168.0.2", offer)
+	}
+	if _, ok := s.leases[mac.String()]; ok {
+		t.Errorf("expired offer to %s is still kept", mac)
+	}
+
+	ack := s.handle(request(other, dhcp4opts.DHCPRequest, map[dhcp4.OptionCode]encoding.BinaryMarshaler{
+		dhcp4.OptionRequestedIPAddress: dhcp4opts.IP(offer.YIAddr),
+	}))
+	if ack == nil || msgType(ack) != dhcp4opts.DHCPACK {
+		t.Fatalf("handle(REQUEST) = %v, want ACK", ack)
+	}
+
+	// The lease outlasts the offer time, but not the lease time.
+	now = now.Add(s.leaseTime / 2)
+	if p := s.handle(request(mac, dhcp4opts.DHCPDiscover, nil)); p != nil {
+		t.Errorf("handle(DISCOVER) for a leased address = %v, want nil", p)
+	}
+	now = now.Add(s.leaseTime)
+	if p := s.handle(request(mac, dhcp4opts.DHCPDiscover, nil)); p == nil || !p.YIAddr.Equal(net.IP{192, 168, 0, 2}) {
+		t.Errorf("handle(DISCOVER) after the lease expired = %v, want offer of 192.168.0.2", p)
+	}
+}
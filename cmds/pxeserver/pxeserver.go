@@ -0,0 +1,261 @@
+// Copyright 2018-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// pxeserver is a netboot server. It hands out addresses and boot files
+// with DHCPv4 and serves files with TFTP and HTTP.
+//
+// Synopsis:
+//     pxeserver [OPTIONS]
+//
+// Description:
+//     pxeserver lets one machine netboot others on an isolated network
+//     segment. Files are served from the directory -dir, or fetched from
+//     -root, which may be any URL pxeboot understands (file, tftp, http,
+//     https).
+//
+//     PXE clients are offered -bootfile over TFTP. UEFI HTTP boot clients,
+//     which identify with the HTTPClient vendor class, are offered
+//     -httpbootfile, which defaults to -bootfile on the HTTP server.
+//
+// Options:
+//     -ip:           IP of self (default: 192.168.0.1)
+//     -subnet:       CIDR of network to assign to clients (default: 192.168.1.0/24)
+//     -gateway:      router to send to clients
+//     -dns:          comma-separated DNS servers to send to clients
+//     -lease:        lease time (default: 1h)
+//     -dir:          directory to serve
+//     -root:         URL to serve files from instead of -dir
+//     -bootfile:     boot file for PXE clients (default: pxelinux.0)
+//     -httpbootfile: boot file URL for UEFI HTTP boot clients
+//     -dhcp:         serve DHCP (default: true)
+//     -interface:    interface to serve DHCP on (default: all)
+//     -tftp:         serve TFTP on port 69 (default: true)
+//     -http:         address to serve HTTP on; empty disables HTTP (default: :80)
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/pxe"
+	"github.com/u-root/u-root/pkg/uio"
+	"pack.ag/tftp"
+)
+
+var (
+	selfIP       = flag.String("ip", "192.168.0.1", "IP of self")
+	subnet       = flag.String("subnet", "192.168.1.0/24", "CIDR of network to assign to clients")
+	gateway      = flag.String("gateway", "", "router to send to clients")
+	dns          = flag.String("dns", "", "comma-separated DNS servers to send to clients")
+	leaseTime    = flag.Duration("lease", time.Hour, "lease time")
+	directory    = flag.String("dir", "", "Directory to serve")
+	root         = flag.String("root", "", "URL to serve files from instead of -dir")
+	bootFile     = flag.String("bootfile", "pxelinux.0", "boot file for PXE clients")
+	httpBootFile = flag.String("httpbootfile", "", "boot file URL for UEFI HTTP boot clients (default: -bootfile on the HTTP server)")
+	serveDHCP    = flag.Bool("dhcp", true, "serve DHCP")
+	iface        = flag.String("interface", "", "interface to serve DHCP on (default: all)")
+	serveTFTP    = flag.Bool("tftp", true, "serve TFTP")
+	httpAddr     = flag.String("http", ":80", "address to serve HTTP on; empty disables HTTP")
+)
+
+// fileServer serves the files below a URL.
+type fileServer struct {
+	root    *url.URL
+	schemes pxe.Schemes
+}
+
+// file is an opened file with what TFTP and HTTP need to serve it.
+type file struct {
+	io.ReadSeeker
+	size    int64
+	modTime time.Time
+}
+
+// open returns the file name relative to the root. name cannot escape the
+// root.
+func (fs *fileServer) open(name string) (*file, error) {
+	u := *fs.root
+	u.Path = path.Join(fs.root.Path, path.Clean("/"+name))
+	r, err := fs.schemes.GetFile(&u)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := r.(*os.File); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if fi.IsDir() {
+			f.Close()
+			return nil, fmt.Errorf("%s is a directory", name)
+		}
+		return &file{f, fi.Size(), fi.ModTime()}, nil
+	}
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &file{bytes.NewReader(b), int64(len(b)), time.Time{}}, nil
+}
+
+func (f *file) Close() error {
+	if c, ok := f.ReadSeeker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// serveTFTP implements tftp.ReadHandler.
+func (fs *fileServer) serveTFTP(w tftp.ReadRequest) {
+	f, err := fs.open(w.Name())
+	if err != nil {
+		log.Printf("TFTP: %s: %v", w.Name(), err)
+		w.WriteError(tftp.ErrCodeFileNotFound, fmt.Sprintf("File %q does not exist", w.Name()))
+		return
+	}
+	defer f.Close()
+
+	w.WriteSize(f.size)
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("TFTP: %s: %v", w.Name(), err)
+		return
+	}
+	log.Printf("TFTP: sent %s to %v", w.Name(), w.Addr())
+}
+
+// ServeHTTP implements http.Handler. Range requests are supported, so that
+// clients can resume downloads.
+func (fs *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := fs.open(r.URL.Path)
+	if err != nil {
+		log.Printf("HTTP: %s: %v", r.URL.Path, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	log.Printf("HTTP: %s %s to %s", r.Method, r.URL.Path, r.RemoteAddr)
+	http.ServeContent(w, r, r.URL.Path, f.modTime, f)
+}
+
+// rootURL returns the URL files are served from.
+func rootURL() (*url.URL, error) {
+	if len(*root) != 0 {
+		return url.Parse(*root)
+	}
+	if len(*directory) == 0 {
+		return nil, nil
+	}
+	dir, err := filepath.Abs(*directory)
+	if err != nil {
+		return nil, err
+	}
+	return &url.URL{Scheme: "file", Path: dir}, nil
+}
+
+func parseIPs(s string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, f := range strings.Split(s, ",") {
+		if len(f) == 0 {
+			continue
+		}
+		ip := net.ParseIP(f)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", f)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func main() {
+	flag.Parse()
+
+	self := net.ParseIP(*selfIP)
+	if self == nil || self.To4() == nil {
+		log.Fatalf("Invalid IPv4 address %q", *selfIP)
+	}
+	log.Printf("Self IP: %v", self)
+
+	u, err := rootURL()
+	if err != nil {
+		log.Fatal(err)
+	}
+	errs := make(chan error)
+	if u != nil {
+		fs := &fileServer{root: u, schemes: pxe.DefaultSchemes}
+		log.Printf("Serving files from %v", u)
+		if *serveTFTP {
+			server, err := tftp.NewServer(":69")
+			if err != nil {
+				log.Fatalf("Could not start TFTP server: %v", err)
+			}
+			server.ReadHandler(tftp.ReadHandlerFunc(fs.serveTFTP))
+			go func() {
+				errs <- fmt.Errorf("TFTP: %v", server.ListenAndServe())
+			}()
+		}
+		if len(*httpAddr) != 0 {
+			go func() {
+				errs <- fmt.Errorf("HTTP: %v", http.ListenAndServe(*httpAddr, fs))
+			}()
+		}
+	}
+
+	if *serveDHCP {
+		_, sn, err := net.ParseCIDR(*subnet)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s := newDHCPServer(self, sn)
+		s.leaseTime = *leaseTime
+		s.bootFile = *bootFile
+		s.httpBootFile = *httpBootFile
+		if len(s.httpBootFile) == 0 && u != nil && len(*httpAddr) != 0 {
+			_, port, err := net.SplitHostPort(*httpAddr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			s.httpBootFile = (&url.URL{
+				Scheme: "http",
+				Host:   net.JoinHostPort(self.String(), port),
+				Path:   path.Join("/", *bootFile),
+			}).String()
+		}
+		if len(*gateway) != 0 {
+			if s.router = net.ParseIP(*gateway).To4(); s.router == nil {
+				log.Fatalf("Invalid gateway %q", *gateway)
+			}
+		}
+		if s.dns, err = parseIPs(*dns); err != nil {
+			log.Fatal(err)
+		}
+
+		conn, err := listenDHCP(*iface)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		go func() {
+			errs <- s.serve(conn)
+		}()
+	}
+	log.Fatal(<-errs)
+}
@@ -0,0 +1,280 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// errOutside is returned for uploads to a path that a symlink takes
+	// out of the directory.
+	errOutside = errors.New("path is outside of the served directory")
+
+	// errTooLarge is returned for uploads larger than the limit.
+	errTooLarge = errors.New("upload is too large")
+)
+
+// fileHandler serves the files in a directory, and takes uploads to it.
+type fileHandler struct {
+	dir    string
+	upload bool
+	// maxUpload is the largest request body of an upload.
+	maxUpload int64
+	files     http.Handler
+}
+
+func newFileHandler(dir string, upload bool, maxUpload int64) http.Handler {
+	return &fileHandler{
+		dir:       dir,
+		upload:    upload,
+		maxUpload: maxUpload,
+		files:     maxAgeHandler(http.FileServer(http.Dir(dir))),
+	}
+}
+
+// local returns the file name of the URL path p. Cleaning p as an absolute
+// path keeps it in the directory.
+func (f *fileHandler) local(p string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// inside returns errOutside if name, once the symlinks in the part of it
+// that exists are resolved, is not in the directory. The rest of name is
+// created by uploads, without symlinks.
+func (f *fileHandler) inside(name string) error {
+	root, err := filepath.EvalSymlinks(f.dir)
+	if err != nil {
+		return err
+	}
+	existing := name
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		// A dangling symlink may point anywhere.
+		return errOutside
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errOutside
+	}
+	return nil
+}
+
+// limitedReader reads from r and fails with errTooLarge once more than n
+// bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// uploadError replies to a failed upload.
+func uploadError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case errOutside:
+		code = http.StatusForbidden
+	case errTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func (f *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		if wantJSON(r) && f.list(w, r) {
+			return
+		}
+		f.files.ServeHTTP(w, r)
+	case "PUT", "POST":
+		if !f.upload {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "uploads are not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.ContentLength > f.maxUpload {
+			uploadError(w, errTooLarge)
+			return
+		}
+		r.Body = ioutil.NopCloser(&limitedReader{r.Body, f.maxUpload})
+		if r.Method == "PUT" {
+			f.put(w, r)
+		} else {
+			f.post(w, r)
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// wantJSON returns whether r asks for a JSON directory listing.
+func wantJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// entry is a file in a JSON directory listing.
+type entry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// list writes the directory of r as JSON. It returns false if there is no
+// such directory.
+func (f *fileHandler) list(w http.ResponseWriter, r *http.Request) bool {
+	name := f.local(r.URL.Path)
+	if fi, err := os.Stat(name); err != nil || !fi.IsDir() {
+		return false
+	}
+	fis, err := ioutil.ReadDir(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	entries := make([]entry, 0, len(fis))
+	for _, fi := range fis {
+		entries = append(entries, entry{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			Mode:    fi.Mode().String(),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+	return true
+}
+
+// save writes r to the file name, creating its directory. The file is
+// written next to name first, so readers never see half of it. save returns
+// whether the file is new.
+func (f *fileHandler) save(name string, r io.Reader) (bool, error) {
+	if err := f.inside(name); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		return false, fmt.Errorf("%s is a directory", filepath.Base(name))
+	}
+	created := os.IsNotExist(err)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return false, err
+	}
+	return created, os.Rename(tmp.Name(), name)
+}
+
+// put saves the body of r to its path.
+func (f *fileHandler) put(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "can not PUT a directory", http.StatusBadRequest)
+		return
+	}
+	created, err := f.save(f.local(r.URL.Path), r.Body)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// post saves the files of the multipart form in r to its path, which is a
+// directory. Only the base names of the files are used.
+func (f *fileHandler) post(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir := f.local(r.URL.Path)
+	var saved []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == errTooLarge {
+			uploadError(w, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Some clients send Windows paths.
+		name := path.Base(strings.Replace(p.FileName(), `\`, "/", -1))
+		if p.FileName() == "" || name == "." || name == ".." || name == "/" {
+			continue
+		}
+		if _, err := f.save(filepath.Join(dir, name), p); err != nil {
+			uploadError(w, err)
+			return
+		}
+		saved = append(saved, path.Join(path.Clean("/"+r.URL.Path), name))
+	}
+	if len(saved) == 0 {
+		http.Error(w, "no files in form", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	for _, s := range saved {
+		fmt.Fprintln(w, s)
+	}
+}
@@ -5,24 +5,54 @@
 // Serve files on the network.
 //
 // Synopsis:
-//     srvfiles [--h=HOST] [--p=PORT] [--d=DIR]
+//     srvfiles [OPTIONS]
+//
+// Description:
+//     Directories are listed as HTML, or as JSON when the request asks for
+//     it with ?format=json or "Accept: application/json". Range requests
+//     are supported.
+//
+//     With -upload, files can be uploaded with PUT to their path, or with
+//     a multipart form POST of files to a directory.
 //
 // Options:
-//     --h: hostname (default: 127.0.0.1)
+//     --h: hostname or IP address, IPv6 included (default: 127.0.0.1)
 //     --p: port number (default: 8080)
 //     --d: directory to serve (default: .)
+//     --tls: serve HTTPS
+//     --cert: certificate for --tls; generated if the file does not exist
+//     --key: private key of --cert (default: the --cert file)
+//     --upload: allow uploads
+//     --max-upload: largest upload in bytes (default: 1 GiB)
+//     --user: user name for basic authentication
+//     --password: password for basic authentication
+//
+// Example:
+//     srvfiles -h :: -tls -cert /var/srvfiles.pem -upload -d /var/log
+//     curl -k -T dmesg.txt https://[fe80::1%eth0]:8080/host1/dmesg.txt
 package main
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"flag"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 )
 
 var (
-	host = flag.String("h", "127.0.0.1", "hostname")
-	port = flag.String("p", "8080", "port number")
-	dir  = flag.String("d", ".", "directory to serve")
+	host     = flag.String("h", "127.0.0.1", "hostname or IP address")
+	port     = flag.String("p", "8080", "port number")
+	dir      = flag.String("d", ".", "directory to serve")
+	useTLS   = flag.Bool("tls", false, "serve HTTPS")
+	certFile = flag.String("cert", "", "certificate for -tls; generated if the file does not exist")
+	keyFile  = flag.String("key", "", "private key of -cert (default: the -cert file)")
+	upload   = flag.Bool("upload", false, "allow uploads with PUT and POST")
+	maxSize  = flag.Int64("max-upload", 1<<30, "largest upload in bytes")
+	user     = flag.String("user", "", "user name for basic authentication")
+	password = flag.String("password", "", "password for basic authentication")
 )
 
 var cacheHeaders = []string{
@@ -46,8 +76,48 @@ func maxAgeHandler(h http.Handler) http.Handler {
 	})
 }
 
+// authHandler lets through only requests with the user name and password
+// given.
+func authHandler(h http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="srvfiles"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handler returns the handler for the files in dir. Uploads are at most
+// maxUpload bytes.
+func handler(dir string, upload bool, maxUpload int64, user, password string) http.Handler {
+	h := newFileHandler(dir, upload, maxUpload)
+	if user != "" || password != "" {
+		h = authHandler(h, user, password)
+	}
+	return h
+}
+
 func main() {
 	flag.Parse()
-	http.Handle("/", maxAgeHandler(http.FileServer(http.Dir(*dir))))
-	log.Fatal(http.ListenAndServe(*host+":"+*port, nil))
+	http.Handle("/", handler(*dir, *upload, *maxSize, *user, *password))
+	// JoinHostPort adds the brackets of IPv6 addresses.
+	addr := net.JoinHostPort(strings.Trim(*host, "[]"), *port)
+	if !*useTLS {
+		log.Fatal(http.ListenAndServe(addr, nil))
+	}
+
+	cert, err := loadCert(*certFile, *keyFile, *host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{
+		Addr:      addr,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	log.Fatal(srv.ListenAndServeTLS("", ""))
 }
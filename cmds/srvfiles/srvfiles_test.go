@@ -0,0 +1,304 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "srvfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func do(t *testing.T, h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRange(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := handler(dir, false, 1<<20, "", "")
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set("Range", "bytes=6-")
+	w := do(t, h, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "world" {
+		t.Errorf("GET bytes=6- = %d %q, want %d %q", w.Code, w.Body, http.StatusPartialContent, "world")
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := handler(dir, false, 1<<20, "", "")
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/?format=json", nil),
+		func() *http.Request {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept", "application/json")
+			return r
+		}(),
+	} {
+		w := do(t, h, req)
+		var got []entry
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%v: %v in %q", req.URL, err, w.Body)
+		}
+		if len(got) != 2 ||
+			got[0].Name != "hello" || got[0].Size != 11 || got[0].IsDir ||
+			got[1].Name != "sub" || !got[1].IsDir {
+			t.Errorf("%v: listing is %+v", req.URL, got)
+		}
+	}
+
+	// Files are served as they are, even when JSON is asked for.
+	if w := do(t, h, httptest.NewRequest("GET", "/hello?format=json", nil)); w.Body.String() != "hello world" {
+		t.Errorf("GET /hello?format=json = %q, want the file", w.Body)
+	}
+	if w := do(t, h, httptest.NewRequest("GET", "/none?format=json", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /none?format=json = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPut(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	h := handler(dir, false, 1<<20, "", "")
+	if w := do(t, h, httptest.NewRequest("PUT", "/new", strings.NewReader("x"))); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT without -upload = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	h = handler(dir, true, 1<<20, "", "")
+	for _, tt := range []struct {
+		path string
+		code int
+		file string
+	}{
+		{"/a/b/log.txt", http.StatusCreated, "a/b/log.txt"},
+		{"/a/b/log.txt", http.StatusNoContent, "a/b/log.txt"},
+		{"/../../escape", http.StatusCreated, "escape"},
+		{"/sub", http.StatusInternalServerError, ""},
+		{"/sub/", http.StatusBadRequest, ""},
+	} {
+		w := do(t, h, httptest.NewRequest("PUT", tt.path, strings.NewReader(tt.path)))
+		if w.Code != tt.code {
+			t.Errorf("PUT %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+		if tt.file == "" {
+			continue
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dir, tt.file)); err != nil || string(b) != tt.path {
+			t.Errorf("PUT %s wrote %q, %v, want %q", tt.path, b, err, tt.path)
+		}
+	}
+}
+
+func TestPost(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := handler(dir, true, 1<<20, "", "")
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range map[string]string{
+		"dmesg.txt":       "dmesg",
+		`C:\logs\sys.log`: "syslog",
+		"../../up.txt":    "up",
+	} {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.WriteField("comment", "not a file")
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/host1/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if w := do(t, h, req); w.Code != http.StatusCreated {
+		t.Fatalf("POST = %d %q, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+	for name, want := range map[string]string{
+		"host1/dmesg.txt": "dmesg",
+		"host1/sys.log":   "syslog",
+		"host1/up.txt":    "up",
+	} {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != want {
+			t.Errorf("%s is %q, %v, want %q", name, b, err, want)
+		}
+	}
+}
+
+func TestUploadSymlink(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "srvfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	for link, target := range map[string]string{
+		"out":      outside,
+		"in":       "sub",
+		"outfile":  filepath.Join(outside, "file"),
+		"dangling": filepath.Join(outside, "none"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := handler(dir, true, 1<<20, "", "")
+
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/out/file", http.StatusForbidden},
+		{"/out/new/file", http.StatusForbidden},
+		{"/outfile", http.StatusForbidden},
+		{"/dangling", http.StatusForbidden},
+		{"/in/file", http.StatusCreated},
+	} {
+		if w := do(t, h, httptest.NewRequest("PUT", tt.path, strings.NewReader("x"))); w.Code != tt.code {
+			t.Errorf("PUT %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+	if fis, err := ioutil.ReadDir(outside); err != nil || len(fis) != 0 {
+		t.Errorf("uploads wrote %d files outside of the directory: %v", len(fis), err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("x"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/out/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if w := do(t, h, req); w.Code != http.StatusForbidden {
+		t.Errorf("POST /out/ = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestUploadSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := handler(dir, true, 10, "", "")
+
+	for _, tt := range []struct {
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"0123456789", false, http.StatusCreated},
+		{"0123456789a", false, http.StatusRequestEntityTooLarge},
+		{"0123456789a", true, http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest("PUT", "/file", strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		os.Remove(filepath.Join(dir, "file"))
+		if w := do(t, h, req); w.Code != tt.code {
+			t.Errorf("PUT of %d bytes (chunked %t) = %d, want %d", len(tt.body), tt.chunked, w.Code, tt.code)
+		}
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "file")); err == nil {
+		t.Errorf("too large upload wrote %q", b)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := handler(dir, false, 1<<20, "user", "secret")
+
+	for _, tt := range []struct {
+		user, password string
+		code           int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"user", "wrong", http.StatusUnauthorized},
+		{"other", "secret", http.StatusUnauthorized},
+		{"user", "secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/hello", nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.password)
+		}
+		if w := do(t, h, req); w.Code != tt.code {
+			t.Errorf("GET as %s:%s = %d, want %d", tt.user, tt.password, w.Code, tt.code)
+		}
+	}
+}
+
+func TestLoadCert(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+
+	// The certificate made up on the first start is used afterwards.
+	first, err := loadCert(certFile, "", "::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadCert(certFile, "", "::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Errorf("certificate changed between starts")
+	}
+
+	// With a separate key file.
+	keyFile := filepath.Join(dir, "key.pem")
+	if _, err := loadCert(filepath.Join(dir, "cert2.pem"), keyFile, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Error(err)
+	}
+
+	s := httptest.NewUnstartedServer(handler(dir, false, 1<<20, "", ""))
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{first}}
+	s.StartTLS()
+	defer s.Close()
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := c.Get(s.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := ioutil.ReadAll(resp.Body); string(b) != "hello world" {
+		t.Errorf("GET over TLS = %q, want %q", b, "hello world")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// From linux/sock_diag.h, linux/inet_diag.h and linux/unix_diag.h.
+const (
+	sockDiagByFamily = 20
+
+	sizeofInetDiagReq = 56
+	sizeofInetDiagMsg = 72
+	sizeofUnixDiagReq = 24
+	sizeofUnixDiagMsg = 16
+
+	unixDiagName  = 0
+	unixDiagPeer  = 2
+	unixDiagRQLen = 4
+
+	udiagShowName  = 0x01
+	udiagShowPeer  = 0x04
+	udiagShowRQLen = 0x10
+
+	allStates = 0xffffffff
+)
+
+// inetDiagReq is struct inet_diag_req_v2 for a dump of all sockets.
+type inetDiagReq struct {
+	family   uint8
+	protocol uint8
+}
+
+func (r *inetDiagReq) Len() int { return sizeofInetDiagReq }
+
+func (r *inetDiagReq) Serialize() []byte {
+	b := make([]byte, sizeofInetDiagReq)
+	b[0], b[1] = r.family, r.protocol
+	nl.NativeEndian().PutUint32(b[4:], allStates)
+	return b
+}
+
+// unixDiagReq is struct unix_diag_req for a dump of all sockets.
+type unixDiagReq struct{}
+
+func (r *unixDiagReq) Len() int { return sizeofUnixDiagReq }
+
+func (r *unixDiagReq) Serialize() []byte {
+	b := make([]byte, sizeofUnixDiagReq)
+	b[0] = unix.AF_UNIX
+	native := nl.NativeEndian()
+	native.PutUint32(b[4:], allStates)
+	native.PutUint32(b[12:], udiagShowName|udiagShowPeer|udiagShowRQLen)
+	return b
+}
+
+func dump(data nl.NetlinkRequestData) ([][]byte, error) {
+	req := nl.NewNetlinkRequest(sockDiagByFamily, unix.NLM_F_DUMP)
+	req.AddData(data)
+	return req.Execute(unix.NETLINK_SOCK_DIAG, sockDiagByFamily)
+}
+
+// diagInet returns the sockets of family and protocol, like AF_INET and
+// IPPROTO_TCP, from sock_diag.
+func diagInet(family, protocol uint8, netid string) ([]socket, error) {
+	msgs, err := dump(&inetDiagReq{family: family, protocol: protocol})
+	if err != nil {
+		return nil, err
+	}
+	native := nl.NativeEndian()
+	var socks []socket
+	for _, m := range msgs {
+		if len(m) < sizeofInetDiagMsg {
+			return nil, fmt.Errorf("short inet_diag_msg of %d bytes", len(m))
+		}
+		iplen := net.IPv4len
+		if m[0] == unix.AF_INET6 {
+			iplen = net.IPv6len
+		}
+		socks = append(socks, socket{
+			netid: netid,
+			state: m[1],
+			local: endpoint{
+				ip:   net.IP(append([]byte{}, m[8:8+iplen]...)),
+				port: int(binary.BigEndian.Uint16(m[4:])),
+			},
+			peer: endpoint{
+				ip:   net.IP(append([]byte{}, m[24:24+iplen]...)),
+				port: int(binary.BigEndian.Uint16(m[6:])),
+			},
+			recvQ: native.Uint32(m[56:]),
+			sendQ: native.Uint32(m[60:]),
+			inode: uint64(native.Uint32(m[68:])),
+		})
+	}
+	return socks, nil
+}
+
+// diagUnix returns the UNIX sockets from sock_diag.
+func diagUnix() ([]socket, error) {
+	msgs, err := dump(&unixDiagReq{})
+	if err != nil {
+		return nil, err
+	}
+	native := nl.NativeEndian()
+	var socks []socket
+	for _, m := range msgs {
+		if len(m) < sizeofUnixDiagMsg {
+			return nil, fmt.Errorf("short unix_diag_msg of %d bytes", len(m))
+		}
+		s := socket{
+			netid: unixNetid(uint16(m[1])),
+			state: m[2],
+			inode: uint64(native.Uint32(m[4:])),
+		}
+		s.local.port = int(s.inode)
+		attrs, err := nl.ParseRouteAttr(m[sizeofUnixDiagMsg:])
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case unixDiagName:
+				s.local.path = unixPath(a.Value)
+			case unixDiagPeer:
+				if len(a.Value) >= 4 {
+					s.peer.port = int(native.Uint32(a.Value))
+				}
+			case unixDiagRQLen:
+				if len(a.Value) >= 8 {
+					s.recvQ, s.sendQ = native.Uint32(a.Value), native.Uint32(a.Value[4:])
+				}
+			}
+		}
+		socks = append(socks, s)
+	}
+	return socks, nil
+}
+
+// unixPath returns the bound name of a UNIX socket. Abstract names start
+// with a NUL byte, which is shown as @.
+func unixPath(b []byte) string {
+	if len(b) > 0 && b[0] == 0 {
+		return "@" + string(b[1:])
+	}
+	return strings.TrimRight(string(b), "\x00")
+}
@@ -0,0 +1,423 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// ss lists sockets.
+//
+// Synopsis:
+//
+//	ss [OPTIONS...] [FILTER]
+//
+// Description:
+//
+//	ss lists TCP, UDP and UNIX sockets. It asks the kernel with netlink
+//	sock_diag, and reads /proc/net if that fails.
+//
+//	By default, ss lists connected sockets. FILTER selects sockets by
+//	state and port:
+//
+//	    state STATE       only sockets in STATE; may be repeated
+//	    exclude STATE     no sockets in STATE; may be repeated
+//	    sport [=] [:]PORT only sockets of local port PORT
+//	    dport [=] [:]PORT only sockets of peer port PORT
+//
+//	STATE is one of established, syn-sent, syn-recv, fin-wait-1,
+//	fin-wait-2, time-wait, closed, close-wait, last-ack, listening,
+//	closing, or all, connected, synchronized, unconnected, bucket and big.
+//
+// Options:
+//
+//	-t, --tcp:       list TCP sockets
+//	-u, --udp:       list UDP sockets
+//	-x, --unix:      list UNIX sockets
+//	-4, --ipv4:      list IPv4 sockets only
+//	-6, --ipv6:      list IPv6 sockets only
+//	-l, --listening: list listening sockets only
+//	-a, --all:       list sockets in all states
+//	-p, --processes: show the processes using the sockets
+//	-n, --numeric:   do not resolve names; ss never does
+//	-H, --no-header: do not print the header
+//	-P, --proc:      read /proc/net instead of using sock_diag
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+	"github.com/u-root/u-root/pkg/procfs"
+)
+
+var (
+	tcp       = flag.BoolP("tcp", "t", false, "list TCP sockets")
+	udp       = flag.BoolP("udp", "u", false, "list UDP sockets")
+	unixSocks = flag.BoolP("unix", "x", false, "list UNIX sockets")
+	inet4     = flag.BoolP("ipv4", "4", false, "list IPv4 sockets only")
+	inet6     = flag.BoolP("ipv6", "6", false, "list IPv6 sockets only")
+	listening = flag.BoolP("listening", "l", false, "list listening sockets only")
+	all       = flag.BoolP("all", "a", false, "list sockets in all states")
+	processes = flag.BoolP("processes", "p", false, "show the processes using the sockets")
+	_         = flag.BoolP("numeric", "n", false, "do not resolve names; ss never does")
+	noHeader  = flag.BoolP("no-header", "H", false, "do not print the header")
+	useProc   = flag.BoolP("proc", "P", false, "read /proc/net instead of using sock_diag")
+
+	procRoot = "/proc"
+)
+
+// stateNames are the names ss shows states with.
+var stateNames = map[uint8]string{
+	procfs.TCPEstablished: "ESTAB",
+	procfs.TCPSynSent:     "SYN-SENT",
+	procfs.TCPSynRecv:     "SYN-RECV",
+	procfs.TCPFinWait1:    "FIN-WAIT-1",
+	procfs.TCPFinWait2:    "FIN-WAIT-2",
+	procfs.TCPTimeWait:    "TIME-WAIT",
+	procfs.TCPClose:       "UNCONN",
+	procfs.TCPCloseWait:   "CLOSE-WAIT",
+	procfs.TCPLastAck:     "LAST-ACK",
+	procfs.TCPListen:      "LISTEN",
+	procfs.TCPClosing:     "CLOSING",
+}
+
+// stateArgs are the names states are selected with.
+var stateArgs = map[string]uint32{
+	"established": 1 << procfs.TCPEstablished,
+	"syn-sent":    1 << procfs.TCPSynSent,
+	"syn-recv":    1 << procfs.TCPSynRecv,
+	"fin-wait-1":  1 << procfs.TCPFinWait1,
+	"fin-wait-2":  1 << procfs.TCPFinWait2,
+	"time-wait":   1 << procfs.TCPTimeWait,
+	"closed":      1 << procfs.TCPClose,
+	"close-wait":  1 << procfs.TCPCloseWait,
+	"last-ack":    1 << procfs.TCPLastAck,
+	"listening":   1 << procfs.TCPListen,
+	"closing":     1 << procfs.TCPClosing,
+
+	"all":          allStates,
+	"connected":    connected,
+	"synchronized": connected &^ (1 << procfs.TCPSynSent),
+	"unconnected":  1<<procfs.TCPListen | 1<<procfs.TCPClose,
+	"bucket":       1<<procfs.TCPSynRecv | 1<<procfs.TCPTimeWait,
+	"big":          allStates &^ (1<<procfs.TCPSynRecv | 1<<procfs.TCPTimeWait),
+}
+
+// connected are the states ss shows by default.
+const connected = allStates &^ (1<<procfs.TCPListen | 1<<procfs.TCPClose | 1<<procfs.TCPTimeWait | 1<<procfs.TCPSynRecv)
+
+// endpoint is the local or peer end of a socket. UNIX sockets have a path
+// and their inode as port.
+type endpoint struct {
+	ip   net.IP
+	path string
+	port int
+}
+
+func (e endpoint) String() string {
+	if e.ip == nil {
+		path := e.path
+		if path == "" {
+			path = "*"
+		}
+		return fmt.Sprintf("%s %d", path, e.port)
+	}
+	port := "*"
+	if e.port != 0 {
+		port = strconv.Itoa(e.port)
+	}
+	return net.JoinHostPort(e.ip.String(), port)
+}
+
+type socket struct {
+	netid string
+	state uint8
+	recvQ uint32
+	sendQ uint32
+	local endpoint
+	peer  endpoint
+	inode uint64
+}
+
+func unixNetid(typ uint16) string {
+	switch typ {
+	case syscall.SOCK_STREAM:
+		return "u_str"
+	case syscall.SOCK_DGRAM:
+		return "u_dgr"
+	case syscall.SOCK_SEQPACKET:
+		return "u_seq"
+	}
+	return "unix"
+}
+
+// filter selects sockets.
+type filter struct {
+	states uint32
+	sport  int
+	dport  int
+}
+
+func (f *filter) match(s *socket) bool {
+	return f.states&(1<<s.state) != 0 &&
+		(f.sport < 0 || s.local.ip != nil && s.local.port == f.sport) &&
+		(f.dport < 0 || s.peer.ip != nil && s.peer.port == f.dport)
+}
+
+// parsePort parses a port expression: [=|==|eq] [:]PORT.
+func parsePort(args []string) (int, []string, error) {
+	if len(args) > 0 && (args[0] == "=" || args[0] == "==" || args[0] == "eq") {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return 0, nil, fmt.Errorf("missing port")
+	}
+	p, err := strconv.ParseUint(strings.TrimPrefix(args[0], ":"), 10, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid port %q", args[0])
+	}
+	return int(p), args[1:], nil
+}
+
+// parseFilter parses the filter in args. states are the states selected
+// if args select none.
+func parseFilter(args []string, states uint32) (*filter, error) {
+	f := &filter{sport: -1, dport: -1}
+	var include, exclude uint32
+	for len(args) > 0 {
+		what := args[0]
+		args = args[1:]
+		var err error
+		switch what {
+		case "state", "exclude":
+			if len(args) == 0 {
+				return nil, fmt.Errorf("missing state")
+			}
+			s, ok := stateArgs[args[0]]
+			if !ok {
+				return nil, fmt.Errorf("unknown state %q", args[0])
+			}
+			if what == "state" {
+				include |= s
+			} else {
+				exclude |= s
+			}
+			args = args[1:]
+		case "sport":
+			f.sport, args, err = parsePort(args)
+		case "dport":
+			f.dport, args, err = parsePort(args)
+		default:
+			return nil, fmt.Errorf("unknown filter %q", what)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if include == 0 {
+		include = states
+		if exclude != 0 {
+			include = allStates
+		}
+	}
+	f.states = include &^ exclude
+	return f, nil
+}
+
+// inetProto is a kind of IP socket.
+type inetProto struct {
+	netid    string
+	protocol uint8
+	v4, v6   string // names in /proc/net
+}
+
+var (
+	tcpProto = inetProto{"tcp", syscall.IPPROTO_TCP, "tcp", "tcp6"}
+	udpProto = inetProto{"udp", syscall.IPPROTO_UDP, "udp", "udp6"}
+)
+
+func procInet(p inetProto, family uint8) ([]socket, error) {
+	name := p.v4
+	if family == syscall.AF_INET6 {
+		name = p.v6
+	}
+	ns, err := procfs.ReadNetIP(procRoot, name)
+	if err != nil {
+		return nil, err
+	}
+	var socks []socket
+	for _, n := range ns {
+		socks = append(socks, socket{
+			netid: p.netid,
+			state: n.State,
+			recvQ: n.RxQueue,
+			sendQ: n.TxQueue,
+			local: endpoint{ip: n.LocalIP, port: n.LocalPort},
+			peer:  endpoint{ip: n.RemoteIP, port: n.RemotePort},
+			inode: n.Inode,
+		})
+	}
+	return socks, nil
+}
+
+func procUnix() ([]socket, error) {
+	us, err := procfs.ReadNetUnix(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	var socks []socket
+	for _, u := range us {
+		socks = append(socks, socket{
+			netid: unixNetid(u.Type),
+			state: u.State,
+			local: endpoint{path: u.Path, port: int(u.Inode)},
+			inode: u.Inode,
+		})
+	}
+	return socks, nil
+}
+
+// inet returns the sockets of p in family, from sock_diag unless it fails
+// or -P is given.
+func inet(p inetProto, family uint8) ([]socket, error) {
+	if !*useProc {
+		if socks, err := diagInet(family, p.protocol, p.netid); err == nil {
+			return socks, nil
+		}
+	}
+	socks, err := procInet(p, family)
+	if os.IsNotExist(err) {
+		// No IPv6, say.
+		return nil, nil
+	}
+	return socks, err
+}
+
+func unixSockets() ([]socket, error) {
+	if !*useProc {
+		if socks, err := diagUnix(); err == nil {
+			return socks, nil
+		}
+	}
+	return procUnix()
+}
+
+// sockets returns the sockets selected by the flags.
+func sockets() ([]socket, error) {
+	showTCP, showUDP, showUnix := *tcp, *udp, *unixSocks
+	if !showTCP && !showUDP && !showUnix {
+		showTCP, showUDP = true, true
+		// -4 and -6 select IP sockets.
+		showUnix = !*inet4 && !*inet6
+	}
+	var families []uint8
+	if !*inet6 || *inet4 {
+		families = append(families, syscall.AF_INET)
+	}
+	if !*inet4 || *inet6 {
+		families = append(families, syscall.AF_INET6)
+	}
+
+	var socks []socket
+	for _, p := range []struct {
+		show  bool
+		proto inetProto
+	}{
+		{showTCP, tcpProto},
+		{showUDP, udpProto},
+	} {
+		if !p.show {
+			continue
+		}
+		for _, family := range families {
+			s, err := inet(p.proto, family)
+			if err != nil {
+				return nil, err
+			}
+			socks = append(socks, s...)
+		}
+	}
+	if showUnix {
+		s, err := unixSockets()
+		if err != nil {
+			return nil, err
+		}
+		socks = append(socks, s...)
+	}
+	return socks, nil
+}
+
+func users(owners []procfs.Owner) string {
+	if len(owners) == 0 {
+		return ""
+	}
+	var s []string
+	for _, o := range owners {
+		s = append(s, o.String())
+	}
+	return "users:(" + strings.Join(s, ",") + ")"
+}
+
+func show(w io.Writer, socks []socket, f *filter, owners map[uint64][]procfs.Owner) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	if !*noHeader {
+		fmt.Fprint(tw, "Netid\tState\tRecv-Q\tSend-Q\tLocal Address:Port\tPeer Address:Port")
+		if owners != nil {
+			fmt.Fprint(tw, "\tProcess")
+		}
+		fmt.Fprintln(tw)
+	}
+	for i := range socks {
+		s := &socks[i]
+		if !f.match(s) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%v\t%v", s.netid, stateNames[s.state], s.recvQ, s.sendQ, s.local, s.peer)
+		if owners != nil {
+			fmt.Fprintf(tw, "\t%s", users(owners[s.inode]))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func main() {
+	flag.Parse()
+
+	states := uint32(connected)
+	switch {
+	case *all:
+		states = allStates
+	case *listening:
+		states = 1<<procfs.TCPListen | 1<<procfs.TCPClose
+	}
+	f, err := parseFilter(flag.Args(), states)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	socks, err := sockets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Group sockets by kind, as ss does.
+	sort.SliceStable(socks, func(i, j int) bool {
+		return socks[i].netid < socks[j].netid
+	})
+
+	var owners map[uint64][]procfs.Owner
+	if *processes {
+		if owners, err = procfs.SocketOwners(procRoot); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := show(os.Stdout, socks, f, owners); err != nil {
+		log.Fatal(err)
+	}
+}
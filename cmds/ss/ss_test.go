@@ -0,0 +1,120 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/procfs"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		states uint32
+		want   filter
+	}{
+		{
+			states: connected,
+			want:   filter{states: connected, sport: -1, dport: -1},
+		},
+		{
+			args:   []string{"state", "listening", "sport", "=", ":22"},
+			states: connected,
+			want:   filter{states: 1 << procfs.TCPListen, sport: 22, dport: -1},
+		},
+		{
+			args:   []string{"state", "established", "state", "time-wait", "dport", "443"},
+			states: connected,
+			want:   filter{states: 1<<procfs.TCPEstablished | 1<<procfs.TCPTimeWait, sport: -1, dport: 443},
+		},
+		{
+			args:   []string{"exclude", "closed"},
+			states: connected,
+			want:   filter{states: allStates &^ (1 << procfs.TCPClose), sport: -1, dport: -1},
+		},
+	} {
+		got, err := parseFilter(tt.args, tt.states)
+		if err != nil {
+			t.Errorf("parseFilter(%q) = %v", tt.args, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseFilter(%q) = %+v, want %+v", tt.args, *got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"state"},
+		{"state", "sleepy"},
+		{"sport"},
+		{"dport", "eq", "http"},
+		{"src", "1.2.3.4"},
+	} {
+		if _, err := parseFilter(args, connected); err == nil {
+			t.Errorf("parseFilter(%q) succeeded, want error", args)
+		}
+	}
+}
+
+func TestShow(t *testing.T) {
+	socks := []socket{
+		{
+			netid: "tcp",
+			state: procfs.TCPListen,
+			sendQ: 128,
+			local: endpoint{ip: net.IPv6zero, port: 22},
+			peer:  endpoint{ip: net.IPv6zero},
+			inode: 1,
+		},
+		{
+			netid: "tcp",
+			state: procfs.TCPEstablished,
+			recvQ: 3,
+			local: endpoint{ip: net.IPv4(10, 0, 0, 1), port: 22},
+			peer:  endpoint{ip: net.IPv4(10, 0, 0, 2), port: 40000},
+			inode: 2,
+		},
+		{
+			netid: "u_str",
+			state: procfs.TCPEstablished,
+			local: endpoint{path: "@bus", port: 3},
+			peer:  endpoint{port: 4},
+			inode: 3,
+		},
+	}
+	owners := map[uint64][]procfs.Owner{
+		2: {{PID: 7, Cmd: "sshd", FD: 4}},
+		3: {{PID: 1, Cmd: "init", FD: 9}, {PID: 8, Cmd: "dbus", FD: 5}},
+	}
+	f := &filter{states: connected, sport: -1, dport: -1}
+
+	var b bytes.Buffer
+	if err := show(&b, socks, f, owners); err != nil {
+		t.Fatal(err)
+	}
+	want := `Netid State Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+tcp   ESTAB 3      0      10.0.0.1:22        10.0.0.2:40000    users:(("sshd",pid=7,fd=4))
+u_str ESTAB 0      0      @bus 3             * 4               users:(("init",pid=1,fd=9),("dbus",pid=8,fd=5))
+`
+	if b.String() != want {
+		t.Errorf("show =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	f = &filter{states: allStates, sport: 22, dport: -1}
+	if err := show(&b, socks, f, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = `Netid State  Recv-Q Send-Q Local Address:Port Peer Address:Port
+tcp   LISTEN 0      128    [::]:22            [::]:*
+tcp   ESTAB  3      0      10.0.0.1:22        10.0.0.2:40000
+`
+	if b.String() != want {
+		t.Errorf("show =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -5,7 +5,6 @@
 package main
 
 import (
-	"crypto/sha1"
 	"crypto/sha256"
 	"flag"
 	"io/ioutil"
@@ -14,12 +13,11 @@ import (
 	"os/exec"
 	"syscall"
 
-	"github.com/google/go-tpm/tpm"
+	"github.com/u-root/u-root/pkg/tpm"
 	"golang.org/x/crypto/ed25519"
 )
 
 const (
-	tpmDevice  string = "/dev/tpm0"
 	mountPath  string = "/mnt/vboot"
 	filesystem string = "ext3"
 )
@@ -71,9 +69,6 @@ func main() {
 	kernelDigest := sha256.Sum256(files[*linuxKernel])
 	initrdDigest := sha256.Sum256(files[*initrd])
 
-	pcrDigestKernel := sha1.Sum(files[*linuxKernel])
-	pcrDigestInitrd := sha1.Sum(files[*initrd])
-
 	kernelSuccess := ed25519.Verify(files[*publicKey], kernelDigest[:], files[*linuxKernelSignature])
 	initrdSuccess := ed25519.Verify(files[*publicKey], initrdDigest[:], files[*initrdSignature])
 
@@ -82,13 +77,18 @@ func main() {
 	}
 
 	if !*noTPM {
-		rwc, err := tpm.OpenTPM(tpmDevice)
+		t, err := tpm.Open(tpm.DefaultDevice)
 		if err != nil {
 			die(err)
 		}
 
-		tpm.PcrExtend(rwc, uint32(*pcr), pcrDigestKernel)
-		tpm.PcrExtend(rwc, uint32(*pcr), pcrDigestInitrd)
+		// Extend every PCR bank of the TPM, SHA-1 only on a TPM 1.2.
+		for _, f := range []string{*linuxKernel, *initrd} {
+			if err := t.Extend(uint32(*pcr), tpm.Digests(t, files[f])); err != nil {
+				die(err)
+			}
+		}
+		t.Close()
 	}
 
 	binary, lookErr := exec.LookPath("kexec")
@@ -5,53 +5,344 @@
 // Wget reads one file from a url and writes to stdout.
 //
 // Synopsis:
-//     wget URL
+//     wget [OPTIONS] URL
 //
 // Description:
 //     Returns a non-zero code on failure.
 //
+//     URL may be http, https, tftp or file. The file is downloaded to
+//     FILE.part and renamed to FILE when complete and verified.
+//
+// Options:
+//     -O FILE:                 output file, - for stdout
+//     -c:                      continue a partial download
+//     -t N:                    retry failed requests N times (default 3)
+//     -waitretry SECONDS:      wait before the first retry; doubles with every retry (default 1)
+//     -q:                      do not show progress
+//     -user USER:              user name for HTTP basic authentication
+//     -password PASSWORD:      password for HTTP basic authentication
+//     -bearer TOKEN:           token for HTTP bearer authentication
+//     -header "NAME: VALUE":   add a header to requests; may be repeated
+//     -proxy URL:              proxy for requests; by default, $http_proxy and friends are used
+//     -no-proxy:               do not use a proxy
+//     -ca-certificate FILE:    trust the CAs in FILE for HTTPS
+//     -certificate FILE:       client certificate for HTTPS
+//     -private-key FILE:       private key of the client certificate
+//     -no-check-certificate:   do not verify the server's certificate
+//     -sha256 HEX:             verify the SHA-256 checksum of the file
+//
 // Notes:
 //     There are a few differences with GNU wget:
 //     - Upon error, the return value is always 1.
-//     - The protocol (http/https) is mandatory.
+//     - The protocol is mandatory.
 //
 // Example:
 //     wget -O google.txt http://google.com/
+//     wget -c -sha256 e3b0c442... https://example.com/os.img
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/u-root/u-root/pkg/pxe"
 )
 
+// headers is a flag.Value for repeated -header flags.
+type headers http.Header
+
+func (h headers) String() string {
+	var s []string
+	for k, v := range h {
+		for _, v := range v {
+			s = append(s, k+": "+v)
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+func (h headers) Set(s string) error {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 {
+		return fmt.Errorf("header %q is not NAME: VALUE", s)
+	}
+	http.Header(h).Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
 var (
-	outPath = flag.String("O", "", "output file")
+	outPath   = flag.String("O", "", "output file, - for stdout")
+	cont      = flag.Bool("c", false, "continue a partial download")
+	tries     = flag.Int("t", 3, "retry failed requests this many times")
+	waitRetry = flag.Float64("waitretry", 1, "seconds to wait before the first retry; doubles with every retry")
+	quiet     = flag.Bool("q", false, "do not show progress")
+	user      = flag.String("user", "", "user name for HTTP basic authentication")
+	password  = flag.String("password", "", "password for HTTP basic authentication")
+	bearer    = flag.String("bearer", "", "token for HTTP bearer authentication")
+	proxy     = flag.String("proxy", "", "proxy for requests; by default, $http_proxy and friends are used")
+	noProxy   = flag.Bool("no-proxy", false, "do not use a proxy")
+	caFile    = flag.String("ca-certificate", "", "trust the CAs in this file for HTTPS")
+	certFile  = flag.String("certificate", "", "client certificate for HTTPS")
+	keyFile   = flag.String("private-key", "", "private key of the client certificate")
+	insecure  = flag.Bool("no-check-certificate", false, "do not verify the server's certificate")
+	checksum  = flag.String("sha256", "", "verify the SHA-256 checksum of the file")
+
+	header = headers{}
 )
 
-func wget(arg, fileName string) error {
-	resp, err := http.Get(arg)
+func init() {
+	flag.Var(header, "header", "add a header to requests, as \"NAME: VALUE\"; may be repeated")
+}
+
+// errChecksum is returned when a file does not have the checksum asked for.
+var errChecksum = errors.New("SHA-256 checksum mismatch")
+
+// download is a download of a URL.
+type download struct {
+	schemes pxe.Schemes
+	url     *url.URL
+
+	// resume continues a partial download.
+	resume bool
+	// sha256 is the checksum the file must have, if not nil.
+	sha256 []byte
+	// progress is where progress is shown, if not nil.
+	progress io.Writer
+}
+
+// sizer is a file that knows its size.
+type sizer interface {
+	Size() int64
+}
+
+// size returns the size of r, or -1 if it is not known.
+func size(r io.ReaderAt) int64 {
+	switch r := r.(type) {
+	case sizer:
+		return r.Size()
+	case *os.File:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// copy copies the file from off on to w, hashing it with h.
+func (d *download) copy(w io.Writer, off int64, h hash.Hash) error {
+	r, err := d.schemes.GetFile(d.url)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("non-200 HTTP status: %d", resp.StatusCode)
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	w = io.MultiWriter(w, h)
+	var p *progress
+	if d.progress != nil {
+		p = &progress{w: d.progress, r: r, n: off, off: off, start: time.Now()}
+		w = io.MultiWriter(w, p)
 	}
+	_, err = io.Copy(w, io.NewSectionReader(r, off, math.MaxInt64-off))
+	if p != nil {
+		p.done()
+	}
+	if err != nil {
+		return &pxe.URLError{URL: d.url, Err: err}
+	}
+	return nil
+}
+
+func (d *download) verify(h hash.Hash) error {
+	if d.sha256 == nil {
+		return nil
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, d.sha256) {
+		return fmt.Errorf("%v: got %x, want %x", errChecksum, sum, d.sha256)
+	}
+	return nil
+}
+
+// to downloads the file to w, which can not be resumed or renamed.
+func (d *download) to(w io.Writer) error {
+	h := sha256.New()
+	if err := d.copy(w, 0, h); err != nil {
+		return err
+	}
+	return d.verify(h)
+}
 
-	w, err := os.Create(fileName)
+// toFile downloads the file to FILE.part and renames it to FILE when
+// complete and verified.
+func (d *download) toFile(name string) error {
+	part := name + ".part"
+	flags := os.O_RDWR | os.O_CREATE
+	if d.resume {
+		if _, err := os.Stat(part); os.IsNotExist(err) {
+			// Continue an incomplete FILE, as GNU wget does.
+			if err := os.Rename(name, part); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(part, flags, 0644)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
+	defer f.Close()
 
-	_, err = io.Copy(w, resp.Body)
-	return err
+	// Hash what is there already.
+	h := sha256.New()
+	off, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if err := d.copy(f, off, h); err != nil {
+		// Keep what was read for -c.
+		if fi, serr := f.Stat(); serr == nil && fi.Size() == 0 {
+			os.Remove(part)
+		}
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := d.verify(h); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, name)
+}
+
+// progress shows the progress of a download.
+type progress struct {
+	w io.Writer
+	r io.ReaderAt
+	// n is how much of the file is there. off of it was there before.
+	n, off int64
+	start  time.Time
+	last   time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	if time.Since(p.last) >= 200*time.Millisecond {
+		p.show()
+	}
+	return len(b), nil
+}
+
+func (p *progress) show() {
+	p.last = time.Now()
+	var rate float64
+	if d := p.last.Sub(p.start); d > 0 {
+		rate = float64(p.n-p.off) / d.Seconds()
+	}
+	var s string
+	if total := size(p.r); total > 0 {
+		s = fmt.Sprintf("%3d%% %s of %s", p.n*100/total, humanize.Bytes(uint64(p.n)), humanize.Bytes(uint64(total)))
+	} else {
+		s = humanize.Bytes(uint64(p.n))
+	}
+	// Pad to overwrite longer lines.
+	fmt.Fprintf(p.w, "\r%-40s", s+", "+humanize.Bytes(uint64(rate))+"/s")
+}
+
+func (p *progress) done() {
+	p.show()
+	fmt.Fprintln(p.w)
+}
+
+// client returns the HTTP client the flags ask for.
+func client() (*http.Client, error) {
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: *insecure},
+	}
+	switch {
+	case *noProxy:
+		t.Proxy = nil
+	case *proxy != "":
+		u, err := url.Parse(*proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if *caFile != "" {
+		pem, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig.RootCAs = x509.NewCertPool()
+		if !t.TLSClientConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", *caFile)
+		}
+	}
+	if *certFile != "" || *keyFile != "" {
+		key := *keyFile
+		if key == "" {
+			key = *certFile
+		}
+		cert, err := tls.LoadX509KeyPair(*certFile, key)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// httpScheme is a pxe.FileScheme for HTTP and HTTPS. Its files are read
+// with requests for the range asked for, so downloads resume where a file
+// on disk or a broken connection stopped.
+type httpScheme struct {
+	*pxe.HTTPClient
+}
+
+// GetFile implements pxe.FileScheme.GetFile. Nothing is requested until the
+// file is read.
+func (h httpScheme) GetFile(u *url.URL) (io.ReaderAt, error) {
+	return h.OpenFile(u), nil
+}
+
+// schemes returns the schemes wget supports: those of pxe, with HTTP and
+// HTTPS done as the flags ask.
+func schemes(c *http.Client, h http.Header) pxe.Schemes {
+	s := make(pxe.Schemes)
+	for k, v := range pxe.DefaultSchemes {
+		s.Register(k, v)
+	}
+	hc := pxe.NewHTTPClient(c)
+	hc.Header = h
+	hc.Retries = *tries
+	hc.RetryDelay = time.Duration(*waitRetry * float64(time.Second))
+	hs := httpScheme{hc}
+	s.Register("http", hs)
+	s.Register("https", hs)
+	return s
 }
 
 func usage() {
@@ -70,20 +361,65 @@ func main() {
 		log.Fatalln("Empty URL")
 	}
 
-	url, err := url.Parse(argURL)
+	u, err := url.Parse(argURL)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	if *outPath == "" {
-		if url.Path != "" && url.Path[len(url.Path)-1] != '/' {
-			*outPath = path.Base(url.Path)
+		if u.Path != "" && u.Path[len(u.Path)-1] != '/' {
+			*outPath = path.Base(u.Path)
 		} else {
 			*outPath = "index.html"
 		}
 	}
 
-	if err := wget(argURL, *outPath); err != nil {
+	h := http.Header(header)
+	if *user != "" || *password != "" {
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*user+":"+*password)))
+	}
+	if *bearer != "" {
+		h.Set("Authorization", "Bearer "+*bearer)
+	}
+	c, err := client()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	d := &download{
+		schemes: schemes(c, h),
+		url:     u,
+		resume:  *cont,
+	}
+	if *checksum != "" {
+		if d.sha256, err = hex.DecodeString(*checksum); err != nil || len(d.sha256) != sha256.Size {
+			log.Fatalf("invalid SHA-256 checksum %q", *checksum)
+		}
+	}
+	if !*quiet && *outPath != "-" {
+		d.progress = os.Stderr
+	}
+
+	if err := get(d, *outPath); err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// get downloads d to the file out, or to stdout if out is "-". Devices are
+// written in place.
+func get(d *download, out string) error {
+	if out == "-" {
+		return d.to(os.Stdout)
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Mode().IsRegular() {
+		return d.toFile(out)
+	}
+	w, err := os.OpenFile(out, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := d.to(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
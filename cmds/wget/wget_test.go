@@ -8,13 +8,24 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/u-root/u-root/pkg/pxe"
 	"github.com/u-root/u-root/pkg/testutil"
 )
 
@@ -76,13 +87,13 @@ var tests = []struct {
 		retCode: 1,
 	}, {
 		// 5xx error
-		flags:   []string{},
+		flags:   []string{"-t", "0"},
 		url:     "http://localhost:%[1]d/500",
 		content: "",
 		retCode: 1,
 	}, {
 		// no server
-		flags:   []string{},
+		flags:   []string{"-t", "0"},
 		url:     "http://localhost:%[2]d/200",
 		content: "",
 		retCode: 1,
@@ -116,9 +127,7 @@ func TestWget(t *testing.T) {
 	port := l.Addr().(*net.TCPAddr).Port
 
 	h := handler{}
-	go func() {
-		t.Fatal(http.Serve(l, h))
-	}()
+	go http.Serve(l, h)
 
 	for i, tt := range tests {
 		args := append(tt.flags, fmt.Sprintf(tt.url, port, unusedPort))
@@ -147,3 +156,281 @@ func TestWget(t *testing.T) {
 func TestMain(m *testing.M) {
 	testutil.Run(m, main)
 }
+
+// fileServer serves content, with Range support, at /file. It breaks off
+// the first response at /broken halfway, fails the first request to
+// /flaky, and wants a user and a header at /auth.
+type fileServer struct {
+	content []byte
+
+	mu       sync.Mutex
+	requests []*http.Request
+	broken   bool
+	flaky    bool
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	broken, flaky := !s.broken, !s.flaky
+	switch r.URL.Path {
+	case "/broken":
+		s.broken = true
+	case "/flaky":
+		s.flaky = true
+	}
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/broken":
+		if broken {
+			w.Header().Set("Content-Length", fmt.Sprint(len(s.content)))
+			w.Write(s.content[:len(s.content)/2])
+			w.(http.Flusher).Flush()
+			c, _, _ := w.(http.Hijacker).Hijack()
+			c.Close()
+			return
+		}
+	case "/flaky":
+		if flaky {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+	case "/auth":
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "secret" || r.Header.Get("X-Test") != "yes" {
+			http.Error(w, "go away", http.StatusUnauthorized)
+			return
+		}
+	case "/file":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+}
+
+func (s *fileServer) ranges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r []string
+	for _, req := range s.requests {
+		r = append(r, req.Header.Get("Range"))
+	}
+	s.requests = nil
+	return r
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	sum := sha256.Sum256(content)
+	fs := &fileServer{content: content}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+	if err := ioutil.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	authHeader := http.Header{}
+	authHeader.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")))
+	authHeader.Set("X-Test", "yes")
+
+	for _, tt := range []struct {
+		name   string
+		url    string
+		header http.Header
+		// part is what is in FILE.part before.
+		part   []byte
+		resume bool
+		sha256 []byte
+		ranges []string
+		err    bool
+	}{
+		{
+			name:   "plain",
+			url:    srv.URL + "/file",
+			sha256: sum[:],
+			ranges: []string{""},
+		},
+		{
+			name:   "checksum mismatch",
+			url:    srv.URL + "/file",
+			sha256: make([]byte, sha256.Size),
+			ranges: []string{""},
+			err:    true,
+		},
+		{
+			name:   "resume",
+			url:    srv.URL + "/file",
+			part:   content[:1000],
+			resume: true,
+			sha256: sum[:],
+			ranges: []string{"bytes=1000-"},
+		},
+		{
+			name:   "resume complete",
+			url:    srv.URL + "/file",
+			part:   content,
+			resume: true,
+			sha256: sum[:],
+			ranges: []string{fmt.Sprintf("bytes=%d-", len(content))},
+		},
+		{
+			name:   "no resume",
+			url:    srv.URL + "/file",
+			part:   []byte("junk"),
+			sha256: sum[:],
+			ranges: []string{""},
+		},
+		{
+			name:   "broken connection",
+			url:    srv.URL + "/broken",
+			sha256: sum[:],
+			ranges: []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)},
+		},
+		{
+			name:   "retry",
+			url:    srv.URL + "/flaky",
+			sha256: sum[:],
+			ranges: []string{"", ""},
+		},
+		{
+			name:   "auth",
+			url:    srv.URL + "/auth",
+			header: authHeader,
+			sha256: sum[:],
+			ranges: []string{""},
+		},
+		{
+			name:   "no auth",
+			url:    srv.URL + "/auth",
+			ranges: []string{""},
+			err:    true,
+		},
+		{
+			name:   "not found",
+			url:    srv.URL + "/nothing",
+			ranges: []string{""},
+			err:    true,
+		},
+		{
+			name:   "file",
+			url:    "file://" + local,
+			part:   content[:10],
+			resume: true,
+			sha256: sum[:],
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, "out")
+			os.Remove(out)
+			os.Remove(out + ".part")
+			if tt.part != nil {
+				if err := ioutil.WriteFile(out+".part", tt.part, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := make(pxe.Schemes)
+			s.Register("file", &pxe.LocalFileClient{})
+			hc := pxe.NewHTTPClient(http.DefaultClient)
+			hc.Header, hc.Retries, hc.RetryDelay = tt.header, 1, time.Millisecond
+			s.Register("http", httpScheme{hc})
+			d := &download{schemes: s, url: u, resume: tt.resume, sha256: tt.sha256}
+
+			err = d.toFile(out)
+			if (err != nil) != tt.err {
+				t.Errorf("toFile = %v, want error %t", err, tt.err)
+			}
+			if got := fs.ranges(); !reflect.DeepEqual(got, tt.ranges) {
+				t.Errorf("requested ranges %q, want %q", got, tt.ranges)
+			}
+			if tt.err {
+				if _, err := os.Stat(out); err == nil {
+					t.Errorf("%s exists after failed download", out)
+				}
+				return
+			}
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded %d bytes, want the %d bytes of content", len(got), len(content))
+			}
+			if _, err := os.Stat(out + ".part"); !os.IsNotExist(err) {
+				t.Errorf("%s.part is left after download: %v", out, err)
+			}
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	h := headers{}
+	for _, s := range []string{"X-A: 1", "x-a:2", "Accept:  text/plain "} {
+		if err := h.Set(s); err != nil {
+			t.Errorf("Set(%q) = %v", s, err)
+		}
+	}
+	want := http.Header{"X-A": {"1", "2"}, "Accept": {"text/plain"}}
+	if !reflect.DeepEqual(http.Header(h), want) {
+		t.Errorf("headers = %v, want %v", h, want)
+	}
+	if err := h.Set("no colon"); err == nil {
+		t.Errorf("Set(%q) succeeded, want error", "no colon")
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(&fileServer{content: []byte(content)})
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, pemCert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(ca string, noCheck bool) {
+		*caFile, *insecure = ca, noCheck
+	}(*caFile, *insecure)
+	for _, tt := range []struct {
+		name     string
+		ca       string
+		insecure bool
+		err      bool
+	}{
+		{name: "unknown CA", err: true},
+		{name: "CA file", ca: ca},
+		{name: "no check", insecure: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			*caFile, *insecure = tt.ca, tt.insecure
+			c, err := client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Get(srv.URL + "/file")
+			if (err != nil) != tt.err {
+				t.Fatalf("Get = %v, want error %t", err, tt.err)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -28,7 +28,7 @@ func TestDhclient(t *testing.T) {
 			"github.com/u-root/u-root/cmds/init",
 			"github.com/u-root/u-root/cmds/sleep",
 			"github.com/u-root/u-root/cmds/shutdown",
-			"github.com/u-root/u-root/integration/testcmd/pxeserver",
+			"github.com/u-root/u-root/cmds/pxeserver",
 		},
 		Uinit: []string{
 			"ip addr add 192.168.0.1/24 dev eth0",
@@ -73,7 +73,7 @@ func TestDhclient(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := dhcpClient.Expect("inet 192.168.1.0"); err != nil {
+	if err := dhcpClient.Expect("inet 192.168.1.1"); err != nil {
 		t.Error(err)
 	}
 	t.Logf("Server: %s\nClient: %s", sb.String(), cb.String())
@@ -91,7 +91,7 @@ func TestPxeboot(t *testing.T) {
 		Cmds: []string{
 			"github.com/u-root/u-root/cmds/init",
 			"github.com/u-root/u-root/cmds/ip",
-			"github.com/u-root/u-root/integration/testcmd/pxeserver",
+			"github.com/u-root/u-root/cmds/pxeserver",
 		},
 		Uinit: []string{
 			"ip addr add 192.168.0.1/24 dev eth0",
@@ -17,6 +17,10 @@ type OSImage interface {
 	// given the printed information.
 	ExecutionInfo(log *log.Logger)
 
+	// Load loads the OS image into memory without jumping to it, so that
+	// the caller can still measure or log before rebooting.
+	Load() error
+
 	// Execute kexec's the OS image: it loads the OS image into memory and
 	// jumps to the kernel's entry point.
 	Execute() error
@@ -31,6 +35,8 @@ var (
 		"linux": func(a *cpio.Archive) (OSImage, error) {
 			return NewLinuxImageFromArchive(a)
 		},
-		"multiboot": newMultibootImage,
+		"multiboot": func(a *cpio.Archive) (OSImage, error) {
+			return NewMultibootImageFromArchive(a)
+		},
 	}
 )
@@ -0,0 +1,34 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// CatInitrds returns an initrd that is the concatenation of initrds.
+//
+// Each initrd is padded with zeroes to a multiple of 4 bytes, since Linux
+// expects every cpio archive in the initramfs to start 4-byte aligned.
+// The initrds are only read when the result is.
+func CatInitrds(initrds ...io.ReaderAt) io.ReaderAt {
+	return uio.NewLazyOpenerAt(func() (io.ReaderAt, error) {
+		var buf bytes.Buffer
+		for _, i := range initrds {
+			b, err := uio.ReadAll(i)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+			for buf.Len()%4 != 0 {
+				buf.WriteByte(0)
+			}
+		}
+		return bytes.NewReader(buf.Bytes()), nil
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/u-root/u-root/pkg/boot/linux"
 	"github.com/u-root/u-root/pkg/cpio"
 	"github.com/u-root/u-root/pkg/kexec"
 	"github.com/u-root/u-root/pkg/uio"
@@ -25,6 +26,10 @@ type LinuxImage struct {
 	Kernel  io.ReaderAt
 	Initrd  io.ReaderAt
 	Cmdline string
+
+	// DTB is the flattened device tree to boot the kernel with, on
+	// platforms that use one. It is optional.
+	DTB io.ReaderAt
 }
 
 var _ OSImage = &LinuxImage{}
@@ -51,6 +56,9 @@ func NewLinuxImageFromArchive(a *cpio.Archive) (*LinuxImage, error) {
 	if initrd, ok := a.Files["modules/initrd/content"]; ok {
 		li.Initrd = initrd
 	}
+	if dtb, ok := a.Files["modules/dtb/content"]; ok {
+		li.DTB = dtb
+	}
 	return li, nil
 }
 
@@ -90,6 +98,19 @@ func (li *LinuxImage) Pack(sw cpio.RecordWriter) error {
 		}
 	}
 
+	if li.DTB != nil {
+		if err := sw.WriteRecord(cpio.Directory("modules/dtb", 0700)); err != nil {
+			return err
+		}
+		dtb, err := uio.ReadAll(li.DTB)
+		if err != nil {
+			return err
+		}
+		if err := sw.WriteRecord(cpio.StaticFile("modules/dtb/content", string(dtb), 0700)); err != nil {
+			return err
+		}
+	}
+
 	return sw.WriteRecord(cpio.StaticFile("package_type", "linux", 0700))
 }
 
@@ -134,11 +155,27 @@ func (li *LinuxImage) ExecutionInfo(l *log.Logger) {
 	if i != nil {
 		l.Printf("Initrd: %s", i.Name())
 	}
+	if li.DTB != nil {
+		d, err := copyToFile(uio.Reader(li.DTB))
+		if err != nil {
+			l.Printf("Copying DTB to file: %v", err)
+		} else {
+			defer d.Close()
+			l.Printf("DTB: %s", d.Name())
+		}
+	}
 	l.Printf("Command line: %s", li.Cmdline)
 }
 
-// Execute implements OSImage.Execute and kexec's the kernel with its initramfs.
-func (li *LinuxImage) Execute() error {
+// Load implements OSImage.Load and loads the kernel with its initramfs.
+//
+// It uses kexec_file_load(2) if the kernel and the architecture have it,
+// and loads the kernel itself with kexec_load(2) otherwise; see package
+// linux.
+func (li *LinuxImage) Load() error {
+	if li.Kernel == nil {
+		return ErrKernelMissing
+	}
 	k, err := copyToFile(uio.Reader(li.Kernel))
 	if err != nil {
 		return err
@@ -154,7 +191,19 @@ func (li *LinuxImage) Execute() error {
 		defer i.Close()
 	}
 
-	if err := kexec.FileLoad(k, i, li.Cmdline); err != nil {
+	err = kexec.FileLoad(k, i, li.Cmdline)
+	if err != kexec.ErrFileLoadUnsupported {
+		return err
+	}
+	if lerr := linux.KexecLoad(k, i, li.Cmdline, li.DTB); lerr != nil {
+		return fmt.Errorf("%v, and kexec_load failed: %v", err, lerr)
+	}
+	return nil
+}
+
+// Execute implements OSImage.Execute and kexec's the kernel with its initramfs.
+func (li *LinuxImage) Execute() error {
+	if err := li.Load(); err != nil {
 		return err
 	}
 	return kexec.Reboot()
@@ -0,0 +1,261 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/zimage"
+)
+
+// Arm64Header is the header of an arm64 Image. See
+// Documentation/arm64/booting.txt.
+type Arm64Header struct {
+	Code0      uint32
+	Code1      uint32
+	TextOffset uint64
+	ImageSize  uint64
+	Flags      uint64
+	Res2       uint64
+	Res3       uint64
+	Res4       uint64
+	Magic      uint32
+	Res5       uint32
+}
+
+const (
+	// Arm64Magic is the magic number of an arm64 Image, "ARM\x64".
+	Arm64Magic = 0x644d5241
+
+	// arm64FlagBE is set in Arm64Header.Flags if the kernel is big
+	// endian.
+	arm64FlagBE = 1 << 0
+
+	// arm64KernelAlign is the alignment of the base the Image is placed at
+	// TextOffset bytes after.
+	arm64KernelAlign = 0x200000
+
+	// maxDTBSize is the largest device tree arm64 kernels take.
+	maxDTBSize = 0x200000
+)
+
+// ErrImageSizeUnknown is returned for arm64 Images of kernels older than
+// 3.17, whose header has no image size.
+var ErrImageSizeUnknown = errors.New("arm64 Image has no image size")
+
+const (
+	// armTextOffset is where in RAM the zImage decompresses the kernel
+	// to, and where it is placed.
+	armTextOffset = 0x8000
+
+	// armLowMem is how much of the start of RAM is surely in lowmem,
+	// which is where the kernel expects its initramfs and device tree.
+	armLowMem = 512 << 20
+)
+
+// arm64Trampoline is purgatory for arm64 kernels. The kernel's kexec
+// enters it with the MMU off and x0 zero; it enters the kernel with the
+// device tree address in x0 and x1-x3 zero, as the boot protocol asks.
+// The addresses of the device tree and of the kernel are patched in; see
+// trampolineAt.
+var arm64Trampoline = []byte{
+	0xc0, 0x00, 0x00, 0x58, // ldr x0, 0x18 (dtb)
+	0xe1, 0x03, 0x1f, 0xaa, // mov x1, xzr
+	0xe2, 0x03, 0x1f, 0xaa, // mov x2, xzr
+	0xe3, 0x03, 0x1f, 0xaa, // mov x3, xzr
+	0x84, 0x00, 0x00, 0x58, // ldr x4, 0x20 (kernel)
+	0x80, 0x00, 0x1f, 0xd6, // br x4
+	// 0x18: device tree address.
+	0, 0, 0, 0, 0, 0, 0, 0,
+	// 0x20: kernel address.
+	0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// armTrampoline is purgatory for 32-bit ARM kernels, which it enters with
+// r0 0, r1 ~0 for a device tree boot, and the device tree address in r2.
+var armTrampoline = []byte{
+	0x00, 0x00, 0xa0, 0xe3, // mov r0, #0
+	0x00, 0x10, 0xe0, 0xe3, // mvn r1, #0
+	0x00, 0x20, 0x9f, 0xe5, // ldr r2, [pc] (dtb)
+	0x00, 0xf0, 0x9f, 0xe5, // ldr pc, [pc] (kernel)
+	// 0x10: device tree address.
+	0, 0, 0, 0,
+	// 0x14: kernel address.
+	0, 0, 0, 0,
+}
+
+// trampolineAt returns the trampoline t, which is one of arm64Trampoline
+// and armTrampoline, with the addresses of the device tree and the kernel
+// in its last two words of size bytes.
+func trampolineAt(t []byte, size int, dtb, kernel uintptr) []byte {
+	t = append([]byte(nil), t...)
+	put := func(b []byte, v uintptr) {
+		if size == 8 {
+			binary.LittleEndian.PutUint64(b, uint64(v))
+		} else {
+			binary.LittleEndian.PutUint32(b, uint32(v))
+		}
+	}
+	put(t[len(t)-2*size:], dtb)
+	put(t[len(t)-size:], kernel)
+	return t
+}
+
+// ramStart returns the lowest address of RAM in mem.
+func ramStart(mem *kexec.Memory) (uintptr, error) {
+	for _, r := range mem.Phys {
+		if r.Type == kexec.RangeRAM {
+			return r.Start, nil
+		}
+	}
+	return 0, errors.New("no RAM in memory map")
+}
+
+// addSegment adds a segment of b at addr of size bytes to mem. size may be
+// larger than b, for memory the kernel needs beyond its image.
+func addSegment(mem *kexec.Memory, b []byte, addr uintptr, size uint) {
+	mem.Segments = append(mem.Segments, kexec.NewSegment(b, kexec.Range{Start: addr, Size: size}))
+}
+
+// loadDT places the initrd, which may be empty, above min and below max,
+// then the device tree fdt for the kernel with it and cmdline, and
+// returns the address of the device tree.
+func loadDT(mem *kexec.Memory, fdt *dt.FDT, initrd []byte, cmdline string, rng io.Reader, min, max uintptr) (uintptr, error) {
+	var initrdRange kexec.Range
+	if len(initrd) > 0 {
+		addr, err := mem.FindSpaceIn(uint(len(initrd)), 0, min, max)
+		if err != nil {
+			return 0, fmt.Errorf("no space for the initrd: %v", err)
+		}
+		initrdRange = kexec.Range{Start: addr, Size: uint(len(initrd))}
+		addSegment(mem, initrd, addr, initrdRange.Size)
+	}
+
+	if err := fixupChosen(fdt, cmdline, initrdRange, rng); err != nil {
+		return 0, err
+	}
+	var b bytes.Buffer
+	if _, err := fdt.Write(&b); err != nil {
+		return 0, fmt.Errorf("writing device tree: %v", err)
+	}
+	if b.Len() > maxDTBSize {
+		return 0, fmt.Errorf("device tree of %d bytes is larger than %d bytes", b.Len(), maxDTBSize)
+	}
+	dtbAddr, err := mem.FindSpaceIn(uint(b.Len()), 0, min, max)
+	if err != nil {
+		return 0, fmt.Errorf("no space for the device tree: %v", err)
+	}
+	addSegment(mem, b.Bytes(), dtbAddr, uint(b.Len()))
+	return dtbAddr, nil
+}
+
+// loadArm64Image adds kexec segments to mem that boot the arm64 Image
+// kernel with initrd, cmdline and the device tree fdt, and returns the
+// entry point. mem.Phys must hold the memory map. The /chosen node of fdt
+// is changed for the kernel.
+func loadArm64Image(mem *kexec.Memory, kernel, initrd []byte, fdt *dt.FDT, cmdline string, rng io.Reader) (uintptr, error) {
+	var h Arm64Header
+	if err := binary.Read(bytes.NewReader(kernel), binary.LittleEndian, &h); err != nil {
+		return 0, fmt.Errorf("reading arm64 Image header: %v", err)
+	}
+	if h.Magic != Arm64Magic {
+		return 0, fmt.Errorf("not an arm64 Image: magic is %#08x, want %#08x", h.Magic, Arm64Magic)
+	}
+	if h.Flags&arm64FlagBE != 0 {
+		return 0, errors.New("big endian arm64 kernels are not supported")
+	}
+	if h.ImageSize == 0 {
+		return 0, ErrImageSizeUnknown
+	}
+	size := uint(h.ImageSize)
+	if size < uint(len(kernel)) {
+		size = uint(len(kernel))
+	}
+
+	// The lowest 2 MiB aligned base, which is as close to the start of
+	// RAM as the kernel may want it.
+	base, err := mem.FindSpaceIn(uint(h.TextOffset)+size, arm64KernelAlign, 0, ^uintptr(0))
+	if err != nil {
+		return 0, fmt.Errorf("no space for the kernel: %v", err)
+	}
+	kernelAddr := base + uintptr(h.TextOffset)
+	addSegment(mem, kernel, kernelAddr, size)
+
+	kernelEnd := kernelAddr + uintptr(size)
+	dtbAddr, err := loadDT(mem, fdt, initrd, cmdline, rng, kernelEnd, ^uintptr(0))
+	if err != nil {
+		return 0, err
+	}
+
+	t := trampolineAt(arm64Trampoline, 8, dtbAddr, kernelAddr)
+	trampolineAddr, err := mem.FindSpaceIn(uint(len(t)), 0, kernelEnd, ^uintptr(0))
+	if err != nil {
+		return 0, fmt.Errorf("no space for the trampoline: %v", err)
+	}
+	addSegment(mem, t, trampolineAddr, uint(len(t)))
+	return trampolineAddr, nil
+}
+
+// zImageSize returns how much memory from where it is placed the zImage
+// kernel needs to decompress itself: its own size, which it moves past the
+// decompressed kernel, plus that of the decompressed kernel and its BSS.
+// Without a size table, it assumes the kernel decompresses to four times
+// its size.
+func zImageSize(z *zimage.ZImage, kernel []byte) (uint, error) {
+	piggySizeAddr, bssSize, err := z.GetKernelSizes()
+	if err != nil {
+		return 4 * uint(len(kernel)), nil
+	}
+	if int(piggySizeAddr)+4 > len(kernel) {
+		return 0, fmt.Errorf("zImage kernel size at %#x is past its end %#x", piggySizeAddr, len(kernel))
+	}
+	decompressed := binary.LittleEndian.Uint32(kernel[piggySizeAddr:])
+	return uint(len(kernel)) + uint(decompressed) + uint(bssSize), nil
+}
+
+// loadZImage adds kexec segments to mem that boot the 32-bit ARM zImage
+// kernel with initrd, cmdline and the device tree fdt, and returns the
+// entry point. mem.Phys must hold the memory map. The /chosen node of fdt
+// is changed for the kernel.
+func loadZImage(mem *kexec.Memory, kernel, initrd []byte, fdt *dt.FDT, cmdline string, rng io.Reader) (uintptr, error) {
+	z, err := zimage.Parse(bytes.NewReader(kernel))
+	if err != nil {
+		return 0, err
+	}
+	size, err := zImageSize(z, kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	start, err := ramStart(mem)
+	if err != nil {
+		return 0, err
+	}
+	kernelAddr := start + armTextOffset
+	if addr, err := mem.FindSpaceIn(size, 0, kernelAddr, start+armLowMem); err != nil || addr != kernelAddr {
+		return 0, fmt.Errorf("no space for the kernel at %#x", kernelAddr)
+	}
+	addSegment(mem, kernel, kernelAddr, size)
+
+	kernelEnd := kernelAddr + uintptr(size)
+	dtbAddr, err := loadDT(mem, fdt, initrd, cmdline, rng, kernelEnd, start+armLowMem)
+	if err != nil {
+		return 0, err
+	}
+
+	t := trampolineAt(armTrampoline, 4, dtbAddr, kernelAddr)
+	trampolineAddr, err := mem.FindSpaceIn(uint(len(t)), 0, kernelEnd, start+armLowMem)
+	if err != nil {
+		return 0, fmt.Errorf("no space for the trampoline: %v", err)
+	}
+	addSegment(mem, t, trampolineAddr, uint(len(t)))
+	return trampolineAddr, nil
+}
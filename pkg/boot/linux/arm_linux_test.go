@@ -0,0 +1,311 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// reg returns a reg property value of 2-cell addresses and sizes.
+func reg(vs ...uint64) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = append(b, u64(v)...)
+	}
+	return b
+}
+
+// testFDT returns a device tree with 1 GiB of RAM at 1 GiB, of which the
+// first 64 KiB and 1 MiB at 0x50000000 are reserved.
+func testFDT() *dt.FDT {
+	return &dt.FDT{
+		ReserveEntries: []dt.ReserveEntry{{Address: 0x40000000, Size: 0x10000}},
+		RootNode: &dt.Node{
+			Properties: []dt.Property{
+				{Name: "#address-cells", Value: u32(2)},
+				{Name: "#size-cells", Value: u32(2)},
+			},
+			Children: []*dt.Node{
+				{Name: "memory@40000000", Properties: []dt.Property{
+					{Name: "device_type", Value: []byte("memory\x00")},
+					{Name: "reg", Value: reg(0x40000000, 0x40000000)},
+				}},
+				{Name: "reserved-memory", Properties: []dt.Property{
+					{Name: "#address-cells", Value: u32(2)},
+					{Name: "#size-cells", Value: u32(2)},
+				}, Children: []*dt.Node{
+					{Name: "fw@50000000", Properties: []dt.Property{
+						{Name: "reg", Value: reg(0x50000000, 0x100000)},
+					}},
+					{Name: "cma", Properties: []dt.Property{
+						{Name: "size", Value: reg(0x4000000)},
+					}},
+				}},
+				{Name: "chosen", Properties: []dt.Property{
+					{Name: "bootargs", Value: []byte("console=ttyAMA0\x00")},
+					{Name: "linux,initrd-start", Value: u32(0x44000000)},
+					{Name: "linux,initrd-end", Value: u32(0x44cb8fc4)},
+				}},
+			},
+		},
+	}
+}
+
+func ranges(mem *kexec.Memory) []kexec.Range {
+	var rs []kexec.Range
+	for _, r := range mem.Phys {
+		rs = append(rs, r.Range)
+	}
+	return rs
+}
+
+func TestMemoryMap(t *testing.T) {
+	var mem kexec.Memory
+	if err := memoryMap(&mem, testFDT(), nil); err != nil {
+		t.Fatalf("memoryMap() = %v", err)
+	}
+	want := []kexec.Range{
+		{Start: 0x40010000, Size: 0xfff0000},
+		{Start: 0x50100000, Size: 0x2ff00000},
+	}
+	if got := ranges(&mem); !reflect.DeepEqual(got, want) {
+		t.Errorf("memoryMap() = %v, want %v", got, want)
+	}
+
+	// Without memory nodes, RAM comes from /proc/iomem.
+	fdt := testFDT()
+	fdt.RootNode.Children = fdt.RootNode.Children[1:]
+	const iomem = `09000000-09000fff : pl011@9000000
+  09000000-09000fff : pl011@9000000
+40000000-7fffffff : System RAM
+  40080000-40dfffff : Kernel code
+  48000000-480fffff : reserved
+  4c000000-4cffffff : Kernel data
+c0000000-c0ffffff : reserved
+`
+	if err := memoryMap(&mem, fdt, strings.NewReader(iomem)); err != nil {
+		t.Fatalf("memoryMap() with /proc/iomem = %v", err)
+	}
+	want = []kexec.Range{
+		{Start: 0x40010000, Size: 0x7ff0000},
+		{Start: 0x48100000, Size: 0x7f00000},
+		{Start: 0x50100000, Size: 0x2ff00000},
+	}
+	if got := ranges(&mem); !reflect.DeepEqual(got, want) {
+		t.Errorf("memoryMap() with /proc/iomem = %v, want %v", got, want)
+	}
+
+	if err := memoryMap(&mem, fdt, nil); err == nil {
+		t.Errorf("memoryMap() without any memory succeeded")
+	}
+	if err := memoryMap(&mem, fdt, strings.NewReader("40000000 : System RAM\n")); err == nil {
+		t.Errorf("memoryMap() with an invalid /proc/iomem succeeded")
+	}
+}
+
+// chosen returns the properties of /chosen of fdt.
+func chosen(t *testing.T, fdt *dt.FDT) map[string][]byte {
+	c, ok := fdt.RootNode.Child("chosen")
+	if !ok {
+		t.Fatalf("device tree has no /chosen")
+	}
+	props := make(map[string][]byte)
+	for _, p := range c.Properties {
+		props[p.Name] = p.Value
+	}
+	return props
+}
+
+func TestFixupChosen(t *testing.T) {
+	seeds := bytes.Repeat([]byte{0x5a}, 8+rngSeedSize)
+
+	fdt := testFDT()
+	if err := fixupChosen(fdt, "root=/dev/vda", kexec.Range{}, bytes.NewReader(seeds)); err != nil {
+		t.Fatalf("fixupChosen() = %v", err)
+	}
+	want := map[string][]byte{
+		"bootargs":   []byte("root=/dev/vda\x00"),
+		"kaslr-seed": seeds[:8],
+		"rng-seed":   seeds[8:],
+	}
+	if got := chosen(t, fdt); !reflect.DeepEqual(got, want) {
+		t.Errorf("/chosen without initrd is %q, want %q", got, want)
+	}
+
+	// A device tree without /chosen gets one.
+	fdt.RootNode.Children = fdt.RootNode.Children[:2]
+	if err := fixupChosen(fdt, "", kexec.Range{Start: 0x48000000, Size: 0x1000}, bytes.NewReader(seeds)); err != nil {
+		t.Fatalf("fixupChosen() = %v", err)
+	}
+	want = map[string][]byte{
+		"bootargs":           []byte("\x00"),
+		"linux,initrd-start": u64(0x48000000),
+		"linux,initrd-end":   u64(0x48001000),
+		"kaslr-seed":         seeds[:8],
+		"rng-seed":           seeds[8:],
+	}
+	if got := chosen(t, fdt); !reflect.DeepEqual(got, want) {
+		t.Errorf("/chosen with initrd is %q, want %q", got, want)
+	}
+
+	if err := fixupChosen(fdt, "", kexec.Range{}, bytes.NewReader(nil)); err == nil {
+		t.Errorf("fixupChosen() without randomness succeeded")
+	}
+}
+
+func testArm64Image(flags, imageSize uint64) []byte {
+	k := make([]byte, 0x10000)
+	binary.LittleEndian.PutUint64(k[8:], 0x80000)
+	binary.LittleEndian.PutUint64(k[16:], imageSize)
+	binary.LittleEndian.PutUint64(k[24:], flags)
+	binary.LittleEndian.PutUint32(k[56:], Arm64Magic)
+	return k
+}
+
+// loadedFDT returns the /chosen node of the device tree segment at addr.
+func loadedFDT(t *testing.T, mem *kexec.Memory, addr uintptr) map[string][]byte {
+	fdt, err := dt.Read(bytes.NewReader(ptrBytes(segment(t, mem, addr))))
+	if err != nil {
+		t.Fatalf("device tree at %#x: %v", addr, err)
+	}
+	return chosen(t, fdt)
+}
+
+func TestLoadArm64Image(t *testing.T) {
+	var mem kexec.Memory
+	fdt := testFDT()
+	if err := memoryMap(&mem, fdt, nil); err != nil {
+		t.Fatal(err)
+	}
+	kernel := testArm64Image(0, 0x1000000)
+	initrd := bytes.Repeat([]byte("initrd"), 1000)
+	entry, err := loadArm64Image(&mem, kernel, initrd, fdt, "root=/dev/vda", bytes.NewReader(make([]byte, 128)))
+	if err != nil {
+		t.Fatalf("loadArm64Image() = %v", err)
+	}
+
+	tr := ptrBytes(segment(t, &mem, entry))
+	if !bytes.Equal(tr[:0x18], arm64Trampoline[:0x18]) {
+		t.Errorf("trampoline code is % x, want % x", tr[:0x18], arm64Trampoline[:0x18])
+	}
+	dtbAddr := uintptr(binary.LittleEndian.Uint64(tr[0x18:]))
+	kernelAddr := uintptr(binary.LittleEndian.Uint64(tr[0x20:]))
+
+	// The first 2 MiB aligned base above the reservation at 1 GiB.
+	if kernelAddr != 0x40280000 {
+		t.Errorf("kernel is at %#x, want %#x", kernelAddr, 0x40280000)
+	}
+	ks := segment(t, &mem, kernelAddr)
+	if ks.Phys.Size != 0x1000000 || !bytes.Equal(ptrBytes(ks), kernel) {
+		t.Errorf("kernel segment is %v, want the kernel in image_size 0x1000000 bytes", ks)
+	}
+
+	c := loadedFDT(t, &mem, dtbAddr)
+	initrdAddr := uintptr(binary.BigEndian.Uint64(c["linux,initrd-start"]))
+	if end := binary.BigEndian.Uint64(c["linux,initrd-end"]); end != uint64(initrdAddr)+uint64(len(initrd)) {
+		t.Errorf("initrd is at %#x to %#x, want %d bytes", initrdAddr, end, len(initrd))
+	}
+	if !bytes.Equal(ptrBytes(segment(t, &mem, initrdAddr)), initrd) {
+		t.Errorf("initrd segment does not hold the initrd")
+	}
+	if string(c["bootargs"]) != "root=/dev/vda\x00" {
+		t.Errorf("bootargs is %q", c["bootargs"])
+	}
+	for _, a := range []uintptr{initrdAddr, dtbAddr, entry} {
+		if a < kernelAddr+0x1000000 {
+			t.Errorf("%#x is not after the kernel", a)
+		}
+	}
+	for i, s := range mem.Segments {
+		for _, s2 := range mem.Segments[i+1:] {
+			if s.Phys.Overlaps(s2.Phys) {
+				t.Errorf("segments %v and %v overlap", s, s2)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		kernel []byte
+		want   error
+	}{
+		{"magic", make([]byte, 0x1000), nil},
+		{"big endian", testArm64Image(arm64FlagBE, 0x1000000), nil},
+		{"image size", testArm64Image(0, 0), ErrImageSizeUnknown},
+		{"short", []byte{0}, nil},
+	} {
+		var mem kexec.Memory
+		memoryMap(&mem, testFDT(), nil)
+		_, err := loadArm64Image(&mem, tt.kernel, nil, testFDT(), "", bytes.NewReader(make([]byte, 128)))
+		if err == nil || (tt.want != nil && err != tt.want) {
+			t.Errorf("%s: loadArm64Image() = %v, want an error", tt.name, err)
+		}
+	}
+}
+
+func TestLoadZImage(t *testing.T) {
+	kernel, err := ioutil.ReadFile("../../zimage/testdata/zImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mem kexec.Memory
+	fdt := testFDT()
+	fdt.ReserveEntries = nil
+	if err := memoryMap(&mem, fdt, nil); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := loadZImage(&mem, kernel, []byte("initrd"), fdt, "console=ttyAMA0", bytes.NewReader(make([]byte, 128)))
+	if err != nil {
+		t.Fatalf("loadZImage() = %v", err)
+	}
+
+	tr := ptrBytes(segment(t, &mem, entry))
+	if !bytes.Equal(tr[:0x10], armTrampoline[:0x10]) {
+		t.Errorf("trampoline code is % x, want % x", tr[:0x10], armTrampoline[:0x10])
+	}
+	dtbAddr := uintptr(binary.LittleEndian.Uint32(tr[0x10:]))
+	kernelAddr := uintptr(binary.LittleEndian.Uint32(tr[0x14:]))
+	if kernelAddr != 0x40008000 {
+		t.Errorf("kernel is at %#x, want %#x", kernelAddr, 0x40008000)
+	}
+	// The zImage, the decompressed kernel whose size is at 0xd55f5, and
+	// the BSS.
+	decompressed := uint(binary.LittleEndian.Uint32(kernel[0xd55f5:]))
+	if ks := segment(t, &mem, kernelAddr); ks.Phys.Size != uint(len(kernel))+decompressed+0x2b83c {
+		t.Errorf("kernel segment is %v, want %#x bytes", ks, uint(len(kernel))+decompressed+0x2b83c)
+	}
+	c := loadedFDT(t, &mem, dtbAddr)
+	initrdAddr := uintptr(binary.BigEndian.Uint64(c["linux,initrd-start"]))
+	for _, a := range []uintptr{initrdAddr, dtbAddr, entry} {
+		if a < kernelAddr+uintptr(len(kernel))+uintptr(decompressed) || a >= 0x40000000+armLowMem {
+			t.Errorf("%#x is not between the kernel and the end of lowmem", a)
+		}
+	}
+
+	// The kernel must go at the start of RAM.
+	mem = kexec.Memory{}
+	memoryMap(&mem, testFDT(), nil)
+	mem.Segments = append(mem.Segments, kexec.Segment{Phys: kexec.Range{Start: 0x40100000, Size: 0x1000}})
+	if _, err := loadZImage(&mem, kernel, nil, testFDT(), "", bytes.NewReader(make([]byte, 128))); err == nil {
+		t.Errorf("loadZImage() with the start of RAM in use succeeded")
+	}
+	if _, err := loadZImage(&mem, testArm64Image(0, 0x1000000), nil, testFDT(), "", bytes.NewReader(make([]byte, 128))); err == nil {
+		t.Errorf("loadZImage() of an arm64 Image succeeded")
+	}
+}
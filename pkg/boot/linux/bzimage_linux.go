@@ -0,0 +1,293 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package linux loads Linux kernels with kexec_load(2), for when
+// kexec_file_load(2) is not available: on kernels built without
+// CONFIG_KEXEC_FILE, and on architectures that do not have it.
+//
+// Unlike kexec_file_load(2), kexec_load(2) leaves it to user space to put
+// the kernel, initramfs and boot parameters where the kernel's boot
+// protocol expects them, and to enter the kernel the way it expects.
+//
+// KexecLoad supports bzImage kernels on amd64, and arm64 and 32-bit ARM
+// kernels booted with a device tree. On other architectures, 386 included,
+// it returns an error.
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/u-root/u-root/pkg/bzimage"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// Offsets in the zero page, the struct boot_params of the x86 boot
+// protocol. See Documentation/x86/zero-page.txt.
+const (
+	zeroPageSize = 0x1000
+
+	extRamdiskImageOff = 0x0c0
+	extRamdiskSizeOff  = 0x0c4
+	extCmdlinePtrOff   = 0x0c8
+	e820EntriesOff     = bzimage.E820NR
+	setupHeaderOff     = 0x1f1
+	typeOfLoaderOff    = 0x210
+	code32StartOff     = 0x214
+	ramdiskImageOff    = 0x218
+	ramdiskSizeOff     = 0x21c
+	cmdlinePtrOff      = 0x228
+	e820TableOff       = bzimage.E820Map
+
+	// e820EntrySize is the size of a struct boot_e820_entry.
+	e820EntrySize = 20
+)
+
+// Boot protocol constants. See Documentation/x86/boot.txt.
+const (
+	// minProtocol is the first boot protocol version with xloadflags.
+	minProtocol = 0x20c
+
+	// xlfKernel64 says that the kernel has the 64-bit entry point at
+	// 0x200 bytes into the protected-mode code.
+	xlfKernel64 = 1 << 0
+	// xlfCanBeLoadedAbove4G says that the kernel, the initramfs and the
+	// boot parameters may be above 4 GiB.
+	xlfCanBeLoadedAbove4G = 1 << 1
+
+	entry64Off = 0x200
+
+	// loaderUndefined is the type_of_loader of boot loaders without an
+	// assigned ID.
+	loaderUndefined = 0xff
+
+	// defaultCmdlineSize is the maximum command line length of kernels
+	// that do not say.
+	defaultCmdlineSize = 255
+
+	// minKernelAddr is the lowest address a relocatable kernel is
+	// loaded at, like kexec-tools does.
+	minKernelAddr = 0x1000000
+	// defaultKernelAddr is where a kernel that is not relocatable and
+	// has no preferred address must be loaded.
+	defaultKernelAddr = 0x100000
+	// lowMem is the end of the memory the boot parameters and the
+	// initramfs are not put into, which holds BIOS data.
+	lowMem = 0x100000
+
+	// max32 is the end of the memory below 4 GiB, less a byte so it fits
+	// in a 32-bit uintptr.
+	max32 = math.MaxUint32
+)
+
+// e820Types maps memory range types to e820 types.
+var e820Types = map[kexec.RangeType]uint32{
+	kexec.RangeRAM:    uint32(bzimage.Ram),
+	kexec.RangeACPI:   bzimage.ACPI,
+	kexec.RangeNVACPI: bzimage.NVS,
+	kexec.RangeNVS:    bzimage.Reserved,
+}
+
+// ErrNotKernel64 is returned for bzImages without the 64-bit entry point.
+var ErrNotKernel64 = errors.New("bzImage has no 64-bit entry point")
+
+// x86Trampoline is entered by kexec in 64-bit mode, with all memory
+// identity mapped. It enters the kernel as the 64-bit boot protocol asks:
+// with a flat GDT, CS __BOOT_CS (0x10) and the data segments __BOOT_DS
+// (0x18), and the address of the zero page in RSI.
+//
+// The absolute address of the GDT, of the zero page and of the 64-bit
+// entry point are patched in; see x86TrampolineAt.
+var x86Trampoline = []byte{
+	// 0x00:
+	0xfa,                            // cli
+	0x0f, 0x01, 0x15, 0x50, 0, 0, 0, // lgdt [rip+0x50] (gdtr)
+	0x48, 0x8d, 0x05, 0x05, 0, 0, 0, // lea rax, [rip+0x05] (1f)
+	0x6a, 0x10, // push 0x10
+	0x50,       // push rax
+	0x48, 0xcb, // retfq
+	// 0x14: 1:
+	0xb8, 0x18, 0, 0, 0, // mov eax, 0x18
+	0x8e, 0xd8, // mov ds, eax
+	0x8e, 0xc0, // mov es, eax
+	0x8e, 0xd0, // mov ss, eax
+	0x8e, 0xe0, // mov fs, eax
+	0x8e, 0xe8, // mov gs, eax
+	0x48, 0x8b, 0x35, 0x3e, 0, 0, 0, // mov rsi, [rip+0x3e] (zero page)
+	0x48, 0x8b, 0x05, 0x3f, 0, 0, 0, // mov rax, [rip+0x3f] (entry)
+	0xff, 0xe0, // jmp rax
+	0, 0, 0, 0, 0,
+	// 0x38: GDT.
+	0, 0, 0, 0, 0, 0, 0, 0, // null
+	0, 0, 0, 0, 0, 0, 0, 0, // unused
+	0xff, 0xff, 0, 0, 0, 0x9a, 0xaf, 0, // 0x10: 64-bit code
+	0xff, 0xff, 0, 0, 0, 0x92, 0xcf, 0, // 0x18: data
+	// 0x58: GDT pointer: limit and address.
+	0x1f, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0,
+	// 0x68: zero page address.
+	0, 0, 0, 0, 0, 0, 0, 0,
+	// 0x70: entry point.
+	0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// Offsets of the patched values in x86Trampoline.
+const (
+	trampolineGDT      = 0x38
+	trampolineGDTAddr  = 0x5a
+	trampolineZeroPage = 0x68
+	trampolineEntry    = 0x70
+)
+
+// x86TrampolineAt returns x86Trampoline for address addr, which enters
+// entry with zeroPage.
+func x86TrampolineAt(addr, zeroPage, entry uintptr) []byte {
+	t := append([]byte(nil), x86Trampoline...)
+	binary.LittleEndian.PutUint64(t[trampolineGDTAddr:], uint64(addr+trampolineGDT))
+	binary.LittleEndian.PutUint64(t[trampolineZeroPage:], uint64(zeroPage))
+	binary.LittleEndian.PutUint64(t[trampolineEntry:], uint64(entry))
+	return t
+}
+
+// e820 writes the e820 memory map of mem to the zero page zp.
+func e820(zp []byte, mem *kexec.Memory) {
+	n := 0
+	for _, r := range mem.Phys {
+		if n == bzimage.E820Max {
+			break
+		}
+		typ, ok := e820Types[r.Type]
+		if !ok {
+			typ = bzimage.Reserved
+		}
+		entry := zp[e820TableOff+n*e820EntrySize:]
+		binary.LittleEndian.PutUint64(entry, uint64(r.Start))
+		// The size of ranges from ParseMemoryMap is one less than the
+		// number of bytes they have.
+		binary.LittleEndian.PutUint64(entry[8:], uint64(r.Size)+1)
+		binary.LittleEndian.PutUint32(entry[16:], typ)
+		n++
+	}
+	zp[e820EntriesOff] = uint8(n)
+}
+
+// putAddr writes the low 32 bits of addr at off in zp, and the high 32 bits
+// at extOff.
+func putAddr(zp []byte, off, extOff int, addr uint64) {
+	binary.LittleEndian.PutUint32(zp[off:], uint32(addr))
+	binary.LittleEndian.PutUint32(zp[extOff:], uint32(addr>>32))
+}
+
+// loadBzImage adds kexec segments to mem that boot the bzImage kernel with
+// initrd and cmdline with the 64-bit boot protocol, and returns the entry
+// point. mem.Phys must hold the memory map.
+func loadBzImage(mem *kexec.Memory, kernel, initrd []byte, cmdline string) (uintptr, error) {
+	var h bzimage.LinuxHeader
+	if err := binary.Read(bytes.NewReader(kernel), binary.LittleEndian, &h); err != nil {
+		return 0, fmt.Errorf("reading bzImage header: %v", err)
+	}
+	if h.HeaderMagic != bzimage.HeaderMagic {
+		return 0, fmt.Errorf("not a bzImage: magic is %q, want %q", h.HeaderMagic, bzimage.HeaderMagic)
+	}
+	if h.Protocolversion < minProtocol {
+		return 0, fmt.Errorf("boot protocol version %#x is too old, need %#x", h.Protocolversion, minProtocol)
+	}
+	if h.XLoadFlags&xlfKernel64 == 0 {
+		return 0, ErrNotKernel64
+	}
+
+	setupSects := int(h.SetupSects)
+	if setupSects == 0 {
+		setupSects = 4
+	}
+	setupSize := (setupSects + 1) * 512
+	if setupSize >= len(kernel) {
+		return 0, fmt.Errorf("bzImage of %d bytes has %d bytes of setup code", len(kernel), setupSize)
+	}
+	code := kernel[setupSize:]
+
+	maxAddr := ^uintptr(0)
+	if h.XLoadFlags&xlfCanBeLoadedAbove4G == 0 {
+		maxAddr = max32
+	}
+
+	// The protected-mode kernel decompresses itself in place, into
+	// init_size bytes.
+	size := uint(h.InitSize)
+	if size < uint(len(code)) {
+		size = uint(len(code))
+	}
+	var kernelAddr uintptr
+	var err error
+	if h.RelocatableKernel != 0 {
+		kernelAddr, err = mem.FindSpaceIn(size, uint(h.Kernelalignment), minKernelAddr, maxAddr)
+	} else {
+		addr := uintptr(h.PrefAddress)
+		if addr == 0 {
+			addr = defaultKernelAddr
+		}
+		// The kernel must be at addr. FindSpaceIn returns the lowest
+		// address with space from addr on, which is addr if it is free.
+		if kernelAddr, err = mem.FindSpaceIn(size, 0, addr, maxAddr); err == nil && kernelAddr != addr {
+			err = kexec.ErrNotEnoughSpace
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("no space for the kernel: %v", err)
+	}
+	mem.Segments = append(mem.Segments, kexec.NewSegment(code, kexec.Range{Start: kernelAddr, Size: size}))
+
+	var initrdAddr uintptr
+	if len(initrd) > 0 {
+		// initrd_addr_max is the last byte the initrd may use.
+		initrdMax := uintptr(h.InitrdAddrMax)
+		if h.XLoadFlags&xlfCanBeLoadedAbove4G != 0 {
+			initrdMax = maxAddr
+		}
+		if initrdAddr, err = mem.FindSpaceIn(uint(len(initrd)), 0, lowMem, initrdMax); err != nil {
+			return 0, fmt.Errorf("no space for the initrd: %v", err)
+		}
+		mem.Segments = append(mem.Segments, kexec.NewSegment(initrd, kexec.Range{Start: initrdAddr, Size: uint(len(initrd))}))
+	}
+
+	cmdlineSize := int(h.CmdLineSize)
+	if cmdlineSize == 0 {
+		cmdlineSize = defaultCmdlineSize
+	}
+	if len(cmdline) > cmdlineSize {
+		return 0, fmt.Errorf("command line of %d bytes is longer than the kernel's maximum %d", len(cmdline), cmdlineSize)
+	}
+
+	// The zero page, followed by the command line.
+	params := make([]byte, zeroPageSize+len(cmdline)+1)
+	zp := params[:zeroPageSize]
+	paramsAddr, err := mem.FindSpaceIn(uint(len(params)), 0, lowMem, maxAddr)
+	if err != nil {
+		return 0, fmt.Errorf("no space for the boot parameters: %v", err)
+	}
+	copy(params[zeroPageSize:], cmdline)
+
+	// The setup header ends at 0x202 plus the offset of the jump at 0x200.
+	headerEnd := 0x202 + int(kernel[0x201])
+	copy(zp[setupHeaderOff:headerEnd], kernel[setupHeaderOff:headerEnd])
+	zp[typeOfLoaderOff] = loaderUndefined
+	binary.LittleEndian.PutUint32(zp[code32StartOff:], uint32(kernelAddr))
+	putAddr(zp, ramdiskImageOff, extRamdiskImageOff, uint64(initrdAddr))
+	putAddr(zp, ramdiskSizeOff, extRamdiskSizeOff, uint64(len(initrd)))
+	putAddr(zp, cmdlinePtrOff, extCmdlinePtrOff, uint64(paramsAddr+zeroPageSize))
+	e820(zp, mem)
+	mem.Segments = append(mem.Segments, kexec.NewSegment(params, kexec.Range{Start: paramsAddr, Size: uint(len(params))}))
+
+	trampolineAddr, err := mem.FindSpaceIn(uint(len(x86Trampoline)), 0, lowMem, max32)
+	if err != nil {
+		return 0, fmt.Errorf("no space for the trampoline: %v", err)
+	}
+	t := x86TrampolineAt(trampolineAddr, paramsAddr, kernelAddr+entry64Off)
+	mem.Segments = append(mem.Segments, kexec.NewSegment(t, kexec.Range{Start: trampolineAddr, Size: uint(len(t))}))
+	return trampolineAddr, nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// testBzImage returns a bzImage with a setup header, one sector of setup
+// code and 4 KiB of kernel code.
+func testBzImage(xloadflags uint16, relocatable bool) []byte {
+	k := make([]byte, 2*512+4096)
+	k[0x1f1] = 1 // setup_sects
+	// A jump over the setup header, which ends at 0x268.
+	k[0x200], k[0x201] = 0xeb, 0x66
+	copy(k[0x202:], "HdrS")
+	binary.LittleEndian.PutUint16(k[0x206:], 0x20d)
+	binary.LittleEndian.PutUint32(k[0x22c:], 0x7fffffff) // initrd_addr_max
+	binary.LittleEndian.PutUint32(k[0x230:], 0x200000)   // kernel_alignment
+	if relocatable {
+		k[0x234] = 1
+	}
+	binary.LittleEndian.PutUint16(k[0x236:], xloadflags)
+	binary.LittleEndian.PutUint32(k[0x238:], 2048)     // cmdline_size
+	binary.LittleEndian.PutUint32(k[0x260:], 0x800000) // init_size
+	for i := 1024; i < len(k); i++ {
+		k[i] = 0xcc
+	}
+	return k
+}
+
+func testMemory() *kexec.Memory {
+	// Sizes as ParseMemoryMap reads them: one less than the number of
+	// bytes.
+	return &kexec.Memory{Phys: []kexec.TypedAddressRange{
+		{Range: kexec.Range{Start: 0, Size: 0x9fbff}, Type: kexec.RangeRAM},
+		{Range: kexec.Range{Start: 0xf0000, Size: 0xffff}, Type: kexec.RangeNVS},
+		{Range: kexec.Range{Start: 0x100000, Size: 0x7fefffff}, Type: kexec.RangeRAM},
+		{Range: kexec.Range{Start: 0x7ff00000, Size: 0xfffff}, Type: kexec.RangeACPI},
+	}}
+}
+
+// segment returns the segment of mem starting at addr.
+func segment(t *testing.T, mem *kexec.Memory, addr uintptr) kexec.Segment {
+	for _, s := range mem.Segments {
+		if s.Phys.Start == addr {
+			return s
+		}
+	}
+	t.Fatalf("no segment at %#x in %v", addr, mem.Segments)
+	return kexec.Segment{}
+}
+
+// ptrBytes returns the buffer of s.
+func ptrBytes(s kexec.Segment) []byte {
+	var b []byte
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	sh.Data = s.Buf.Start
+	sh.Len = int(s.Buf.Size)
+	sh.Cap = int(s.Buf.Size)
+	return b
+}
+
+// rip returns the target of the RIP-relative operand of the instruction of
+// length n at off in code.
+func rip(code []byte, off, n int) int {
+	return off + n + int(int32(binary.LittleEndian.Uint32(code[off+n-4:])))
+}
+
+func TestX86Trampoline(t *testing.T) {
+	tr := x86TrampolineAt(0x10000, 0x20000, 0x1000200)
+	for _, tt := range []struct {
+		name   string
+		off, n int
+		want   int
+	}{
+		{"lgdt", 0x01, 7, trampolineGDTAddr - 2},
+		{"lea", 0x08, 7, 0x14},
+		{"mov rsi", 0x23, 7, trampolineZeroPage},
+		{"mov rax", 0x2a, 7, trampolineEntry},
+	} {
+		if got := rip(tr, tt.off, tt.n); got != tt.want {
+			t.Errorf("%s refers to %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		off  int
+		want uint64
+	}{
+		{trampolineGDTAddr, 0x10000 + trampolineGDT},
+		{trampolineZeroPage, 0x20000},
+		{trampolineEntry, 0x1000200},
+	} {
+		if got := binary.LittleEndian.Uint64(tr[tt.off:]); got != tt.want {
+			t.Errorf("value at %#x is %#x, want %#x", tt.off, got, tt.want)
+		}
+	}
+	if !bytes.Equal(x86Trampoline[trampolineEntry:], make([]byte, 8)) {
+		t.Errorf("x86TrampolineAt changed x86Trampoline")
+	}
+}
+
+func TestLoadBzImage(t *testing.T) {
+	kernel := testBzImage(xlfKernel64, true)
+	initrd := bytes.Repeat([]byte("initrd"), 1000)
+	const cmdline = "console=ttyS0 root=/dev/sda1"
+
+	mem := testMemory()
+	entry, err := loadBzImage(mem, kernel, initrd, cmdline)
+	if err != nil {
+		t.Fatalf("loadBzImage() = %v", err)
+	}
+	if len(mem.Segments) != 4 {
+		t.Fatalf("got %d segments, want 4: %v", len(mem.Segments), mem.Segments)
+	}
+	for i, s := range mem.Segments {
+		for _, s2 := range mem.Segments[i+1:] {
+			if s.Phys.Overlaps(s2.Phys) {
+				t.Errorf("segments %v and %v overlap", s, s2)
+			}
+		}
+	}
+
+	tb := ptrBytes(segment(t, mem, entry))
+	zeroPage := uintptr(binary.LittleEndian.Uint64(tb[trampolineZeroPage:]))
+	kernelEntry := uintptr(binary.LittleEndian.Uint64(tb[trampolineEntry:]))
+
+	kernelAddr := kernelEntry - entry64Off
+	if kernelAddr%0x200000 != 0 || kernelAddr < minKernelAddr {
+		t.Errorf("kernel is at %#x, want an address aligned to 2 MiB above 16 MiB", kernelAddr)
+	}
+	ks := segment(t, mem, kernelAddr)
+	if ks.Phys.Size != 0x800000 || !bytes.Equal(ptrBytes(ks), kernel[1024:]) {
+		t.Errorf("kernel segment is %v, want the %d bytes of kernel code in init_size 0x800000 bytes", ks, len(kernel)-1024)
+	}
+
+	zs := segment(t, mem, zeroPage)
+	zp := ptrBytes(zs)
+	if got := string(zp[zeroPageSize : len(zp)-1]); got != cmdline || zp[len(zp)-1] != 0 {
+		t.Errorf("command line is %q, want %q", zp[zeroPageSize:], cmdline)
+	}
+	if !bytes.Equal(zp[0x1f1:0x210], kernel[0x1f1:0x210]) || !bytes.Equal(zp[0x211:0x214], kernel[0x211:0x214]) {
+		t.Errorf("setup header was not copied")
+	}
+	for _, f := range []struct {
+		name string
+		off  int
+		want uint32
+	}{
+		{"cmd_line_ptr", cmdlinePtrOff, uint32(zeroPage + zeroPageSize)},
+		{"ext_cmd_line_ptr", extCmdlinePtrOff, 0},
+		{"ramdisk_size", ramdiskSizeOff, uint32(len(initrd))},
+		{"code32_start", code32StartOff, uint32(kernelAddr)},
+	} {
+		if got := binary.LittleEndian.Uint32(zp[f.off:]); got != f.want {
+			t.Errorf("%s is %#x, want %#x", f.name, got, f.want)
+		}
+	}
+	if zp[typeOfLoaderOff] != loaderUndefined {
+		t.Errorf("type_of_loader is %#x, want %#x", zp[typeOfLoaderOff], loaderUndefined)
+	}
+	initrdAddr := uintptr(binary.LittleEndian.Uint32(zp[ramdiskImageOff:]))
+	if is := segment(t, mem, initrdAddr); !bytes.Equal(ptrBytes(is), initrd) {
+		t.Errorf("initrd segment at %#x does not hold the initrd", initrdAddr)
+	}
+	if initrdAddr < lowMem || initrdAddr+uintptr(len(initrd)) > 0x7fffffff {
+		t.Errorf("initrd at %#x is not between 1 MiB and initrd_addr_max", initrdAddr)
+	}
+
+	if zp[e820EntriesOff] != 4 {
+		t.Fatalf("%d e820 entries, want 4", zp[e820EntriesOff])
+	}
+	for i, want := range []struct {
+		addr, size uint64
+		typ        uint32
+	}{
+		{0, 0x9fc00, 1},
+		{0xf0000, 0x10000, 2},
+		{0x100000, 0x7ff00000, 1},
+		{0x7ff00000, 0x100000, 3},
+	} {
+		e := zp[e820TableOff+i*e820EntrySize:]
+		addr, size, typ := binary.LittleEndian.Uint64(e), binary.LittleEndian.Uint64(e[8:]), binary.LittleEndian.Uint32(e[16:])
+		if addr != want.addr || size != want.size || typ != want.typ {
+			t.Errorf("e820 entry %d is %#x+%#x type %d, want %#x+%#x type %d", i, addr, size, typ, want.addr, want.size, want.typ)
+		}
+	}
+}
+
+func TestLoadBzImageFixed(t *testing.T) {
+	mem := testMemory()
+	entry, err := loadBzImage(mem, testBzImage(xlfKernel64, false), nil, "")
+	if err != nil {
+		t.Fatalf("loadBzImage() = %v", err)
+	}
+	tb := ptrBytes(segment(t, mem, entry))
+	if got := binary.LittleEndian.Uint64(tb[trampolineEntry:]); got != defaultKernelAddr+entry64Off {
+		t.Errorf("entry point is %#x, want %#x", got, defaultKernelAddr+entry64Off)
+	}
+	// Nothing else fits where the kernel must go.
+	mem = testMemory()
+	mem.Segments = append(mem.Segments, kexec.Segment{Phys: kexec.Range{Start: 0x200000, Size: 0x1000}})
+	if _, err := loadBzImage(mem, testBzImage(xlfKernel64, false), nil, ""); err == nil {
+		t.Errorf("loadBzImage() with the kernel's memory in use succeeded")
+	}
+}
+
+func TestLoadBzImageErrors(t *testing.T) {
+	badMagic := testBzImage(xlfKernel64, true)
+	copy(badMagic[0x202:], "HdrX")
+	oldProtocol := testBzImage(xlfKernel64, true)
+	binary.LittleEndian.PutUint16(oldProtocol[0x206:], 0x20a)
+
+	for _, tt := range []struct {
+		name    string
+		kernel  []byte
+		cmdline string
+	}{
+		{"short", []byte("HdrS"), ""},
+		{"magic", badMagic, ""},
+		{"protocol", oldProtocol, ""},
+		{"32-bit", testBzImage(0, true), ""},
+		{"no code", testBzImage(xlfKernel64, true)[:1024], ""},
+		{"command line", testBzImage(xlfKernel64, true), strings.Repeat("x", 2049)},
+	} {
+		if _, err := loadBzImage(testMemory(), tt.kernel, nil, tt.cmdline); err == nil {
+			t.Errorf("%s: loadBzImage() succeeded, want error", tt.name)
+		}
+	}
+	if _, err := loadBzImage(testMemory(), testBzImage(0, true), nil, ""); err != ErrNotKernel64 {
+		t.Errorf("loadBzImage() of a 32-bit kernel = %v, want %v", err, ErrNotKernel64)
+	}
+}
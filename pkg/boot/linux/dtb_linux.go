@@ -0,0 +1,266 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// FirmwareFDT is where Linux exposes the device tree it booted with.
+const FirmwareFDT = "/sys/firmware/fdt"
+
+// rngSeedSize is the number of random bytes in /chosen/rng-seed.
+const rngSeedSize = 64
+
+// cells returns the u32 property name of n, or def if n does not have it.
+func cells(n *dt.Node, name string, def uint32) (uint32, error) {
+	p, ok := n.LookProperty(name)
+	if !ok {
+		return def, nil
+	}
+	c, err := p.AsU32()
+	if err != nil {
+		return 0, err
+	}
+	if c > 2 {
+		return 0, fmt.Errorf("%s of %d is not supported", name, c)
+	}
+	return c, nil
+}
+
+// readCells reads a number of c cells from b.
+func readCells(b []byte, c uint32) uint64 {
+	var v uint64
+	for i := uint32(0); i < c; i++ {
+		v = v<<32 | uint64(binary.BigEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// regRanges returns the (address, size) pairs of the reg property p, in
+// which addresses have addrCells cells and sizes sizeCells cells. Ranges
+// that do not fit in a uintptr are left out.
+func regRanges(p *dt.Property, addrCells, sizeCells uint32) ([]kexec.Range, error) {
+	n := int(4 * (addrCells + sizeCells))
+	if n == 0 || len(p.Value)%n != 0 {
+		return nil, fmt.Errorf("property %q is not a list of %d-cell addresses and %d-cell sizes", p.Name, addrCells, sizeCells)
+	}
+	var rs []kexec.Range
+	for b := p.Value; len(b) > 0; b = b[n:] {
+		addr, size := readCells(b, addrCells), readCells(b[4*addrCells:], sizeCells)
+		if size == 0 || addr > uint64(^uintptr(0)) {
+			continue
+		}
+		if max := uint64(^uintptr(0)) - addr + 1; max != 0 && size > max {
+			size = max
+		}
+		rs = append(rs, kexec.Range{Start: uintptr(addr), Size: uint(size)})
+	}
+	return rs, nil
+}
+
+// isMemoryNode returns whether n describes RAM.
+func isMemoryNode(n *dt.Node) bool {
+	if p, ok := n.LookProperty("device_type"); ok {
+		s, _ := p.AsString()
+		return s == "memory"
+	}
+	return n.Name == "memory" || strings.HasPrefix(n.Name, "memory@")
+}
+
+// fdtMemory returns the RAM described by the memory nodes of fdt, and the
+// memory that the memory reservation block and /reserved-memory reserve.
+func fdtMemory(fdt *dt.FDT) (ram, reserved []kexec.Range, err error) {
+	root := fdt.RootNode
+	addrCells, err := cells(root, "#address-cells", 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	sizeCells, err := cells(root, "#size-cells", 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, n := range root.Children {
+		reg, ok := n.LookProperty("reg")
+		if !ok || !isMemoryNode(n) {
+			continue
+		}
+		rs, err := regRanges(reg, addrCells, sizeCells)
+		if err != nil {
+			return nil, nil, fmt.Errorf("/%s: %v", n.Name, err)
+		}
+		ram = append(ram, rs...)
+	}
+
+	for _, e := range fdt.ReserveEntries {
+		if e.Size == 0 || e.Address > uint64(^uintptr(0)) {
+			continue
+		}
+		reserved = append(reserved, kexec.Range{Start: uintptr(e.Address), Size: uint(e.Size)})
+	}
+	if rm, ok := root.Child("reserved-memory"); ok {
+		if addrCells, err = cells(rm, "#address-cells", addrCells); err != nil {
+			return nil, nil, err
+		}
+		if sizeCells, err = cells(rm, "#size-cells", sizeCells); err != nil {
+			return nil, nil, err
+		}
+		for _, n := range rm.Children {
+			reg, ok := n.LookProperty("reg")
+			if !ok {
+				// Dynamically allocated: the kernel picks the
+				// place again, so it need not be kept.
+				continue
+			}
+			rs, err := regRanges(reg, addrCells, sizeCells)
+			if err != nil {
+				return nil, nil, fmt.Errorf("/reserved-memory/%s: %v", n.Name, err)
+			}
+			reserved = append(reserved, rs...)
+		}
+	}
+	return ram, reserved, nil
+}
+
+// iomemRAM parses /proc/iomem and returns the top-level "System RAM"
+// ranges, and the ranges within them that are "reserved".
+//
+// On machines booted with UEFI, the EFI stub removes the memory nodes from
+// the device tree, and /proc/iomem is the only account of RAM.
+func iomemRAM(r io.Reader) (ram, reserved []kexec.Range, err error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		nested := strings.HasPrefix(line, " ")
+		fields := strings.SplitN(strings.TrimSpace(line), " : ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, nil, fmt.Errorf("invalid /proc/iomem line %q", line)
+		}
+		start, err := strconv.ParseUint(bounds[0], 16, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid /proc/iomem line %q: %v", line, err)
+		}
+		end, err := strconv.ParseUint(bounds[1], 16, 64)
+		if err != nil || end < start {
+			return nil, nil, fmt.Errorf("invalid /proc/iomem line %q: %v", line, err)
+		}
+		if end > uint64(^uintptr(0)) {
+			continue
+		}
+		rg := kexec.Range{Start: uintptr(start), Size: uint(end - start + 1)}
+		switch {
+		case !nested && fields[1] == "System RAM":
+			ram = append(ram, rg)
+		case nested && fields[1] == "reserved":
+			reserved = append(reserved, rg)
+		}
+	}
+	return ram, reserved, s.Err()
+}
+
+// subtractRanges returns the parts of the ranges ram that are not in any of
+// reserved, sorted by address.
+func subtractRanges(ram, reserved []kexec.Range) []kexec.Range {
+	avail := append([]kexec.Range(nil), ram...)
+	for _, r := range reserved {
+		rEnd := r.Start + uintptr(r.Size)
+		var next []kexec.Range
+		for _, a := range avail {
+			if !a.Overlaps(r) {
+				next = append(next, a)
+				continue
+			}
+			aEnd := a.Start + uintptr(a.Size)
+			if a.Start < r.Start {
+				next = append(next, kexec.Range{Start: a.Start, Size: uint(r.Start - a.Start)})
+			}
+			if rEnd < aEnd {
+				next = append(next, kexec.Range{Start: rEnd, Size: uint(aEnd - rEnd)})
+			}
+		}
+		avail = next
+	}
+	sort.Slice(avail, func(i, j int) bool { return avail[i].Start < avail[j].Start })
+	return avail
+}
+
+// memoryMap sets mem.Phys to the RAM that the segments of a kernel can use:
+// the RAM of the memory nodes of fdt, or of iomem if there are none,
+// without the reserved memory of either.
+func memoryMap(mem *kexec.Memory, fdt *dt.FDT, iomem io.Reader) error {
+	ram, reserved, err := fdtMemory(fdt)
+	if err != nil {
+		return err
+	}
+	if iomem != nil {
+		ioRAM, ioReserved, err := iomemRAM(iomem)
+		if err != nil {
+			return err
+		}
+		if len(ram) == 0 {
+			ram = ioRAM
+		}
+		reserved = append(reserved, ioReserved...)
+	}
+	if len(ram) == 0 {
+		return fmt.Errorf("device tree has no memory nodes")
+	}
+	mem.Phys = nil
+	for _, r := range subtractRanges(ram, reserved) {
+		mem.Phys = append(mem.Phys, kexec.TypedAddressRange{Range: r, Type: kexec.RangeRAM})
+	}
+	return nil
+}
+
+// u64 returns v as a device tree <u64>.
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// fixupChosen sets up /chosen of fdt for the next kernel: its command line,
+// the location of its initramfs, and fresh seeds from rng for KASLR and the
+// kernel's random number generator. An initrd of size 0 means there is
+// none.
+func fixupChosen(fdt *dt.FDT, cmdline string, initrd kexec.Range, rng io.Reader) error {
+	chosen, ok := fdt.RootNode.Child("chosen")
+	if !ok {
+		chosen = &dt.Node{Name: "chosen"}
+		fdt.RootNode.Children = append(fdt.RootNode.Children, chosen)
+	}
+
+	chosen.UpdateProperty("bootargs", append([]byte(cmdline), 0))
+
+	if initrd.Size != 0 {
+		chosen.UpdateProperty("linux,initrd-start", u64(uint64(initrd.Start)))
+		chosen.UpdateProperty("linux,initrd-end", u64(uint64(initrd.Start)+uint64(initrd.Size)))
+	} else {
+		chosen.RemoveProperty("linux,initrd-start")
+		chosen.RemoveProperty("linux,initrd-end")
+	}
+
+	// The seeds of this boot must not be used again.
+	seed := make([]byte, 8+rngSeedSize)
+	if _, err := io.ReadFull(rng, seed); err != nil {
+		return fmt.Errorf("reading random seeds: %v", err)
+	}
+	chosen.UpdateProperty("kaslr-seed", seed[:8])
+	chosen.UpdateProperty("rng-seed", seed[8:])
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build arm arm64
+
+package linux
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/u-root/pkg/kexec"
+)
+
+// readFDT reads the device tree dtb, or the one Linux booted with if dtb is
+// nil.
+func readFDT(dtb io.ReaderAt) (*dt.FDT, error) {
+	if dtb != nil {
+		return dt.Read(io.NewSectionReader(dtb, 0, math.MaxInt64))
+	}
+	f, err := os.Open(FirmwareFDT)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dt.Read(f)
+}
+
+// KexecLoad loads kernel, an arm64 Image on arm64 and a zImage on arm,
+// with the initramfs ramfs, which may be nil, and cmdline with
+// kexec_load(2).
+//
+// The kernel boots with the device tree dtb, or, if dtb is nil, the one
+// Linux booted with. Its /chosen node gets cmdline, the location of the
+// initramfs and new random seeds. The RAM the kernel may be put in is that
+// of the memory nodes of the device tree, or of /proc/iomem if it has none.
+func KexecLoad(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt) error {
+	k, err := ioutil.ReadAll(kernel)
+	if err != nil {
+		return err
+	}
+	var initrd []byte
+	if ramfs != nil {
+		if initrd, err = ioutil.ReadAll(ramfs); err != nil {
+			return err
+		}
+	}
+
+	fdt, err := readFDT(dtb)
+	if err != nil {
+		return fmt.Errorf("reading device tree: %v", err)
+	}
+
+	var iomem io.Reader
+	if f, err := os.Open("/proc/iomem"); err == nil {
+		defer f.Close()
+		iomem = f
+	}
+	var mem kexec.Memory
+	if err := memoryMap(&mem, fdt, iomem); err != nil {
+		return fmt.Errorf("reading memory map: %v", err)
+	}
+
+	load := loadArm64Image
+	if runtime.GOARCH == "arm" {
+		load = loadZImage
+	}
+	entry, err := load(&mem, k, initrd, fdt, cmdline, rand.Reader)
+	if err != nil {
+		return err
+	}
+	return kexec.Load(entry, mem.Segments, 0)
+}
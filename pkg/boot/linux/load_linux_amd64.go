@@ -0,0 +1,51 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/uroot/util"
+)
+
+// KexecLoad loads the bzImage kernel with the initramfs ramfs, which may be
+// nil, and cmdline with kexec_load(2). It is kexec.FileLoad for kernels
+// without kexec_file_load(2).
+//
+// The kernel gets the firmware's e820 memory map and, like with
+// kexec.FileLoad, the ACPI RSDP on its command line. It does not get EFI
+// system table information, so it boots as on a BIOS machine. x86 kernels
+// do not boot with a device tree, and dtb is ignored.
+func KexecLoad(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt) error {
+	k, err := ioutil.ReadAll(kernel)
+	if err != nil {
+		return err
+	}
+	var initrd []byte
+	if ramfs != nil {
+		if initrd, err = ioutil.ReadAll(ramfs); err != nil {
+			return err
+		}
+	}
+
+	if rsdp, _ := util.GetRSDP(); len(rsdp) != 0 {
+		// Prepend the RSDP.
+		cmdline = fmt.Sprintf("acpi_rsdp=%s %s", rsdp, cmdline)
+	}
+
+	var mem kexec.Memory
+	if err := mem.ParseMemoryMap(); err != nil {
+		return fmt.Errorf("parsing memory map: %v", err)
+	}
+	entry, err := loadBzImage(&mem, k, initrd, cmdline)
+	if err != nil {
+		return err
+	}
+	return kexec.Load(entry, mem.Segments, 0)
+}
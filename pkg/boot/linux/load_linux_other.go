@@ -0,0 +1,20 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,!amd64,!arm,!arm64
+
+package linux
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// KexecLoad is not implemented on this architecture. On 386, which has no
+// kexec_file_load(2) either, Linux kernels cannot be loaded at all.
+func KexecLoad(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt) error {
+	return fmt.Errorf("loading Linux with kexec_load is not implemented on %s", runtime.GOARCH)
+}
@@ -16,6 +16,8 @@ import (
 func imageEqual(li1, li2 *LinuxImage) bool {
 	return uio.ReaderAtEqual(li1.Kernel, li2.Kernel) &&
 		uio.ReaderAtEqual(li1.Kernel, li2.Kernel) &&
+		(li1.DTB == nil) == (li2.DTB == nil) &&
+		(li1.DTB == nil || uio.ReaderAtEqual(li1.DTB, li2.DTB)) &&
 		li1.Cmdline == li2.Cmdline
 }
 
@@ -82,6 +84,14 @@ func TestLinuxImage(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			li: &LinuxImage{
+				Kernel:  strings.NewReader("foo"),
+				Cmdline: "console=ttyAMA0",
+				DTB:     strings.NewReader("dtb"),
+			},
+			err: nil,
+		},
 	} {
 		a := cpio.InMemArchive()
 		sw := NewSigningWriter(a)
@@ -98,3 +108,21 @@ func TestLinuxImage(t *testing.T) {
 		}
 	}
 }
+
+func TestCatInitrds(t *testing.T) {
+	got, err := uio.ReadAll(CatInitrds(
+		strings.NewReader("abcde"),
+		strings.NewReader("fghi"),
+		strings.NewReader("j"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "abcde\x00\x00\x00fghij\x00\x00\x00"; string(got) != want {
+		t.Errorf("CatInitrds = %q, want %q", got, want)
+	}
+
+	if _, err := uio.ReadAll(CatInitrds(&errorReaderAt{err: errSkip})); err != errSkip {
+		t.Errorf("CatInitrds(error reader) = %v, want %v", err, errSkip)
+	}
+}
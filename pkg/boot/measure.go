@@ -0,0 +1,152 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/tpm"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// The PCRs OS images and boot configurations are measured into. They are
+// the PCRs GRUB uses, so attestation policies written for GRUB work the
+// same: command lines go to PCR 8, and files to PCR 9. Every kernel,
+// initrd, module, command line and configuration file is an EV_IPL event
+// of its own.
+const (
+	// CmdlinePCR holds the command lines of kernels and modules.
+	CmdlinePCR = 8
+	// KernelPCR holds kernels, multiboot modules and device trees.
+	KernelPCR = 9
+	// InitrdPCR holds initrds.
+	InitrdPCR = 9
+	// ConfigPCR holds boot configuration files and scripts.
+	ConfigPCR = 9
+)
+
+// EventLogPath is where the next kernel finds the event log in its
+// initramfs; see AttachEventLog.
+const EventLogPath = "tpm/eventlog"
+
+// measureFile measures the content of r into pcr.
+func measureFile(m *tpm.Measurer, pcr uint32, desc string, r io.ReaderAt) error {
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", desc, err)
+	}
+	return m.Measure(pcr, tpm.EvIPL, []byte(desc), b)
+}
+
+// measureCmdline measures a command line into CmdlinePCR.
+func measureCmdline(m *tpm.Measurer, desc, cmdline string) error {
+	return m.Measure(CmdlinePCR, tpm.EvIPL, []byte(desc+": "+cmdline), []byte(cmdline))
+}
+
+// MeasureConfig measures the boot configuration file or script name.
+func MeasureConfig(m *tpm.Measurer, name string, content []byte) error {
+	return m.Measure(ConfigPCR, tpm.EvIPL, []byte("config: "+name), content)
+}
+
+// Measure measures the kernel, initrd or modules, device tree and command
+// lines of img.
+func Measure(m *tpm.Measurer, img OSImage) error {
+	switch img := img.(type) {
+	case *LinuxImage:
+		if img.Kernel == nil {
+			return ErrKernelMissing
+		}
+		if err := measureFile(m, KernelPCR, "kernel", img.Kernel); err != nil {
+			return err
+		}
+		if img.Initrd != nil {
+			if err := measureFile(m, InitrdPCR, "initrd", img.Initrd); err != nil {
+				return err
+			}
+		}
+		if img.DTB != nil {
+			if err := measureFile(m, KernelPCR, "device tree", img.DTB); err != nil {
+				return err
+			}
+		}
+		return measureCmdline(m, "kernel_cmdline", img.Cmdline)
+
+	case *MultibootImage:
+		if img.Kernel == nil {
+			return ErrKernelMissing
+		}
+		if err := measureFile(m, KernelPCR, "multiboot kernel", img.Kernel); err != nil {
+			return err
+		}
+		if err := measureCmdline(m, "kernel_cmdline", img.Cmdline); err != nil {
+			return err
+		}
+		for _, mod := range img.Modules {
+			if err := measureFile(m, KernelPCR, "module "+mod.Name, mod.Content); err != nil {
+				return err
+			}
+			if err := measureCmdline(m, "module_cmdline", mod.cmdline()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("can not measure %T", img)
+}
+
+// AttachEventLog adds the event log of m to the initramfs of img, where the
+// next kernel finds it at /EventLogPath. It must be called after the last
+// measurement. The event log itself is not measured.
+//
+// Only Linux images can carry the event log.
+func AttachEventLog(m *tpm.Measurer, img OSImage) error {
+	li, ok := img.(*LinuxImage)
+	if !ok {
+		return fmt.Errorf("can not pass an event log to %T", img)
+	}
+	eventLog, err := m.Log.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var archive bytes.Buffer
+	w := cpio.Newc.Writer(&archive)
+	if err := cpio.WriteRecords(w, []cpio.Record{
+		cpio.Directory("tpm", 0755),
+		cpio.StaticFile(EventLogPath, string(eventLog), 0444),
+	}); err != nil {
+		return err
+	}
+	if err := cpio.WriteTrailer(w); err != nil {
+		return err
+	}
+
+	if li.Initrd == nil {
+		li.Initrd = bytes.NewReader(archive.Bytes())
+	} else {
+		li.Initrd = CatInitrds(li.Initrd, bytes.NewReader(archive.Bytes()))
+	}
+	return nil
+}
+
+// MeasureAndLoad measures img with m, attaches the event log if img can
+// carry it, and loads img. With a nil m, it only loads img.
+//
+// Failing to measure does not stop img from loading: the PCRs then do not
+// match what a verifier expects, which is what attestation is for.
+func MeasureAndLoad(m *tpm.Measurer, img OSImage) error {
+	if m != nil {
+		if err := Measure(m, img); err != nil {
+			log.Printf("Measuring %T: %v", img, err)
+		} else if err := AttachEventLog(m, img); err != nil {
+			log.Printf("No event log for the next kernel: %v", err)
+		}
+	}
+	return img.Load()
+}
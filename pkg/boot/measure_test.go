@@ -0,0 +1,117 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"bytes"
+	"crypto"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/tpm"
+	"github.com/u-root/u-root/pkg/tpm/tpmtest"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+func testMeasurer(t *testing.T) (*tpm.Measurer, *tpmtest.Simulator) {
+	sim := tpmtest.NewSimulator(crypto.SHA1, crypto.SHA256)
+	tp, err := tpm.New(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tpm.Measurer{TPM: tp, Log: tpm.NewEventLog(tp)}, sim
+}
+
+func eventData(l *tpm.EventLog) []string {
+	var s []string
+	for _, e := range l.Events {
+		s = append(s, string(e.Data))
+	}
+	return s
+}
+
+func TestMeasureLinux(t *testing.T) {
+	m, sim := testMeasurer(t)
+	if err := MeasureConfig(m, "/boot/grub/grub.cfg", []byte("menuentry")); err != nil {
+		t.Fatal(err)
+	}
+	li := &LinuxImage{
+		Kernel:  strings.NewReader("kernel"),
+		Initrd:  strings.NewReader("initrd"),
+		Cmdline: "console=ttyS0",
+	}
+	if err := Measure(m, li); err != nil {
+		t.Fatalf("Measure() = %v", err)
+	}
+	want := []string{"config: /boot/grub/grub.cfg", "kernel", "initrd", "kernel_cmdline: console=ttyS0"}
+	if got := eventData(m.Log); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events are %q, want %q", got, want)
+	}
+	for h, pcrs := range m.Log.Replay() {
+		for pcr, v := range pcrs {
+			if !bytes.Equal(sim.PCRs[h][pcr], v) {
+				t.Errorf("PCR %d of bank %v is %x, the log says %x", pcr, h, sim.PCRs[h][pcr], v)
+			}
+		}
+	}
+
+	if err := AttachEventLog(m, li); err != nil {
+		t.Fatalf("AttachEventLog() = %v", err)
+	}
+	initrd, err := uio.ReadAll(li.Initrd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The original initrd, padded, then the archive with the log.
+	if !bytes.HasPrefix(initrd, []byte("initrd\x00\x00")) {
+		t.Fatalf("initrd starts with %q", initrd[:8])
+	}
+	a, err := cpio.ArchiveFromReader(cpio.Newc.Reader(bytes.NewReader(initrd[8:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := a.Get(EventLogPath)
+	if !ok {
+		t.Fatalf("no %s in initrd", EventLogPath)
+	}
+	b, err := uio.ReadAll(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tpm.ParseEventLog(b)
+	if err != nil {
+		t.Fatalf("ParseEventLog() = %v", err)
+	}
+	if got := eventData(l); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("attached log has events %q, want %q", got, want)
+	}
+}
+
+func TestMeasureMultiboot(t *testing.T) {
+	m, _ := testMeasurer(t)
+	mi := &MultibootImage{
+		Kernel:  strings.NewReader("xen"),
+		Cmdline: "dom0_mem=1G",
+		Modules: []MultibootModule{
+			{Name: "/vmlinuz", Content: strings.NewReader("linux"), Cmdline: "ro"},
+		},
+	}
+	if err := Measure(m, mi); err != nil {
+		t.Fatalf("Measure() = %v", err)
+	}
+	want := []string{"multiboot kernel", "kernel_cmdline: dom0_mem=1G", "module /vmlinuz", "module_cmdline: /vmlinuz ro"}
+	if got := eventData(m.Log); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events are %q, want %q", got, want)
+	}
+	for i, pcr := range []uint32{KernelPCR, CmdlinePCR, KernelPCR, CmdlinePCR} {
+		if m.Log.Events[i].PCR != pcr {
+			t.Errorf("event %d is in PCR %d, want %d", i, m.Log.Events[i].PCR, pcr)
+		}
+	}
+	if err := AttachEventLog(m, mi); err == nil {
+		t.Errorf("AttachEventLog() to a multiboot image succeeded")
+	}
+}
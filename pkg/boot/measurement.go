@@ -6,56 +6,82 @@ package boot
 
 import (
 	"bytes"
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha1"
-	"crypto/sha256"
-	// Registers with crypto
-	_ "crypto/sha512"
-	//"encoding/binary"
 	"fmt"
-	"io"
+	"strings"
 
-	"github.com/google/go-tpm/tpm"
 	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/tpm"
 	"github.com/u-root/u-root/pkg/uio"
 	"golang.org/x/sys/unix"
 )
 
-// MeasuringReader is a cpio.Reader that collects the signed data and compares
-// it against the signature in the given cpio archive.
+// MeasuringReader is a cpio.Reader that collects the signed data and the
+// signatures in the given cpio archive.
 type MeasuringReader struct {
 	r cpio.RecordReader
 
-	signed    *bytes.Buffer
-	signature *bytes.Buffer
+	signed *bytes.Buffer
+	// signatures are the signatures read so far, by the suffix of their
+	// records, in the order of suffixes.
+	signatures map[string]*Signature
+	suffixes   []string
 }
 
 // NewMeasuringReader returns a new measuring reader.
 func NewMeasuringReader(r cpio.RecordReader) *MeasuringReader {
 	return &MeasuringReader{
-		r:         r,
-		signed:    &bytes.Buffer{},
-		signature: &bytes.Buffer{},
+		r:          r,
+		signed:     &bytes.Buffer{},
+		signatures: make(map[string]*Signature),
 	}
 }
 
-// Verify verifies the contents of the archive as read so far.
-//
-// NOTE(UGH): Go crypto stuff is totally incompatible. ecdsa.PrivateKey.Sign
-// does not output shit that is compatible with ecdsa.Verify -- COME ON. Only
-// ecdsa.Sign does.
-func (mr *MeasuringReader) Verify(pk *rsa.PublicKey) error {
-	hashed := sha256.Sum256(mr.signed.Bytes())
-	return rsa.VerifyPKCS1v15(pk, crypto.SHA256, hashed[:], mr.signature.Bytes())
+// signatureRecord returns whether name is the name of a signature or
+// signature_algo record, and its suffix. The first signature of a package is
+// in signature and signature_algo, the next ones in signature.1,
+// signature_algo.1 and so on.
+func signatureRecord(name string) (algo bool, suffix string, ok bool) {
+	base := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		base, suffix = name[:i], name[i:]
+	}
+	switch base {
+	case "signature":
+		return false, suffix, true
+	case "signature_algo":
+		return true, suffix, true
+	}
+	return false, "", false
 }
 
-// ExtendTPM extends the given tpm at pcrIndex with the content of the package.
-func (mr *MeasuringReader) ExtendTPM(tpmRW io.ReadWriter, pcrIndex uint32) error {
-	pcrValue := sha1.Sum(mr.signed.Bytes())
-	_, err := tpm.PcrExtend(tpmRW, pcrIndex, pcrValue)
-	return err
+// Signatures returns the signatures of the archive read so far.
+func (mr *MeasuringReader) Signatures() []Signature {
+	var sigs []Signature
+	for _, suffix := range mr.suffixes {
+		sig := *mr.signatures[suffix]
+		if sig.Signature == nil {
+			continue
+		}
+		if sig.Algorithm == "" {
+			// Older packages have no signature_algo.
+			sig.Algorithm = AlgoRSAPKCS1v15SHA256
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// Verify verifies the contents of the archive as read so far against the
+// trusted keys in k. One signature made with a key in k is enough.
+func (mr *MeasuringReader) Verify(k *Keyring) error {
+	return k.Verify(mr.signed.Bytes(), mr.Signatures())
+}
+
+// ExtendTPM extends pcrIndex of t with the content of the package, in every
+// PCR bank.
+func (mr *MeasuringReader) ExtendTPM(t tpm.TPM, pcrIndex uint32) error {
+	return t.Extend(pcrIndex, tpm.Digests(t, mr.signed.Bytes()))
 }
 
 // ReadRecord wraps cpio.Reader.ReadRecord and adds the content to `signed` as
@@ -67,28 +93,35 @@ func (mr *MeasuringReader) ReadRecord() (cpio.Record, error) {
 			return rec, err
 		}
 
-		switch rec.Name {
-		case "signature":
-			// Error return is intentionally ignored.
-			mr.signature.ReadFrom(uio.Reader(rec))
-			continue
-
-		case "signature_algo":
-			//err = binary.Read(uio.Reader(rec), binary.LittleEndian, &mr.algo)
+		if algo, suffix, ok := signatureRecord(rec.Name); ok {
+			b, err := uio.ReadAll(rec)
+			if err != nil {
+				return cpio.Record{}, err
+			}
+			sig, ok := mr.signatures[suffix]
+			if !ok {
+				sig = &Signature{}
+				mr.signatures[suffix] = sig
+				mr.suffixes = append(mr.suffixes, suffix)
+			}
+			if algo {
+				sig.Algorithm = strings.TrimSpace(string(b))
+			} else {
+				sig.Signature = b
+			}
 			continue
+		}
 
-		default:
-			// Measure all regular files.
-			if rec.Info.Mode&unix.S_IFMT == unix.S_IFREG {
-				if _, err := mr.signed.WriteString(rec.Name); err != nil {
-					return cpio.Record{}, err
-				}
-				if _, err := mr.signed.ReadFrom(uio.Reader(rec)); err != nil {
-					return cpio.Record{}, err
-				}
+		// Measure all regular files.
+		if rec.Info.Mode&unix.S_IFMT == unix.S_IFREG {
+			if _, err := mr.signed.WriteString(rec.Name); err != nil {
+				return cpio.Record{}, err
+			}
+			if _, err := mr.signed.ReadFrom(uio.Reader(rec)); err != nil {
+				return cpio.Record{}, err
 			}
-			return rec, nil
 		}
+		return rec, nil
 	}
 }
 
@@ -98,6 +131,8 @@ type SigningWriter struct {
 	w cpio.RecordWriter
 
 	digest *bytes.Buffer
+	// signatures is the number of signatures written.
+	signatures int
 }
 
 // NewSigningWriter returns a new signing cpio writer.
@@ -111,7 +146,7 @@ func NewSigningWriter(w cpio.RecordWriter) *SigningWriter {
 // WriteRecord implements cpio.RecordWriter.
 func (sw *SigningWriter) WriteRecord(rec cpio.Record) error {
 	rec = cpio.MakeReproducible(rec)
-	if rec.Info.Name == "signature" || rec.Info.Name == "signature_algo" {
+	if _, _, ok := signatureRecord(rec.Info.Name); ok {
 		return fmt.Errorf("cannot write signature or signature_algo files")
 	}
 	if rec.Info.Mode&unix.S_IFMT == unix.S_IFREG {
@@ -130,27 +165,24 @@ func (sw *SigningWriter) SHA1Sum() [sha1.Size]byte {
 	return sha1.Sum(sw.digest.Bytes())
 }
 
-// WriteSignature writes the signature and signature_algo files based on the
-// collected digest.
-//
-// TODO(hugelgupf): stop hard-coding the private key and algorithm. Use
-// crypto.Signer so TPM could be used to sign this if so desired.
-func (sw *SigningWriter) WriteSignature(signer *rsa.PrivateKey) error {
-	hashed := sha256.Sum256(sw.digest.Bytes())
-	signature, err := signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+// WriteSignature writes the signature_algo and signature files for a
+// signature of the collected digest by signer. It may be called once for
+// every signer.
+func (sw *SigningWriter) WriteSignature(signer Signer) error {
+	signature, err := signer.Sign(sw.digest.Bytes())
 	if err != nil {
 		return err
 	}
-	if err := sw.w.WriteRecord(cpio.StaticFile("signature", string(signature), 0700)); err != nil {
+	var suffix string
+	if sw.signatures > 0 {
+		suffix = fmt.Sprintf(".%d", sw.signatures)
+	}
+	if err := sw.w.WriteRecord(cpio.StaticFile("signature_algo"+suffix, signer.Algorithm(), 0700)); err != nil {
 		return err
 	}
-
-	return nil
-	/*algo := &bytes.Buffer{}
-	if err := binary.Write(algo, binary.LittleEndian, x509.ECDSAWithSHA512); err != nil {
+	if err := sw.w.WriteRecord(cpio.StaticFile("signature"+suffix, string(signature), 0700)); err != nil {
 		return err
 	}
-	// TODO(hugelgupf): use x509 package for all of this.
-	// TODO(hugelgupf, later): no, please don't.
-	return sw.w.WriteRecord(cpio.StaticFile("signature_algo", string(algo.Bytes()), 0700))*/
+	sw.signatures++
+	return nil
 }
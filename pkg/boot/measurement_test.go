@@ -107,7 +107,7 @@ func TestWriterAndReader(t *testing.T) {
 		t.Errorf("rsa GenerateKey() = %v", err)
 	}
 
-	if err := s.WriteSignature(privateKey); err != nil {
+	if err := s.WriteSignature(NewPKCS1v15Signer(privateKey)); err != nil {
 		t.Errorf("WriteSignature() = %v, want nil", err)
 	}
 	if err := cpio.WriteTrailer(s); err != nil {
@@ -131,7 +131,11 @@ func TestWriterAndReader(t *testing.T) {
 		t.Errorf("ReadAllRecords() = \n%v, want \n%v", got, want)
 	}
 
-	if err := r.Verify(&privateKey.PublicKey); err != nil {
+	v, err := NewVerifier(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("NewVerifier() = %v", err)
+	}
+	if err := r.Verify(NewKeyring(v)); err != nil {
 		t.Errorf("Verify() = %v, want nil", err)
 	}
 }
@@ -6,31 +6,220 @@ package boot
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/multiboot"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
-// multibootImage is a multiboot-formated OSImage.
-type multibootImage struct{}
+// MultibootImage implements OSImage for a multiboot kernel and its modules,
+// e.g. Xen or ESXi.
+type MultibootImage struct {
+	Kernel  io.ReaderAt
+	Cmdline string
+	Modules []MultibootModule
+}
+
+// MultibootModule is a module loaded along with a multiboot kernel.
+type MultibootModule struct {
+	// Name is passed to the kernel as the first word of the module's
+	// command line. It is usually the module's original path.
+	Name    string
+	Content io.ReaderAt
+	Cmdline string
+}
+
+// cmdline returns the command line the kernel sees for the module.
+func (m MultibootModule) cmdline() string {
+	return strings.TrimSpace(m.Name + " " + m.Cmdline)
+}
+
+var _ OSImage = &MultibootImage{}
+
+// NewMultibootImageFromArchive reads a netboot21 multiboot OSImage from a
+// CPIO file archive.
+func NewMultibootImageFromArchive(a *cpio.Archive) (*MultibootImage, error) {
+	kernel, ok := a.Files["modules/kernel/content"]
+	if !ok {
+		return nil, fmt.Errorf("kernel missing from archive")
+	}
+	mi := &MultibootImage{Kernel: kernel}
+
+	readString := func(name string) (string, error) {
+		r, ok := a.Files[name]
+		if !ok {
+			return "", nil
+		}
+		b, err := uio.ReadAll(r)
+		return string(b), err
+	}
+
+	var err error
+	if mi.Cmdline, err = readString("modules/kernel/params"); err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		dir := fmt.Sprintf("modules/module%d", i)
+		content, ok := a.Files[dir+"/content"]
+		if !ok {
+			break
+		}
+		m := MultibootModule{Content: content}
+		if m.Name, err = readString(dir + "/name"); err != nil {
+			return nil, err
+		}
+		if m.Cmdline, err = readString(dir + "/params"); err != nil {
+			return nil, err
+		}
+		mi.Modules = append(mi.Modules, m)
+	}
+	return mi, nil
+}
 
-var _ OSImage = &multibootImage{}
+// Pack implements OSImage.Pack and writes the kernel to modules/kernel and
+// the i'th module to modules/module<i>.
+func (mi *MultibootImage) Pack(sw cpio.RecordWriter) error {
+	if mi.Kernel == nil {
+		return ErrKernelMissing
+	}
+	if err := sw.WriteRecord(cpio.Directory("modules", 0700)); err != nil {
+		return err
+	}
+	if err := sw.WriteRecord(cpio.Directory("modules/kernel", 0700)); err != nil {
+		return err
+	}
+	kernel, err := uio.ReadAll(mi.Kernel)
+	if err != nil {
+		return err
+	}
+	if err := sw.WriteRecord(cpio.StaticFile("modules/kernel/content", string(kernel), 0700)); err != nil {
+		return err
+	}
+	if err := sw.WriteRecord(cpio.StaticFile("modules/kernel/params", mi.Cmdline, 0700)); err != nil {
+		return err
+	}
 
-func newMultibootImage(a *cpio.Archive) (OSImage, error) {
-	return nil, fmt.Errorf("multiboot images unimplemented")
+	for i, m := range mi.Modules {
+		dir := fmt.Sprintf("modules/module%d", i)
+		if err := sw.WriteRecord(cpio.Directory(dir, 0700)); err != nil {
+			return err
+		}
+		content, err := uio.ReadAll(m.Content)
+		if err != nil {
+			return err
+		}
+		if err := sw.WriteRecord(cpio.StaticFile(dir+"/content", string(content), 0700)); err != nil {
+			return err
+		}
+		if err := sw.WriteRecord(cpio.StaticFile(dir+"/name", m.Name, 0700)); err != nil {
+			return err
+		}
+		if err := sw.WriteRecord(cpio.StaticFile(dir+"/params", m.Cmdline, 0700)); err != nil {
+			return err
+		}
+	}
+
+	return sw.WriteRecord(cpio.StaticFile("package_type", "multiboot", 0700))
+}
+
+// writeFiles copies the kernel and modules to files in dir and returns their
+// paths.
+func (mi *MultibootImage) writeFiles(dir string) (kernel string, modules []string, err error) {
+	write := func(name string, r io.ReaderAt) (string, error) {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, uio.Reader(r)); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	if mi.Kernel == nil {
+		return "", nil, ErrKernelMissing
+	}
+	if kernel, err = write("kernel", mi.Kernel); err != nil {
+		return "", nil, err
+	}
+	for i, m := range mi.Modules {
+		path, err := write(fmt.Sprintf("module%d", i), m.Content)
+		if err != nil {
+			return "", nil, err
+		}
+		modules = append(modules, path)
+	}
+	return kernel, modules, nil
 }
 
 // ExecutionInfo implements OSImage.ExecutionInfo.
-func (multibootImage) ExecutionInfo(log *log.Logger) {
-	log.Printf("Multiboot images are unsupported")
+func (mi *MultibootImage) ExecutionInfo(l *log.Logger) {
+	dir, err := ioutil.TempDir("", "multiboot")
+	if err != nil {
+		l.Printf("Creating temporary directory: %v", err)
+		return
+	}
+	kernel, modules, err := mi.writeFiles(dir)
+	if err != nil {
+		l.Printf("Copying multiboot image to %s: %v", dir, err)
+	}
+
+	l.Printf("Multiboot kernel: %s", kernel)
+	l.Printf("Command line: %s", mi.Cmdline)
+	for i, path := range modules {
+		l.Printf("Module: %s (%s)", path, mi.Modules[i].cmdline())
+	}
 }
 
-// Execute implements OSImage.Execute.
-func (multibootImage) Execute() error {
-	return fmt.Errorf("multiboot images unimplemented")
+// Load implements OSImage.Load. The current executable is used as the
+// trampoline that puts the machine into the state the multiboot spec
+// requires.
+func (mi *MultibootImage) Load() error {
+	trampoline, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find trampoline: %v", err)
+	}
+	if trampoline, err = filepath.EvalSymlinks(trampoline); err != nil {
+		return fmt.Errorf("cannot find trampoline: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "multiboot")
+	if err != nil {
+		return err
+	}
+	// The multiboot loader copies everything into segments, so the files
+	// are not needed once Load returns.
+	defer os.RemoveAll(dir)
+
+	kernel, files, err := mi.writeFiles(dir)
+	if err != nil {
+		return err
+	}
+	var cmds []string
+	for _, m := range mi.Modules {
+		cmds = append(cmds, m.cmdline())
+	}
+
+	m := multiboot.NewWithModuleFiles(kernel, mi.Cmdline, trampoline, cmds, files)
+	if err := m.Load(false); err != nil {
+		return fmt.Errorf("loading multiboot kernel: %v", err)
+	}
+	return kexec.Load(m.EntryPoint, m.Segments(), 0)
 }
 
-// Pack implements OSImage.Pack.
-func (multibootImage) Pack(sw cpio.RecordWriter) error {
-	return fmt.Errorf("multiboot images unimplemented")
+// Execute implements OSImage.Execute.
+func (mi *MultibootImage) Execute() error {
+	if err := mi.Load(); err != nil {
+		return err
+	}
+	return kexec.Reboot()
 }
@@ -0,0 +1,81 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+func multibootImageEqual(mi1, mi2 *MultibootImage) bool {
+	if !uio.ReaderAtEqual(mi1.Kernel, mi2.Kernel) ||
+		mi1.Cmdline != mi2.Cmdline ||
+		len(mi1.Modules) != len(mi2.Modules) {
+		return false
+	}
+	for i := range mi1.Modules {
+		m1, m2 := mi1.Modules[i], mi2.Modules[i]
+		if m1.Name != m2.Name || m1.Cmdline != m2.Cmdline || !uio.ReaderAtEqual(m1.Content, m2.Content) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMultibootImage(t *testing.T) {
+	for _, tt := range []struct {
+		mi  *MultibootImage
+		err error
+	}{
+		{
+			mi: &MultibootImage{
+				Kernel:  strings.NewReader("xen"),
+				Cmdline: "dom0_mem=1G",
+				Modules: []MultibootModule{
+					{Name: "/vmlinuz", Content: strings.NewReader("linux"), Cmdline: "root=/dev/sda1"},
+					{Name: "/initrd.img", Content: strings.NewReader("initrd")},
+				},
+			},
+		},
+		{
+			mi: &MultibootImage{
+				Kernel: strings.NewReader("xen"),
+			},
+		},
+		{
+			mi:  &MultibootImage{},
+			err: ErrKernelMissing,
+		},
+		{
+			mi: &MultibootImage{
+				Kernel: strings.NewReader("xen"),
+				Modules: []MultibootModule{
+					{Name: "/vmlinuz", Content: &errorReaderAt{err: errSkip}},
+				},
+			},
+			err: errSkip,
+		},
+	} {
+		a := cpio.InMemArchive()
+		if err := tt.mi.Pack(a); err != tt.err {
+			t.Errorf("Pack(%v) = %v, want %v", tt.mi, err, tt.err)
+		} else if err == nil {
+			p := &Package{}
+			if err := p.Unpack(a.Reader(), nil); err != nil {
+				t.Fatalf("Unpack() = %v", err)
+			}
+			mi, ok := p.OSImage.(*MultibootImage)
+			if !ok {
+				t.Fatalf("Unpacked image is %T, want *MultibootImage", p.OSImage)
+			}
+			if !multibootImageEqual(tt.mi, mi) {
+				t.Errorf("Images are not equal: got %v\nwant %v", mi, tt.mi)
+			}
+		}
+	}
+}
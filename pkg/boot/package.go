@@ -5,7 +5,6 @@
 package boot
 
 import (
-	"crypto/rsa"
 	"errors"
 	"fmt"
 	"path"
@@ -40,11 +39,8 @@ func (p *Package) AddMetadata(relPath string, content string) {
 	p.Metadata[relPath] = content
 }
 
-// Pack writes the boot package into archive w.
-//
-// TODO(hugelgupf): use a generic private key interface. No idea if we intend
-// to keep using RSA here. Make usable with TPM.
-func (p *Package) Pack(w cpio.RecordWriter, signer *rsa.PrivateKey) error {
+// Pack writes the boot package into archive w, signed by each of signers.
+func (p *Package) Pack(w cpio.RecordWriter, signers ...Signer) error {
 	sw := NewSigningWriter(w)
 
 	if len(p.Metadata) > 0 {
@@ -63,16 +59,18 @@ func (p *Package) Pack(w cpio.RecordWriter, signer *rsa.PrivateKey) error {
 		return err
 	}
 
-	if signer != nil {
-		return sw.WriteSignature(signer)
+	for _, signer := range signers {
+		if err := sw.WriteSignature(signer); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // Unpack unpacks a boot package in rr to p.
 //
-// TODO(hugelgupf): RSA? Generalize.
-func (p *Package) Unpack(rr cpio.RecordReader, pk *rsa.PublicKey) error {
+// If keyring is not nil, the package must be signed by one of its keys.
+func (p *Package) Unpack(rr cpio.RecordReader, keyring *Keyring) error {
 	*p = Package{
 		Metadata: make(map[string]string),
 	}
@@ -82,8 +80,8 @@ func (p *Package) Unpack(rr cpio.RecordReader, pk *rsa.PublicKey) error {
 	if err != nil {
 		return err
 	}
-	if pk != nil {
-		if err := recs.Verify(pk); err != nil {
+	if keyring != nil {
+		if err := recs.Verify(keyring); err != nil {
 			return err
 		}
 	}
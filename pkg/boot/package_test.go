@@ -26,6 +26,7 @@ func newMockImage(*cpio.Archive) (OSImage, error) {
 }
 
 func (mockOSImage) ExecutionInfo(log *log.Logger)     {}
+func (mockOSImage) Load() error                       { return nil }
 func (mockOSImage) Execute() error                    { return nil }
 func (m mockOSImage) Pack(sw cpio.RecordWriter) error { return m.packErr }
 
@@ -46,13 +47,22 @@ func TestBootPackage(t *testing.T) {
 	if err != nil {
 		t.Errorf("GenerateKey() = %v", err)
 	}
+	signer, err := NewSigner(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(v)
 
 	for _, tt := range []struct {
 		pkg       *Package
 		packErr   error
 		unpackErr error
-		signer    *rsa.PrivateKey
-		verifier  *rsa.PublicKey
+		signer    Signer
+		verifier  *Keyring
 	}{
 		{
 			pkg: &Package{
@@ -65,8 +75,8 @@ func TestBootPackage(t *testing.T) {
 					"stuff": "fooasdf",
 				},
 			},
-			signer:   privateKey,
-			verifier: &privateKey.PublicKey,
+			signer:   signer,
+			verifier: keyring,
 			packErr:  nil,
 		},
 		{
@@ -92,8 +102,8 @@ func TestBootPackage(t *testing.T) {
 					"stuff": "fooasdf",
 				},
 			},
-			verifier:  &privateKey.PublicKey,
-			unpackErr: rsa.ErrVerification,
+			verifier:  keyring,
+			unpackErr: ErrNotSigned,
 		},
 		{
 			pkg: &Package{
@@ -104,8 +114,8 @@ func TestBootPackage(t *testing.T) {
 				},
 				Metadata: map[string]string{},
 			},
-			signer:   privateKey,
-			verifier: &privateKey.PublicKey,
+			signer:   signer,
+			verifier: keyring,
 			packErr:  nil,
 		},
 		{
@@ -117,8 +127,8 @@ func TestBootPackage(t *testing.T) {
 				},
 				Metadata: map[string]string{},
 			},
-			signer:   privateKey,
-			verifier: &privateKey.PublicKey,
+			signer:   signer,
+			verifier: keyring,
 			packErr:  nil,
 		},
 		{
@@ -133,8 +143,8 @@ func TestBootPackage(t *testing.T) {
 					"abcd/foo": "haha",
 				},
 			},
-			signer:   privateKey,
-			verifier: &privateKey.PublicKey,
+			signer:   signer,
+			verifier: keyring,
 			packErr:  nil,
 		},
 		{
@@ -149,13 +159,17 @@ func TestBootPackage(t *testing.T) {
 					"abc/foo": "haha",
 				},
 			},
-			signer:   privateKey,
-			verifier: &privateKey.PublicKey,
+			signer:   signer,
+			verifier: keyring,
 			packErr:  nil,
 		},
 	} {
 		a := cpio.InMemArchive()
-		if err := tt.pkg.Pack(a, tt.signer); err != tt.packErr {
+		var signers []Signer
+		if tt.signer != nil {
+			signers = append(signers, tt.signer)
+		}
+		if err := tt.pkg.Pack(a, signers...); err != tt.packErr {
 			t.Errorf("Pack(%v) = %v, want %v", tt.pkg, err, tt.packErr)
 		}
 
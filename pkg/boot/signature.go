@@ -0,0 +1,360 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signature algorithms, as recorded in the signature_algo file of boot
+// packages.
+const (
+	// AlgoRSAPKCS1v15SHA256 is assumed for signatures without a
+	// signature_algo record, which older packages do not have.
+	AlgoRSAPKCS1v15SHA256 = "rsa-pkcs1v15-sha256"
+	AlgoRSAPSSSHA256      = "rsa-pss-sha256"
+	AlgoECDSAP256SHA256   = "ecdsa-p256-sha256"
+	AlgoECDSAP384SHA384   = "ecdsa-p384-sha384"
+	AlgoEd25519           = "ed25519"
+)
+
+var (
+	// ErrNotSigned is returned when verifying a package without signatures.
+	ErrNotSigned = errors.New("boot package is not signed")
+
+	// ErrUntrusted is returned when no signature of a package verifies
+	// with a trusted key.
+	ErrUntrusted = errors.New("no signature of the boot package verifies with a trusted key")
+
+	// errAlgo is returned by verifiers for signatures of algorithms their
+	// key is not for.
+	errAlgo = errors.New("signature algorithm does not match the key")
+)
+
+// Signer signs boot packages.
+type Signer interface {
+	// Algorithm returns the signature algorithm, one of the Algo
+	// constants.
+	Algorithm() string
+
+	// Sign returns the signature of message.
+	Sign(message []byte) ([]byte, error)
+}
+
+// Verifier verifies signatures of boot packages made with one key.
+type Verifier interface {
+	// Verify returns nil if sig is a signature of message made with algo
+	// by the key of the verifier.
+	Verify(algo string, message, sig []byte) error
+}
+
+// Signature is a signature of a boot package.
+type Signature struct {
+	Algorithm string
+	Signature []byte
+}
+
+// digest returns the hash algo signs with, and message hashed with it.
+func digest(algo string, message []byte) (crypto.Hash, []byte) {
+	switch algo {
+	case AlgoECDSAP384SHA384:
+		h := sha512.Sum384(message)
+		return crypto.SHA384, h[:]
+	default:
+		h := sha256.Sum256(message)
+		return crypto.SHA256, h[:]
+	}
+}
+
+type rsaSigner struct {
+	key *rsa.PrivateKey
+	pss bool
+}
+
+func (s *rsaSigner) Algorithm() string {
+	if s.pss {
+		return AlgoRSAPSSSHA256
+	}
+	return AlgoRSAPKCS1v15SHA256
+}
+
+func (s *rsaSigner) Sign(message []byte) ([]byte, error) {
+	h, hashed := digest(s.Algorithm(), message)
+	if s.pss {
+		return rsa.SignPSS(rand.Reader, s.key, h, hashed, nil)
+	}
+	return rsa.SignPKCS1v15(rand.Reader, s.key, h, hashed)
+}
+
+type rsaVerifier struct {
+	key *rsa.PublicKey
+}
+
+func (v *rsaVerifier) Verify(algo string, message, sig []byte) error {
+	h, hashed := digest(algo, message)
+	switch algo {
+	case AlgoRSAPSSSHA256:
+		return rsa.VerifyPSS(v.key, h, hashed, sig, nil)
+	case AlgoRSAPKCS1v15SHA256:
+		return rsa.VerifyPKCS1v15(v.key, h, hashed, sig)
+	}
+	return errAlgo
+}
+
+// ecdsaAlgo returns the signature algorithm for keys on curve c.
+func ecdsaAlgo(c elliptic.Curve) (string, error) {
+	switch c {
+	case elliptic.P256():
+		return AlgoECDSAP256SHA256, nil
+	case elliptic.P384():
+		return AlgoECDSAP384SHA384, nil
+	}
+	return "", fmt.Errorf("unsupported ECDSA curve %s", c.Params().Name)
+}
+
+// ecdsaSignature is the ASN.1 form of ECDSA signatures.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+type ecdsaSigner struct {
+	key  *ecdsa.PrivateKey
+	algo string
+}
+
+func (s *ecdsaSigner) Algorithm() string {
+	return s.algo
+}
+
+func (s *ecdsaSigner) Sign(message []byte) ([]byte, error) {
+	_, hashed := digest(s.algo, message)
+	return s.key.Sign(rand.Reader, hashed, nil)
+}
+
+type ecdsaVerifier struct {
+	key  *ecdsa.PublicKey
+	algo string
+}
+
+func (v *ecdsaVerifier) Verify(algo string, message, sig []byte) error {
+	if algo != v.algo {
+		return errAlgo
+	}
+	var es ecdsaSignature
+	if rest, err := asn1.Unmarshal(sig, &es); err != nil || len(rest) > 0 || es.R == nil || es.S == nil {
+		return errors.New("malformed ECDSA signature")
+	}
+	_, hashed := digest(algo, message)
+	if !ecdsa.Verify(v.key, hashed, es.R, es.S) {
+		return errors.New("ECDSA verification error")
+	}
+	return nil
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s ed25519Signer) Algorithm() string {
+	return AlgoEd25519
+}
+
+func (s ed25519Signer) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+type ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+func (v ed25519Verifier) Verify(algo string, message, sig []byte) error {
+	if algo != AlgoEd25519 {
+		return errAlgo
+	}
+	if !ed25519.Verify(v.key, message, sig) {
+		return errors.New("Ed25519 verification error")
+	}
+	return nil
+}
+
+// NewSigner returns a signer for key, which is an *rsa.PrivateKey, an
+// *ecdsa.PrivateKey on P-256 or P-384, or an ed25519.PrivateKey.
+//
+// RSA keys sign with RSA-PSS; see NewPKCS1v15Signer for packages that older
+// readers can verify.
+func NewSigner(key crypto.PrivateKey) (Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &rsaSigner{key: k, pss: true}, nil
+	case *ecdsa.PrivateKey:
+		algo, err := ecdsaAlgo(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsaSigner{key: k, algo: algo}, nil
+	case ed25519.PrivateKey:
+		return ed25519Signer{k}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// NewPKCS1v15Signer returns a signer for RSA PKCS#1 v1.5 signatures with
+// SHA-256.
+func NewPKCS1v15Signer(key *rsa.PrivateKey) Signer {
+	return &rsaSigner{key: key}
+}
+
+// NewVerifier returns a verifier for key, which is an *rsa.PublicKey, an
+// *ecdsa.PublicKey on P-256 or P-384, or an ed25519.PublicKey. RSA keys
+// verify both RSA-PSS and PKCS#1 v1.5 signatures.
+func NewVerifier(key crypto.PublicKey) (Verifier, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &rsaVerifier{k}, nil
+	case *ecdsa.PublicKey:
+		algo, err := ecdsaAlgo(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsaVerifier{key: k, algo: algo}, nil
+	case ed25519.PublicKey:
+		return ed25519Verifier{k}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// Keyring is a set of trusted keys.
+type Keyring struct {
+	verifiers []Verifier
+}
+
+// NewKeyring returns a keyring trusting the keys of verifiers.
+func NewKeyring(verifiers ...Verifier) *Keyring {
+	return &Keyring{verifiers: verifiers}
+}
+
+// Add adds a trusted key.
+func (k *Keyring) Add(v Verifier) {
+	k.verifiers = append(k.verifiers, v)
+}
+
+// Len returns the number of keys in k.
+func (k *Keyring) Len() int {
+	return len(k.verifiers)
+}
+
+// Verify returns nil if any of sigs is a signature of message made by a key
+// in k.
+func (k *Keyring) Verify(message []byte, sigs []Signature) error {
+	if len(sigs) == 0 {
+		return ErrNotSigned
+	}
+	for _, sig := range sigs {
+		for _, v := range k.verifiers {
+			if v.Verify(sig.Algorithm, message, sig.Signature) == nil {
+				return nil
+			}
+		}
+	}
+	return ErrUntrusted
+}
+
+// oidEd25519 identifies Ed25519 keys, as in RFC 8410.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// subjectPublicKeyInfo is the PKIX form of public keys.
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// parsePublicKey parses a PKIX public key. x509 does not know Ed25519 keys,
+// so they are parsed here.
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	var spki subjectPublicKeyInfo
+	if rest, err := asn1.Unmarshal(der, &spki); err == nil && len(rest) == 0 && spki.Algorithm.Algorithm.Equal(oidEd25519) {
+		if len(spki.PublicKey.Bytes) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 public key")
+		}
+		return ed25519.PublicKey(spki.PublicKey.Bytes), nil
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// MarshalPublicKey returns key in PKIX form, as a PEM "PUBLIC KEY" block
+// for keyring files.
+func MarshalPublicKey(key crypto.PublicKey) ([]byte, error) {
+	var der []byte
+	var err error
+	if k, ok := key.(ed25519.PublicKey); ok {
+		der, err = asn1.Marshal(subjectPublicKeyInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			PublicKey: asn1.BitString{Bytes: k, BitLength: 8 * len(k)},
+		})
+	} else {
+		der, err = x509.MarshalPKIXPublicKey(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParseKeyring returns a keyring of the PEM "PUBLIC KEY" blocks in b.
+// Other blocks are ignored.
+func ParseKeyring(b []byte) (*Keyring, error) {
+	k := NewKeyring()
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := parsePublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		v, err := NewVerifier(key)
+		if err != nil {
+			return nil, err
+		}
+		k.Add(v)
+	}
+	if k.Len() == 0 {
+		return nil, errors.New("no public keys in keyring")
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keyring file of PEM "PUBLIC KEY" blocks.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParseKeyring(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return k, nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boot
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ed25519"
+)
+
+type testKey struct {
+	algo   string
+	signer Signer
+	public crypto.PublicKey
+}
+
+func testKeys(t *testing.T) []testKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []testKey{
+		{AlgoRSAPKCS1v15SHA256, NewPKCS1v15Signer(rsaKey), &rsaKey.PublicKey},
+	}
+	for _, k := range []struct {
+		algo    string
+		private crypto.PrivateKey
+		public  crypto.PublicKey
+	}{
+		{AlgoRSAPSSSHA256, rsaKey, &rsaKey.PublicKey},
+		{AlgoECDSAP256SHA256, p256, &p256.PublicKey},
+		{AlgoECDSAP384SHA384, p384, &p384.PublicKey},
+		{AlgoEd25519, edPrivate, edPublic},
+	} {
+		s, err := NewSigner(k.private)
+		if err != nil {
+			t.Fatalf("NewSigner(%T) = %v", k.private, err)
+		}
+		keys = append(keys, testKey{k.algo, s, k.public})
+	}
+	return keys
+}
+
+func keyring(t *testing.T, keys ...crypto.PublicKey) *Keyring {
+	k := NewKeyring()
+	for _, key := range keys {
+		v, err := NewVerifier(key)
+		if err != nil {
+			t.Fatalf("NewVerifier(%T) = %v", key, err)
+		}
+		k.Add(v)
+	}
+	return k
+}
+
+func testPackage() *Package {
+	return &Package{
+		OSImage: &LinuxImage{
+			Kernel:  strings.NewReader("lana"),
+			Cmdline: "foo=bar",
+		},
+		Metadata: map[string]string{},
+	}
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	keys := testKeys(t)
+	other := testKeys(t)
+
+	for i, k := range keys {
+		if got := k.signer.Algorithm(); got != k.algo {
+			t.Errorf("Algorithm() = %q, want %q", got, k.algo)
+		}
+
+		a := cpio.InMemArchive()
+		if err := testPackage().Pack(a, k.signer); err != nil {
+			t.Fatalf("%s: Pack() = %v", k.algo, err)
+		}
+		if rec, ok := a.Get("signature_algo"); !ok {
+			t.Errorf("%s: no signature_algo record", k.algo)
+		} else if b, _ := uio.ReadAll(rec); string(b) != k.algo {
+			t.Errorf("%s: signature_algo is %q", k.algo, b)
+		}
+
+		var p Package
+		if err := p.Unpack(a.Reader(), keyring(t, k.public)); err != nil {
+			t.Errorf("%s: Unpack() = %v, want nil", k.algo, err)
+		}
+		if err := p.Unpack(a.Reader(), keyring(t, other[i].public)); err != ErrUntrusted {
+			t.Errorf("%s: Unpack() with another key = %v, want %v", k.algo, err, ErrUntrusted)
+		}
+	}
+}
+
+func TestMultipleSignatures(t *testing.T) {
+	keys := testKeys(t)
+	a := cpio.InMemArchive()
+	if err := testPackage().Pack(a, keys[1].signer, keys[3].signer, keys[4].signer); err != nil {
+		t.Fatalf("Pack() = %v", err)
+	}
+
+	r := NewMeasuringReader(a.Reader())
+	if _, err := cpio.ReadAllRecords(r); err != nil {
+		t.Fatal(err)
+	}
+	var algos []string
+	for _, s := range r.Signatures() {
+		algos = append(algos, s.Algorithm)
+	}
+	if got, want := strings.Join(algos, ","), AlgoRSAPSSSHA256+","+AlgoECDSAP384SHA384+","+AlgoEd25519; got != want {
+		t.Errorf("signatures are %s, want %s", got, want)
+	}
+
+	// Any one trusted key is enough.
+	for _, k := range []int{1, 3, 4} {
+		if err := r.Verify(keyring(t, keys[2].public, keys[k].public)); err != nil {
+			t.Errorf("Verify() with key %s = %v, want nil", keys[k].algo, err)
+		}
+	}
+	if err := r.Verify(keyring(t, keys[2].public)); err != ErrUntrusted {
+		t.Errorf("Verify() with an untrusted key = %v, want %v", err, ErrUntrusted)
+	}
+	if err := r.Verify(NewKeyring()); err != ErrUntrusted {
+		t.Errorf("Verify() with an empty keyring = %v, want %v", err, ErrUntrusted)
+	}
+}
+
+func TestLegacySignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Packages used to have a PKCS#1 v1.5 signature and no signature_algo.
+	a := cpio.InMemArchive()
+	sw := NewSigningWriter(a)
+	if err := testPackage().OSImage.Pack(sw); err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256(sw.digest.Bytes())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteRecord(cpio.StaticFile("signature", string(sig), 0700)); err != nil {
+		t.Fatal(err)
+	}
+
+	var p Package
+	if err := p.Unpack(a.Reader(), keyring(t, &key.PublicKey)); err != nil {
+		t.Errorf("Unpack() = %v, want nil", err)
+	}
+}
+
+func TestTamperedPackage(t *testing.T) {
+	keys := testKeys(t)
+	for _, k := range keys {
+		a := cpio.InMemArchive()
+		if err := testPackage().Pack(a, k.signer); err != nil {
+			t.Fatal(err)
+		}
+		// Replace the kernel.
+		var recs []cpio.Record
+		for _, rec := range a.Order {
+			if rec == "modules/kernel/content" {
+				recs = append(recs, cpio.StaticFile(rec, "evil", 0700))
+			} else {
+				r, _ := a.Get(rec)
+				recs = append(recs, r)
+			}
+		}
+		var p Package
+		if err := p.Unpack(cpio.ArchiveFromRecords(recs).Reader(), keyring(t, k.public)); err != ErrUntrusted {
+			t.Errorf("%s: Unpack() of a tampered package = %v, want %v", k.algo, err, ErrUntrusted)
+		}
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	keys := testKeys(t)
+	var pem []byte
+	for _, k := range keys[1:] {
+		b, err := MarshalPublicKey(k.public)
+		if err != nil {
+			t.Fatalf("MarshalPublicKey(%T) = %v", k.public, err)
+		}
+		pem = append(pem, b...)
+	}
+	kr, err := ParseKeyring(pem)
+	if err != nil {
+		t.Fatalf("ParseKeyring() = %v", err)
+	}
+	if kr.Len() != len(keys)-1 {
+		t.Errorf("keyring has %d keys, want %d", kr.Len(), len(keys)-1)
+	}
+
+	for _, k := range keys {
+		a := cpio.InMemArchive()
+		if err := testPackage().Pack(a, k.signer); err != nil {
+			t.Fatal(err)
+		}
+		var p Package
+		if err := p.Unpack(a.Reader(), kr); err != nil {
+			t.Errorf("%s: Unpack() = %v, want nil", k.algo, err)
+		}
+	}
+
+	if _, err := ParseKeyring([]byte("no keys")); err == nil {
+		t.Errorf("ParseKeyring() of no keys succeeded, want error")
+	}
+}
@@ -10,6 +10,7 @@
 package cmdline
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -38,7 +39,7 @@ func cmdLineOpener() {
 	if err != nil {
 		errorMsg := fmt.Sprintf("Can't open /proc/cmdline: %v", err)
 		log.Print(errorMsg)
-		procCmdLine = CmdLine{Err: fmt.Errorf(errorMsg)}
+		procCmdLine = CmdLine{Err: errors.New(errorMsg)}
 		return
 	}
 
@@ -74,9 +75,8 @@ func parse(cmdlineReader io.Reader) CmdLine {
 	return line
 }
 
-// parseToMap turns a space-separated kernel commandline into a map
-func parseToMap(input string) map[string]string {
-
+// fields splits a kernel commandline into its flags, respecting quotes
+func fields(input string) []string {
 	lastQuote := rune(0)
 	quotedFieldsCheck := func(c rune) bool {
 		switch {
@@ -93,8 +93,13 @@ func parseToMap(input string) map[string]string {
 		}
 	}
 
+	return strings.FieldsFunc(input, quotedFieldsCheck)
+}
+
+// parseToMap turns a space-separated kernel commandline into a map
+func parseToMap(input string) map[string]string {
 	flagMap := make(map[string]string)
-	for _, flag := range strings.FieldsFunc(string(input), quotedFieldsCheck) {
+	for _, flag := range fields(input) {
 		// kernel variables must allow '-' and '_' to be equivalent in variable
 		// names. We will replace dashes with underscores for processing.
 
@@ -138,6 +143,25 @@ func Flag(flag string) (string, bool) {
 	return value, present
 }
 
+// FlagValues returns all values of a flag, in order. Some flags, like ip=,
+// may be given more than once, and AsMap only holds the last value.
+func (c CmdLine) FlagValues(flag string) []string {
+	canonicalFlag := strings.Replace(flag, "-", "_", -1)
+	var values []string
+	for _, f := range fields(c.Raw) {
+		kv := strings.SplitN(f, "=", 2)
+		if strings.Replace(kv[0], "-", "_", -1) != canonicalFlag {
+			continue
+		}
+		if len(kv) == 1 {
+			values = append(values, "1")
+		} else {
+			values = append(values, strings.Trim(kv[1], "\"'"))
+		}
+	}
+	return values
+}
+
 // getFlagMap gets specified flags as a map
 func getFlagMap(flagName string) map[string]string {
 	return parseToMap(flagName)
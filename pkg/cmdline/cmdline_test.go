@@ -5,6 +5,7 @@
 package cmdline
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -81,3 +82,17 @@ func TestCmdline(t *testing.T) {
 	}
 
 }
+
+func TestFlagValues(t *testing.T) {
+	c := CmdLine{Raw: `ip=eth0:dhcp console=tty0 ip="eth1:dhcp6" ip-foo=1 nameserver=10.0.0.1 ip`}
+	want := []string{"eth0:dhcp", "eth1:dhcp6", "1"}
+	if got := c.FlagValues("ip"); !reflect.DeepEqual(got, want) {
+		t.Errorf("FlagValues(ip) = %q, want %q", got, want)
+	}
+	if got := c.FlagValues("ip_foo"); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("FlagValues(ip_foo) = %q, want [1]", got)
+	}
+	if got := c.FlagValues("vlan"); got != nil {
+		t.Errorf("FlagValues(vlan) = %q, want none", got)
+	}
+}
@@ -86,6 +86,8 @@ func Configure4(iface netlink.Link, packet *dhcp4.Packet) error {
 }
 
 // Configure6 adds IPv6 addresses, routes, and DNS servers to the system.
+//
+// Addresses configured by SLAAC are left alone; see addAddr6.
 func Configure6(iface netlink.Link, packet *dhcp6.Packet, iana *dhcp6opts.IANA) error {
 	p := NewPacket6(packet, iana)
 
@@ -94,26 +96,61 @@ func Configure6(iface netlink.Link, packet *dhcp6.Packet, iana *dhcp6opts.IANA)
 		return fmt.Errorf("no lease returned")
 	}
 
-	// Add the address to the iface.
+	if err := addAddr6(iface, l); err != nil {
+		return err
+	}
+
+	if ips := p.DNS(); ips != nil {
+		if err := WriteDNSSettings(ips); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addAddr6 adds the address assigned by DHCPv6 to iface.
+//
+// DHCPv6 assigns addresses without a prefix length; which prefixes are on
+// the link is up to router advertisements, RFC 4861 Section 6.3.4. So the
+// address gets the length of an on-link route the kernel installed from an
+// advertisement, or /128. An existing address that outlives the assigned
+// one, e.g. because SLAAC configured it too, is not touched.
+func addAddr6(iface netlink.Link, a *dhcp6opts.IAAddr) error {
+	addrs, err := netlink.AddrList(iface, netlink.FAMILY_V6)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(a.IP) && addr.ValidLft > int(a.ValidLifetime.Seconds()) {
+			return nil
+		}
+	}
+
+	mask := net.CIDRMask(128, 128)
+	if routes, err := netlink.RouteList(iface, netlink.FAMILY_V6); err == nil {
+		for _, r := range routes {
+			if r.Dst == nil || !r.Dst.Contains(a.IP) || r.Dst.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ones, _ := r.Dst.Mask.Size(); ones > 0 {
+				mask = r.Dst.Mask
+			}
+		}
+	}
+
 	dst := &netlink.Addr{
 		IPNet: &net.IPNet{
-			IP:   l.IP,
-			Mask: net.IPMask(net.ParseIP("ffff:ffff:ffff:ffff::")),
+			IP:   a.IP,
+			Mask: mask,
 		},
-		PreferedLft: int(l.PreferredLifetime.Seconds()),
-		ValidLft:    int(l.ValidLifetime.Seconds()),
+		PreferedLft: int(a.PreferredLifetime.Seconds()),
+		ValidLft:    int(a.ValidLifetime.Seconds()),
 	}
 	if err := netlink.AddrReplace(iface, dst); err != nil {
 		if os.IsExist(err) {
 			return fmt.Errorf("add/replace %s to %v: %v", dst, iface, err)
 		}
 	}
-
-	if ips := p.DNS(); ips != nil {
-		if err := WriteDNSSettings(ips); err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
@@ -7,6 +7,7 @@ package dhclient
 import (
 	"net"
 	"net/url"
+	"strings"
 
 	"github.com/u-root/dhcp4"
 	"github.com/u-root/dhcp4/dhcp4opts"
@@ -66,8 +67,15 @@ func (p *Packet4) Boot() (url.URL, error) {
 	// stuff is encoded in options instead of in the packet's BootFile and
 	// ServerName fields.
 
+	bootFile := p.P.BootFile
+	// Boot file URLs often do not fit into the BootFile field, so HTTP
+	// boot servers send them in an option instead.
+	if opt := p.P.Options.Get(dhcp4.OptionBootFileName); len(opt) > 0 {
+		bootFile = strings.TrimRight(string(opt), "\x00")
+	}
+
 	// While the default is tftp, servers may specify HTTP or FTP URIs.
-	u, err := url.Parse(p.P.BootFile)
+	u, err := url.Parse(bootFile)
 	if err != nil {
 		return url.URL{}, err
 	}
@@ -75,7 +83,7 @@ func (p *Packet4) Boot() (url.URL, error) {
 	if len(u.Scheme) == 0 {
 		// Defaults to tftp is not specified.
 		u.Scheme = "tftp"
-		u.Path = p.P.BootFile
+		u.Path = bootFile
 		if len(p.P.ServerName) == 0 {
 			server := dhcp4opts.GetServerIdentifier(p.P.Options)
 			if server == nil {
@@ -0,0 +1,350 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4client"
+	"github.com/u-root/dhcp4/dhcp4opts"
+	"github.com/u-root/u-root/pkg/dhcp6client"
+	"github.com/vishvananda/netlink"
+)
+
+// Lease is a DHCPv4 or DHCPv6 lease obtained on an interface.
+type Lease struct {
+	// Link is the interface the lease was obtained on.
+	Link netlink.Link
+
+	// Exactly one of P4 and P6 is set.
+	P4 *Packet4
+	P6 *Packet6
+}
+
+func (l *Lease) String() string {
+	if l.P4 != nil {
+		return fmt.Sprintf("DHCPv4 lease %v on %s", l.P4.Lease(), l.Link.Attrs().Name)
+	}
+	var ip interface{}
+	if a := l.P6.Lease(); a != nil {
+		ip = a.IP
+	}
+	return fmt.Sprintf("DHCPv6 lease %v on %s", ip, l.Link.Attrs().Name)
+}
+
+// Configure adds the lease's addresses, routes, and DNS servers to the system.
+func (l *Lease) Configure() error {
+	if l.P4 != nil {
+		return Configure4(l.Link, l.P4.P)
+	}
+	return Configure6(l.Link, l.P6.p, l.P6.iana)
+}
+
+// Boot returns the boot file URL of the lease.
+func (l *Lease) Boot() (url.URL, error) {
+	if l.P4 != nil {
+		return l.P4.Boot()
+	}
+	u, _, err := l.P6.Boot()
+	return u, err
+}
+
+// hasBoot returns whether the lease carries a boot file.
+func (l *Lease) hasBoot() bool {
+	if l.P4 != nil {
+		return len(l.P4.P.BootFile) > 0 || len(l.P4.P.Options.Get(dhcp4.OptionBootFileName)) > 0
+	}
+	_, err := l.Boot()
+	return err == nil
+}
+
+// Config configures RequestAny.
+type Config struct {
+	// Timeout is the timeout of each DHCP request.
+	Timeout time.Duration
+
+	// Retries is the number of attempts of each DHCP request. -1 means
+	// infinity.
+	Retries int
+
+	// IPv4 and IPv6 select which protocols to request leases with.
+	IPv4 bool
+	IPv6 bool
+
+	// NeedBoot discards leases that carry no boot file.
+	NeedBoot bool
+}
+
+// RequestAny brings up all links and requests leases on all of them in
+// parallel. It returns the first usable lease and cancels all other
+// requests, or it returns an error once all requests have failed or ctx is
+// canceled.
+//
+// Leases that are not returned are released, and links that were down are
+// brought down again, except for the link of the returned lease.
+//
+// RequestAny does not configure the interface; see Lease.Configure.
+func RequestAny(ctx context.Context, links []netlink.Link, c Config) (*Lease, error) {
+	return requestAny(ctx, links, c, netRequester{})
+}
+
+// requester brings links up and down and requests and releases leases on
+// them, for requestAny.
+type requester interface {
+	// up brings up the link named name, and returns it and whether it
+	// was up before. The link is returned with an error, too, if it
+	// could be found.
+	up(name string) (netlink.Link, bool, error)
+	down(link netlink.Link) error
+
+	request4(ctx context.Context, iface netlink.Link, c Config) (*Lease, error)
+	request6(ctx context.Context, iface netlink.Link, c Config) (*Lease, error)
+	release(l *Lease) error
+}
+
+func requestAny(ctx context.Context, links []netlink.Link, c Config, r requester) (*Lease, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		lease *Lease
+		err   error
+	}
+	// Results are buffered so that the losers need not wait for the
+	// winner to be picked.
+	results := make(chan result, 2*len(links))
+
+	var mu sync.Mutex
+	var wasDown []netlink.Link
+
+	var wg sync.WaitGroup
+	for _, link := range links {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			iface, up, err := r.up(name)
+			if iface != nil && !up {
+				mu.Lock()
+				wasDown = append(wasDown, iface)
+				mu.Unlock()
+			}
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			if c.IPv4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					l, err := r.request4(ctx, iface, c)
+					results <- result{l, err}
+				}()
+			}
+			if c.IPv6 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					l, err := r.request6(ctx, iface, c)
+					results <- result{l, err}
+				}()
+			}
+		}(link.Attrs().Name)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	release := func(l *Lease) {
+		if err := r.release(l); err != nil {
+			log.Printf("Releasing %v: %v", l, err)
+		}
+	}
+	pick := func() (*Lease, error) {
+		var errs []string
+		for {
+			select {
+			case res, ok := <-results:
+				if !ok {
+					if len(errs) == 0 {
+						return nil, errors.New("no lease requested")
+					}
+					return nil, fmt.Errorf("no usable lease: %s", strings.Join(errs, "; "))
+				}
+				if res.err != nil {
+					errs = append(errs, res.err.Error())
+				} else if c.NeedBoot && !res.lease.hasBoot() {
+					errs = append(errs, fmt.Sprintf("%v has no boot file", res.lease))
+					release(res.lease)
+				} else {
+					return res.lease, nil
+				}
+
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	lease, err := pick()
+
+	// Stop the other requests, and give back what they got anyway.
+	cancel()
+	for res := range results {
+		if res.lease != nil {
+			release(res.lease)
+		}
+	}
+	for _, l := range wasDown {
+		if lease != nil && l.Attrs().Name == lease.Link.Attrs().Name {
+			continue
+		}
+		if err := r.down(l); err != nil {
+			log.Printf("Bringing %s down: %v", l.Attrs().Name, err)
+		}
+	}
+	return lease, err
+}
+
+// netRequester is the requester of the system's links and DHCP servers.
+type netRequester struct{}
+
+func (netRequester) up(name string) (netlink.Link, bool, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, false, err
+	}
+	iface, err := IfUp(name)
+	if err != nil {
+		return link, link.Attrs().Flags&net.FlagUp != 0, err
+	}
+	return iface, link.Attrs().Flags&net.FlagUp != 0, nil
+}
+
+func (netRequester) down(link netlink.Link) error {
+	return netlink.LinkSetDown(link)
+}
+
+func (netRequester) request4(ctx context.Context, iface netlink.Link, c Config) (*Lease, error) {
+	return request4(ctx, iface, c)
+}
+
+func (netRequester) request6(ctx context.Context, iface netlink.Link, c Config) (*Lease, error) {
+	return request6(ctx, iface, c)
+}
+
+func (netRequester) release(l *Lease) error {
+	if l.P4 != nil {
+		conn, err := dhcp4client.NewPacketUDPConn(l.Link.Attrs().Name, dhcp4client.ClientPort)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return release4(conn, l)
+	}
+
+	client, err := dhcp6client.New(l.Link)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	dl, err := dhcp6client.NewLease(l.P6.p, time.Now())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	return client.Release(ctx, dl)
+}
+
+// release4 sends a DHCPRELEASE of the DHCPv4 lease l to its server on conn.
+// The address of l is not configured, so conn must be able to send without
+// it. No reply is expected.
+func release4(conn net.PacketConn, l *Lease) error {
+	l4 := NewLease4(l.P4.P, time.Now(), DefaultLeaseTime)
+	b, err := ReleasePacket(l.Link.Attrs().HardwareAddr, l4).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(b, &net.UDPAddr{IP: l4.ServerID(), Port: dhcp4client.ServerPort})
+	return err
+}
+
+// request4 requests a DHCPv4 lease on iface.
+func request4(ctx context.Context, iface netlink.Link, c Config) (*Lease, error) {
+	client, err := dhcp4client.New(iface,
+		dhcp4client.WithTimeout(c.Timeout),
+		dhcp4client.WithRetry(c.Retries))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", iface.Attrs().Name, err)
+	}
+	defer client.Close()
+
+	offer, err := sendAndRead4(ctx, client, dhcp4client.DefaultServers, client.DiscoverPacket(), dhcp4opts.DHCPOffer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: DHCPv4 discover: %v", iface.Attrs().Name, err)
+	}
+	ack, err := sendAndRead4(ctx, client, dhcp4client.DefaultServers, client.RequestPacket(offer), dhcp4opts.DHCPACK)
+	if err != nil {
+		return nil, fmt.Errorf("%s: DHCPv4 request: %v", iface.Attrs().Name, err)
+	}
+	return &Lease{Link: iface, P4: NewPacket4(ack)}, nil
+}
+
+// errNAK is returned when a server rejects a request.
+var errNAK = errors.New("server sent NAK")
+
+// sendAndRead4 sends p to dest and returns the first reply of type typ. It
+// gives up early if ctx is canceled.
+func sendAndRead4(ctx context.Context, client *dhcp4client.Client, dest *net.UDPAddr, p *dhcp4.Packet, typ dhcp4opts.DHCPMessageType) (*dhcp4.Packet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	wg, out, errCh := client.SimpleSendAndRead(ctx, dest, p)
+	defer func() {
+		// Explicitly cancel first, then wait.
+		cancel()
+		wg.Wait()
+	}()
+
+	for packet := range out {
+		switch dhcp4opts.GetDHCPMessageType(packet.Packet.Options) {
+		case typ:
+			return packet.Packet, nil
+		case dhcp4opts.DHCPNAK:
+			return nil, errNAK
+		}
+	}
+	if err, ok := <-errCh; ok && err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("didn't get a packet")
+}
+
+// request6 requests a DHCPv6 lease on iface.
+func request6(ctx context.Context, iface netlink.Link, c Config) (*Lease, error) {
+	client, err := dhcp6client.New(iface,
+		dhcp6client.WithTimeout(c.Timeout),
+		dhcp6client.WithRetry(c.Retries))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", iface.Attrs().Name, err)
+	}
+	defer client.Close()
+
+	iana, packet, err := client.RapidSolicit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: DHCPv6 solicit: %v", iface.Attrs().Name, err)
+	}
+	return &Lease{Link: iface, P6: NewPacket6(packet, iana)}, nil
+}
@@ -0,0 +1,491 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4client"
+	"github.com/u-root/dhcp4/dhcp4opts"
+	"github.com/vishvananda/netlink"
+)
+
+// Timers used if the server does not send them.
+const (
+	// DefaultLeaseTime is the lease time assumed if the server sends
+	// none.
+	DefaultLeaseTime = time.Hour
+
+	// minRetransmit is the minimum delay between renew or rebind
+	// attempts, RFC 2131 Section 4.4.5.
+	minRetransmit = time.Minute
+)
+
+// LeaseState is the state of a DHCPv4 lease, RFC 2131 Section 4.4.
+type LeaseState int
+
+// Lease states.
+const (
+	// StateBound means the lease is valid and need not be renewed yet.
+	StateBound LeaseState = iota
+
+	// StateRenewing means the lease is past T1 and is renewed with the
+	// server that granted it.
+	StateRenewing
+
+	// StateRebinding means the lease is past T2 and is renewed with any
+	// server.
+	StateRebinding
+
+	// StateExpired means the lease is no longer valid.
+	StateExpired
+)
+
+func (s LeaseState) String() string {
+	switch s {
+	case StateBound:
+		return "BOUND"
+	case StateRenewing:
+		return "RENEWING"
+	case StateRebinding:
+		return "REBINDING"
+	case StateExpired:
+		return "EXPIRED"
+	}
+	return fmt.Sprintf("LeaseState(%d)", int(s))
+}
+
+// Lease4 is a DHCPv4 lease and the timers that drive its renewal.
+type Lease4 struct {
+	// Packet is the server's ACK.
+	Packet *Packet4
+
+	// Acquired is when the lease was granted or last renewed.
+	Acquired time.Time
+
+	// LeaseTime is how long the lease is valid from Acquired on. T1 and
+	// T2 are when renewing and rebinding starts.
+	LeaseTime time.Duration
+	T1        time.Duration
+	T2        time.Duration
+}
+
+// optionDuration returns the duration in seconds stored in option code of
+// o, or 0 if there is none.
+func optionDuration(o dhcp4.Options, code dhcp4.OptionCode) time.Duration {
+	v := o.Get(code)
+	if len(v) != 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+}
+
+// NewLease4 returns the lease granted by ack at acquired.
+//
+// Timers the server does not send default to RFC 2131 Section 4.4.5: T1 is
+// half the lease time, and T2 is 7/8 of it. If the server sends no lease
+// time, defaultLeaseTime is used.
+func NewLease4(ack *dhcp4.Packet, acquired time.Time, defaultLeaseTime time.Duration) *Lease4 {
+	l := &Lease4{
+		Packet:    NewPacket4(ack),
+		Acquired:  acquired,
+		LeaseTime: optionDuration(ack.Options, dhcp4.OptionIPAddressLeaseTime),
+		T1:        optionDuration(ack.Options, dhcp4.OptionRenewalTimeValue),
+		T2:        optionDuration(ack.Options, dhcp4.OptionRebindingTimeValue),
+	}
+	if l.LeaseTime == 0 {
+		l.LeaseTime = defaultLeaseTime
+	}
+	if l.T2 == 0 || l.T2 > l.LeaseTime {
+		l.T2 = l.LeaseTime * 7 / 8
+	}
+	if l.T1 == 0 || l.T1 > l.T2 {
+		l.T1 = l.LeaseTime / 2
+		if l.T1 > l.T2 {
+			l.T1 = l.T2
+		}
+	}
+	return l
+}
+
+// IP returns the leased address.
+func (l *Lease4) IP() net.IP {
+	return l.Packet.P.YIAddr
+}
+
+// ServerID returns the identifier of the server that granted the lease.
+func (l *Lease4) ServerID() net.IP {
+	if sid := dhcp4opts.GetServerIdentifier(l.Packet.P.Options); sid != nil {
+		return net.IP(sid)
+	}
+	return l.Packet.P.SIAddr
+}
+
+// Expiry returns when the lease expires.
+func (l *Lease4) Expiry() time.Time {
+	return l.Acquired.Add(l.LeaseTime)
+}
+
+// State returns the state of the lease at now.
+func (l *Lease4) State(now time.Time) LeaseState {
+	switch t := now.Sub(l.Acquired); {
+	case t < l.T1:
+		return StateBound
+	case t < l.T2:
+		return StateRenewing
+	case t < l.LeaseTime:
+		return StateRebinding
+	}
+	return StateExpired
+}
+
+// NextEvent returns when the lease enters its next state, or the zero time
+// if it has expired.
+func (l *Lease4) NextEvent(now time.Time) time.Time {
+	switch l.State(now) {
+	case StateBound:
+		return l.Acquired.Add(l.T1)
+	case StateRenewing:
+		return l.Acquired.Add(l.T2)
+	case StateRebinding:
+		return l.Expiry()
+	}
+	return time.Time{}
+}
+
+// leaseFile is the on-disk format of a Lease4.
+type leaseFile struct {
+	Interface string
+	Acquired  time.Time
+	LeaseTime time.Duration
+	T1        time.Duration
+	T2        time.Duration
+	Packet    []byte
+}
+
+// Save writes the lease for iface to path.
+func (l *Lease4) Save(path, iface string) error {
+	p, err := l.Packet.P.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(&leaseFile{
+		Interface: iface,
+		Acquired:  l.Acquired,
+		LeaseTime: l.LeaseTime,
+		T1:        l.T1,
+		T2:        l.T2,
+		Packet:    p,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write a new file and rename it, so that a crash cannot leave a
+	// partial lease behind.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadLease4 reads a lease saved with Save, and the interface it is for.
+func LoadLease4(path string) (*Lease4, string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var f leaseFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, "", fmt.Errorf("lease file %s: %v", path, err)
+	}
+	p, err := dhcp4.ParsePacket(f.Packet)
+	if err != nil {
+		return nil, "", fmt.Errorf("lease file %s: %v", path, err)
+	}
+	return &Lease4{
+		Packet:    NewPacket4(p),
+		Acquired:  f.Acquired,
+		LeaseTime: f.LeaseTime,
+		T1:        f.T1,
+		T2:        f.T2,
+	}, f.Interface, nil
+}
+
+// newPacket returns a new client packet of type typ from mac.
+func newPacket(mac net.HardwareAddr, typ dhcp4opts.DHCPMessageType) *dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootRequest)
+	rand.Read(p.TransactionID[:])
+	p.CHAddr = mac
+	p.Options.Add(dhcp4.OptionDHCPMessageType, typ)
+	return p
+}
+
+// RenewPacket returns a DHCPREQUEST that extends l, RFC 2131 Section 4.3.2.
+// In the RENEWING state, it is unicast to the server that granted l; in the
+// REBINDING state, it is broadcast to any server.
+func RenewPacket(mac net.HardwareAddr, l *Lease4, state LeaseState) *dhcp4.Packet {
+	p := newPacket(mac, dhcp4opts.DHCPRequest)
+	p.CIAddr = l.IP()
+	p.Broadcast = state == StateRebinding
+	return p
+}
+
+// InitRebootPacket returns a DHCPREQUEST that verifies the previously
+// leased ip after a reboot, RFC 2131 Section 4.3.2.
+func InitRebootPacket(mac net.HardwareAddr, ip net.IP) *dhcp4.Packet {
+	p := newPacket(mac, dhcp4opts.DHCPRequest)
+	p.Broadcast = true
+	p.Options.Add(dhcp4.OptionRequestedIPAddress, dhcp4opts.IP(ip))
+	return p
+}
+
+// ReleasePacket returns a DHCPRELEASE that gives up l, RFC 2131 Section
+// 4.4.6.
+func ReleasePacket(mac net.HardwareAddr, l *Lease4) *dhcp4.Packet {
+	p := newPacket(mac, dhcp4opts.DHCPRelease)
+	p.CIAddr = l.IP()
+	p.Options.Add(dhcp4.OptionServerIdentifier, dhcp4opts.IP(l.ServerID()))
+	return p
+}
+
+// Client4 maintains a DHCPv4 lease on an interface: it obtains a lease,
+// renews and rebinds it as the server's timers demand, and releases it when
+// done.
+type Client4 struct {
+	iface  netlink.Link
+	client *dhcp4client.Client
+
+	// LeaseFile is where the lease is persisted, so that a later run can
+	// ask for the same address again. Empty disables persistence.
+	LeaseFile string
+
+	// DefaultLeaseTime is used if the server sends no lease time.
+	DefaultLeaseTime time.Duration
+
+	// Renewals is the number of renewals after which Run returns. -1
+	// means infinity.
+	Renewals int
+
+	// RenewAfter, if not zero, is how long after the lease is acquired
+	// it is renewed, instead of the server's T1. It is at most T2.
+	RenewAfter time.Duration
+
+	lease *Lease4
+}
+
+// NewClient4 returns a client for iface that uses client to talk to servers.
+func NewClient4(iface netlink.Link, client *dhcp4client.Client) *Client4 {
+	return &Client4{
+		iface:            iface,
+		client:           client,
+		DefaultLeaseTime: DefaultLeaseTime,
+	}
+}
+
+// Lease returns the current lease, or nil if there is none.
+func (c *Client4) Lease() *Lease4 {
+	return c.lease
+}
+
+// mac returns the interface's hardware address.
+func (c *Client4) mac() net.HardwareAddr {
+	return c.iface.Attrs().HardwareAddr
+}
+
+// server returns the address of the server that granted the lease.
+func (c *Client4) server() *net.UDPAddr {
+	return &net.UDPAddr{IP: c.lease.ServerID(), Port: dhcp4client.ServerPort}
+}
+
+// acquire obtains a new lease: first by asking for the address of the
+// persisted lease (INIT-REBOOT), then by discovering servers (INIT).
+func (c *Client4) acquire(ctx context.Context) (*dhcp4.Packet, error) {
+	if c.LeaseFile != "" {
+		old, iface, err := LoadLease4(c.LeaseFile)
+		if err == nil && iface == c.iface.Attrs().Name && old.State(time.Now()) != StateExpired {
+			ack, err := sendAndRead4(ctx, c.client, dhcp4client.DefaultServers, InitRebootPacket(c.mac(), old.IP()), dhcp4opts.DHCPACK)
+			if err == nil {
+				return ack, nil
+			}
+			log.Printf("%s: INIT-REBOOT with %v: %v", c.iface.Attrs().Name, old.IP(), err)
+		}
+	}
+
+	offer, err := sendAndRead4(ctx, c.client, dhcp4client.DefaultServers, c.client.DiscoverPacket(), dhcp4opts.DHCPOffer)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %v", err)
+	}
+	return sendAndRead4(ctx, c.client, dhcp4client.DefaultServers, c.client.RequestPacket(offer), dhcp4opts.DHCPACK)
+}
+
+// bind configures the interface with the lease granted by ack.
+func (c *Client4) bind(ack *dhcp4.Packet) error {
+	c.lease = NewLease4(ack, time.Now(), c.DefaultLeaseTime)
+	if c.RenewAfter > 0 {
+		c.lease.T1 = c.RenewAfter
+		if c.lease.T1 > c.lease.T2 {
+			c.lease.T1 = c.lease.T2
+		}
+	}
+	if err := Configure4(c.iface, ack); err != nil {
+		return err
+	}
+	if c.LeaseFile != "" {
+		if err := c.lease.Save(c.LeaseFile, c.iface.Attrs().Name); err != nil {
+			log.Printf("%s: saving lease: %v", c.iface.Attrs().Name, err)
+		}
+	}
+	return nil
+}
+
+// unbind removes the leased address from the interface.
+func (c *Client4) unbind() {
+	addr := &netlink.Addr{IPNet: c.lease.Packet.Lease()}
+	if err := netlink.AddrDel(c.iface, addr); err != nil {
+		log.Printf("%s: removing %v: %v", c.iface.Attrs().Name, addr, err)
+	}
+	if c.LeaseFile != "" {
+		os.Remove(c.LeaseFile)
+	}
+	c.lease = nil
+}
+
+// renew tries to extend the lease until its state changes.
+func (c *Client4) renew(ctx context.Context, state LeaseState) (*dhcp4.Packet, error) {
+	dest := dhcp4client.DefaultServers
+	if state == StateRenewing {
+		dest = c.server()
+	}
+	ack, err := sendAndRead4(ctx, c.client, dest, RenewPacket(c.mac(), c.lease, state), dhcp4opts.DHCPACK)
+	if err == nil || err == errNAK || ctx.Err() != nil {
+		return ack, err
+	}
+	log.Printf("%s: %v: %v", c.iface.Attrs().Name, state, err)
+
+	return nil, sleep(ctx, retryWait(c.lease.NextEvent(time.Now())))
+}
+
+// retryWait returns how long to wait before trying to extend a lease again
+// whose state changes at next: half the time left, but not more often than
+// every minute, RFC 2131 Section 4.4.5.
+func retryWait(next time.Time) time.Duration {
+	left := time.Until(next)
+	wait := left / 2
+	if wait < minRetransmit {
+		wait = minRetransmit
+	}
+	if wait > left {
+		wait = left
+	}
+	return wait
+}
+
+// sleep waits for d, or until ctx is canceled.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run obtains a lease and keeps it until c.Renewals renewals are done or
+// ctx is canceled. If ctx is canceled, the lease is released.
+func (c *Client4) Run(ctx context.Context) error {
+	name := c.iface.Attrs().Name
+	for renewals := 0; ; {
+		if c.lease == nil {
+			ack, err := c.acquire(ctx)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if err := c.bind(ack); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			log.Printf("%s: bound to %v until %v", name, c.lease.IP(), c.lease.Expiry().Format(time.RFC3339))
+		}
+		if c.Renewals >= 0 && renewals >= c.Renewals {
+			return nil
+		}
+
+		state := c.lease.State(time.Now())
+		var err error
+		switch state {
+		case StateBound:
+			err = sleep(ctx, time.Until(c.lease.NextEvent(time.Now())))
+
+		case StateRenewing, StateRebinding:
+			var ack *dhcp4.Packet
+			ack, err = c.renew(ctx, state)
+			if ack != nil {
+				if err = c.bind(ack); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				renewals++
+			} else if err == errNAK {
+				// The address is no longer ours; start over.
+				log.Printf("%s: server refused to extend the lease on %v", name, c.lease.IP())
+				c.unbind()
+				err = nil
+			}
+
+		case StateExpired:
+			log.Printf("%s: lease on %v expired", name, c.lease.IP())
+			c.unbind()
+		}
+		if ctx.Err() != nil {
+			return c.Release()
+		}
+		if err != nil && err != ctx.Err() {
+			log.Printf("%s: %v: %v", name, state, err)
+		}
+	}
+}
+
+// Release gives the lease back to the server and removes the address from
+// the interface.
+func (c *Client4) Release() error {
+	if c.lease == nil {
+		return nil
+	}
+	b, err := ReleasePacket(c.mac(), c.lease).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// The address is still configured, so an ordinary socket will do.
+	// No reply is expected.
+	laddr := &net.UDPAddr{IP: c.lease.IP(), Port: dhcp4client.ClientPort}
+	conn, err := net.DialUDP("udp4", laddr, c.server())
+	if err != nil {
+		laddr.Port = 0
+		if conn, err = net.DialUDP("udp4", laddr, c.server()); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write(b)
+	conn.Close()
+
+	c.unbind()
+	return err
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4opts"
+)
+
+func ack(opts map[dhcp4.OptionCode]uint32) *dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootReply)
+	p.YIAddr = net.IP{192, 168, 0, 10}
+	p.Options.Add(dhcp4.OptionDHCPMessageType, dhcp4opts.DHCPACK)
+	p.Options.Add(dhcp4.OptionServerIdentifier, dhcp4opts.IP(net.IP{192, 168, 0, 1}))
+	for code, v := range opts {
+		p.Options.Add(code, dhcp4opts.Uint32(v))
+	}
+	return p
+}
+
+func TestNewLease4(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		opts          map[dhcp4.OptionCode]uint32
+		lease, t1, t2 time.Duration
+	}{
+		{
+			name:  "no timers",
+			lease: DefaultLeaseTime,
+			t1:    DefaultLeaseTime / 2,
+			t2:    DefaultLeaseTime * 7 / 8,
+		},
+		{
+			name: "lease time",
+			opts: map[dhcp4.OptionCode]uint32{
+				dhcp4.OptionIPAddressLeaseTime: 800,
+			},
+			lease: 800 * time.Second,
+			t1:    400 * time.Second,
+			t2:    700 * time.Second,
+		},
+		{
+			name: "all timers",
+			opts: map[dhcp4.OptionCode]uint32{
+				dhcp4.OptionIPAddressLeaseTime: 800,
+				dhcp4.OptionRenewalTimeValue:   100,
+				dhcp4.OptionRebindingTimeValue: 200,
+			},
+			lease: 800 * time.Second,
+			t1:    100 * time.Second,
+			t2:    200 * time.Second,
+		},
+		{
+			name: "bogus timers",
+			opts: map[dhcp4.OptionCode]uint32{
+				dhcp4.OptionIPAddressLeaseTime: 800,
+				dhcp4.OptionRenewalTimeValue:   900,
+				dhcp4.OptionRebindingTimeValue: 1000,
+			},
+			lease: 800 * time.Second,
+			t1:    400 * time.Second,
+			t2:    700 * time.Second,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLease4(ack(tt.opts), time.Now(), DefaultLeaseTime)
+			if l.LeaseTime != tt.lease || l.T1 != tt.t1 || l.T2 != tt.t2 {
+				t.Errorf("NewLease4() timers = %v, %v, %v, want %v, %v, %v", l.LeaseTime, l.T1, l.T2, tt.lease, tt.t1, tt.t2)
+			}
+		})
+	}
+}
+
+func TestLease4State(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLease4(ack(map[dhcp4.OptionCode]uint32{
+		dhcp4.OptionIPAddressLeaseTime: 800,
+	}), start, DefaultLeaseTime)
+
+	for _, tt := range []struct {
+		after time.Duration
+		state LeaseState
+		next  time.Duration
+	}{
+		{0, StateBound, 400 * time.Second},
+		{399 * time.Second, StateBound, 400 * time.Second},
+		{400 * time.Second, StateRenewing, 700 * time.Second},
+		{700 * time.Second, StateRebinding, 800 * time.Second},
+		{800 * time.Second, StateExpired, 0},
+	} {
+		now := start.Add(tt.after)
+		if got := l.State(now); got != tt.state {
+			t.Errorf("State(+%v) = %v, want %v", tt.after, got, tt.state)
+		}
+		want := start.Add(tt.next)
+		if tt.next == 0 {
+			want = time.Time{}
+		}
+		if got := l.NextEvent(now); !got.Equal(want) {
+			t.Errorf("NextEvent(+%v) = %v, want %v", tt.after, got, want)
+		}
+	}
+}
+
+func TestLease4SaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "leases", "eth0.lease")
+	l := NewLease4(ack(map[dhcp4.OptionCode]uint32{
+		dhcp4.OptionIPAddressLeaseTime: 800,
+	}), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), DefaultLeaseTime)
+	if err := l.Save(path, "eth0"); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	got, iface, err := LoadLease4(path)
+	if err != nil {
+		t.Fatalf("LoadLease4() = %v", err)
+	}
+	if iface != "eth0" {
+		t.Errorf("LoadLease4() interface = %q, want eth0", iface)
+	}
+	if !got.Acquired.Equal(l.Acquired) || got.LeaseTime != l.LeaseTime || got.T1 != l.T1 || got.T2 != l.T2 {
+		t.Errorf("LoadLease4() = %+v, want %+v", got, l)
+	}
+	if !got.IP().Equal(l.IP()) || !got.ServerID().Equal(l.ServerID()) {
+		t.Errorf("LoadLease4() = %v from %v, want %v from %v", got.IP(), got.ServerID(), l.IP(), l.ServerID())
+	}
+}
+
+func TestLease4Packets(t *testing.T) {
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	l := NewLease4(ack(nil), time.Now(), DefaultLeaseTime)
+
+	msgType := func(p *dhcp4.Packet) dhcp4opts.DHCPMessageType {
+		return dhcp4opts.GetDHCPMessageType(p.Options)
+	}
+
+	renew := RenewPacket(mac, l, StateRenewing)
+	if msgType(renew) != dhcp4opts.DHCPRequest || !renew.CIAddr.Equal(l.IP()) || renew.Broadcast {
+		t.Errorf("RenewPacket(RENEWING) = %+v, want unicast request from %v", renew, l.IP())
+	}
+	if renew.Options.Get(dhcp4.OptionServerIdentifier) != nil || renew.Options.Get(dhcp4.OptionRequestedIPAddress) != nil {
+		t.Errorf("RenewPacket(RENEWING) has server identifier or requested IP: %v", renew.Options)
+	}
+	if rebind := RenewPacket(mac, l, StateRebinding); !rebind.Broadcast {
+		t.Errorf("RenewPacket(REBINDING) = %+v, want broadcast", rebind)
+	}
+
+	reboot := InitRebootPacket(mac, l.IP())
+	if msgType(reboot) != dhcp4opts.DHCPRequest || reboot.CIAddr != nil {
+		t.Errorf("InitRebootPacket() = %+v, want request without client address", reboot)
+	}
+	if got := reboot.Options.Get(dhcp4.OptionRequestedIPAddress); !reflect.DeepEqual(got, []byte(l.IP().To4())) {
+		t.Errorf("InitRebootPacket() requested IP = %v, want %v", got, l.IP())
+	}
+
+	release := ReleasePacket(mac, l)
+	if msgType(release) != dhcp4opts.DHCPRelease || !release.CIAddr.Equal(l.IP()) {
+		t.Errorf("ReleasePacket() = %+v, want release of %v", release, l.IP())
+	}
+	if got := release.Options.Get(dhcp4.OptionServerIdentifier); !reflect.DeepEqual(got, []byte(l.ServerID().To4())) {
+		t.Errorf("ReleasePacket() server identifier = %v, want %v", got, l.ServerID())
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mdlayher/dhcp6"
+	"github.com/mdlayher/dhcp6/dhcp6opts"
+	"github.com/u-root/u-root/pkg/dhcp6client"
+	"github.com/vishvananda/netlink"
+)
+
+// releaseTimeout is how long to wait for the server to confirm a DHCPv6
+// release.
+const releaseTimeout = 5 * time.Second
+
+// routerTimeout is how long to wait for a router advertisement before
+// going on without one. Routers answer solicitations within half a
+// second, RFC 4861 Section 6.2.6.
+const routerTimeout = 4 * time.Second
+
+// State6 returns the state of the DHCPv6 lease l at now.
+func State6(l *dhcp6client.Lease, now time.Time) LeaseState {
+	return state6(l, l.T1(), now)
+}
+
+// state6 returns the state of l at now if renewing starts t1 after it was
+// acquired.
+func state6(l *dhcp6client.Lease, t1 time.Duration, now time.Time) LeaseState {
+	switch t := now.Sub(l.Acquired); {
+	case t < t1:
+		return StateBound
+	case t < l.T2():
+		return StateRenewing
+	case now.Before(l.Expiry()):
+		return StateRebinding
+	}
+	return StateExpired
+}
+
+// nextEvent6 returns when l enters its next state if renewing starts t1
+// after it was acquired.
+func nextEvent6(l *dhcp6client.Lease, t1 time.Duration, now time.Time) time.Time {
+	switch state6(l, t1, now) {
+	case StateBound:
+		return l.Acquired.Add(t1)
+	case StateRenewing:
+		return l.Acquired.Add(l.T2())
+	}
+	return l.Expiry()
+}
+
+// Prefixes6 returns the prefixes delegated by the DHCPv6 lease l.
+func Prefixes6(l *dhcp6client.Lease) []*net.IPNet {
+	var prefixes []*net.IPNet
+	for _, p := range l.Prefixes() {
+		prefixes = append(prefixes, &net.IPNet{
+			IP:   p.Prefix,
+			Mask: net.CIDRMask(int(p.PrefixLength), 128),
+		})
+	}
+	return prefixes
+}
+
+// Client6 maintains the DHCPv6 configuration of an interface.
+//
+// It follows the router advertisements on the link: if they have the M
+// flag, or if there are none, addresses are assigned with DHCPv6 and
+// renewed and rebound as the server's timers demand. Otherwise, the kernel
+// configures addresses with SLAAC, and only DNS servers are requested with
+// an Information-Request if the O flag is set.
+type Client6 struct {
+	iface  netlink.Link
+	client *dhcp6client.Client
+
+	// PD requests a delegated prefix, RFC 3633, even if the router
+	// advertisements do not call for DHCPv6.
+	PD bool
+
+	// Delegated, if not nil, is called with the delegated prefixes every
+	// time a lease with some is bound, for the caller to assign them to
+	// its downstream links.
+	Delegated func(prefixes []*net.IPNet)
+
+	// Renewals is the number of renewals after which Run returns. -1
+	// means infinity.
+	Renewals int
+
+	// RenewAfter, if not zero, is how long after the lease is acquired
+	// it is renewed, instead of the server's T1. It is at most T2.
+	RenewAfter time.Duration
+
+	// RA is the router advertisement received, if any.
+	RA *dhcp6client.RouterAdvertisement
+
+	lease *dhcp6client.Lease
+}
+
+// NewClient6 returns a client for iface that uses client to talk to servers.
+func NewClient6(iface netlink.Link, client *dhcp6client.Client) *Client6 {
+	return &Client6{
+		iface:  iface,
+		client: client,
+	}
+}
+
+// Lease returns the current lease, or nil if there is none.
+func (c *Client6) Lease() *dhcp6client.Lease {
+	return c.lease
+}
+
+// t1 returns how long after the lease is acquired it is renewed.
+func (c *Client6) t1() time.Duration {
+	t1 := c.lease.T1()
+	if c.RenewAfter > 0 {
+		t1 = c.RenewAfter
+		if t2 := c.lease.T2(); t1 > t2 {
+			t1 = t2
+		}
+	}
+	return t1
+}
+
+// writeDNS writes the DNS servers in p, if there are any.
+func writeDNS(p *dhcp6.Packet) error {
+	ips, err := dhcp6opts.GetDNSServers(p.Options)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	return WriteDNSSettings([]net.IP(ips))
+}
+
+// stateless configures DNS servers without DHCPv6 addresses.
+func (c *Client6) stateless(ctx context.Context) error {
+	if c.RA.OtherConfig {
+		reply, err := c.client.InformationRequest(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: information request: %v", c.iface.Attrs().Name, err)
+		}
+		return writeDNS(reply)
+	}
+	if len(c.RA.DNS) > 0 {
+		return WriteDNSSettings(c.RA.DNS)
+	}
+	return nil
+}
+
+// bind configures the interface with l.
+func (c *Client6) bind(l *dhcp6client.Lease) error {
+	c.lease = l
+	for _, a := range l.Addresses() {
+		if err := addAddr6(c.iface, a); err != nil {
+			return err
+		}
+	}
+	if prefixes := Prefixes6(l); len(prefixes) > 0 && c.Delegated != nil {
+		c.Delegated(prefixes)
+	}
+	return writeDNS(l.Reply)
+}
+
+// unbind removes the leased addresses from the interface.
+func (c *Client6) unbind() {
+	addrs, err := netlink.AddrList(c.iface, netlink.FAMILY_V6)
+	if err != nil {
+		log.Printf("%s: %v", c.iface.Attrs().Name, err)
+	}
+	for _, a := range c.lease.Addresses() {
+		for _, addr := range addrs {
+			if !addr.IP.Equal(a.IP) {
+				continue
+			}
+			if err := netlink.AddrDel(c.iface, &addr); err != nil {
+				log.Printf("%s: removing %v: %v", c.iface.Attrs().Name, addr, err)
+			}
+		}
+	}
+	c.lease = nil
+}
+
+// Run configures the interface, and keeps a DHCPv6 lease until c.Renewals
+// renewals are done or ctx is canceled. If ctx is canceled, the lease is
+// released.
+func (c *Client6) Run(ctx context.Context) error {
+	name := c.iface.Attrs().Name
+	rctx, cancel := context.WithTimeout(ctx, routerTimeout)
+	ra, err := c.client.SolicitRouter(rctx)
+	cancel()
+	if err != nil {
+		log.Printf("%s: no router advertisement (%v), trying DHCPv6", name, err)
+	} else {
+		c.RA = ra
+	}
+	na := c.RA == nil || c.RA.Managed
+	if !na && !c.PD {
+		return c.stateless(ctx)
+	}
+
+	for renewals := 0; ; {
+		if c.lease == nil {
+			l, err := c.client.SolicitLease(ctx, na, c.PD)
+			if err != nil {
+				return fmt.Errorf("%s: solicit: %v", name, err)
+			}
+			if err := c.bind(l); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			log.Printf("%s: bound until %v", name, l.Expiry().Format(time.RFC3339))
+		}
+		if c.Renewals >= 0 && renewals >= c.Renewals {
+			return nil
+		}
+
+		switch state := state6(c.lease, c.t1(), time.Now()); state {
+		case StateBound:
+			sleep(ctx, time.Until(nextEvent6(c.lease, c.t1(), time.Now())))
+
+		case StateRenewing, StateRebinding:
+			var l *dhcp6client.Lease
+			var err error
+			if state == StateRenewing {
+				l, err = c.client.Renew(ctx, c.lease)
+			} else {
+				l, err = c.client.Rebind(ctx, c.lease)
+			}
+			if err == nil {
+				if err := c.bind(l); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				renewals++
+			} else if ctx.Err() == nil {
+				log.Printf("%s: %v: %v", name, state, err)
+				sleep(ctx, retryWait(nextEvent6(c.lease, c.t1(), time.Now())))
+			}
+
+		case StateExpired:
+			log.Printf("%s: DHCPv6 lease expired", name)
+			c.unbind()
+		}
+		if ctx.Err() != nil {
+			return c.Release()
+		}
+	}
+}
+
+// Release gives the lease back to the server and removes the addresses
+// from the interface.
+func (c *Client6) Release() error {
+	if c.lease == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	err := c.client.Release(ctx, c.lease)
+	c.unbind()
+	return err
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/u-root/dhcp4"
+	"github.com/u-root/dhcp4/dhcp4opts"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeRequester gets a DHCPv4 lease at once on the links in now, and on the
+// links in late only once the request is canceled. Links in isUp are up
+// already; requests on other links fail.
+type fakeRequester struct {
+	now, late, isUp map[string]bool
+
+	mu       sync.Mutex
+	released []string
+	downed   []string
+}
+
+func (f *fakeRequester) up(name string) (netlink.Link, bool, error) {
+	return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}, f.isUp[name], nil
+}
+
+func (f *fakeRequester) down(link netlink.Link) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.downed = append(f.downed, link.Attrs().Name)
+	return nil
+}
+
+func (f *fakeRequester) request4(ctx context.Context, iface netlink.Link, c Config) (*Lease, error) {
+	name := iface.Attrs().Name
+	switch {
+	case f.now[name]:
+	case f.late[name]:
+		<-ctx.Done()
+	default:
+		return nil, errors.New("no server")
+	}
+	return &Lease{Link: iface, P4: NewPacket4(ack(nil))}, nil
+}
+
+func (f *fakeRequester) request6(ctx context.Context, iface netlink.Link, c Config) (*Lease, error) {
+	return nil, errors.New("no server")
+}
+
+func (f *fakeRequester) release(l *Lease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released = append(f.released, l.Link.Attrs().Name)
+	return nil
+}
+
+func links(names ...string) []netlink.Link {
+	var ls []netlink.Link
+	for _, n := range names {
+		ls = append(ls, &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: n}})
+	}
+	return ls
+}
+
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+func TestRequestAny(t *testing.T) {
+	f := &fakeRequester{
+		now:  set("eth0"),
+		late: set("eth1", "eth2"),
+		isUp: set("eth2"),
+	}
+	l, err := requestAny(context.Background(), links("eth0", "eth1", "eth2", "eth3"), Config{IPv4: true, IPv6: true}, f)
+	if err != nil {
+		t.Fatalf("requestAny() = %v", err)
+	}
+	if l.Link.Attrs().Name != "eth0" {
+		t.Errorf("requestAny() = %v, want the lease on eth0", l)
+	}
+	sort.Strings(f.released)
+	if want := []string{"eth1", "eth2"}; !reflect.DeepEqual(f.released, want) {
+		t.Errorf("released leases on %v, want %v", f.released, want)
+	}
+	// eth0 has the lease and eth2 was up.
+	sort.Strings(f.downed)
+	if want := []string{"eth1", "eth3"}; !reflect.DeepEqual(f.downed, want) {
+		t.Errorf("brought %v down, want %v", f.downed, want)
+	}
+}
+
+func TestRequestAnyNeedBoot(t *testing.T) {
+	f := &fakeRequester{now: set("eth0")}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if l, err := requestAny(ctx, links("eth0", "eth1"), Config{IPv4: true, NeedBoot: true}, f); err == nil {
+		t.Fatalf("requestAny() = %v, want error for a lease without boot file", l)
+	}
+	if want := []string{"eth0"}; !reflect.DeepEqual(f.released, want) {
+		t.Errorf("released leases on %v, want %v", f.released, want)
+	}
+	sort.Strings(f.downed)
+	if want := []string{"eth0", "eth1"}; !reflect.DeepEqual(f.downed, want) {
+		t.Errorf("brought %v down, want %v", f.downed, want)
+	}
+}
+
+// packetConn records the packets written to it.
+type packetConn struct {
+	net.PacketConn
+	b    []byte
+	addr net.Addr
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.b, c.addr = append([]byte(nil), b...), addr
+	return len(b), nil
+}
+
+func TestRelease4(t *testing.T) {
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	l := &Lease{
+		Link: &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", HardwareAddr: mac}},
+		P4:   NewPacket4(ack(nil)),
+	}
+	var conn packetConn
+	if err := release4(&conn, l); err != nil {
+		t.Fatalf("release4() = %v", err)
+	}
+	if got := conn.addr.String(); got != "192.168.0.1:67" {
+		t.Errorf("release sent to %s, want the server at 192.168.0.1:67", got)
+	}
+	p, err := dhcp4.ParsePacket(conn.b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dhcp4opts.GetDHCPMessageType(p.Options) != dhcp4opts.DHCPRelease || !p.CIAddr.Equal(net.IP{192, 168, 0, 10}) || p.CHAddr.String() != mac.String() {
+		t.Errorf("release4() sent %+v, want a release of 192.168.0.10 from %v", p, mac)
+	}
+}
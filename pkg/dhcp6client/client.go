@@ -49,7 +49,7 @@ var (
 //
 //  c, err := dhcp6client.New(iface)
 //  ...
-//  iana, packet, err := c.RapidSolicit()
+//  iana, packet, err := c.RapidSolicit(context.Background())
 //  ...
 //  // iana now contains the IP assigned in the IAAddr option.
 //
@@ -66,7 +66,7 @@ var (
 //   // Selecting the advertisement of server 3.
 //   request, err := dhcp6client.RequestIANAFrom(ads[2])
 //   ...
-//   iana, packet, err := c.RequestOne(request)
+//   iana, packet, err := c.RequestOne(ctx, request)
 //   ...
 //   // iana now contains the IP assigned in the IAAddr option.
 type Client struct {
@@ -116,6 +116,11 @@ func New(iface netlink.Link, opts ...ClientOpt) (*Client, error) {
 	return c, nil
 }
 
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
 // ClientOpt is a function that configures the client.
 type ClientOpt func(*Client) error
 
@@ -147,18 +152,19 @@ func WithRetry(retry int) ClientOpt {
 // DHCPv6 solicitation message with the rapid commit option.
 //
 // RapidSolicit returns the first valid, suitable response by any remote server.
-func (c *Client) RapidSolicit() (*dhcp6opts.IANA, *dhcp6.Packet, error) {
+// It gives up early if ctx is canceled.
+func (c *Client) RapidSolicit(ctx context.Context) (*dhcp6opts.IANA, *dhcp6.Packet, error) {
 	solicit, err := NewRapidSolicit(c.iface.Attrs().HardwareAddr)
 	if err != nil {
 		return nil, nil, err
 	}
-	return c.RequestOne(solicit)
+	return c.RequestOne(ctx, solicit)
 }
 
 // RequestOne multicasts the `request` and returns the first matching IANA and
 // its associated Packet returned by any server.
-func (c *Client) RequestOne(request *dhcp6.Packet) (*dhcp6opts.IANA, *dhcp6.Packet, error) {
-	ianas, pkt, err := c.Request(request)
+func (c *Client) RequestOne(ctx context.Context, request *dhcp6.Packet) (*dhcp6opts.IANA, *dhcp6.Packet, error) {
+	ianas, pkt, err := c.Request(ctx, request)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -217,7 +223,9 @@ func (e errorList) Error() string {
 //
 // This request message may be any DHCPv6 request message type; e.g. a
 // Solicit with the Rapid Commit option or a Rebind message.
-func (c *Client) Request(request *dhcp6.Packet) ([]*dhcp6opts.IANA, *dhcp6.Packet, error) {
+//
+// Request gives up early if ctx is canceled.
+func (c *Client) Request(ctx context.Context, request *dhcp6.Packet) ([]*dhcp6opts.IANA, *dhcp6.Packet, error) {
 	errs := newManyErrs()
 
 	// These are the IANAs we are looking for in responses.
@@ -226,7 +234,7 @@ func (c *Client) Request(request *dhcp6.Packet) ([]*dhcp6opts.IANA, *dhcp6.Packe
 		return nil, nil, fmt.Errorf("request packet contains no IANAs: %v", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	wg, out, errCh := c.SimpleSendAndRead(ctx, DefaultServers, request)
 	// Explicitly cancel the goroutine first, then wait.
 	defer func() {
@@ -253,6 +261,110 @@ func (c *Client) Request(request *dhcp6.Packet) ([]*dhcp6opts.IANA, *dhcp6.Packe
 	return nil, nil, errs
 }
 
+// reply multicasts request and returns the first reply accepted by accept.
+func (c *Client) reply(ctx context.Context, request *dhcp6.Packet, accept func(*dhcp6.Packet) error) (*dhcp6.Packet, error) {
+	errs := newManyErrs()
+
+	ctx, cancel := context.WithCancel(ctx)
+	wg, out, errCh := c.SimpleSendAndRead(ctx, DefaultServers, request)
+	// Explicitly cancel the goroutine first, then wait.
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for packet := range out {
+		if err := accept(packet.Packet); err != nil {
+			errs.add(err)
+		} else {
+			return packet.Packet, nil
+		}
+	}
+
+	if err, ok := <-errCh; ok && err != nil {
+		errs.add(err)
+	}
+	errs.add(fmt.Errorf("no suitable responses"))
+	return nil, errs
+}
+
+// RequestLease multicasts request and returns the lease granted by the first
+// suitable reply of any server.
+func (c *Client) RequestLease(ctx context.Context, request *dhcp6.Packet) (*Lease, error) {
+	var l *Lease
+	_, err := c.reply(ctx, request, func(p *dhcp6.Packet) error {
+		var err error
+		l, err = NewLease(p, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SolicitLease solicits a non-temporary address if na is set and a
+// delegated prefix if pd is set, with the rapid commit option.
+func (c *Client) SolicitLease(ctx context.Context, na, pd bool) (*Lease, error) {
+	solicit, err := NewRapidSolicitIAs(c.iface.Attrs().HardwareAddr, na, pd)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestLease(ctx, solicit)
+}
+
+// Renew extends l with the server that granted it.
+func (c *Client) Renew(ctx context.Context, l *Lease) (*Lease, error) {
+	renew, err := NewRenewPacket(l)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestLease(ctx, renew)
+}
+
+// Rebind extends l with any server.
+func (c *Client) Rebind(ctx context.Context, l *Lease) (*Lease, error) {
+	rebind, err := NewRebindPacket(l)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestLease(ctx, rebind)
+}
+
+// Release gives l back to the server that granted it.
+func (c *Client) Release(ctx context.Context, l *Lease) error {
+	release, err := NewReleasePacket(l)
+	if err != nil {
+		return err
+	}
+	_, err = c.reply(ctx, release, func(p *dhcp6.Packet) error {
+		if p.MessageType != dhcp6.MessageTypeReply {
+			return fmt.Errorf("got DHCP message of type %s, wanted %s", p.MessageType, dhcp6.MessageTypeReply)
+		}
+		return nil
+	})
+	return err
+}
+
+// InformationRequest asks for configuration without addresses, such as DNS
+// servers, and returns the first reply of any server. This is what clients
+// do when router advertisements have the O flag but not the M flag.
+func (c *Client) InformationRequest(ctx context.Context) (*dhcp6.Packet, error) {
+	request, err := NewInformationRequest(c.iface.Attrs().HardwareAddr)
+	if err != nil {
+		return nil, err
+	}
+	return c.reply(ctx, request, func(p *dhcp6.Packet) error {
+		if p.MessageType != dhcp6.MessageTypeReply {
+			return fmt.Errorf("got DHCP message of type %s, wanted %s", p.MessageType, dhcp6.MessageTypeReply)
+		}
+		if status, err := dhcp6opts.GetStatusCode(p.Options); err == nil && status.Code != dhcp6.StatusSuccess {
+			return fmt.Errorf("packet has status %s: %s", status.Code, status.Message)
+		}
+		return nil
+	})
+}
+
 // ClientPacket is a DHCP packet and the interface it corresponds to.
 type ClientPacket struct {
 	Interface netlink.Link
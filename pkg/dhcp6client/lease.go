@@ -0,0 +1,166 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mdlayher/dhcp6"
+	"github.com/mdlayher/dhcp6/dhcp6opts"
+)
+
+// Lease is the set of addresses and prefixes granted by a server's Reply.
+type Lease struct {
+	// Reply is the server's Reply.
+	Reply *dhcp6.Packet
+
+	// IANAs are the non-temporary address IAs that were granted.
+	IANAs []*dhcp6opts.IANA
+
+	// IAPDs are the prefix delegation IAs that were granted.
+	IAPDs []*dhcp6opts.IAPD
+
+	// Acquired is when the lease was granted or last extended.
+	Acquired time.Time
+}
+
+// NewLease returns the lease granted by reply at acquired, RFC 3315 Section
+// 18.1.8.
+//
+// Only IAs with a successful status and at least one address or prefix
+// count. It is an error if there are none.
+func NewLease(reply *dhcp6.Packet, acquired time.Time) (*Lease, error) {
+	if reply.MessageType != dhcp6.MessageTypeReply {
+		return nil, fmt.Errorf("got DHCP message of type %s, wanted %s", reply.MessageType, dhcp6.MessageTypeReply)
+	}
+	if status, err := dhcp6opts.GetStatusCode(reply.Options); err == nil && status.Code != dhcp6.StatusSuccess {
+		return nil, fmt.Errorf("packet has status %s: %s", status.Code, status.Message)
+	}
+
+	l := &Lease{
+		Reply:    reply,
+		Acquired: acquired,
+	}
+	ianas, _ := dhcp6opts.GetIANA(reply.Options)
+	for _, iana := range ianas {
+		if status, err := dhcp6opts.GetStatusCode(iana.Options); err == nil && status.Code != dhcp6.StatusSuccess {
+			continue
+		}
+		if addrs, err := dhcp6opts.GetIAAddr(iana.Options); err == nil && len(addrs) > 0 {
+			l.IANAs = append(l.IANAs, iana)
+		}
+	}
+	iapds, _ := dhcp6opts.GetIAPD(reply.Options)
+	for _, iapd := range iapds {
+		if status, err := dhcp6opts.GetStatusCode(iapd.Options); err == nil && status.Code != dhcp6.StatusSuccess {
+			continue
+		}
+		if prefixes, err := dhcp6opts.GetIAPrefix(iapd.Options); err == nil && len(prefixes) > 0 {
+			l.IAPDs = append(l.IAPDs, iapd)
+		}
+	}
+	if len(l.IANAs) == 0 && len(l.IAPDs) == 0 {
+		return nil, fmt.Errorf("no suitable IAs in reply")
+	}
+	return l, nil
+}
+
+// Addresses returns all addresses of the lease.
+func (l *Lease) Addresses() []*dhcp6opts.IAAddr {
+	var addrs []*dhcp6opts.IAAddr
+	for _, iana := range l.IANAs {
+		a, _ := dhcp6opts.GetIAAddr(iana.Options)
+		addrs = append(addrs, a...)
+	}
+	return addrs
+}
+
+// Prefixes returns all delegated prefixes of the lease.
+func (l *Lease) Prefixes() []*dhcp6opts.IAPrefix {
+	var prefixes []*dhcp6opts.IAPrefix
+	for _, iapd := range l.IAPDs {
+		p, _ := dhcp6opts.GetIAPrefix(iapd.Options)
+		prefixes = append(prefixes, p...)
+	}
+	return prefixes
+}
+
+// lifetimes returns the shortest preferred and the longest valid lifetime
+// of all addresses and prefixes.
+func (l *Lease) lifetimes() (preferred, valid time.Duration) {
+	update := func(p, v time.Duration) {
+		if preferred == 0 || (p > 0 && p < preferred) {
+			preferred = p
+		}
+		if v > valid {
+			valid = v
+		}
+	}
+	for _, a := range l.Addresses() {
+		update(a.PreferredLifetime, a.ValidLifetime)
+	}
+	for _, p := range l.Prefixes() {
+		update(p.PreferredLifetime, p.ValidLifetime)
+	}
+	return preferred, valid
+}
+
+// timers returns the shortest non-zero T1 and T2 of all IAs. IAs leave
+// them to the client by sending 0.
+func (l *Lease) timers() (t1, t2 time.Duration) {
+	update := func(a, b time.Duration) {
+		if a > 0 && (t1 == 0 || a < t1) {
+			t1 = a
+		}
+		if b > 0 && (t2 == 0 || b < t2) {
+			t2 = b
+		}
+	}
+	for _, iana := range l.IANAs {
+		update(iana.T1, iana.T2)
+	}
+	for _, iapd := range l.IAPDs {
+		update(iapd.T1, iapd.T2)
+	}
+	return t1, t2
+}
+
+// T1 returns how long after Acquired the lease is renewed with the server
+// that granted it. If the server leaves it to the client, it is half the
+// shortest preferred lifetime, RFC 3315 Section 22.4.
+func (l *Lease) T1() time.Duration {
+	t1, _ := l.timers()
+	if t1 == 0 {
+		preferred, _ := l.lifetimes()
+		t1 = preferred / 2
+	}
+	if t2 := l.T2(); t1 > t2 {
+		t1 = t2
+	}
+	return t1
+}
+
+// T2 returns how long after Acquired the lease is extended with any server.
+// If the server leaves it to the client, it is 80% of the shortest preferred
+// lifetime, RFC 3315 Section 22.4.
+func (l *Lease) T2() time.Duration {
+	_, t2 := l.timers()
+	preferred, valid := l.lifetimes()
+	if t2 == 0 {
+		t2 = preferred * 4 / 5
+	}
+	if t2 > valid {
+		t2 = valid
+	}
+	return t2
+}
+
+// Expiry returns when the last address or prefix of the lease becomes
+// invalid.
+func (l *Lease) Expiry() time.Time {
+	_, valid := l.lifetimes()
+	return l.Acquired.Add(valid)
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/dhcp6"
+	"github.com/mdlayher/dhcp6/dhcp6opts"
+)
+
+var (
+	testMAC      = net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	testServerID = dhcp6opts.NewDUIDLL(6, net.HardwareAddr{6, 5, 4, 3, 2, 1})
+)
+
+// newReply returns a Reply with an address with the given lifetimes in an
+// IA_NA with t1 and t2, and optionally a delegated prefix.
+func newReply(t *testing.T, t1, t2, preferred, valid time.Duration, pd bool) *dhcp6.Packet {
+	addr, err := dhcp6opts.NewIAAddr(net.ParseIP("2001:db8::10"), preferred, valid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iana := dhcp6opts.NewIANA(IAID, t1, t2, nil)
+	if err := iana.Options.Add(dhcp6.OptionIAAddr, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := make(dhcp6.Options)
+	opts.Add(dhcp6.OptionClientID, dhcp6opts.NewDUIDLL(6, testMAC))
+	opts.Add(dhcp6.OptionServerID, testServerID)
+	opts.Add(dhcp6.OptionIANA, iana)
+	if pd {
+		prefix, err := dhcp6opts.NewIAPrefix(preferred, valid, 56, net.ParseIP("2001:db8:100::"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iapd := dhcp6opts.NewIAPD(IAID, t1, t2, nil)
+		if err := iapd.Options.Add(dhcp6.OptionIAPrefix, prefix); err != nil {
+			t.Fatal(err)
+		}
+		opts.Add(dhcp6.OptionIAPD, iapd)
+	}
+	return NewPacket(dhcp6.MessageTypeReply, opts)
+}
+
+func TestNewLease(t *testing.T) {
+	for _, tt := range []struct {
+		name                   string
+		t1, t2, pref, valid    time.Duration
+		pd                     bool
+		wantT1, wantT2, expiry time.Duration
+	}{
+		{
+			name:   "server timers",
+			t1:     100 * time.Second,
+			t2:     200 * time.Second,
+			pref:   400 * time.Second,
+			valid:  800 * time.Second,
+			wantT1: 100 * time.Second,
+			wantT2: 200 * time.Second,
+			expiry: 800 * time.Second,
+		},
+		{
+			name:   "client timers",
+			pref:   400 * time.Second,
+			valid:  800 * time.Second,
+			wantT1: 200 * time.Second,
+			wantT2: 320 * time.Second,
+			expiry: 800 * time.Second,
+		},
+		{
+			name:   "prefix delegation",
+			t1:     100 * time.Second,
+			t2:     200 * time.Second,
+			pref:   400 * time.Second,
+			valid:  800 * time.Second,
+			pd:     true,
+			wantT1: 100 * time.Second,
+			wantT2: 200 * time.Second,
+			expiry: 800 * time.Second,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			l, err := NewLease(newReply(t, tt.t1, tt.t2, tt.pref, tt.valid, tt.pd), now)
+			if err != nil {
+				t.Fatalf("NewLease() = %v", err)
+			}
+			if l.T1() != tt.wantT1 || l.T2() != tt.wantT2 {
+				t.Errorf("T1, T2 = %v, %v, want %v, %v", l.T1(), l.T2(), tt.wantT1, tt.wantT2)
+			}
+			if got, want := l.Expiry(), now.Add(tt.expiry); !got.Equal(want) {
+				t.Errorf("Expiry() = %v, want %v", got, want)
+			}
+			if got := len(l.Addresses()); got != 1 {
+				t.Errorf("Addresses() has %d addresses, want 1", got)
+			}
+			if got, want := len(l.Prefixes()), map[bool]int{false: 0, true: 1}[tt.pd]; got != want {
+				t.Errorf("Prefixes() has %d prefixes, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestNewLeaseErrors(t *testing.T) {
+	reply := newReply(t, 0, 0, time.Minute, time.Hour, false)
+	reply.MessageType = dhcp6.MessageTypeAdvertise
+	if _, err := NewLease(reply, time.Now()); err == nil {
+		t.Errorf("NewLease(Advertise) = nil, want error")
+	}
+
+	empty := NewPacket(dhcp6.MessageTypeReply, make(dhcp6.Options))
+	if _, err := NewLease(empty, time.Now()); err == nil {
+		t.Errorf("NewLease(no IAs) = nil, want error")
+	}
+}
+
+func TestLeasePackets(t *testing.T) {
+	l, err := NewLease(newReply(t, 0, 0, time.Minute, time.Hour, true), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		new        func(*Lease) (*dhcp6.Packet, error)
+		typ        dhcp6.MessageType
+		withServer bool
+	}{
+		{NewRenewPacket, dhcp6.MessageTypeRenew, true},
+		{NewRebindPacket, dhcp6.MessageTypeRebind, false},
+		{NewReleasePacket, dhcp6.MessageTypeRelease, true},
+	} {
+		p, err := tt.new(l)
+		if err != nil {
+			t.Fatalf("%s packet: %v", tt.typ, err)
+		}
+		if p.MessageType != tt.typ {
+			t.Errorf("packet type = %s, want %s", p.MessageType, tt.typ)
+		}
+		if _, err := dhcp6opts.GetClientID(p.Options); err != nil {
+			t.Errorf("%s packet has no client ID: %v", tt.typ, err)
+		}
+		if _, err := dhcp6opts.GetServerID(p.Options); (err == nil) != tt.withServer {
+			t.Errorf("%s packet server ID: %v, want server ID %t", tt.typ, err, tt.withServer)
+		}
+		if ianas, err := dhcp6opts.GetIANA(p.Options); err != nil || len(ianas) != 1 {
+			t.Errorf("%s packet IA_NAs = %v, %v, want 1", tt.typ, ianas, err)
+		}
+		if iapds, err := dhcp6opts.GetIAPD(p.Options); err != nil || len(iapds) != 1 {
+			t.Errorf("%s packet IA_PDs = %v, %v, want 1", tt.typ, iapds, err)
+		}
+	}
+
+	solicit, err := NewRapidSolicitIAs(testMAC, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dhcp6opts.GetIANA(solicit.Options); err == nil {
+		t.Errorf("NewRapidSolicitIAs(na=false) requests an address")
+	}
+	if iapds, err := dhcp6opts.GetIAPD(solicit.Options); err != nil || len(iapds) != 1 {
+		t.Errorf("NewRapidSolicitIAs(pd=true) IA_PDs = %v, %v, want 1", iapds, err)
+	}
+
+	info, err := NewInformationRequest(testMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MessageType != dhcp6.MessageTypeInformationRequest {
+		t.Errorf("NewInformationRequest() type = %s", info.MessageType)
+	}
+	if _, err := dhcp6opts.GetIANA(info.Options); err == nil {
+		t.Errorf("NewInformationRequest() requests an address")
+	}
+}
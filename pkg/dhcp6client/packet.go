@@ -47,14 +47,23 @@ func RequestIANAFrom(ad *dhcp6.Packet) (*dhcp6.Packet, error) {
 	return NewPacket(dhcp6.MessageTypeRequest, opts), nil
 }
 
+// IAID is the identifier of the IAs the client requests. The client requests
+// at most one IA of each type, so they can share it.
+//
+// TODO: This should be generated.
+var IAID = [4]byte{'r', 'o', 'o', 't'}
+
 func newRequestOptions(options dhcp6.Options) error {
-	// TODO: This should be generated.
-	id := [4]byte{'r', 'o', 'o', 't'}
-	iana := dhcp6opts.NewIANA(id, 0, 0, nil)
+	iana := dhcp6opts.NewIANA(IAID, 0, 0, nil)
 	// IANA = requesting a non-temporary address.
 	if err := options.Add(dhcp6.OptionIANA, iana); err != nil {
 		return err
 	}
+	return addCommonOptions(options)
+}
+
+// addCommonOptions adds the options every client message carries.
+func addCommonOptions(options dhcp6.Options) error {
 	if err := options.Add(dhcp6.OptionElapsedTime, dhcp6opts.ElapsedTime(0)); err != nil {
 		return err
 	}
@@ -107,6 +116,93 @@ func NewSolicitPacket(mac net.HardwareAddr) (*dhcp6.Packet, error) {
 	return NewPacket(dhcp6.MessageTypeSolicit, options), nil
 }
 
+// NewRapidSolicitIAs returns a Solicit packet with the RapidCommit option
+// that requests a non-temporary address if na is set, and a delegated prefix
+// (RFC 3633) if pd is set.
+func NewRapidSolicitIAs(mac net.HardwareAddr, na, pd bool) (*dhcp6.Packet, error) {
+	p, err := NewRapidSolicit(mac)
+	if err != nil {
+		return nil, err
+	}
+	if !na {
+		delete(p.Options, dhcp6.OptionIANA)
+	}
+	if pd {
+		if err := p.Options.Add(dhcp6.OptionIAPD, dhcp6opts.NewIAPD(IAID, 0, 0, nil)); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// NewInformationRequest returns an Information-Request packet, which asks
+// for configuration such as DNS servers without assigning addresses, RFC
+// 3315 Section 18.1.5.
+func NewInformationRequest(mac net.HardwareAddr) (*dhcp6.Packet, error) {
+	options := make(dhcp6.Options)
+	if err := options.Add(dhcp6.OptionClientID, dhcp6opts.NewDUIDLL(6, mac)); err != nil {
+		return nil, err
+	}
+	if err := addCommonOptions(options); err != nil {
+		return nil, err
+	}
+	return NewPacket(dhcp6.MessageTypeInformationRequest, options), nil
+}
+
+// newLeasePacket returns a packet of type typ for the IAs of l. If
+// withServer is set, it is addressed to the server that granted l.
+func newLeasePacket(typ dhcp6.MessageType, l *Lease, withServer bool) (*dhcp6.Packet, error) {
+	options := make(dhcp6.Options)
+	clientID, err := dhcp6opts.GetClientID(l.Reply.Options)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find client ID in %v: %v", l.Reply, err)
+	}
+	if err := options.Add(dhcp6.OptionClientID, clientID); err != nil {
+		return nil, err
+	}
+	if withServer {
+		serverID, err := dhcp6opts.GetServerID(l.Reply.Options)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find server ID in %v: %v", l.Reply, err)
+		}
+		if err := options.Add(dhcp6.OptionServerID, serverID); err != nil {
+			return nil, err
+		}
+	}
+	for _, iana := range l.IANAs {
+		if err := options.Add(dhcp6.OptionIANA, iana); err != nil {
+			return nil, err
+		}
+	}
+	for _, iapd := range l.IAPDs {
+		if err := options.Add(dhcp6.OptionIAPD, iapd); err != nil {
+			return nil, err
+		}
+	}
+	if err := addCommonOptions(options); err != nil {
+		return nil, err
+	}
+	return NewPacket(typ, options), nil
+}
+
+// NewRenewPacket returns a Renew packet that extends l with the server that
+// granted it, RFC 3315 Section 18.1.3.
+func NewRenewPacket(l *Lease) (*dhcp6.Packet, error) {
+	return newLeasePacket(dhcp6.MessageTypeRenew, l, true)
+}
+
+// NewRebindPacket returns a Rebind packet that extends l with any server,
+// RFC 3315 Section 18.1.4.
+func NewRebindPacket(l *Lease) (*dhcp6.Packet, error) {
+	return newLeasePacket(dhcp6.MessageTypeRebind, l, false)
+}
+
+// NewReleasePacket returns a Release packet that gives up l, RFC 3315
+// Section 18.1.6.
+func NewReleasePacket(l *Lease) (*dhcp6.Packet, error) {
+	return newLeasePacket(dhcp6.MessageTypeRelease, l, true)
+}
+
 // NewPacket creates a new DHCPv6 packet using the given message type and
 // options.
 //
@@ -0,0 +1,240 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ICMPv6 neighbor discovery, RFC 4861.
+const (
+	icmpRouterSolicitation  = 133
+	icmpRouterAdvertisement = 134
+
+	ndOptSourceLinkAddr = 1
+	ndOptPrefixInfo     = 3
+	ndOptRDNSS          = 25 // RFC 8106
+)
+
+// AllRouters is all routers on the local network segment.
+var AllRouters = net.ParseIP("ff02::2")
+
+// RouterAdvertisement is an ICMPv6 router advertisement, RFC 4861 Section
+// 4.2. Its flags tell hosts how to configure addresses.
+type RouterAdvertisement struct {
+	// Managed (M) means addresses are assigned with DHCPv6.
+	Managed bool
+
+	// OtherConfig (O) means other configuration, such as DNS servers, is
+	// available with DHCPv6.
+	OtherConfig bool
+
+	// RouterLifetime is how long the router is a default router.
+	RouterLifetime time.Duration
+
+	// Prefixes are the advertised prefixes.
+	Prefixes []PrefixInfo
+
+	// DNS are the advertised recursive DNS servers, RFC 8106.
+	DNS []net.IP
+}
+
+// PrefixInfo is a prefix information option, RFC 4861 Section 4.6.2.
+type PrefixInfo struct {
+	Prefix *net.IPNet
+
+	// OnLink (L) means addresses in Prefix are on the link.
+	OnLink bool
+
+	// Autonomous (A) means hosts configure addresses in Prefix with
+	// SLAAC.
+	Autonomous bool
+
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// SLAAC returns whether hosts configure addresses without DHCPv6.
+func (ra *RouterAdvertisement) SLAAC() bool {
+	for _, p := range ra.Prefixes {
+		if p.Autonomous {
+			return true
+		}
+	}
+	return false
+}
+
+func seconds(b []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+}
+
+// ParseRouterAdvertisement parses the ICMPv6 message b.
+func ParseRouterAdvertisement(b []byte) (*RouterAdvertisement, error) {
+	if len(b) < 16 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if b[0] != icmpRouterAdvertisement || b[1] != 0 {
+		return nil, fmt.Errorf("ICMPv6 message type %d code %d is not a router advertisement", b[0], b[1])
+	}
+	ra := &RouterAdvertisement{
+		Managed:        b[5]&0x80 != 0,
+		OtherConfig:    b[5]&0x40 != 0,
+		RouterLifetime: time.Duration(binary.BigEndian.Uint16(b[6:8])) * time.Second,
+	}
+
+	for opts := b[16:]; len(opts) > 0; {
+		if len(opts) < 2 || opts[1] == 0 || len(opts) < 8*int(opts[1]) {
+			return nil, errors.New("malformed router advertisement option")
+		}
+		opt := opts[:8*int(opts[1])]
+		opts = opts[len(opt):]
+
+		switch opt[0] {
+		case ndOptPrefixInfo:
+			if len(opt) != 32 || opt[2] > 128 {
+				return nil, errors.New("malformed prefix information option")
+			}
+			prefix := make(net.IP, net.IPv6len)
+			copy(prefix, opt[16:32])
+			ra.Prefixes = append(ra.Prefixes, PrefixInfo{
+				Prefix: &net.IPNet{
+					IP:   prefix,
+					Mask: net.CIDRMask(int(opt[2]), 128),
+				},
+				OnLink:            opt[3]&0x80 != 0,
+				Autonomous:        opt[3]&0x40 != 0,
+				ValidLifetime:     seconds(opt[4:8]),
+				PreferredLifetime: seconds(opt[8:12]),
+			})
+
+		case ndOptRDNSS:
+			if len(opt) < 24 || (len(opt)-8)%16 != 0 {
+				return nil, errors.New("malformed recursive DNS server option")
+			}
+			if seconds(opt[4:8]) == 0 {
+				// The servers must no longer be used.
+				continue
+			}
+			for a := opt[8:]; len(a) > 0; a = a[16:] {
+				ip := make(net.IP, net.IPv6len)
+				copy(ip, a[:16])
+				ra.DNS = append(ra.DNS, ip)
+			}
+		}
+	}
+	return ra, nil
+}
+
+// newRouterSolicitation returns an ICMPv6 router solicitation from mac. The
+// kernel fills in the checksum.
+func newRouterSolicitation(mac net.HardwareAddr) []byte {
+	b := make([]byte, 8, 16)
+	b[0] = icmpRouterSolicitation
+	if len(mac) == 6 {
+		b = append(b, ndOptSourceLinkAddr, 1)
+		b = append(b, mac...)
+	}
+	return b
+}
+
+// hopLimit returns the hop limit in the control messages oob, or -1 if
+// there is none.
+func hopLimit(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1
+	}
+	for _, m := range msgs {
+		if m.Header.Level != syscall.IPPROTO_IPV6 || m.Header.Type != syscall.IPV6_HOPLIMIT || len(m.Data) < 4 {
+			continue
+		}
+		// The hop limit is a native endian int of at most 255, so
+		// all its bytes but one are zero.
+		return int(m.Data[0] | m.Data[1] | m.Data[2] | m.Data[3])
+	}
+	return -1
+}
+
+// fromLink returns whether a neighbor discovery message from src that
+// arrived with hop limit hops was sent by a router on the link. Others must
+// be dropped, RFC 4861 Section 6.1.2.
+func fromLink(src net.IP, hops int) bool {
+	return hops == 255 && src.IsLinkLocalUnicast()
+}
+
+// SolicitRouter sends router solicitations on the client's interface and
+// returns the first valid router advertisement received, RFC 4861 Section
+// 6.3.7.
+func (c *Client) SolicitRouter(ctx context.Context) (*RouterAdvertisement, error) {
+	conn, err := net.ListenIP("ip6:ipv6-icmp", &net.IPAddr{IP: net.IPv6unspecified})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	name := c.iface.Attrs().Name
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		// Neighbor discovery messages must be sent with hop limit 255
+		// and only concern this link.
+		if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255); serr != nil {
+			return
+		}
+		if serr = syscall.BindToDevice(int(fd), name); serr != nil {
+			return
+		}
+		// The hop limit of received messages tells whether they
+		// come from this link.
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+	}); err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+
+	rs := newRouterSolicitation(c.iface.Attrs().HardwareAddr)
+	dest := &net.IPAddr{IP: AllRouters, Zone: name}
+	b := make([]byte, 1500)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	for i := 0; i < c.retry || c.retry < 0; i++ {
+		if _, err := conn.WriteTo(rs, dest); err != nil {
+			return nil, fmt.Errorf("error writing router solicitation: %v", err)
+		}
+
+		deadline := time.Now().Add(c.timeout)
+		for time.Now().Before(deadline) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			// Check ctx every once in a while.
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, oobn, _, src, err := conn.ReadMsgIP(b, oob)
+			if oerr, ok := err.(net.Error); ok && oerr.Timeout() {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("error reading from ICMPv6 connection: %v", err)
+			}
+			if !fromLink(src.IP, hopLimit(oob[:oobn])) {
+				continue
+			}
+			if ra, err := ParseRouterAdvertisement(b[:n]); err == nil {
+				return ra, nil
+			}
+		}
+	}
+	return nil, context.DeadlineExceeded
+}
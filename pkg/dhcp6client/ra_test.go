@@ -0,0 +1,130 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6client
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRouterAdvertisement(t *testing.T) {
+	header := func(flags byte) []byte {
+		return []byte{
+			134, 0, 0, 0, // type, code, checksum
+			64, flags, 0x07, 0x08, // hop limit, flags, router lifetime
+			0, 0, 0, 0, // reachable time
+			0, 0, 0, 0, // retrans timer
+		}
+	}
+	prefixInfo := []byte{
+		3, 4, 64, 0xc0, // type, length, prefix length, L and A
+		0, 0, 0x0e, 0x10, // valid lifetime
+		0, 0, 0x07, 0x08, // preferred lifetime
+		0, 0, 0, 0,
+		0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	rdnss := []byte{
+		25, 3, 0, 0, // type, length
+		0, 0, 0x0e, 0x10, // lifetime
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53,
+	}
+
+	for _, tt := range []struct {
+		name    string
+		b       []byte
+		want    *RouterAdvertisement
+		wantErr bool
+	}{
+		{
+			name: "managed",
+			b:    header(0x80),
+			want: &RouterAdvertisement{
+				Managed:        true,
+				RouterLifetime: 1800 * time.Second,
+			},
+		},
+		{
+			name: "SLAAC with DNS",
+			b:    append(append(header(0x40), prefixInfo...), rdnss...),
+			want: &RouterAdvertisement{
+				OtherConfig:    true,
+				RouterLifetime: 1800 * time.Second,
+				Prefixes: []PrefixInfo{
+					{
+						Prefix: &net.IPNet{
+							IP:   net.ParseIP("2001:db8:1::"),
+							Mask: net.CIDRMask(64, 128),
+						},
+						OnLink:            true,
+						Autonomous:        true,
+						ValidLifetime:     3600 * time.Second,
+						PreferredLifetime: 1800 * time.Second,
+					},
+				},
+				DNS: []net.IP{net.ParseIP("2001:db8::53")},
+			},
+		},
+		{
+			name:    "not an RA",
+			b:       append([]byte{133}, header(0)[1:]...),
+			wantErr: true,
+		},
+		{
+			name:    "short",
+			b:       header(0)[:8],
+			wantErr: true,
+		},
+		{
+			name:    "truncated option",
+			b:       append(header(0), prefixInfo[:16]...),
+			wantErr: true,
+		},
+		{
+			name:    "zero length option",
+			b:       append(header(0), 3, 0, 0, 0, 0, 0, 0, 0),
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRouterAdvertisement(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRouterAdvertisement() = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRouterAdvertisement() = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && got.SLAAC() != (len(tt.want.Prefixes) > 0) {
+				t.Errorf("SLAAC() = %t, want %t", got.SLAAC(), len(tt.want.Prefixes) > 0)
+			}
+		})
+	}
+}
+
+func TestNewRouterSolicitation(t *testing.T) {
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	want := []byte{133, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 2, 3, 4, 5, 6}
+	if got := newRouterSolicitation(mac); !reflect.DeepEqual(got, want) {
+		t.Errorf("newRouterSolicitation() = %v, want %v", got, want)
+	}
+}
+
+func TestFromLink(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		hops int
+		want bool
+	}{
+		{src: "fe80::1", hops: 255, want: true},
+		{src: "fe80::1", hops: 254},
+		{src: "fe80::1", hops: -1},
+		{src: "2001:db8::1", hops: 255},
+	} {
+		if got := fromLink(net.ParseIP(tt.src), tt.hops); got != tt.want {
+			t.Errorf("fromLink(%s, %d) = %t, want %t", tt.src, tt.hops, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// blsLocations are the directories, relative to the root of a partition,
+// that contain BootLoaderSpec type #1 entries.
+var blsLocations = []string{
+	"loader/entries",
+	"boot/loader/entries",
+}
+
+// grubEnvLocations are the places grubenv files are looked for when
+// expanding variables in BLS entries, as done by Fedora's blscfg.
+var grubEnvLocations = []string{
+	"grub2/grubenv",
+	"boot/grub2/grubenv",
+	"grub/grubenv",
+	"boot/grub/grubenv",
+}
+
+// BLSEntry is a BootLoaderSpec type #1 entry as found in
+// /loader/entries/*.conf.
+type BLSEntry struct {
+	// ID is the file name of the entry without the .conf suffix.
+	ID string
+
+	Title      string
+	Version    string
+	MachineID  string
+	SortKey    string
+	Linux      string
+	Initrd     []string
+	Options    string
+	DeviceTree string
+}
+
+// ParseBLSEntry parses a BLS entry file. Unknown keys are ignored.
+func ParseBLSEntry(id string, r io.Reader) (*BLSEntry, error) {
+	e := &BLSEntry{ID: id}
+	var options []string
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			key, val = line[:i], strings.TrimSpace(line[i:])
+		}
+
+		switch key {
+		case "title":
+			e.Title = val
+		case "version":
+			e.Version = val
+		case "machine-id":
+			e.MachineID = val
+		case "sort-key":
+			e.SortKey = val
+		case "linux":
+			e.Linux = val
+		case "initrd":
+			// Multiple initrd lines, and multiple files per line, are
+			// loaded in order.
+			e.Initrd = append(e.Initrd, strings.Fields(val)...)
+		case "options":
+			options = append(options, val)
+		case "devicetree":
+			e.DeviceTree = val
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	e.Options = strings.Join(options, " ")
+	return e, nil
+}
+
+// Name returns the name to show for the entry: the title if there is one,
+// then the version and finally the entry ID.
+func (b *BLSEntry) Name() string {
+	switch {
+	case b.Title != "":
+		return b.Title
+	case b.Version != "":
+		return b.Version
+	}
+	return b.ID
+}
+
+// Entry converts the BLS entry to a boot Entry. Variable references in the
+// options, such as Fedora's $kernelopts, are expanded using vars.
+func (b *BLSEntry) Entry(vars map[string]string) (*Entry, error) {
+	if b.Linux == "" {
+		return nil, fmt.Errorf("BLS entry %q: missing linux key", b.ID)
+	}
+	options := os.Expand(b.Options, func(name string) string {
+		return vars[name]
+	})
+	e := &Entry{
+		Name:       b.Name(),
+		Type:       Elf,
+		Modules:    []Module{NewModule(b.Linux, strings.Fields(options))},
+		DeviceTree: b.DeviceTree,
+	}
+	for _, initrd := range b.Initrd {
+		e.Modules = append(e.Modules, NewModule(initrd, nil))
+	}
+	return e, nil
+}
+
+// usesVars reports whether the options of b refer to a variable for which
+// isVar returns true.
+func (b *BLSEntry) usesVars(isVar func(string) bool) bool {
+	used := false
+	os.Expand(b.Options, func(name string) string {
+		used = used || isVar(name)
+		return ""
+	})
+	return used
+}
+
+// blsLess sorts BLS entries the way systemd-boot does: entries with a sort
+// key come first, ordered by sort key, machine ID and newest version. The
+// rest are ordered by newest entry ID.
+func blsLess(a, b *BLSEntry) bool {
+	if (a.SortKey != "") != (b.SortKey != "") {
+		return a.SortKey != ""
+	}
+	if a.SortKey != "" {
+		if a.SortKey != b.SortKey {
+			return a.SortKey < b.SortKey
+		}
+		if a.MachineID != b.MachineID {
+			return a.MachineID < b.MachineID
+		}
+		if c := CompareVersions(a.Version, b.Version); c != 0 {
+			return c > 0
+		}
+	}
+	return CompareVersions(a.ID, b.ID) > 0
+}
+
+// SortBLSEntries sorts entries in boot menu order.
+func SortBLSEntries(entries []*BLSEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return blsLess(entries[i], entries[j])
+	})
+}
+
+// CompareVersions compares two version strings in the style of RPM and
+// systemd's strverscmp_improved. It returns -1 if a is older than b, 0 if
+// they are equal and 1 if a is newer.
+//
+// Versions are compared segment by segment: digit runs numerically, letter
+// runs lexically, and a digit run is newer than a letter run. '~' sorts
+// before everything, even the end of the string, so 1.0~rc1 < 1.0.
+func CompareVersions(a, b string) int {
+	isAlnum := func(c byte) bool {
+		return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+	}
+	isDigit := func(c byte) bool {
+		return '0' <= c && c <= '9'
+	}
+	span := func(s string, f func(byte) bool) int {
+		i := 0
+		for i < len(s) && f(s[i]) {
+			i++
+		}
+		return i
+	}
+
+	for {
+		// Skip separators other than '~'.
+		a = a[span(a, func(c byte) bool { return !isAlnum(c) && c != '~' }):]
+		b = b[span(b, func(c byte) bool { return !isAlnum(c) && c != '~' }):]
+
+		aTilde := strings.HasPrefix(a, "~")
+		bTilde := strings.HasPrefix(b, "~")
+		if aTilde || bTilde {
+			if !aTilde {
+				return 1
+			}
+			if !bTilde {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			switch {
+			case a == b:
+				return 0
+			case a == "":
+				return -1
+			}
+			return 1
+		}
+
+		if isDigit(a[0]) != isDigit(b[0]) {
+			// Numeric segments are newer than alphabetic ones.
+			if isDigit(a[0]) {
+				return 1
+			}
+			return -1
+		}
+
+		var sa, sb string
+		if isDigit(a[0]) {
+			sa, sb = a[:span(a, isDigit)], b[:span(b, isDigit)]
+			a, b = a[len(sa):], b[len(sb):]
+			sa = strings.TrimLeft(sa, "0")
+			sb = strings.TrimLeft(sb, "0")
+			if len(sa) != len(sb) {
+				if len(sa) > len(sb) {
+					return 1
+				}
+				return -1
+			}
+		} else {
+			isAlpha := func(c byte) bool { return isAlnum(c) && !isDigit(c) }
+			sa, sb = a[:span(a, isAlpha)], b[:span(b, isAlpha)]
+			a, b = a[len(sa):], b[len(sb):]
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+}
+
+// readGrubEnvVars returns the variables of the first grubenv found below
+// mountPath, or nil.
+func readGrubEnvVars(mountPath string) map[string]string {
+	for _, loc := range grubEnvLocations {
+		contents, err := ioutil.ReadFile(filepath.Join(mountPath, loc))
+		if err != nil {
+			continue
+		}
+		if env, err := ParseGrubEnv(contents); err == nil {
+			return env
+		}
+	}
+	return nil
+}
+
+// readBLSEntries parses and sorts all entries in dir.
+func readBLSEntries(dir string) ([]*BLSEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*BLSEntry
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		e, err := ParseBLSEntry(strings.TrimSuffix(filepath.Base(path), ".conf"), f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	SortBLSEntries(entries)
+	return entries, nil
+}
+
+// blsPath returns the path of a file referenced by an entry in entriesDir.
+// Paths are relative to the partition holding the entries. When /boot is
+// part of the root file system, they are relative to /boot.
+func blsPath(mountPath, entriesDir, path string) string {
+	path = filepath.Clean("/" + path)
+	if !strings.HasPrefix(entriesDir, "boot/") {
+		return path
+	}
+	if _, err := os.Stat(filepath.Join(mountPath, path)); err == nil {
+		return path
+	}
+	return filepath.Join("/boot", path)
+}
+
+// ParseBLSConfig reads all BLS entries in entriesDir, which is relative to
+// mountPath. The default entry is taken from loader.conf's default pattern
+// or grubenv's saved_entry, and otherwise is the first entry in menu order.
+func ParseBLSConfig(mountPath, entriesDir string) (*Config, error) {
+	configPath := filepath.Join(mountPath, entriesDir)
+	blsEntries, err := readBLSEntries(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(blsEntries) == 0 {
+		return nil, fmt.Errorf("no BLS entries in %s", configPath)
+	}
+
+	vars := readGrubEnvVars(mountPath)
+	config := &Config{
+		MountPath:    mountPath,
+		ConfigPath:   configPath,
+		DefaultEntry: -1,
+	}
+	var ids []string
+	for _, b := range blsEntries {
+		e, err := b.Entry(vars)
+		if err != nil {
+			continue
+		}
+		for i, m := range e.Modules {
+			e.Modules[i].Path = blsPath(mountPath, entriesDir, m.Path)
+		}
+		e.File = filepath.Join("/", entriesDir, b.ID+".conf")
+		e.EnvCmdline = b.usesVars(func(name string) bool {
+			_, ok := vars[name]
+			return ok
+		})
+		config.Entries = append(config.Entries, *e)
+		ids = append(ids, b.ID)
+	}
+	if len(config.Entries) == 0 {
+		return nil, fmt.Errorf("no valid BLS entries in %s", configPath)
+	}
+
+	config.DefaultEntry = 0
+	loaderConf := filepath.Join(filepath.Dir(configPath), "loader.conf")
+	if pattern := loaderDefault(loaderConf); pattern != "" {
+		config.DefaultEntry = matchBLSDefault(ids, pattern, config.DefaultEntry)
+	} else if saved := vars["saved_entry"]; saved != "" {
+		config.DefaultEntry = matchBLSDefault(ids, saved, config.DefaultEntry)
+	}
+	return config, nil
+}
+
+// loaderDefault returns the default entry pattern in a systemd-boot
+// loader.conf.
+func loaderDefault(path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == "default" {
+			return f[1]
+		}
+	}
+	return ""
+}
+
+// matchBLSDefault returns the index of the first ID matching the glob
+// pattern. The pattern may include the .conf suffix.
+func matchBLSDefault(ids []string, pattern string, def int) int {
+	pattern = strings.TrimSuffix(pattern, ".conf")
+	for i, id := range ids {
+		if ok, _ := filepath.Match(pattern, id); ok {
+			return i
+		}
+	}
+	return def
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.010", "1.9", 1},
+		{"5.0.16-300.fc30", "5.0.9-301.fc30", 1},
+		{"1.0", "1.0.1", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.01", -1},
+		{"2", "a", 1},
+		{"", "", 0},
+		{"", "1", -1},
+	} {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseBLSEntry(t *testing.T) {
+	entry := `# comment
+title   Arch Linux
+version	5.1.2
+machine-id 6a9857a393724b7a981ebb5b8495b9ea
+linux /vmlinuz-linux
+initrd /intel-ucode.img
+initrd /initramfs-linux.img /extra.img
+options root=/dev/sda2
+options rw  quiet
+devicetree /dtbs/board.dtb
+unknown-key value
+`
+	got, err := ParseBLSEntry("arch", strings.NewReader(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BLSEntry{
+		ID:         "arch",
+		Title:      "Arch Linux",
+		Version:    "5.1.2",
+		MachineID:  "6a9857a393724b7a981ebb5b8495b9ea",
+		Linux:      "/vmlinuz-linux",
+		Initrd:     []string{"/intel-ucode.img", "/initramfs-linux.img", "/extra.img"},
+		Options:    "root=/dev/sda2 rw  quiet",
+		DeviceTree: "/dtbs/board.dtb",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseBLSEntry = %#v, want %#v", got, want)
+	}
+
+	e, err := got.Entry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEntry := &Entry{
+		Name: "Arch Linux",
+		Type: Elf,
+		Modules: []Module{
+			{Path: "/vmlinuz-linux", Params: "root=/dev/sda2 rw quiet"},
+			{Path: "/intel-ucode.img"},
+			{Path: "/initramfs-linux.img"},
+			{Path: "/extra.img"},
+		},
+		DeviceTree: "/dtbs/board.dtb",
+	}
+	if !reflect.DeepEqual(e, wantEntry) {
+		t.Errorf("Entry = %#v, want %#v", e, wantEntry)
+	}
+
+	if _, err := (&BLSEntry{ID: "nolinux"}).Entry(nil); err == nil {
+		t.Errorf("Entry without linux key succeeded, want error")
+	}
+}
+
+func TestSortBLSEntries(t *testing.T) {
+	entries := []*BLSEntry{
+		{ID: "fedora-4.9"},
+		{ID: "fedora-4.19"},
+		{ID: "b", SortKey: "fedora", Version: "1.0"},
+		{ID: "a", SortKey: "fedora", Version: "1.1"},
+		{ID: "c", SortKey: "arch", Version: "0.1"},
+		{ID: "fedora-4.19~rc1"},
+	}
+	SortBLSEntries(entries)
+
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	want := []string{"c", "a", "b", "fedora-4.19", "fedora-4.19~rc1", "fedora-4.9"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("SortBLSEntries = %v, want %v", ids, want)
+	}
+}
+
+func TestParseBLSConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		files       map[string]string
+		wantNames   []string
+		wantPaths   []string
+		wantDefault int
+	}{
+		{
+			name: "newest first",
+			files: map[string]string{
+				"loader/entries/linux-5.1.conf": "title Linux 5.1\nlinux /vmlinuz-5.1\n",
+				"loader/entries/linux-5.2.conf": "title Linux 5.2\nlinux /vmlinuz-5.2\n",
+				"loader/entries/broken.conf":    "title Broken\n",
+			},
+			wantNames:   []string{"Linux 5.2", "Linux 5.1"},
+			wantPaths:   []string{"/vmlinuz-5.2", "/vmlinuz-5.1"},
+			wantDefault: 0,
+		},
+		{
+			name: "loader.conf default",
+			files: map[string]string{
+				"loader/loader.conf":            "timeout 3\ndefault linux-5.1*\n",
+				"loader/entries/linux-5.1.conf": "title Linux 5.1\nlinux /vmlinuz-5.1\n",
+				"loader/entries/linux-5.2.conf": "title Linux 5.2\nlinux /vmlinuz-5.2\n",
+			},
+			wantNames:   []string{"Linux 5.2", "Linux 5.1"},
+			wantPaths:   []string{"/vmlinuz-5.2", "/vmlinuz-5.1"},
+			wantDefault: 1,
+		},
+		{
+			name: "/boot on the root file system",
+			files: map[string]string{
+				"boot/loader/entries/linux.conf": "title Linux\nlinux /vmlinuz\n",
+				"boot/vmlinuz":                   "",
+				"boot/grub2/grubenv":             grubEnvBlock("saved_entry=linux\n"),
+			},
+			wantNames:   []string{"Linux"},
+			wantPaths:   []string{"/boot/vmlinuz"},
+			wantDefault: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bls-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeFiles(t, dir, tt.files)
+
+			configs := FindConfigs(dir)
+			if len(configs) != 1 {
+				t.Fatalf("FindConfigs(%q) returned %d configs, want 1", dir, len(configs))
+			}
+			var names, paths []string
+			for _, e := range configs[0].Entries {
+				names = append(names, e.Name)
+				paths = append(paths, e.Modules[0].Path)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("entry names = %v, want %v", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("kernel paths = %v, want %v", paths, tt.wantPaths)
+			}
+			if got := configs[0].DefaultEntry; got != tt.wantDefault {
+				t.Errorf("DefaultEntry = %d, want %d", got, tt.wantDefault)
+			}
+		})
+	}
+}
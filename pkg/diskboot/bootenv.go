@@ -0,0 +1,143 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Boot environment variables. Entries are identified by index or name, as
+// with GRUB's default variable.
+const (
+	// NextEntryVar is the entry to boot once, as set by grub-reboot.
+	NextEntryVar = "next_entry"
+
+	// SavedEntryVar is the default entry, as set by grub-set-default.
+	SavedEntryVar = "saved_entry"
+
+	// TryEntryVar is an entry to boot for the next TryCountVar attempts,
+	// e.g. after an OS update. Once the tries are used up, the boot falls
+	// back to SavedEntryVar, unless the new OS has made the entry
+	// permanent in the meantime; see BootEnv.Commit.
+	TryEntryVar = "try_entry"
+
+	// TryCountVar is the number of attempts left to boot TryEntryVar.
+	TryCountVar = "try_count"
+)
+
+// BootEnv is persistent boot selection state, stored in a GRUB environment
+// block so that GRUB and grub-editenv can read and change it too.
+type BootEnv struct {
+	Path string
+	Vars map[string]string
+
+	// size is the size of the block on disk.
+	size int
+}
+
+// LoadBootEnv reads the environment block at path. A missing file gives an
+// empty environment, which Save creates.
+func LoadBootEnv(path string) (*BootEnv, error) {
+	e := &BootEnv{
+		Path: path,
+		Vars: make(map[string]string),
+		size: grubEnvSize,
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if e.Vars, err = ParseGrubEnv(contents); err != nil {
+		return nil, err
+	}
+	if len(contents) > e.size {
+		e.size = len(contents)
+	}
+	return e, nil
+}
+
+// FindBootEnv returns the path of the first GRUB environment block found
+// below mountPath, or "" if there is none.
+func FindBootEnv(mountPath string) string {
+	for _, loc := range grubEnvLocations {
+		path := filepath.Join(mountPath, loc)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Save writes the environment back. Like GRUB, it overwrites the existing
+// block in place instead of replacing the file.
+func (e *BootEnv) Save() error {
+	b, err := FormatGrubEnv(e.Vars, e.size)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(e.Path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Next returns the entry to boot now and records the attempt: a one-shot
+// next entry is consumed, and the try counter is decremented. It returns ""
+// if the environment selects no entry.
+//
+// The caller must Save the environment before booting.
+func (e *BootEnv) Next() string {
+	if next := e.Vars[NextEntryVar]; next != "" {
+		// GRUB's own scripts clear the variable rather than unsetting
+		// it.
+		e.Vars[NextEntryVar] = ""
+		return next
+	}
+	if try := e.Vars[TryEntryVar]; try != "" {
+		if n, err := strconv.Atoi(e.Vars[TryCountVar]); err == nil && n > 0 {
+			e.Vars[TryCountVar] = strconv.Itoa(n - 1)
+			return try
+		}
+		// Out of tries: fall back to the previous default.
+		delete(e.Vars, TryEntryVar)
+		delete(e.Vars, TryCountVar)
+	}
+	return e.Vars[SavedEntryVar]
+}
+
+// SetNext makes entry the entry to boot once.
+func (e *BootEnv) SetNext(entry string) {
+	e.Vars[NextEntryVar] = entry
+}
+
+// SetTry makes entry the entry to boot for the next tries attempts.
+func (e *BootEnv) SetTry(entry string, tries int) {
+	e.Vars[TryEntryVar] = entry
+	e.Vars[TryCountVar] = strconv.Itoa(tries)
+}
+
+// Commit makes the entry being tried the default. An OS that booted from a
+// tried entry calls it once it is up.
+func (e *BootEnv) Commit() {
+	if try := e.Vars[TryEntryVar]; try != "" {
+		e.Vars[SavedEntryVar] = try
+	}
+	delete(e.Vars, TryEntryVar)
+	delete(e.Vars, TryCountVar)
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormatGrubEnv(t *testing.T) {
+	env := map[string]string{
+		"saved_entry": "Ubuntu>Recovery",
+		"multi":       "a\nb",
+		"back":        `c\d`,
+	}
+	b, err := FormatGrubEnv(env, grubEnvSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := grubEnvBlock("back=c\\\\d\nmulti=a\\\nb\nsaved_entry=Ubuntu>Recovery\n"); string(b) != want {
+		t.Errorf("FormatGrubEnv = %q, want %q", b, want)
+	}
+	got, err := ParseGrubEnv(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, env) {
+		t.Errorf("ParseGrubEnv(FormatGrubEnv(%q)) = %q", env, got)
+	}
+
+	if _, err := FormatGrubEnv(map[string]string{"a=b": ""}, grubEnvSize); err == nil {
+		t.Errorf("FormatGrubEnv with '=' in a name succeeded, want error")
+	}
+	if _, err := FormatGrubEnv(map[string]string{"a": "b"}, 10); err == nil {
+		t.Errorf("FormatGrubEnv larger than the block succeeded, want error")
+	}
+}
+
+func TestBootEnvNext(t *testing.T) {
+	e := &BootEnv{Vars: map[string]string{
+		SavedEntryVar: "old",
+		NextEntryVar:  "once",
+	}}
+	e.SetTry("new", 2)
+
+	var got []string
+	for i := 0; i < 5; i++ {
+		got = append(got, e.Next())
+	}
+	want := []string{"once", "new", "new", "old", "old"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Next() sequence = %q, want %q", got, want)
+	}
+	if _, ok := e.Vars[TryEntryVar]; ok {
+		t.Errorf("%s still set after the tries ran out", TryEntryVar)
+	}
+
+	e.SetTry("new", 1)
+	if got := e.Next(); got != "new" {
+		t.Errorf("Next() = %q, want %q", got, "new")
+	}
+	e.Commit()
+	if got := e.Next(); got != "new" {
+		t.Errorf("Next() after Commit = %q, want %q", got, "new")
+	}
+	if got := e.Vars[SavedEntryVar]; got != "new" {
+		t.Errorf("%s after Commit = %q, want %q", SavedEntryVar, got, "new")
+	}
+}
+
+func TestBootEnvSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootenv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A missing file gives an empty environment.
+	path := filepath.Join(dir, "grubenv")
+	e, err := LoadBootEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Vars) != 0 {
+		t.Errorf("LoadBootEnv(missing) = %v, want empty", e.Vars)
+	}
+
+	// Existing blocks keep their size.
+	big := grubEnvBlock("next_entry=1\n")
+	for len(big) < 2048 {
+		big += "#"
+	}
+	writeFiles(t, dir, map[string]string{"boot/grub/grubenv": big})
+	if path = FindBootEnv(dir); path != filepath.Join(dir, "boot/grub/grubenv") {
+		t.Fatalf("FindBootEnv = %q", path)
+	}
+	if e, err = LoadBootEnv(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Next(); got != "1" {
+		t.Errorf("Next() = %q, want %q", got, "1")
+	}
+	if err := e.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2048 {
+		t.Errorf("saved block is %d bytes, want 2048", len(b))
+	}
+	if e, err = LoadBootEnv(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Next(); got != "" {
+		t.Errorf("Next() after a one-shot boot = %q, want none", got)
+	}
+}
+
+func TestConfigFindEntry(t *testing.T) {
+	c := &Config{Entries: []Entry{{Name: "A"}, {Name: "B"}}}
+	for id, want := range map[string]int{
+		"0": 0,
+		"1": 1,
+		"2": -1,
+		"B": 1,
+		"C": -1,
+	} {
+		if got := c.FindEntry(id); got != want {
+			t.Errorf("FindEntry(%q) = %d, want %d", id, got, want)
+		}
+	}
+}
@@ -6,14 +6,17 @@ package diskboot
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
 
-	"github.com/u-root/u-root/pkg/kexec"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/tpm"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
 // Config contains boot entries for a single configuration file
@@ -23,6 +26,28 @@ type Config struct {
 	ConfigPath   string
 	Entries      []Entry
 	DefaultEntry int
+
+	// Files are the paths of the scripts read to build the entries,
+	// relative to the mount path: the config file and those it loads
+	// with source or configfile. Only set for GRUB configs.
+	Files []string `json:",omitempty"`
+}
+
+// FindEntry returns the index of the entry identified by id, which is
+// either an index or an entry name, or -1 if there is no such entry.
+func (c *Config) FindEntry(id string) int {
+	if i, err := strconv.Atoi(id); err == nil {
+		if i >= 0 && i < len(c.Entries) {
+			return i
+		}
+		return -1
+	}
+	for i, e := range c.Entries {
+		if e.Name == id {
+			return i
+		}
+	}
+	return -1
 }
 
 // EntryType dictates the method by which kexec should use to load
@@ -59,48 +84,148 @@ type Entry struct {
 	Name    string
 	Type    EntryType
 	Modules []Module
+
+	// DeviceTree is the path to a flattened device tree to pass to the
+	// kernel, relative to the mount path. Only set by BLS entries.
+	DeviceTree string `json:",omitempty"`
+
+	// File is the path of the BLS entry file that defines the entry,
+	// relative to the mount path. Entries of other configs are defined in
+	// the config file.
+	File string `json:",omitempty"`
+
+	// EnvCmdline is set if the command line of the entry takes variables
+	// from the GRUB environment block, such as $kernelopts.
+	EnvCmdline bool `json:",omitempty"`
 }
 
-// KexecLoad calls the appropriate kexec load routines based on the
-// type of Entry
-func (e *Entry) KexecLoad(mountPath, appendCmdline string, dryrun bool) error {
+// file returns a lazily opened reader for a path relative to mountPath.
+// It fails early if the file does not exist.
+func file(mountPath, path string) (io.ReaderAt, error) {
+	fullPath := filepath.Join(mountPath, path)
+	if _, err := os.Stat(fullPath); err != nil {
+		return nil, err
+	}
+	return uio.NewLazyOpenerAt(func() (io.ReaderAt, error) {
+		return os.Open(fullPath)
+	}), nil
+}
+
+// OSImage converts the entry into a boot.OSImage: a boot.LinuxImage for Elf
+// entries and a boot.MultibootImage for Multiboot entries. Module paths are
+// relative to mountPath, and appendCmdline is appended to the kernel command
+// line.
+//
+// Elf entries with more than one initrd get their initrds concatenated.
+func (e *Entry) OSImage(mountPath, appendCmdline string) (boot.OSImage, error) {
+	return e.osImage(appendCmdline, func(path string) (io.ReaderAt, error) {
+		return file(mountPath, path)
+	})
+}
+
+// VerifiedOSImage is like OSImage, but every file of the entry must have a
+// valid signature; see Verifier. The files are read into memory when they
+// are verified, so they can not change before they are loaded.
+//
+// Unless v.Warn is set, appendCmdline must be empty: nothing signs it.
+func (e *Entry) VerifiedOSImage(mountPath, appendCmdline string, v *Verifier) (boot.OSImage, error) {
+	if appendCmdline != "" {
+		if !v.Warn {
+			return nil, ErrUnsignedCmdline
+		}
+		log.Printf("Appending %q anyway: %v", appendCmdline, ErrUnsignedCmdline)
+	}
+	return e.osImage(appendCmdline, func(path string) (io.ReaderAt, error) {
+		return v.open(mountPath, path)
+	})
+}
+
+// osImage implements OSImage, with the files of the entry opened by file.
+func (e *Entry) osImage(appendCmdline string, file func(path string) (io.ReaderAt, error)) (boot.OSImage, error) {
+	if len(e.Modules) < 1 {
+		return nil, fmt.Errorf("missing kernel")
+	}
+	kernel, err := file(e.Modules[0].Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kernel: %v", err)
+	}
+	cmdline := e.Modules[0].Params
+	if appendCmdline != "" {
+		cmdline = strings.TrimSpace(cmdline + " " + appendCmdline)
+	}
+
 	switch e.Type {
 	case Multiboot:
-		// TODO: implement using kexec_load syscall
-		return syscall.ENOSYS
-	case Elf:
-		// TODO: implement using kexec_file_load syscall
-		// e.Module[0].Path is kernel
-		// e.Module[0].Params is kernel parameters
-		// e.Module[1].Path is initrd
-		if len(e.Modules) < 1 {
-			return fmt.Errorf("missing kernel")
-		}
-		var ramfs *os.File
-		kernelPath := filepath.Join(mountPath, e.Modules[0].Path)
-		log.Print("Kernel Path:", kernelPath)
-		kernel, err := os.OpenFile(kernelPath, os.O_RDONLY, 0)
-		cmdline := e.Modules[0].Params
-		if appendCmdline != "" {
-			cmdline += " " + appendCmdline
+		mi := &boot.MultibootImage{
+			Kernel:  kernel,
+			Cmdline: cmdline,
 		}
-		log.Print("Kernel Params:", cmdline)
-		if err != nil {
-			return fmt.Errorf("failed to load kernel: %v", err)
+		for _, m := range e.Modules[1:] {
+			content, err := file(m.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load module: %v", err)
+			}
+			mi.Modules = append(mi.Modules, boot.MultibootModule{
+				Name:    m.Path,
+				Content: content,
+				Cmdline: m.Params,
+			})
 		}
-		if len(e.Modules) > 1 {
-			ramfsPath := filepath.Join(mountPath, e.Modules[1].Path)
-			log.Print("Ramfs Path:", ramfsPath)
-			ramfs, err = os.OpenFile(ramfsPath, os.O_RDONLY, 0)
+		return mi, nil
+
+	case Elf:
+		var initrds []io.ReaderAt
+		for _, m := range e.Modules[1:] {
+			initrd, err := file(m.Path)
 			if err != nil {
-				return fmt.Errorf("failed to load ramfs: %v", err)
+				return nil, fmt.Errorf("failed to load ramfs: %v", err)
+			}
+			initrds = append(initrds, initrd)
+		}
+		li := &boot.LinuxImage{
+			Kernel:  kernel,
+			Cmdline: cmdline,
+		}
+		if e.DeviceTree != "" {
+			if li.DTB, err = file(e.DeviceTree); err != nil {
+				return nil, fmt.Errorf("failed to load device tree: %v", err)
 			}
 		}
-		if !dryrun {
-			return kexec.FileLoad(kernel, ramfs, cmdline)
+		switch len(initrds) {
+		case 0:
+		case 1:
+			li.Initrd = initrds[0]
+		default:
+			// Multiple initrds (e.g. microcode and initramfs) are
+			// concatenated, as the kernel accepts a series of cpio
+			// archives.
+			li.Initrd = boot.CatInitrds(initrds...)
 		}
+		return li, nil
+	}
+	return nil, fmt.Errorf("unknown entry type %d", e.Type)
+}
+
+// KexecLoad loads the entry with kexec. With dryrun, it only logs what would
+// be loaded. With a non-nil m, the entry is measured into the TPM first; see
+// boot.MeasureAndLoad. With a non-nil v, the files of the entry are verified
+// first; see VerifiedOSImage.
+func (e *Entry) KexecLoad(mountPath, appendCmdline string, dryrun bool, m *tpm.Measurer, v *Verifier) error {
+	var img boot.OSImage
+	var err error
+	if v != nil {
+		img, err = e.VerifiedOSImage(mountPath, appendCmdline, v)
+	} else {
+		img, err = e.OSImage(mountPath, appendCmdline)
+	}
+	if err != nil {
+		return err
+	}
+	if dryrun {
+		img.ExecutionInfo(log.New(os.Stderr, "", log.LstdFlags))
+		return nil
 	}
-	return nil
+	return boot.MeasureAndLoad(m, img)
 }
 
 type location struct {
@@ -113,6 +238,7 @@ var (
 		{"boot/grub/grub.cfg", grub},
 		{"grub/grub.cfg", grub},
 		{"grub2/grub.cfg", grub},
+		{"boot/grub2/grub.cfg", grub},
 		// following entries from the syslinux wiki
 		// TODO: add priorities override (top over bottom)
 		{"boot/isolinux/isolinux.cfg", syslinux},
@@ -127,28 +253,47 @@ var (
 // TODO: add iso handling along with iso_path variable replacement
 
 // FindConfigs searching the path for valid boot configuration files
-// and returns a Config for each valid instance found.
+// and returns a Config for each valid instance found. BLS entries that a
+// grub.cfg already added with blscfg are not returned again.
 func FindConfigs(mountPath string) []*Config {
 	var configs []*Config
+	blsFiles := make(map[string]bool)
 
 	for _, location := range locations {
 		configPath := filepath.Join(mountPath, location.Path)
+		if location.Type == grub {
+			config, err := ParseGrubConfig(mountPath, configPath)
+			if err != nil {
+				// TODO: log error
+				continue
+			}
+			for _, e := range config.Entries {
+				if e.File != "" {
+					blsFiles[e.File] = true
+				}
+			}
+			configs = append(configs, config)
+			continue
+		}
+
 		contents, err := ioutil.ReadFile(configPath)
 		if err != nil {
 			// TODO: log error
 			continue
 		}
 
-		var lines []string
-		if location.Type == syslinux {
-			lines = loadSyslinuxLines(configPath, contents)
-		} else {
-			lines = strings.Split(string(contents), "\n")
-		}
-
+		lines := loadSyslinuxLines(configPath, contents)
 		configs = append(configs, ParseConfig(mountPath, configPath, lines))
 	}
 
+	for _, dir := range blsLocations {
+		config, err := ParseBLSConfig(mountPath, dir)
+		if err != nil || blsFiles[config.Entries[0].File] {
+			continue
+		}
+		configs = append(configs, config)
+	}
+
 	return configs
 }
 
@@ -7,11 +7,15 @@ package diskboot
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/go-test/deep"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/uio"
 )
 
 func TestParseEmpty(t *testing.T) {
@@ -52,3 +56,81 @@ func TestConfigs(t *testing.T) {
 		}
 	}
 }
+
+func TestEntryOSImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskboot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"boot/vmlinuz":   "kernel",
+		"boot/ucode.img": "ucode",
+		"boot/initrd":    "initrd",
+		"boot/xen.gz":    "xen",
+	})
+
+	elf := &Entry{
+		Name: "Linux",
+		Type: Elf,
+		Modules: []Module{
+			{Path: "/boot/vmlinuz", Params: "root=/dev/sda1"},
+			{Path: "/boot/ucode.img"},
+			{Path: "/boot/initrd"},
+		},
+	}
+	img, err := elf.OSImage(dir, "quiet")
+	if err != nil {
+		t.Fatalf("OSImage() = %v", err)
+	}
+
+	// The image must survive being packed into a boot package.
+	a := cpio.InMemArchive()
+	if err := (&boot.Package{OSImage: img}).Pack(a); err != nil {
+		t.Fatalf("Pack() = %v", err)
+	}
+	var p boot.Package
+	if err := p.Unpack(a.Reader(), nil); err != nil {
+		t.Fatalf("Unpack() = %v", err)
+	}
+	li, ok := p.OSImage.(*boot.LinuxImage)
+	if !ok {
+		t.Fatalf("OSImage() is %T, want *boot.LinuxImage", p.OSImage)
+	}
+	if want := "root=/dev/sda1 quiet"; li.Cmdline != want {
+		t.Errorf("Cmdline = %q, want %q", li.Cmdline, want)
+	}
+	if !uio.ReaderAtEqual(li.Kernel, strings.NewReader("kernel")) {
+		t.Errorf("Kernel does not match /boot/vmlinuz")
+	}
+	if !uio.ReaderAtEqual(li.Initrd, strings.NewReader("ucode\x00\x00\x00initrd\x00\x00")) {
+		t.Errorf("Initrd is not the concatenation of both initrds")
+	}
+
+	mb := &Entry{
+		Name: "Xen",
+		Type: Multiboot,
+		Modules: []Module{
+			{Path: "/boot/xen.gz", Params: "dom0_mem=1G"},
+			{Path: "/boot/vmlinuz", Params: "placeholder root=/dev/sda1"},
+			{Path: "/boot/initrd"},
+		},
+	}
+	img, err = mb.OSImage(dir, "")
+	if err != nil {
+		t.Fatalf("OSImage() = %v", err)
+	}
+	mi, ok := img.(*boot.MultibootImage)
+	if !ok {
+		t.Fatalf("OSImage() is %T, want *boot.MultibootImage", img)
+	}
+	if mi.Cmdline != "dom0_mem=1G" || len(mi.Modules) != 2 ||
+		mi.Modules[0].Name != "/boot/vmlinuz" || mi.Modules[0].Cmdline != "placeholder root=/dev/sda1" {
+		t.Errorf("OSImage() = %+v, want Xen with 2 modules", mi)
+	}
+
+	missing := &Entry{Type: Elf, Modules: []Module{{Path: "/boot/nonexistent"}}}
+	if _, err := missing.OSImage(dir, ""); err == nil {
+		t.Errorf("OSImage() with missing kernel succeeded, want error")
+	}
+}
@@ -0,0 +1,908 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxGrubDepth bounds nested source/configfile and function calls.
+	maxGrubDepth = 32
+
+	// maxGrubLoops bounds the iterations of while/until loops.
+	maxGrubLoops = 1024
+)
+
+// grubDefaults are the variables GRUB itself sets before running grub.cfg.
+// The feature_* variables tell grub-mkconfig generated scripts that they
+// run on a modern GRUB.
+var grubDefaults = map[string]string{
+	"feature_200_final":            "y",
+	"feature_all_video_module":     "y",
+	"feature_chainloader_bpb":      "y",
+	"feature_default_font_path":    "y",
+	"feature_menuentry_id":         "y",
+	"feature_menuentry_options":    "y",
+	"feature_nativedisk_cmd":       "y",
+	"feature_ntldr":                "y",
+	"feature_platform_search_hint": "y",
+	"feature_timeout_style":        "y",
+	"grub_platform":                "pc",
+}
+
+// grubControl is returned by the break, continue and return commands to
+// unwind the evaluator.
+type grubControl struct {
+	cmd    string
+	status bool
+}
+
+func (c grubControl) Error() string {
+	return fmt.Sprintf("%s outside of loop or function", c.cmd)
+}
+
+// grubMenuItem is a menuentry or submenu seen while running a script.
+type grubMenuItem struct {
+	submenu bool
+	title   string
+	id      string
+	args    []string
+	argsEnv bool
+	body    []grubNode
+
+	// entry is set for items added by blscfg, which need no evaluation.
+	entry *Entry
+
+	// owner is the interpreter that defined the item. Its environment is
+	// used to evaluate the body.
+	owner *grubInterp
+}
+
+// grubPathElem identifies a menu item at one level of the menu tree.
+type grubPathElem struct {
+	index int
+	title string
+	id    string
+}
+
+type grubFlatEntry struct {
+	path  []grubPathElem
+	entry Entry
+}
+
+// grubInterp evaluates GRUB scripts.
+type grubInterp struct {
+	mountPath string
+	configDir string
+
+	vars  map[string]string
+	funcs map[string]*grubFunction
+	args  []string
+	depth int
+
+	// env holds the names of the variables whose value comes from the
+	// GRUB environment block, which is not signed. argsEnv is set if args
+	// do, and cmdEnv if the arguments of the running command do.
+	env     map[string]bool
+	argsEnv bool
+	cmdEnv  bool
+
+	// files is shared by all contexts and holds the paths of the scripts
+	// read, relative to the mount path.
+	files map[string]bool
+
+	status bool
+	menu   []*grubMenuItem
+
+	// entry is the boot entry being built while evaluating a menuentry.
+	entry *Entry
+}
+
+// ParseGrubConfig evaluates the GRUB2 script at configPath and returns its
+// boot entries. Submenus are flattened and files loaded with configfile or
+// source are followed. All paths are resolved relative to mountPath, and
+// the scripts read are listed in the config's Files.
+//
+// Commands that only matter to an interactive GRUB, such as insmod or
+// terminal_output, are accepted and ignored.
+func ParseGrubConfig(mountPath, configPath string) (*Config, error) {
+	rel, err := filepath.Rel(mountPath, filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("config file path not relative to mount path: %v", err)
+	}
+	configDir := filepath.Clean("/" + rel)
+
+	g := &grubInterp{
+		mountPath: mountPath,
+		configDir: configDir,
+		vars:      make(map[string]string),
+		funcs:     make(map[string]*grubFunction),
+		env:       make(map[string]bool),
+		files:     map[string]bool{filepath.Join(configDir, filepath.Base(configPath)): true},
+		status:    true,
+	}
+	for k, v := range grubDefaults {
+		g.vars[k] = v
+	}
+	g.vars["grub_cpu"] = grubCPU()
+	g.vars["root"] = ""
+	g.vars["prefix"] = configDir
+	g.vars["config_directory"] = configDir
+	g.vars["config_file"] = filepath.Join(configDir, filepath.Base(configPath))
+
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseGrub(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", configPath, err)
+	}
+	if err := g.run(nodes); err != nil {
+		if _, ok := err.(grubControl); !ok {
+			return nil, err
+		}
+	}
+
+	flat := g.flatten(nil)
+	config := &Config{
+		MountPath:    mountPath,
+		ConfigPath:   configPath,
+		DefaultEntry: -1,
+	}
+	for _, fe := range flat {
+		config.Entries = append(config.Entries, fe.entry)
+	}
+	for f := range g.files {
+		config.Files = append(config.Files, f)
+	}
+	sort.Strings(config.Files)
+
+	def := g.vars["default"]
+	if def == "saved" {
+		def = g.vars["saved_entry"]
+	}
+	if def != "" {
+		config.DefaultEntry = grubFindEntry(flat, def)
+	}
+	return config, nil
+}
+
+func grubCPU() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i386"
+	}
+	return runtime.GOARCH
+}
+
+// grubFindEntry returns the index of the entry selected by a GRUB default
+// string. Each '>' separated component selects a menu item by number, title
+// or id. -1 is returned if no entry matches.
+func grubFindEntry(flat []grubFlatEntry, def string) int {
+	parts := strings.Split(def, ">")
+	for i, fe := range flat {
+		if len(fe.path) < len(parts) {
+			continue
+		}
+		match := true
+		for j, part := range parts {
+			elem := fe.path[j]
+			if part != strconv.Itoa(elem.index) && part != elem.title &&
+				(elem.id == "" || part != elem.id) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// child returns a new interpreter for evaluating a nested context, such as
+// a menu entry. It starts with a copy of g's variables and functions.
+func (g *grubInterp) child() *grubInterp {
+	c := &grubInterp{
+		mountPath: g.mountPath,
+		configDir: g.configDir,
+		vars:      make(map[string]string, len(g.vars)),
+		funcs:     make(map[string]*grubFunction, len(g.funcs)),
+		env:       make(map[string]bool, len(g.env)),
+		files:     g.files,
+		depth:     g.depth + 1,
+		status:    true,
+	}
+	for k, v := range g.vars {
+		c.vars[k] = v
+	}
+	for k := range g.env {
+		c.env[k] = true
+	}
+	for k, v := range g.funcs {
+		c.funcs[k] = v
+	}
+	return c
+}
+
+func (g *grubInterp) lookup(name string) string {
+	switch name {
+	case "?":
+		if g.status {
+			return "0"
+		}
+		return "1"
+	case "#":
+		return strconv.Itoa(len(g.args))
+	case "@", "*":
+		return strings.Join(g.args, " ")
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n >= 1 && n <= len(g.args) {
+			return g.args[n-1]
+		}
+		return ""
+	}
+	return g.vars[name]
+}
+
+// expand expands words, and reports whether any variable from the
+// environment block was used.
+func (g *grubInterp) expand(words []string) ([]string, bool) {
+	var fields []string
+	env := false
+	lookup := func(name string) string {
+		switch {
+		case g.env[name]:
+			env = true
+		case name == "@" || name == "*" || strings.Trim(name, "0123456789") == "":
+			env = env || g.argsEnv
+		}
+		return g.lookup(name)
+	}
+	for _, w := range words {
+		fields = append(fields, expandGrubWord(w, lookup)...)
+	}
+	return fields, env
+}
+
+// set sets a variable. env tells whether its value comes from the
+// environment block.
+func (g *grubInterp) set(name, val string, env bool) {
+	g.vars[name] = val
+	if env {
+		g.env[name] = true
+	} else {
+		delete(g.env, name)
+	}
+}
+
+// read reads the file at a GRUB file name and records it in g.files.
+func (g *grubInterp) read(name string) ([]byte, error) {
+	path := g.path(name)
+	contents, err := ioutil.ReadFile(path)
+	if err == nil {
+		rel, _ := filepath.Rel(g.mountPath, path)
+		g.files[filepath.Join("/", rel)] = true
+	}
+	return contents, err
+}
+
+// path resolves a GRUB file name to a path below the mount point. A device
+// prefix such as "(hd0,gpt1)" is dropped since only one device is mounted.
+func (g *grubInterp) path(name string) string {
+	name = stripGrubDevice(name)
+	if !strings.HasPrefix(name, "/") {
+		name = filepath.Join(g.configDir, name)
+	}
+	return filepath.Join(g.mountPath, filepath.Clean("/"+name))
+}
+
+// stripGrubDevice removes a leading GRUB device name from a file name.
+func stripGrubDevice(name string) string {
+	if strings.HasPrefix(name, "(") {
+		if i := strings.IndexByte(name, ')'); i >= 0 {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+
+func (g *grubInterp) run(nodes []grubNode) error {
+	for _, n := range nodes {
+		if err := g.exec(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *grubInterp) exec(n grubNode) error {
+	switch n := n.(type) {
+	case *grubSimple:
+		// Assignments without set, e.g. `font=unicode`.
+		if len(n.words) == 1 {
+			if i := strings.IndexByte(n.words[0], '='); i > 0 && isGrubName(n.words[0][:i]) {
+				val, env := g.expand([]string{n.words[0][i+1:]})
+				g.set(n.words[0][:i], strings.Join(val, " "), env)
+				g.status = true
+				return nil
+			}
+		}
+		args, env := g.expand(n.words)
+		if len(args) == 0 {
+			return nil
+		}
+		g.cmdEnv = env
+		return g.call(args[0], args[1:])
+
+	case *grubIf:
+		for i, cond := range n.conds {
+			if err := g.run(cond); err != nil {
+				return err
+			}
+			if g.status {
+				return g.run(n.bodies[i])
+			}
+		}
+		if n.elseBody != nil {
+			return g.run(n.elseBody)
+		}
+		g.status = true
+
+	case *grubFor:
+		vals, env := g.expand(n.words)
+		for _, val := range vals {
+			g.set(n.name, val, env)
+			if err := g.run(n.body); err != nil {
+				if c, ok := err.(grubControl); ok && c.cmd == "break" {
+					break
+				} else if !ok || c.cmd != "continue" {
+					return err
+				}
+			}
+		}
+
+	case *grubWhile:
+		for i := 0; i < maxGrubLoops; i++ {
+			if err := g.run(n.cond); err != nil {
+				return err
+			}
+			if g.status == n.until {
+				break
+			}
+			if err := g.run(n.body); err != nil {
+				if c, ok := err.(grubControl); ok && c.cmd == "break" {
+					break
+				} else if !ok || c.cmd != "continue" {
+					return err
+				}
+			}
+		}
+
+	case *grubFunction:
+		g.funcs[n.name] = n
+
+	case *grubMenu:
+		g.defineMenu(n)
+	}
+	return nil
+}
+
+func (g *grubInterp) defineMenu(n *grubMenu) {
+	item := &grubMenuItem{
+		submenu: n.submenu,
+		body:    n.body,
+		owner:   g,
+	}
+	args, env := g.expand(n.words)
+	item.argsEnv = env
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		switch {
+		case opt == "--id" && i+1 < len(args):
+			i++
+			item.id = args[i]
+		case strings.HasPrefix(opt, "--id="):
+			item.id = strings.TrimPrefix(opt, "--id=")
+		case (opt == "--class" || opt == "--users" || opt == "--hotkey" || opt == "--source") && i+1 < len(args):
+			i++
+		case strings.HasPrefix(opt, "--"):
+			// --unrestricted and the --opt=value forms of the above.
+		default:
+			item.args = append(item.args, opt)
+		}
+	}
+	if len(item.args) > 0 {
+		item.title = item.args[0]
+		item.args = item.args[1:]
+	}
+	g.menu = append(g.menu, item)
+	g.status = true
+}
+
+// flatten evaluates all menu entries defined by g, recursing into submenus.
+func (g *grubInterp) flatten(path []grubPathElem) []grubFlatEntry {
+	var flat []grubFlatEntry
+	for i, item := range g.menu {
+		elemPath := append(append([]grubPathElem(nil), path...), grubPathElem{
+			index: i,
+			title: item.title,
+			id:    item.id,
+		})
+
+		if item.entry != nil {
+			flat = append(flat, grubFlatEntry{path: elemPath, entry: *item.entry})
+			continue
+		}
+
+		c := item.owner.child()
+		c.args = item.args
+		c.argsEnv = item.argsEnv
+		if !item.submenu {
+			c.entry = &Entry{Name: item.title, Type: Elf}
+		}
+		if err := c.run(item.body); err != nil {
+			if _, ok := err.(grubControl); !ok {
+				log.Printf("grub: error evaluating %q: %v", item.title, err)
+				continue
+			}
+		}
+
+		if item.submenu {
+			flat = append(flat, c.flatten(elemPath)...)
+		} else if len(c.entry.Modules) > 0 {
+			flat = append(flat, grubFlatEntry{path: elemPath, entry: *c.entry})
+		}
+	}
+	return flat
+}
+
+// source runs the script at name in the current context.
+func (g *grubInterp) source(name string) (bool, error) {
+	if g.depth >= maxGrubDepth {
+		return false, nil
+	}
+	contents, err := g.read(name)
+	if err != nil {
+		return false, nil
+	}
+	nodes, err := parseGrub(string(contents))
+	if err != nil {
+		log.Printf("grub: %s: %v", name, err)
+		return false, nil
+	}
+	g.depth++
+	defer func() { g.depth-- }()
+	return true, g.run(nodes)
+}
+
+// configfile runs the script at name in a new context. Its menu entries are
+// added to the current menu. If it is the first to define any entries, its
+// default is used too.
+func (g *grubInterp) configfile(name string) bool {
+	if g.depth >= maxGrubDepth {
+		return false
+	}
+	contents, err := g.read(name)
+	if err != nil {
+		return false
+	}
+	nodes, err := parseGrub(string(contents))
+	if err != nil {
+		log.Printf("grub: %s: %v", name, err)
+		return false
+	}
+	c := g.child()
+	if err := c.run(nodes); err != nil {
+		if _, ok := err.(grubControl); !ok {
+			log.Printf("grub: %s: %v", name, err)
+		}
+	}
+	if len(g.menu) == 0 && c.vars["default"] != "" {
+		g.vars["default"] = c.vars["default"]
+		g.vars["saved_entry"] = c.vars["saved_entry"]
+	}
+	g.menu = append(g.menu, c.menu...)
+	return true
+}
+
+// loadEnv implements the load_env command.
+func (g *grubInterp) loadEnv(args []string) bool {
+	file := filepath.Join(g.vars["prefix"], "grubenv")
+	var only []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-f" || arg == "--file") && i+1 < len(args):
+			i++
+			file = args[i]
+		case strings.HasPrefix(arg, "--file="):
+			file = strings.TrimPrefix(arg, "--file=")
+		case arg == "-s" || arg == "--skip-sig":
+		default:
+			only = append(only, arg)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(g.path(file))
+	if err != nil {
+		return false
+	}
+	env, err := ParseGrubEnv(contents)
+	if err != nil {
+		return false
+	}
+	if len(only) > 0 {
+		for _, name := range only {
+			if val, ok := env[name]; ok {
+				g.set(name, val, true)
+			}
+		}
+		return true
+	}
+	for name, val := range env {
+		g.set(name, val, true)
+	}
+	return true
+}
+
+// blscfg implements Fedora's blscfg command, which adds the BootLoaderSpec
+// entries of the boot partition to the menu.
+func (g *grubInterp) blscfg() bool {
+	dirs := blsLocations
+	if dir := g.vars["blsdir"]; dir != "" {
+		dirs = []string{strings.TrimPrefix(stripGrubDevice(dir), "/")}
+	}
+	for _, dir := range dirs {
+		entries, err := readBLSEntries(filepath.Join(g.mountPath, dir))
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		for _, b := range entries {
+			e, err := b.Entry(g.vars)
+			if err != nil {
+				continue
+			}
+			for i, m := range e.Modules {
+				e.Modules[i].Path = blsPath(g.mountPath, dir, m.Path)
+			}
+			e.File = filepath.Join("/", dir, b.ID+".conf")
+			e.EnvCmdline = b.usesVars(func(name string) bool { return g.env[name] })
+			g.menu = append(g.menu, &grubMenuItem{
+				title: e.Name,
+				id:    b.ID,
+				entry: e,
+				owner: g,
+			})
+		}
+		return true
+	}
+	return false
+}
+
+// search implements the search family of commands. Only --file searches can
+// be checked; UUIDs and labels cannot be resolved and are assumed to be the
+// mounted device.
+func (g *grubInterp) search(cmd string, args []string) bool {
+	mode := strings.TrimPrefix(cmd, "search.")
+	variable := ""
+	var keys []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--set" || arg == "-s":
+			variable = "root"
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && len(args) > i+2 {
+				i++
+				variable = args[i]
+			}
+		case strings.HasPrefix(arg, "--set="):
+			variable = strings.TrimPrefix(arg, "--set=")
+		case arg == "--file" || arg == "-f":
+			mode = "file"
+		case arg == "--fs-uuid" || arg == "-u":
+			mode = "fs_uuid"
+		case arg == "--label" || arg == "-l":
+			mode = "fs_label"
+		case strings.HasPrefix(arg, "-"):
+			// --no-floppy, --hint*, etc.
+		default:
+			keys = append(keys, arg)
+		}
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	if mode == "file" {
+		if _, err := os.Stat(g.path(keys[0])); err != nil {
+			return false
+		}
+	}
+	if variable != "" {
+		// The device name is meaningless once mounted; paths are
+		// resolved against the mount point regardless.
+		g.set(variable, "", false)
+	}
+	return true
+}
+
+func (g *grubInterp) call(name string, args []string) error {
+	if f, ok := g.funcs[name]; ok {
+		if g.depth >= maxGrubDepth {
+			g.status = false
+			return nil
+		}
+		saved, savedEnv := g.args, g.argsEnv
+		g.args, g.argsEnv = args, g.cmdEnv
+		g.depth++
+		err := g.run(f.body)
+		g.depth--
+		g.args, g.argsEnv = saved, savedEnv
+		if c, ok := err.(grubControl); ok && c.cmd == "return" {
+			g.status = c.status
+			return nil
+		}
+		return err
+	}
+
+	status := true
+	switch name {
+	case "set":
+		for _, arg := range args {
+			if i := strings.IndexByte(arg, '='); i > 0 {
+				g.set(arg[:i], arg[i+1:], g.cmdEnv)
+			}
+		}
+	case "unset":
+		for _, arg := range args {
+			delete(g.vars, arg)
+			delete(g.env, arg)
+		}
+	case "true":
+	case "false":
+		status = false
+	case "[":
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			status = false
+		} else {
+			status = g.test(args[:len(args)-1])
+		}
+	case "test":
+		status = g.test(args)
+	case "source", ".":
+		if len(args) == 0 {
+			status = false
+			break
+		}
+		var err error
+		if status, err = g.source(args[0]); err != nil {
+			return err
+		}
+	case "configfile":
+		status = len(args) > 0 && g.configfile(args[0])
+	case "load_env":
+		status = g.loadEnv(args)
+	case "blscfg":
+		status = g.blscfg()
+	case "search", "search.file", "search.fs_uuid", "search.fs_label":
+		status = g.search(name, args)
+	case "return", "break", "continue":
+		c := grubControl{cmd: name, status: g.status}
+		if name == "return" && len(args) > 0 {
+			c.status = args[0] == "0"
+		}
+		return c
+	case "linux", "linux16", "linuxefi", "multiboot", "multiboot2":
+		if g.entry != nil && len(args) > 0 {
+			if strings.HasPrefix(name, "multiboot") {
+				g.entry.Type = Multiboot
+			}
+			g.entry.Modules = append(g.entry.Modules, g.module(args[0], args[1:]))
+			g.entry.EnvCmdline = g.entry.EnvCmdline || g.cmdEnv
+		}
+	case "initrd", "initrd16", "initrdefi":
+		if g.entry != nil {
+			for _, arg := range args {
+				g.entry.Modules = append(g.entry.Modules, g.module(arg, nil))
+			}
+		}
+	case "module", "module2":
+		var filtered []string
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "--nounzip") {
+				filtered = append(filtered, arg)
+			}
+		}
+		if g.entry != nil && len(filtered) > 0 {
+			g.entry.Modules = append(g.entry.Modules, g.module(filtered[0], filtered[1:]))
+			g.entry.EnvCmdline = g.entry.EnvCmdline || g.cmdEnv
+		}
+	default:
+		// Everything else (insmod, echo, save_env, terminal_output,
+		// ...) only affects GRUB's own state and is a no-op here.
+	}
+	g.status = status
+	return nil
+}
+
+// module builds a Module whose path is relative to the mount point.
+func (g *grubInterp) module(path string, args []string) Module {
+	path = stripGrubDevice(path)
+	if !strings.HasPrefix(path, "/") {
+		path = filepath.Join(g.configDir, path)
+	}
+	return Module{
+		Path:   filepath.Clean(path),
+		Params: grubCmdline(args),
+	}
+}
+
+// grubCmdline joins arguments the way GRUB's linux command builds a kernel
+// command line: empty arguments are dropped and arguments containing spaces
+// are quoted.
+func grubCmdline(args []string) string {
+	var params []string
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		if strings.ContainsAny(arg, " \t") {
+			arg = `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+		}
+		params = append(params, arg)
+	}
+	return strings.Join(params, " ")
+}
+
+// test implements the test and [ commands.
+func (g *grubInterp) test(args []string) bool {
+	t := &grubTest{g: g, args: args}
+	result := t.or()
+	return result && t.pos == len(t.args)
+}
+
+type grubTest struct {
+	g    *grubInterp
+	args []string
+	pos  int
+}
+
+func (t *grubTest) peek() string {
+	if t.pos < len(t.args) {
+		return t.args[t.pos]
+	}
+	return ""
+}
+
+func (t *grubTest) or() bool {
+	result := t.and()
+	for t.pos < len(t.args) && t.peek() == "-o" {
+		t.pos++
+		// Evaluate both sides so the position always advances.
+		rhs := t.and()
+		result = result || rhs
+	}
+	return result
+}
+
+func (t *grubTest) and() bool {
+	result := t.not()
+	for t.pos < len(t.args) && t.peek() == "-a" {
+		t.pos++
+		rhs := t.not()
+		result = result && rhs
+	}
+	return result
+}
+
+func (t *grubTest) not() bool {
+	if t.pos < len(t.args) && t.peek() == "!" {
+		t.pos++
+		return !t.not()
+	}
+	return t.primary()
+}
+
+func (t *grubTest) primary() bool {
+	rest := t.args[t.pos:]
+	if len(rest) == 0 {
+		return false
+	}
+
+	if rest[0] == "(" {
+		t.pos++
+		result := t.or()
+		if t.peek() == ")" {
+			t.pos++
+		}
+		return result
+	}
+
+	if len(rest) >= 3 {
+		if result, ok := grubCompare(rest[0], rest[1], rest[2]); ok {
+			t.pos += 3
+			return result
+		}
+	}
+
+	if len(rest) >= 2 {
+		switch rest[0] {
+		case "-z":
+			t.pos += 2
+			return rest[1] == ""
+		case "-n":
+			t.pos += 2
+			return rest[1] != ""
+		case "-e", "-f", "-d", "-s":
+			t.pos += 2
+			fi, err := os.Stat(t.g.path(rest[1]))
+			if err != nil {
+				return false
+			}
+			switch rest[0] {
+			case "-f":
+				return fi.Mode().IsRegular()
+			case "-d":
+				return fi.IsDir()
+			case "-s":
+				return fi.Size() > 0
+			}
+			return true
+		}
+	}
+
+	t.pos++
+	return rest[0] != ""
+}
+
+// grubCompare evaluates a binary test operator. ok is false if op is not a
+// binary operator.
+func grubCompare(a, op, b string) (result bool, ok bool) {
+	switch op {
+	case "=", "==":
+		return a == b, true
+	case "!=":
+		return a != b, true
+	case "<":
+		return a < b, true
+	case ">":
+		return a > b, true
+	case "<=":
+		return a <= b, true
+	case ">=":
+		return a >= b, true
+	case "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		x, err1 := strconv.ParseInt(a, 10, 64)
+		y, err2 := strconv.ParseInt(b, 10, 64)
+		if err1 != nil || err2 != nil {
+			return false, true
+		}
+		switch op {
+		case "-eq":
+			return x == y, true
+		case "-ne":
+			return x != y, true
+		case "-lt":
+			return x < y, true
+		case "-le":
+			return x <= y, true
+		case "-gt":
+			return x > y, true
+		}
+		return x >= y, true
+	}
+	return false, false
+}
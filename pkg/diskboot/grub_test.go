@@ -0,0 +1,333 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandGrubWord(t *testing.T) {
+	vars := map[string]string{
+		"a":     "foo",
+		"space": "x y",
+		"empty": "",
+	}
+	lookup := func(name string) string { return vars[name] }
+
+	for _, tt := range []struct {
+		raw  string
+		want []string
+	}{
+		{`plain`, []string{"plain"}},
+		{`$a`, []string{"foo"}},
+		{`${a}bar`, []string{"foobar"}},
+		{`"$a bar"`, []string{"foo bar"}},
+		{`'$a'`, []string{"$a"}},
+		{`$space`, []string{"x", "y"}},
+		{`"$space"`, []string{"x y"}},
+		{`$empty`, nil},
+		{`"$empty"`, []string{""}},
+		{`x$empty`, []string{"x"}},
+		{`a\ b`, []string{"a b"}},
+		{`"a\"b"`, []string{`a"b`}},
+		{`$`, []string{"$"}},
+		{`--id=$a`, []string{"--id=foo"}},
+	} {
+		if got := expandGrubWord(tt.raw, lookup); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandGrubWord(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseGrubErrors(t *testing.T) {
+	for _, script := range []string{
+		`if true; then echo`,
+		`menuentry "x" { linux /vmlinuz`,
+		`echo "unterminated`,
+		`echo 'unterminated`,
+		`for x in a b; echo $x; done`,
+		`}`,
+	} {
+		if _, err := parseGrub(script); err == nil {
+			t.Errorf("parseGrub(%q) = nil, want error", script)
+		}
+	}
+}
+
+// writeFiles creates files with the given contents below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func grubEnvBlock(vars string) string {
+	b := grubEnvHeader + vars
+	for len(b) < 1024 {
+		b += "#"
+	}
+	return b
+}
+
+func TestParseGrubConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		files       map[string]string
+		wantEntries []Entry
+		wantDefault int
+		wantFiles   []string
+	}{
+		{
+			name: "variables and conditionals",
+			files: map[string]string{
+				"boot/grub/grub.cfg": `
+set kernel=/vmlinuz
+if [ "$grub_platform" = "efi" ]; then
+  args=efi
+elif [ -z "$unset" -a -n "$kernel" ]; then
+  args="quiet splash"
+else
+  args=else
+fi
+menuentry 'Linux' --class os $menuentry_id_option 'linux-id' {
+  insmod ext2
+  search --no-floppy --fs-uuid --set=root 1234
+  linux ($root)$kernel root=/dev/sda1 $args
+  initrd /initrd.img /microcode.img
+}
+`,
+			},
+			wantEntries: []Entry{
+				{
+					Name: "Linux",
+					Type: Elf,
+					Modules: []Module{
+						{Path: "/vmlinuz", Params: "root=/dev/sda1 quiet splash"},
+						{Path: "/initrd.img"},
+						{Path: "/microcode.img"},
+					},
+				},
+			},
+			wantDefault: -1,
+		},
+		{
+			name: "functions and loops",
+			files: map[string]string{
+				"boot/grub/grub.cfg": `
+function mkargs {
+  if [ "$1" = "keep" ]; then
+    set handoff=vt.handoff=7
+    return 0
+  fi
+  set handoff=
+}
+for v in 1 2; do
+  menuentry "Linux $v" "$v" {
+    mkargs keep
+    linux /vmlinuz-$1 $handoff
+  }
+done
+menuentry "Firmware setup" {
+  fwsetup
+}
+set default=1
+`,
+			},
+			wantEntries: []Entry{
+				{
+					Name:    "Linux 1",
+					Type:    Elf,
+					Modules: []Module{{Path: "/vmlinuz-1", Params: "vt.handoff=7"}},
+				},
+				{
+					Name:    "Linux 2",
+					Type:    Elf,
+					Modules: []Module{{Path: "/vmlinuz-2", Params: "vt.handoff=7"}},
+				},
+			},
+			wantDefault: 1,
+		},
+		{
+			name: "submenu default by title and id",
+			files: map[string]string{
+				"grub2/grub.cfg": `
+set default="Advanced>recovery-id"
+menuentry "Main" {
+  linux vmlinuz
+}
+submenu "Advanced" {
+  menuentry "Normal" {
+    linux /vmlinuz-a
+  }
+  menuentry "Recovery" --id recovery-id {
+    linux /vmlinuz-a single
+  }
+}
+`,
+			},
+			wantEntries: []Entry{
+				{Name: "Main", Type: Elf, Modules: []Module{{Path: "/grub2/vmlinuz"}}},
+				{Name: "Normal", Type: Elf, Modules: []Module{{Path: "/vmlinuz-a"}}},
+				{Name: "Recovery", Type: Elf, Modules: []Module{{Path: "/vmlinuz-a", Params: "single"}}},
+			},
+			wantDefault: 2,
+		},
+		{
+			name: "saved default from grubenv",
+			files: map[string]string{
+				"boot/grub/grub.cfg": `
+if [ -s $prefix/grubenv ]; then
+  load_env
+fi
+if [ "${next_entry}" ] ; then
+   set default="${next_entry}"
+   set next_entry=
+   save_env next_entry
+else
+   set default="saved"
+fi
+menuentry "A" { linux /a }
+menuentry "B" { linux /b }
+menuentry "C" { linux /c }
+`,
+				"boot/grub/grubenv": grubEnvBlock("saved_entry=B\n"),
+			},
+			wantEntries: []Entry{
+				{Name: "A", Type: Elf, Modules: []Module{{Path: "/a"}}},
+				{Name: "B", Type: Elf, Modules: []Module{{Path: "/b"}}},
+				{Name: "C", Type: Elf, Modules: []Module{{Path: "/c"}}},
+			},
+			wantDefault: 1,
+		},
+		{
+			name: "next_entry from grubenv",
+			files: map[string]string{
+				"boot/grub/grub.cfg": `
+load_env -f /boot/grub/grubenv next_entry
+if [ "${next_entry}" ] ; then
+   set default="${next_entry}"
+fi
+menuentry "A" { linux /a }
+menuentry "B" { linux /b }
+`,
+				"boot/grub/grubenv": grubEnvBlock("next_entry=0\nsaved_entry=B\n"),
+			},
+			wantEntries: []Entry{
+				{Name: "A", Type: Elf, Modules: []Module{{Path: "/a"}}},
+				{Name: "B", Type: Elf, Modules: []Module{{Path: "/b"}}},
+			},
+			wantDefault: 0,
+		},
+		{
+			name: "configfile and source",
+			files: map[string]string{
+				"boot/grub/grub.cfg": `
+search --no-floppy --set=dev --file /EFI/distro/grub.cfg
+set prefix=($dev)/EFI/distro
+configfile $prefix/grub.cfg
+`,
+				"EFI/distro/grub.cfg": `
+source /EFI/distro/vars.cfg
+set default=1
+menuentry "Distro" { linux /vmlinuz $opts }
+menuentry "Distro multiboot" {
+  multiboot2 /xen.gz dom0_mem=1G
+  module2 --nounzip /vmlinuz $opts
+}
+`,
+				"EFI/distro/vars.cfg": `opts="ro quiet"`,
+			},
+			wantEntries: []Entry{
+				{Name: "Distro", Type: Elf, Modules: []Module{{Path: "/vmlinuz", Params: "ro quiet"}}},
+				{Name: "Distro multiboot", Type: Multiboot, Modules: []Module{
+					{Path: "/xen.gz", Params: "dom0_mem=1G"},
+					{Path: "/vmlinuz", Params: "ro quiet"},
+				}},
+			},
+			wantDefault: 1,
+			wantFiles:   []string{"/EFI/distro/grub.cfg", "/EFI/distro/vars.cfg", "/boot/grub/grub.cfg"},
+		},
+		{
+			name: "command line from grubenv",
+			files: map[string]string{
+				"boot/grub/grub.cfg": `
+load_env
+set opts="ro $kernelopts"
+function boot { linux /vmlinuz $1 }
+menuentry "Env" { linux /vmlinuz $kernelopts }
+menuentry "Copied" { linux /vmlinuz $opts }
+menuentry "Argument" { boot "$kernelopts" }
+menuentry "Overridden" {
+  set kernelopts=quiet
+  linux /vmlinuz $kernelopts
+}
+menuentry "Signed" { linux /vmlinuz ro }
+`,
+				"boot/grub/grubenv": grubEnvBlock("kernelopts=init=/bin/sh\n"),
+			},
+			wantEntries: []Entry{
+				{Name: "Env", Type: Elf, Modules: []Module{{Path: "/vmlinuz", Params: "init=/bin/sh"}}, EnvCmdline: true},
+				{Name: "Copied", Type: Elf, Modules: []Module{{Path: "/vmlinuz", Params: "ro init=/bin/sh"}}, EnvCmdline: true},
+				{Name: "Argument", Type: Elf, Modules: []Module{{Path: "/vmlinuz", Params: "init=/bin/sh"}}, EnvCmdline: true},
+				{Name: "Overridden", Type: Elf, Modules: []Module{{Path: "/vmlinuz", Params: "quiet"}}},
+				{Name: "Signed", Type: Elf, Modules: []Module{{Path: "/vmlinuz", Params: "ro"}}},
+			},
+			wantDefault: -1,
+			wantFiles:   []string{"/boot/grub/grub.cfg"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "grub-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeFiles(t, dir, tt.files)
+
+			configs := FindConfigs(dir)
+			if len(configs) != 1 {
+				t.Fatalf("FindConfigs(%q) returned %d configs, want 1", dir, len(configs))
+			}
+			if got := configs[0].Entries; !reflect.DeepEqual(got, tt.wantEntries) {
+				t.Errorf("Entries = %#v, want %#v", got, tt.wantEntries)
+			}
+			if got := configs[0].DefaultEntry; got != tt.wantDefault {
+				t.Errorf("DefaultEntry = %d, want %d", got, tt.wantDefault)
+			}
+			if got := configs[0].Files; tt.wantFiles != nil && !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("Files = %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestParseGrubEnv(t *testing.T) {
+	env, err := ParseGrubEnv([]byte(grubEnvBlock("saved_entry=Ubuntu>Recovery\nmulti=a\\\nb\nback=c\\\\d\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"saved_entry": "Ubuntu>Recovery",
+		"multi":       "a\nb",
+		"back":        `c\d`,
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("ParseGrubEnv = %q, want %q", env, want)
+	}
+
+	if _, err := ParseGrubEnv([]byte("saved_entry=0\n")); err == nil {
+		t.Errorf("ParseGrubEnv without header succeeded, want error")
+	}
+}
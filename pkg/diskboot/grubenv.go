@@ -0,0 +1,100 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// grubEnvHeader is the first line of every GRUB environment block.
+const grubEnvHeader = "# GRUB Environment Block\n"
+
+// grubEnvSize is the size of the blocks grub-editenv creates. GRUB itself
+// can only rewrite a block in place, so an existing block keeps its size.
+const grubEnvSize = 1024
+
+// ParseGrubEnv parses a GRUB environment block as written by grub-editenv
+// and the save_env command.
+//
+// The block is a list of name=value lines padded to a fixed size with '#'.
+// Backslashes and newlines in values are escaped with a backslash.
+func ParseGrubEnv(b []byte) (map[string]string, error) {
+	if !bytes.HasPrefix(b, []byte(grubEnvHeader)) {
+		return nil, fmt.Errorf("invalid GRUB environment block: missing header")
+	}
+	env := make(map[string]string)
+
+	r := bufio.NewReader(bytes.NewReader(b[len(grubEnvHeader):]))
+	for {
+		line, err := readGrubEnvLine(r)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && kv[0] != "" {
+				env[kv[0]] = kv[1]
+			}
+		}
+		if err != nil {
+			return env, nil
+		}
+	}
+}
+
+// readGrubEnvLine reads one line, unescaping "\\" and "\<newline>".
+func readGrubEnvLine(r *bufio.Reader) (string, error) {
+	var line strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return line.String(), err
+		}
+		switch c {
+		case '\n':
+			return line.String(), nil
+		case '\\':
+			c, err = r.ReadByte()
+			if err != nil {
+				return line.String(), err
+			}
+		}
+		line.WriteByte(c)
+	}
+}
+
+// FormatGrubEnv encodes env as a GRUB environment block of size bytes.
+// Variables are written sorted by name.
+func FormatGrubEnv(env map[string]string, size int) ([]byte, error) {
+	var names []string
+	for name := range env {
+		if name == "" || strings.ContainsAny(name, "=\n") {
+			return nil, fmt.Errorf("invalid GRUB environment variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(grubEnvHeader)
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		for _, c := range []byte(env[name]) {
+			if c == '\\' || c == '\n' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('\n')
+	}
+	if b.Len() > size {
+		return nil, fmt.Errorf("GRUB environment needs %d bytes, more than the block size of %d", b.Len(), size)
+	}
+	for b.Len() < size {
+		b.WriteByte('#')
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,582 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains the lexer and parser for the GRUB2 script language as
+// used by grub.cfg files. The grammar is a small subset of the Bourne shell:
+// simple commands, if/elif/else, for, while/until, functions, and the GRUB
+// specific menuentry and submenu blocks.
+//
+// Words are kept in their raw, quoted form by the lexer and are only
+// expanded when the command is evaluated, since variable values depend on
+// the commands that ran before.
+
+type grubTokenKind int
+
+const (
+	grubWord grubTokenKind = iota
+	grubNewline
+	grubSemicolon
+	grubLBrace
+	grubRBrace
+	grubEOF
+)
+
+type grubToken struct {
+	kind grubTokenKind
+	val  string
+	line int
+}
+
+func (t grubToken) String() string {
+	switch t.kind {
+	case grubNewline:
+		return "newline"
+	case grubSemicolon:
+		return "';'"
+	case grubLBrace:
+		return "'{'"
+	case grubRBrace:
+		return "'}'"
+	case grubEOF:
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+// lexGrub splits a GRUB script into tokens.
+func lexGrub(s string) ([]grubToken, error) {
+	var toks []grubToken
+	line := 1
+	emit := func(kind grubTokenKind, val string) {
+		toks = append(toks, grubToken{kind: kind, val: val, line: line})
+	}
+
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			// Line continuation.
+			i += 2
+			line++
+		case c == '\n':
+			emit(grubNewline, "")
+			line++
+			i++
+		case c == ';':
+			emit(grubSemicolon, "")
+			i++
+		case c == '{':
+			emit(grubLBrace, "")
+			i++
+		case c == '}':
+			emit(grubRBrace, "")
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		default:
+			start := line
+			var w strings.Builder
+			var err error
+			if i, err = lexGrubWord(s, i, &w, &line); err != nil {
+				return nil, err
+			}
+			toks = append(toks, grubToken{kind: grubWord, val: w.String(), line: start})
+		}
+	}
+	emit(grubEOF, "")
+	return toks, nil
+}
+
+// lexGrubWord reads one raw word starting at s[i] into w and returns the
+// index just past it. Quotes and escapes are preserved for expansion.
+func lexGrubWord(s string, i int, w *strings.Builder, line *int) (int, error) {
+	for i < len(s) {
+		c := s[i]
+		switch c {
+		case ' ', '\t', '\r', '\n', ';', '{', '}':
+			return i, nil
+
+		case '\\':
+			if i+1 >= len(s) {
+				w.WriteByte(c)
+				return i + 1, nil
+			}
+			if s[i+1] == '\n' {
+				*line++
+			} else {
+				w.WriteString(s[i : i+2])
+			}
+			i += 2
+
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return 0, fmt.Errorf("line %d: unterminated single quote", *line)
+			}
+			q := s[i : i+end+2]
+			*line += strings.Count(q, "\n")
+			w.WriteString(q)
+			i += end + 2
+
+		case '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return 0, fmt.Errorf("line %d: unterminated double quote", *line)
+			}
+			q := s[i : j+1]
+			*line += strings.Count(q, "\n")
+			w.WriteString(q)
+			i = j + 1
+
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				end := strings.IndexByte(s[i:], '}')
+				if end < 0 {
+					return 0, fmt.Errorf("line %d: unterminated variable reference", *line)
+				}
+				w.WriteString(s[i : i+end+1])
+				i += end + 1
+			} else {
+				w.WriteByte(c)
+				i++
+			}
+
+		default:
+			w.WriteByte(c)
+			i++
+		}
+	}
+	return i, nil
+}
+
+// grubNode is a parsed GRUB script command.
+type grubNode interface{}
+
+// grubSimple is a simple command and its raw arguments, e.g. `linux /vmlinuz
+// root=/dev/sda1`.
+type grubSimple struct {
+	words []string
+	line  int
+}
+
+// grubIf is an if/elif/else/fi statement. conds[i] guards bodies[i].
+type grubIf struct {
+	conds    [][]grubNode
+	bodies   [][]grubNode
+	elseBody []grubNode
+}
+
+// grubFor is a `for name in words; do body; done` loop.
+type grubFor struct {
+	name  string
+	words []string
+	body  []grubNode
+}
+
+// grubWhile is a while or until loop.
+type grubWhile struct {
+	until bool
+	cond  []grubNode
+	body  []grubNode
+}
+
+// grubFunction is a function definition.
+type grubFunction struct {
+	name string
+	body []grubNode
+}
+
+// grubMenu is a menuentry or submenu definition. The body is only evaluated
+// once the whole script has run, just like GRUB only runs it once the entry
+// is selected.
+type grubMenu struct {
+	submenu bool
+	words   []string
+	body    []grubNode
+}
+
+type grubParser struct {
+	toks []grubToken
+	pos  int
+}
+
+// parseGrub parses a GRUB script into a list of commands.
+func parseGrub(s string) ([]grubNode, error) {
+	toks, err := lexGrub(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &grubParser{toks: toks}
+	nodes, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != grubEOF {
+		return nil, fmt.Errorf("line %d: unexpected %v", t.line, t)
+	}
+	return nodes, nil
+}
+
+func (p *grubParser) peek() grubToken {
+	return p.toks[p.pos]
+}
+
+func (p *grubParser) next() grubToken {
+	t := p.toks[p.pos]
+	if t.kind != grubEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword returns true if t is one of the reserved words that terminate a
+// command list.
+func isKeyword(t grubToken, words ...string) bool {
+	if t.kind != grubWord {
+		return false
+	}
+	for _, w := range words {
+		if t.val == w {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *grubParser) expectKeyword(word string) error {
+	if t := p.next(); !isKeyword(t, word) {
+		return fmt.Errorf("line %d: expected %q, got %v", t.line, word, t)
+	}
+	return nil
+}
+
+func (p *grubParser) expect(kind grubTokenKind) error {
+	if t := p.next(); t.kind != kind {
+		return fmt.Errorf("line %d: expected %v, got %v", t.line, grubToken{kind: kind}, t)
+	}
+	return nil
+}
+
+func (p *grubParser) skipSeparators() {
+	for t := p.peek(); t.kind == grubNewline || t.kind == grubSemicolon; t = p.peek() {
+		p.next()
+	}
+}
+
+// parseList parses commands until a closing brace, the end of the file, or
+// one of the reserved words that end a compound command.
+func (p *grubParser) parseList() ([]grubNode, error) {
+	var nodes []grubNode
+	for {
+		p.skipSeparators()
+		t := p.peek()
+		if t.kind == grubEOF || t.kind == grubRBrace ||
+			isKeyword(t, "then", "elif", "else", "fi", "do", "done") {
+			return nodes, nil
+		}
+		n, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+// parseWords collects words up to the end of the current command.
+func (p *grubParser) parseWords() []string {
+	var words []string
+	for t := p.peek(); t.kind == grubWord; t = p.peek() {
+		words = append(words, p.next().val)
+	}
+	return words
+}
+
+func (p *grubParser) parseBlock() ([]grubNode, error) {
+	if err := p.expect(grubLBrace); err != nil {
+		return nil, err
+	}
+	body, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(grubRBrace); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (p *grubParser) parseCommand() (grubNode, error) {
+	t := p.peek()
+	if t.kind != grubWord {
+		return nil, fmt.Errorf("line %d: unexpected %v", t.line, t)
+	}
+
+	switch t.val {
+	case "if":
+		return p.parseIf()
+
+	case "for":
+		p.next()
+		name := p.next()
+		if name.kind != grubWord {
+			return nil, fmt.Errorf("line %d: expected loop variable, got %v", name.line, name)
+		}
+		if err := p.expectKeyword("in"); err != nil {
+			return nil, err
+		}
+		words := p.parseWords()
+		p.skipSeparators()
+		if err := p.expectKeyword("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("done"); err != nil {
+			return nil, err
+		}
+		return &grubFor{name: name.val, words: words, body: body}, nil
+
+	case "while", "until":
+		p.next()
+		cond, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("done"); err != nil {
+			return nil, err
+		}
+		return &grubWhile{until: t.val == "until", cond: cond, body: body}, nil
+
+	case "function":
+		p.next()
+		name := p.next()
+		if name.kind != grubWord {
+			return nil, fmt.Errorf("line %d: expected function name, got %v", name.line, name)
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &grubFunction{name: name.val, body: body}, nil
+
+	case "menuentry", "submenu":
+		p.next()
+		words := p.parseWords()
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &grubMenu{submenu: t.val == "submenu", words: words, body: body}, nil
+	}
+
+	return &grubSimple{words: p.parseWords(), line: t.line}, nil
+}
+
+func (p *grubParser) parseIf() (grubNode, error) {
+	n := &grubIf{}
+	// The first iteration consumes "if", subsequent ones "elif".
+	for p.next(); ; {
+		cond, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		n.conds = append(n.conds, cond)
+		n.bodies = append(n.bodies, body)
+
+		t := p.next()
+		switch {
+		case isKeyword(t, "elif"):
+			continue
+		case isKeyword(t, "else"):
+			if n.elseBody, err = p.parseList(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("fi"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case isKeyword(t, "fi"):
+			return n, nil
+		default:
+			return nil, fmt.Errorf("line %d: expected \"fi\", got %v", t.line, t)
+		}
+	}
+}
+
+// expandGrubWord performs variable expansion and quote removal on a raw
+// word. Unquoted variable expansions are split on whitespace, so a word may
+// expand to zero or more fields.
+func expandGrubWord(raw string, lookup func(string) string) []string {
+	var (
+		fields []string
+		buf    strings.Builder
+		inWord bool
+	)
+	flush := func() {
+		if inWord {
+			fields = append(fields, buf.String())
+		}
+		buf.Reset()
+		inWord = false
+	}
+
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch c {
+		case '\\':
+			if i+1 < len(raw) {
+				buf.WriteByte(raw[i+1])
+			}
+			inWord = true
+			i += 2
+
+		case '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end < 0 {
+				end = len(raw) - i - 1
+			}
+			buf.WriteString(raw[i+1 : i+1+end])
+			inWord = true
+			i += end + 2
+
+		case '"':
+			inWord = true
+			for i++; i < len(raw) && raw[i] != '"'; {
+				switch raw[i] {
+				case '\\':
+					if i+1 < len(raw) && strings.IndexByte("$\"\\", raw[i+1]) >= 0 {
+						buf.WriteByte(raw[i+1])
+						i += 2
+					} else {
+						buf.WriteByte('\\')
+						i++
+					}
+				case '$':
+					name, n := grubVarName(raw[i:])
+					if n == 0 {
+						buf.WriteByte('$')
+						i++
+					} else {
+						buf.WriteString(lookup(name))
+						i += n
+					}
+				default:
+					buf.WriteByte(raw[i])
+					i++
+				}
+			}
+			i++
+
+		case '$':
+			name, n := grubVarName(raw[i:])
+			if n == 0 {
+				buf.WriteByte('$')
+				inWord = true
+				i++
+				break
+			}
+			i += n
+
+			val := lookup(name)
+			if val == "" {
+				break
+			}
+			if strings.IndexAny(val[:1], " \t\n") == 0 {
+				flush()
+			}
+			for j, f := range strings.Fields(val) {
+				if j > 0 {
+					flush()
+				}
+				buf.WriteString(f)
+				inWord = true
+			}
+			if strings.IndexAny(val[len(val)-1:], " \t\n") == 0 {
+				flush()
+			}
+
+		default:
+			buf.WriteByte(c)
+			inWord = true
+			i++
+		}
+	}
+	flush()
+	return fields
+}
+
+// grubVarName parses a variable reference at the start of s, which must
+// begin with '$'. It returns the variable name and the length of the
+// reference, or 0 if s does not start with a valid reference.
+func grubVarName(s string) (string, int) {
+	if len(s) < 2 {
+		return "", 0
+	}
+	if s[1] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[2:end], end + 1
+	}
+	if strings.IndexByte("0123456789?#@*", s[1]) >= 0 {
+		return s[1:2], 2
+	}
+	n := 1
+	for n < len(s) && isGrubNameChar(s[n]) {
+		n++
+	}
+	if n == 1 {
+		return "", 0
+	}
+	return s[1:n], n
+}
+
+func isGrubNameChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// isGrubName returns true if s is a valid variable name.
+func isGrubName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isGrubNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
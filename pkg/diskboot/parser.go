@@ -7,7 +7,6 @@ package diskboot
 import (
 	"log"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -15,16 +14,15 @@ type parserState int
 
 const (
 	search   parserState = iota // searching for a valid entry
-	grub                        // building a grub entry
+	grub                        // a GRUB2 script, see ParseGrubConfig
 	syslinux                    // building a syslinux entry
 )
 
 type parser struct {
-	state        parserState
-	config       *Config
-	entry        *Entry
-	defaultName  string
-	defaultIndex int
+	state       parserState
+	config      *Config
+	entry       *Entry
+	defaultName string
 }
 
 func (p *parser) parseSearch(line string) {
@@ -38,18 +36,6 @@ func (p *parser) parseSearch(line string) {
 	var name string
 
 	switch strings.ToUpper(f[0]) {
-	case "MENUENTRY": // grub
-		p.state = grub
-		newEntry = true
-		repNames := strings.Replace(trimmedLine, "'", "\"", -1)
-		names := strings.Split(repNames, "\"")
-		if len(names) > 1 {
-			name = names[1]
-		}
-	case "SET": // grub
-		if len(f) > 1 {
-			p.parseSearchHandleSet(f[1])
-		}
 	case "LABEL": // syslinux
 		p.state = syslinux
 		newEntry = true
@@ -74,54 +60,6 @@ func (p *parser) parseSearch(line string) {
 	}
 }
 
-func (p *parser) parseSearchHandleSet(val string) {
-	val = strings.Replace(val, "\"", "", -1)
-	expr := strings.Split(val, "=")
-	if len(expr) != 2 {
-		return
-	}
-
-	switch expr[0] {
-	case "default":
-		index, err := strconv.Atoi(expr[1])
-		if err == nil {
-			p.defaultIndex = index
-		}
-	default:
-		// TODO: handle variables when grub conditionals are implemented
-		return
-	}
-}
-
-func (p *parser) parseGrubEntry(line string) {
-	trimmedLine := strings.TrimSpace(line)
-	f := strings.Fields(trimmedLine)
-	if len(f) == 0 {
-		return
-	}
-
-	switch f[0] {
-	case "}":
-		p.finishEntry()
-	case "multiboot":
-		p.entry.Type = Multiboot
-		p.entry.Modules = append(p.entry.Modules, NewModule(f[1], f[2:]))
-	case "module":
-		var filteredParams []string
-		for _, param := range f {
-			if param != "--nounzip" {
-				filteredParams = append(filteredParams, param)
-			}
-		}
-		p.entry.Modules = append(p.entry.Modules,
-			NewModule(filteredParams[1], filteredParams[2:]))
-	case "linux":
-		p.entry.Modules = append(p.entry.Modules, NewModule(f[1], f[2:]))
-	case "initrd":
-		p.entry.Modules = append(p.entry.Modules, NewModule(f[1], nil))
-	}
-}
-
 func (p *parser) parseSyslinuxEntry(line string) {
 	trimmedLine := strings.TrimSpace(line)
 	if len(trimmedLine) == 0 {
@@ -231,8 +169,6 @@ func (p *parser) parseLines(lines []string) {
 		switch p.state {
 		case search:
 			p.parseSearch(line)
-		case grub:
-			p.parseGrubEntry(line)
 		case syslinux:
 			p.parseSyslinuxEntry(line)
 		}
@@ -244,7 +180,8 @@ func (p *parser) parseLines(lines []string) {
 }
 
 // ParseConfig attempts to construct a valid boot Config from the location
-// and lines contents passed in.
+// and lines contents of a syslinux config passed in. GRUB2 configs are run
+// by ParseGrubConfig instead.
 func ParseConfig(mountPath, configPath string, lines []string) *Config {
 	p := &parser{
 		config: &Config{
@@ -252,7 +189,6 @@ func ParseConfig(mountPath, configPath string, lines []string) *Config {
 			ConfigPath:   configPath,
 			DefaultEntry: -1,
 		},
-		defaultIndex: -1,
 	}
 	p.parseLines(lines)
 
@@ -263,9 +199,6 @@ func ParseConfig(mountPath, configPath string, lines []string) *Config {
 			}
 		}
 	}
-	if p.defaultIndex >= 0 && len(p.config.Entries) > p.defaultIndex {
-		p.config.DefaultEntry = p.defaultIndex
-	}
 
 	return p.config
 }
@@ -0,0 +1,185 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/gpgv"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// SignatureSuffixes are appended to the path of a file to find its detached
+// signatures.
+var SignatureSuffixes = []string{".sig", ".asc"}
+
+// rawAlgorithms are the algorithms a raw signature may be made with.
+var rawAlgorithms = []string{
+	boot.AlgoEd25519,
+	boot.AlgoRSAPKCS1v15SHA256,
+	boot.AlgoRSAPSSSHA256,
+	boot.AlgoECDSAP256SHA256,
+	boot.AlgoECDSAP384SHA384,
+}
+
+// ErrUnsignedCmdline is returned when a kernel command line is added to that
+// of a verified entry.
+var ErrUnsignedCmdline = errors.New("a command line added to a verified entry is not signed")
+
+// ErrEnvCmdline is returned for entries whose command line takes variables
+// from the GRUB environment block, which is not signed.
+var ErrEnvCmdline = errors.New("the command line takes variables from the unsigned GRUB environment block")
+
+// Verifier checks that the files of boot entries, kernels, initrds, modules
+// and device trees, have a valid detached signature next to them, in a file
+// with one of SignatureSuffixes.
+//
+// A signature is either an OpenPGP signature, binary or ASCII armored, as
+// made by gpg --detach-sign, or a raw signature of the file, as made by
+// openssl dgst -sha256 -sign or openssl pkeyutl -sign -rawin for Ed25519.
+//
+// The signatures of the files do not cover the kernel command line, with
+// which a signed kernel can still be made to run init=/bin/sh. It is in the
+// scripts of the config and the BLS entry file that define the entry,
+// which VerifyConfig checks the signatures of. The GRUB environment block
+// cannot be signed since GRUB rewrites it, so VerifyConfig rejects entries
+// whose command line takes variables from it, like $kernelopts.
+type Verifier struct {
+	// Keyring holds the keys trusted for raw signatures.
+	Keyring *boot.Keyring
+
+	// PGPKeys holds the keys trusted for OpenPGP signatures.
+	PGPKeys []*packet.PublicKey
+
+	// Warn makes files without a valid signature load anyway. Their
+	// errors are logged.
+	Warn bool
+}
+
+// verifySignature checks sig, of either kind, of content.
+func (v *Verifier) verifySignature(content, sig []byte) error {
+	var err error = boot.ErrUntrusted
+	if len(v.PGPKeys) > 0 {
+		if err = gpgv.VerifyDetachedSignatureKeys(v.PGPKeys, bytes.NewReader(content), bytes.NewReader(sig)); err == nil {
+			return nil
+		}
+	}
+	if v.Keyring != nil {
+		sigs := make([]boot.Signature, 0, len(rawAlgorithms))
+		for _, algo := range rawAlgorithms {
+			sigs = append(sigs, boot.Signature{Algorithm: algo, Signature: sig})
+		}
+		if v.Keyring.Verify(content, sigs) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Verify checks that path has a valid signature of content. It returns
+// boot.ErrNotSigned if path has no signature.
+func (v *Verifier) Verify(path string, content []byte) error {
+	err := boot.ErrNotSigned
+	for _, suffix := range SignatureSuffixes {
+		sig, rerr := ioutil.ReadFile(path + suffix)
+		if os.IsNotExist(rerr) {
+			continue
+		}
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+		if err = v.verifySignature(content, sig); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// open reads and verifies a file relative to mountPath.
+func (v *Verifier) open(mountPath, path string) (io.ReaderAt, error) {
+	fullPath := filepath.Join(mountPath, path)
+	content, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Verify(fullPath, content); err != nil {
+		if !v.Warn {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		log.Printf("Loading %s anyway: %v", path, err)
+	}
+	return bytes.NewReader(content), nil
+}
+
+// Files returns the paths of the files the entry loads, relative to the
+// mount path.
+func (e *Entry) Files() []string {
+	var files []string
+	for _, m := range e.Modules {
+		files = append(files, m.Path)
+	}
+	if e.DeviceTree != "" {
+		files = append(files, e.DeviceTree)
+	}
+	return files
+}
+
+// VerifyConfig checks that the files of c that define e, and with them e's
+// command line, have a valid signature: every script of c and the BLS
+// entry file of e, or else the config file. It returns ErrEnvCmdline if
+// e's command line takes variables from the GRUB environment block.
+func (v *Verifier) VerifyConfig(c *Config, e *Entry) error {
+	if e.EnvCmdline {
+		return ErrEnvCmdline
+	}
+	var paths []string
+	for _, f := range c.Files {
+		paths = append(paths, filepath.Join(c.MountPath, f))
+	}
+	if e.File != "" {
+		paths = append(paths, filepath.Join(c.MountPath, e.File))
+	}
+	if len(paths) == 0 {
+		paths = []string{c.ConfigPath}
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = v.Verify(path, content)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// VerifyEntry checks that every file of e, relative to mountPath, has a
+// valid signature. It returns the error of the first one that does not.
+func (v *Verifier) VerifyEntry(mountPath string, e *Entry) error {
+	if len(e.Modules) < 1 {
+		return fmt.Errorf("missing kernel")
+	}
+	for _, path := range e.Files() {
+		fullPath := filepath.Join(mountPath, path)
+		content, err := ioutil.ReadFile(fullPath)
+		if err == nil {
+			err = v.Verify(fullPath, content)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
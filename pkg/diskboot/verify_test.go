@@ -0,0 +1,170 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package diskboot
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/uio"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskboot-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := openpgp.NewEntity("boot", "", "boot@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edVerifier, _ := boot.NewVerifier(edPub)
+	ecVerifier, _ := boot.NewVerifier(&ecPriv.PublicKey)
+	v := &Verifier{
+		Keyring: boot.NewKeyring(edVerifier, ecVerifier),
+		PGPKeys: []*packet.PublicKey{entity.PrimaryKey},
+	}
+
+	// The kernel has an Ed25519 signature, the initrd an ECDSA one, and
+	// the device tree an ASCII armored OpenPGP one.
+	writeFile(t, filepath.Join(dir, "vmlinuz"), "kernel")
+	writeFile(t, filepath.Join(dir, "vmlinuz.sig"), string(ed25519.Sign(edPriv, []byte("kernel"))))
+	writeFile(t, filepath.Join(dir, "initrd"), "initrd")
+	ecSigner, _ := boot.NewSigner(ecPriv)
+	sig, err := ecSigner.Sign([]byte("initrd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "initrd.sig"), string(sig))
+	writeFile(t, filepath.Join(dir, "dtb"), "device tree")
+	var asc bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&asc, entity, bytes.NewReader([]byte("device tree")), nil); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "dtb.asc"), asc.String())
+	writeFile(t, filepath.Join(dir, "unsigned"), "unsigned")
+	writeFile(t, filepath.Join(dir, "tampered"), "tampered")
+	writeFile(t, filepath.Join(dir, "tampered.sig"), string(ed25519.Sign(edPriv, []byte("original"))))
+
+	signed := &Entry{
+		Modules:    []Module{{Path: "/vmlinuz", Params: "ro"}, {Path: "/initrd"}},
+		DeviceTree: "/dtb",
+	}
+	if err := v.VerifyEntry(dir, signed); err != nil {
+		t.Errorf("VerifyEntry() = %v, want nil", err)
+	}
+	img, err := signed.VerifiedOSImage(dir, "", v)
+	if err != nil {
+		t.Fatalf("VerifiedOSImage() = %v", err)
+	}
+	li := img.(*boot.LinuxImage)
+	for _, f := range []struct {
+		name string
+		r    io.ReaderAt
+		want string
+	}{
+		{"kernel", li.Kernel, "kernel"},
+		{"initrd", li.Initrd, "initrd"},
+		{"device tree", li.DTB, "device tree"},
+	} {
+		if b, err := uio.ReadAll(f.r); err != nil || string(b) != f.want {
+			t.Errorf("%s is %q, %v, want %q", f.name, b, err, f.want)
+		}
+	}
+
+	for _, e := range []*Entry{
+		{Modules: []Module{{Path: "/vmlinuz"}, {Path: "/unsigned"}}},
+		{Modules: []Module{{Path: "/tampered"}}},
+		{Modules: []Module{{Path: "/missing"}}},
+		{},
+	} {
+		if err := v.VerifyEntry(dir, e); err == nil {
+			t.Errorf("VerifyEntry(%v) succeeded, want error", e.Modules)
+		}
+		if _, err := e.VerifiedOSImage(dir, "", v); err == nil {
+			t.Errorf("VerifiedOSImage(%v) succeeded, want error", e.Modules)
+		}
+	}
+
+	// The command line is not signed.
+	if _, err := signed.VerifiedOSImage(dir, "init=/bin/sh", v); err != ErrUnsignedCmdline {
+		t.Errorf("VerifiedOSImage() with an appended command line = %v, want %v", err, ErrUnsignedCmdline)
+	}
+
+	// Without the Ed25519 key, the kernel is untrusted.
+	other := &Verifier{Keyring: boot.NewKeyring(ecVerifier)}
+	if err := other.Verify(filepath.Join(dir, "vmlinuz"), []byte("kernel")); err != boot.ErrUntrusted {
+		t.Errorf("Verify() with another key = %v, want %v", err, boot.ErrUntrusted)
+	}
+	if err := other.Verify(filepath.Join(dir, "unsigned"), []byte("unsigned")); err != boot.ErrNotSigned {
+		t.Errorf("Verify() of an unsigned file = %v, want %v", err, boot.ErrNotSigned)
+	}
+
+	// The config file holds the command line of its entries, and BLS
+	// entry files that of theirs.
+	writeFile(t, filepath.Join(dir, "grub.cfg"), "menuentry")
+	writeFile(t, filepath.Join(dir, "grub.cfg.sig"), string(ed25519.Sign(edPriv, []byte("menuentry"))))
+	writeFile(t, filepath.Join(dir, "entry.conf"), "linux /vmlinuz")
+	grub := &Config{MountPath: dir, ConfigPath: filepath.Join(dir, "grub.cfg")}
+	if err := v.VerifyConfig(grub, signed); err != nil {
+		t.Errorf("VerifyConfig() of a signed config = %v, want nil", err)
+	}
+	bls := &Config{MountPath: dir, ConfigPath: dir}
+	if err := v.VerifyConfig(bls, &Entry{File: "/entry.conf"}); err == nil {
+		t.Errorf("VerifyConfig() of an unsigned BLS entry succeeded, want error")
+	}
+	writeFile(t, filepath.Join(dir, "grub.cfg"), "menuentry init=/bin/sh")
+	if err := v.VerifyConfig(grub, signed); err == nil {
+		t.Errorf("VerifyConfig() of a changed config succeeded, want error")
+	}
+
+	// So do the scripts it sources, while the environment block cannot be
+	// signed.
+	writeFile(t, filepath.Join(dir, "grub.cfg"), "menuentry")
+	sourced := &Config{MountPath: dir, ConfigPath: filepath.Join(dir, "grub.cfg"), Files: []string{"/grub.cfg", "/unsigned"}}
+	if err := v.VerifyConfig(sourced, signed); err == nil {
+		t.Errorf("VerifyConfig() of a config sourcing an unsigned script succeeded, want error")
+	}
+	if err := v.VerifyConfig(grub, &Entry{Modules: signed.Modules, EnvCmdline: true}); err != ErrEnvCmdline {
+		t.Errorf("VerifyConfig() of a command line from grubenv = %v, want %v", err, ErrEnvCmdline)
+	}
+
+	// With Warn, unsigned files load anyway.
+	v.Warn = true
+	unsigned := &Entry{Modules: []Module{{Path: "/unsigned"}}}
+	if _, err := unsigned.VerifiedOSImage(dir, "", v); err != nil {
+		t.Errorf("VerifiedOSImage() with Warn = %v, want nil", err)
+	}
+	if err := v.VerifyEntry(dir, unsigned); err == nil {
+		t.Errorf("VerifyEntry() with Warn succeeded, want error")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// typeOPT is the EDNS pseudo record, which has no TTL.
+	typeOPT Type = 41
+
+	// maxTTL bounds how long responses are cached.
+	maxTTL = 24 * time.Hour
+
+	// negativeTTL is how long negative responses without an SOA record
+	// are cached.
+	negativeTTL = time.Minute
+)
+
+type cacheEntry struct {
+	m       *Message
+	stored  time.Time
+	expires time.Time
+}
+
+// Cache caches responses by question. Expired responses are kept, so that
+// they can be used if no server answers, until the cache is full.
+type Cache struct {
+	// Size is the maximum number of cached responses.
+	Size int
+
+	// now returns the current time. Tests set it.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[Question]*cacheEntry
+}
+
+// NewCache returns a cache of at most size responses.
+func NewCache(size int) *Cache {
+	return &Cache{
+		Size:    size,
+		now:     time.Now,
+		entries: make(map[Question]*cacheEntry),
+	}
+}
+
+func cacheKey(q Question) Question {
+	q.Name = strings.ToLower(q.Name)
+	return q
+}
+
+// ttl returns how long m may be cached: the least TTL of its records, or
+// for negative responses, the SOA minimum of RFC 2308.
+func ttl(m *Message) (time.Duration, bool) {
+	if m.Truncated || m.RCode != RCodeSuccess && m.RCode != RCodeNameError {
+		return 0, false
+	}
+	min := maxTTL
+	var any bool
+	for _, section := range [][]Resource{m.Answers, m.Authorities, m.Additionals} {
+		for _, r := range section {
+			if r.Type == typeOPT {
+				continue
+			}
+			any = true
+			t := time.Duration(r.TTL) * time.Second
+			if soa, ok := r.Data.(*SOAData); ok && len(m.Answers) == 0 {
+				if st := time.Duration(soa.MinTTL) * time.Second; st < t {
+					t = st
+				}
+			}
+			if t < min {
+				min = t
+			}
+		}
+	}
+	if !any {
+		if len(m.Answers) == 0 {
+			return negativeTTL, true
+		}
+		return 0, false
+	}
+	return min, min > 0
+}
+
+// Put caches the response m to its question.
+func (c *Cache) Put(m *Message) {
+	if len(m.Questions) != 1 {
+		return
+	}
+	t, ok := ttl(m)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.entries[cacheKey(m.Questions[0])] = &cacheEntry{m: m, stored: now, expires: now.Add(t)}
+	if len(c.entries) > c.Size {
+		c.evict(now)
+	}
+}
+
+// evict removes all expired entries, or if there are none, the one that
+// expires first.
+func (c *Cache) evict(now time.Time) {
+	var first Question
+	var firstExpires time.Time
+	for k, e := range c.entries {
+		if !e.expires.After(now) {
+			delete(c.entries, k)
+			continue
+		}
+		if firstExpires.IsZero() || e.expires.Before(firstExpires) {
+			first, firstExpires = k, e.expires
+		}
+	}
+	if len(c.entries) > c.Size {
+		delete(c.entries, first)
+	}
+}
+
+// age returns a copy of m whose TTLs are reduced by d, but not below zero.
+func age(m *Message, d time.Duration) *Message {
+	sec := uint32(d / time.Second)
+	out := *m
+	for _, section := range []*[]Resource{&out.Answers, &out.Authorities, &out.Additionals} {
+		rs := make([]Resource, len(*section))
+		for i, r := range *section {
+			if r.Type != typeOPT {
+				if r.TTL > sec {
+					r.TTL -= sec
+				} else {
+					r.TTL = 0
+				}
+			}
+			rs[i] = r
+		}
+		*section = rs
+	}
+	return &out
+}
+
+// Get returns the cached response to q, with TTLs reduced by its age, and
+// whether it has expired. It returns nil if there is none.
+func (c *Cache) Get(q Question) (*Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cacheKey(q)]
+	if !ok {
+		return nil, false
+	}
+	now := c.now()
+	return age(e.m, now.Sub(e.stored)), !e.expires.After(now)
+}
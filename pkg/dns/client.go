@@ -0,0 +1,232 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout of a query if Client.Timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+// Client sends queries to DNS servers.
+type Client struct {
+	// Net is "udp" or "tcp". Over UDP, truncated responses are retried
+	// over TCP. Empty means "udp".
+	Net string
+
+	// Timeout is the timeout of each attempt.
+	Timeout time.Duration
+
+	// Retries is the number of times a query that timed out is sent
+	// again over UDP.
+	Retries int
+}
+
+// NewID returns a random message ID.
+func NewID() uint16 {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint16(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// ServerAddr returns server with port 53 unless it has a port.
+func ServerAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+// isResponse returns whether r answers q.
+func isResponse(q, r *Message) bool {
+	if !r.Response || r.ID != q.ID || len(r.Questions) != len(q.Questions) {
+		return false
+	}
+	for i := range q.Questions {
+		a, b := q.Questions[i], r.Questions[i]
+		if a.Type != b.Type || a.Class != b.Class || !strings.EqualFold(a.Name, b.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Exchange sends q to server and returns the response, and how long it
+// took to get it.
+func (c *Client) Exchange(ctx context.Context, q *Message, server string) (*Message, time.Duration, error) {
+	b, err := q.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	server = ServerAddr(server)
+	start := time.Now()
+
+	if c.Net != "tcp" {
+		r, err := c.exchangeUDP(ctx, q, b, server)
+		if err != nil || !r.Truncated {
+			return r, time.Since(start), err
+		}
+	}
+	r, err := c.exchangeTCP(ctx, q, b, server)
+	return r, time.Since(start), err
+}
+
+func (c *Client) exchangeUDP(ctx context.Context, q *Message, b []byte, server string) (*Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer cancelOnDone(ctx, conn)()
+
+	buf := make([]byte, 65535)
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(c.timeout()))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if e, ok := err.(net.Error); ok && e.Timeout() && attempt < c.Retries {
+					break
+				}
+				return nil, err
+			}
+			// Ignore anything that does not answer q, like
+			// late responses to earlier attempts or spoofs.
+			if r, err := Unpack(buf[:n]); err == nil && isResponse(q, r) {
+				return r, nil
+			}
+		}
+	}
+	return nil, errors.New("no response")
+}
+
+func (c *Client) exchangeTCP(ctx context.Context, q *Message, b []byte, server string) (*Message, error) {
+	d := net.Dialer{Timeout: c.timeout()}
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer cancelOnDone(ctx, conn)()
+
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	if err := writeTCP(conn, b); err != nil {
+		return nil, err
+	}
+	rb, err := readTCP(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	r, err := Unpack(rb)
+	if err != nil {
+		return nil, err
+	}
+	if !isResponse(q, r) {
+		return nil, errors.New("response does not match query")
+	}
+	return r, nil
+}
+
+// cancelOnDone interrupts I/O on conn once ctx is done. Call the returned
+// function when done with conn.
+func cancelOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// writeTCP writes a message with the length prefix of DNS over TCP.
+func writeTCP(w io.Writer, b []byte) error {
+	if len(b) > 0xffff {
+		return errors.New("message too long")
+	}
+	_, err := w.Write(append([]byte{byte(len(b) >> 8), byte(len(b))}, b...))
+	return err
+}
+
+// readTCP reads a message with the length prefix of DNS over TCP.
+func readTCP(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ResolvConf is the configuration in /etc/resolv.conf.
+type ResolvConf struct {
+	Nameservers []string
+	Search      []string
+}
+
+// ReadResolvConf reads the nameservers and search domains of a
+// resolv.conf file.
+func ReadResolvConf(path string) (*ResolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rc := &ResolvConf{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			rc.Nameservers = append(rc.Nameservers, fields[1])
+		case "domain", "search":
+			// The last of them wins.
+			rc.Search = fields[1:]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rc, nil
+}
@@ -0,0 +1,422 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dns implements a small DNS client, cache and stub resolver.
+//
+// It packs and unpacks the DNS messages of RFC 1035 with the record types
+// needed to debug name resolution, and passes other records through
+// unchanged.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Type is a resource record type.
+type Type uint16
+
+// Resource record types.
+const (
+	TypeA     Type = 1
+	TypeNS    Type = 2
+	TypeCNAME Type = 5
+	TypeSOA   Type = 6
+	TypePTR   Type = 12
+	TypeMX    Type = 15
+	TypeTXT   Type = 16
+	TypeAAAA  Type = 28
+	TypeSRV   Type = 33
+	TypeANY   Type = 255
+)
+
+var typeNames = map[Type]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypeSOA:   "SOA",
+	TypePTR:   "PTR",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeSRV:   "SRV",
+	TypeANY:   "ANY",
+}
+
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// ParseType parses a type name, like AAAA, or the generic TYPEnnn form of
+// RFC 3597.
+func ParseType(s string) (Type, error) {
+	s = strings.ToUpper(s)
+	for t, name := range typeNames {
+		if name == s {
+			return t, nil
+		}
+	}
+	if strings.HasPrefix(s, "TYPE") {
+		if n, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return Type(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown type %q", s)
+}
+
+// Class is a resource record class.
+type Class uint16
+
+// ClassINET is the Internet class, the only one in use.
+const ClassINET Class = 1
+
+func (c Class) String() string {
+	if c == ClassINET {
+		return "IN"
+	}
+	return fmt.Sprintf("CLASS%d", uint16(c))
+}
+
+// RCode is a response code.
+type RCode uint8
+
+// Response codes.
+const (
+	RCodeSuccess        RCode = 0
+	RCodeFormatError    RCode = 1
+	RCodeServerFailure  RCode = 2
+	RCodeNameError      RCode = 3
+	RCodeNotImplemented RCode = 4
+	RCodeRefused        RCode = 5
+)
+
+var rcodeNames = map[RCode]string{
+	RCodeSuccess:        "NOERROR",
+	RCodeFormatError:    "FORMERR",
+	RCodeServerFailure:  "SERVFAIL",
+	RCodeNameError:      "NXDOMAIN",
+	RCodeNotImplemented: "NOTIMP",
+	RCodeRefused:        "REFUSED",
+}
+
+func (r RCode) String() string {
+	if s, ok := rcodeNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("RCODE%d", uint8(r))
+}
+
+// Header is the header of a message.
+type Header struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              RCode
+}
+
+func (h *Header) flags() uint16 {
+	f := uint16(h.Opcode&0xf)<<11 | uint16(h.RCode&0xf)
+	for _, b := range []struct {
+		set bool
+		bit uint16
+	}{
+		{h.Response, 1 << 15},
+		{h.Authoritative, 1 << 10},
+		{h.Truncated, 1 << 9},
+		{h.RecursionDesired, 1 << 8},
+		{h.RecursionAvailable, 1 << 7},
+	} {
+		if b.set {
+			f |= b.bit
+		}
+	}
+	return f
+}
+
+func (h *Header) setFlags(f uint16) {
+	h.Response = f&(1<<15) != 0
+	h.Opcode = uint8(f>>11) & 0xf
+	h.Authoritative = f&(1<<10) != 0
+	h.Truncated = f&(1<<9) != 0
+	h.RecursionDesired = f&(1<<8) != 0
+	h.RecursionAvailable = f&(1<<7) != 0
+	h.RCode = RCode(f & 0xf)
+}
+
+// Question is a question of a message.
+type Question struct {
+	// Name is a fully qualified domain name, with a trailing dot.
+	Name  string
+	Type  Type
+	Class Class
+}
+
+func (q Question) String() string {
+	return fmt.Sprintf("%s\t%v\t%v", q.Name, q.Class, q.Type)
+}
+
+// Resource is a resource record.
+type Resource struct {
+	Name  string
+	Type  Type
+	Class Class
+	TTL   uint32
+	Data  RData
+}
+
+func (r *Resource) String() string {
+	return fmt.Sprintf("%s\t%d\t%v\t%v\t%v", r.Name, r.TTL, r.Class, r.Type, r.Data)
+}
+
+// Message is a DNS message.
+type Message struct {
+	Header
+	Questions   []Question
+	Answers     []Resource
+	Authorities []Resource
+	Additionals []Resource
+}
+
+// Errors returned by Unpack.
+var (
+	ErrShort   = errors.New("dns: message too short")
+	ErrPointer = errors.New("dns: invalid name compression pointer")
+	ErrName    = errors.New("dns: invalid name")
+)
+
+// Fqdn returns name with a trailing dot.
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func append16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func append32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func packName(b []byte, name string) ([]byte, error) {
+	name = Fqdn(name)
+	if len(name) > 254 {
+		return nil, ErrName
+	}
+	if name == "." {
+		return append(b, 0), nil
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, ErrName
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// unpackName unpacks the name at off in msg and returns it and the offset
+// after it.
+func unpackName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	// Every pointer must point backwards, so this terminates, but
+	// bound it anyway.
+	for ptrs := 0; ; {
+		if off >= len(msg) {
+			return "", 0, ErrShort
+		}
+		c := int(msg[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				name := strings.Join(labels, ".") + "."
+				if len(name) > 254 {
+					return "", 0, ErrName
+				}
+				return name, end, nil
+			}
+			if off+1+c > len(msg) {
+				return "", 0, ErrShort
+			}
+			labels = append(labels, string(msg[off+1:off+1+c]))
+			off += 1 + c
+		case 0xc0:
+			if off+2 > len(msg) {
+				return "", 0, ErrShort
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			ptr := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			if ptr >= off || ptrs > 64 {
+				return "", 0, ErrPointer
+			}
+			ptrs++
+			off = ptr
+		default:
+			return "", 0, ErrName
+		}
+	}
+}
+
+func (q *Question) pack(b []byte) ([]byte, error) {
+	b, err := packName(b, q.Name)
+	if err != nil {
+		return nil, err
+	}
+	b = append16(b, uint16(q.Type))
+	return append16(b, uint16(q.Class)), nil
+}
+
+func (r *Resource) pack(b []byte) ([]byte, error) {
+	b, err := packName(b, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	b = append16(b, uint16(r.Type))
+	b = append16(b, uint16(r.Class))
+	b = append32(b, r.TTL)
+	lenOff := len(b)
+	b = append(b, 0, 0)
+	if b, err = r.Data.pack(b); err != nil {
+		return nil, fmt.Errorf("%v record of %s: %v", r.Type, r.Name, err)
+	}
+	n := len(b) - lenOff - 2
+	if n > 0xffff {
+		return nil, fmt.Errorf("%v record of %s: data too long", r.Type, r.Name)
+	}
+	binary.BigEndian.PutUint16(b[lenOff:], uint16(n))
+	return b, nil
+}
+
+// Pack returns the wire format of m. Names are not compressed.
+func (m *Message) Pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.flags())
+	for i, n := range []int{len(m.Questions), len(m.Answers), len(m.Authorities), len(m.Additionals)} {
+		if n > 0xffff {
+			return nil, errors.New("dns: too many records")
+		}
+		binary.BigEndian.PutUint16(b[4+2*i:], uint16(n))
+	}
+
+	var err error
+	for i := range m.Questions {
+		if b, err = m.Questions[i].pack(b); err != nil {
+			return nil, err
+		}
+	}
+	for _, section := range [][]Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range section {
+			if b, err = section[i].pack(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// Unpack parses the message in b.
+func Unpack(b []byte) (*Message, error) {
+	if len(b) < 12 {
+		return nil, ErrShort
+	}
+	m := &Message{}
+	m.ID = binary.BigEndian.Uint16(b[0:])
+	m.setFlags(binary.BigEndian.Uint16(b[2:]))
+	var counts [4]int
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(b[4+2*i:]))
+	}
+
+	off := 12
+	for i := 0; i < counts[0]; i++ {
+		name, n, err := unpackName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if n+4 > len(b) {
+			return nil, ErrShort
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  Type(binary.BigEndian.Uint16(b[n:])),
+			Class: Class(binary.BigEndian.Uint16(b[n+2:])),
+		})
+		off = n + 4
+	}
+
+	for s, section := range []*[]Resource{&m.Answers, &m.Authorities, &m.Additionals} {
+		for i := 0; i < counts[s+1]; i++ {
+			name, n, err := unpackName(b, off)
+			if err != nil {
+				return nil, err
+			}
+			if n+10 > len(b) {
+				return nil, ErrShort
+			}
+			r := Resource{
+				Name:  name,
+				Type:  Type(binary.BigEndian.Uint16(b[n:])),
+				Class: Class(binary.BigEndian.Uint16(b[n+2:])),
+				TTL:   binary.BigEndian.Uint32(b[n+4:]),
+			}
+			length := int(binary.BigEndian.Uint16(b[n+8:]))
+			off = n + 10
+			if off+length > len(b) {
+				return nil, ErrShort
+			}
+			if r.Data, err = unpackData(r.Type, b, off, length); err != nil {
+				return nil, fmt.Errorf("%v record of %s: %v", r.Type, name, err)
+			}
+			off += length
+			*section = append(*section, r)
+		}
+	}
+	return m, nil
+}
+
+// NewQuery returns a recursive query for name of type t.
+func NewQuery(id uint16, name string, t Type) *Message {
+	return &Message{
+		Header:    Header{ID: id, RecursionDesired: true},
+		Questions: []Question{{Name: Fqdn(name), Type: t, Class: ClassINET}},
+	}
+}
+
+// ReverseName returns the name to look up PTR records of ip with, in
+// in-addr.arpa or ip6.arpa.
+func ReverseName(ip net.IP) (string, error) {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0]), nil
+	}
+	if len(ip) != net.IPv6len {
+		return "", fmt.Errorf("invalid IP address %v", ip)
+	}
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", ip[i]&0xf, ip[i]>>4)
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	m := &Message{
+		Header: Header{ID: 0x1234, Response: true, RecursionDesired: true, RecursionAvailable: true, RCode: RCodeNameError},
+		Questions: []Question{
+			{Name: "example.com.", Type: TypeA, Class: ClassINET},
+		},
+		Answers: []Resource{
+			{Name: "example.com.", Type: TypeA, Class: ClassINET, TTL: 300, Data: &AData{IP: net.IP{10, 0, 0, 1}}},
+			{Name: "example.com.", Type: TypeAAAA, Class: ClassINET, TTL: 300, Data: &AAAAData{IP: net.ParseIP("2001:db8::1")}},
+			{Name: "www.example.com.", Type: TypeCNAME, Class: ClassINET, TTL: 60, Data: &NameData{Name: "example.com."}},
+			{Name: "example.com.", Type: TypeMX, Class: ClassINET, TTL: 60, Data: &MXData{Pref: 10, Host: "mx.example.com."}},
+			{Name: "_http._tcp.example.com.", Type: TypeSRV, Class: ClassINET, TTL: 60, Data: &SRVData{Priority: 1, Weight: 2, Port: 80, Target: "www.example.com."}},
+			{Name: "example.com.", Type: TypeTXT, Class: ClassINET, TTL: 60, Data: &TXTData{TXT: []string{"v=spf1 -all", ""}}},
+			{Name: "example.com.", Type: Type(99), Class: ClassINET, TTL: 60, Data: &RawData{Data: []byte{1, 2, 3}}},
+		},
+		Authorities: []Resource{
+			{Name: "example.com.", Type: TypeSOA, Class: ClassINET, TTL: 3600, Data: &SOAData{
+				NS: "ns.example.com.", MBox: "root.example.com.", Serial: 1, Refresh: 2, Retry: 3, Expire: 4, MinTTL: 5,
+			}},
+		},
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unpacked IPv4 addresses are 4 bytes long.
+	m.Answers[0].Data = &AData{IP: net.IP{10, 0, 0, 1}}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Unpack(Pack(m)) = %+v, want %+v", got, m)
+	}
+
+	for i := 0; i < len(b); i++ {
+		if _, err := Unpack(b[:i]); err == nil {
+			t.Errorf("Unpack of %d of %d bytes succeeded", i, len(b))
+		}
+	}
+}
+
+func TestUnpackCompressed(t *testing.T) {
+	b := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0,
+		// Question: www.example.com. A IN
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, 1, 0, 1,
+		// www.example.com. CNAME a.example.com.
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 4,
+		1, 'a', 0xc0, 16,
+		// a.example.com. A 10.0.0.1
+		0xc0, 45, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4,
+		10, 0, 0, 1,
+	}
+	m, err := Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != 2 {
+		t.Fatalf("Unpack() = %d answers, want 2", len(m.Answers))
+	}
+	for i, want := range []string{
+		"www.example.com.\t60\tIN\tCNAME\ta.example.com.",
+		"a.example.com.\t60\tIN\tA\t10.0.0.1",
+	} {
+		if got := m.Answers[i].String(); got != want {
+			t.Errorf("answer %d = %q, want %q", i, got, want)
+		}
+	}
+
+	// A pointer to itself.
+	loop := append([]byte{}, b[:12]...)
+	loop = append(loop, 0xc0, 12, 0, 1, 0, 1)
+	if _, err := Unpack(loop); err != ErrPointer {
+		t.Errorf("Unpack() = %v, want %v", err, ErrPointer)
+	}
+}
+
+func TestPackErrors(t *testing.T) {
+	for _, m := range []*Message{
+		NewQuery(1, "a..example.com", TypeA),
+		NewQuery(1, "0123456789012345678901234567890123456789012345678901234567890123.com", TypeA),
+		{Answers: []Resource{{Name: "a.", Type: TypeA, Data: &AData{IP: net.ParseIP("::1")}}}},
+	} {
+		if _, err := m.Pack(); err == nil {
+			t.Errorf("Pack(%+v) succeeded, want error", m)
+		}
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for s, want := range map[string]Type{"a": TypeA, "AAAA": TypeAAAA, "srv": TypeSRV, "TYPE99": Type(99)} {
+		if got, err := ParseType(s); err != nil || got != want {
+			t.Errorf("ParseType(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseType("bogus"); err == nil {
+		t.Errorf("ParseType(bogus) succeeded")
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for ip, want := range map[string]string{
+		"10.1.2.3":    "3.2.1.10.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		if got, err := ReverseName(net.ParseIP(ip)); err != nil || got != want {
+			t.Errorf("ReverseName(%s) = %q, %v, want %q", ip, got, err, want)
+		}
+	}
+}
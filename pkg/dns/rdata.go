@@ -0,0 +1,260 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RData is the data of a resource record.
+type RData interface {
+	fmt.Stringer
+
+	// pack appends the data in wire format to b.
+	pack(b []byte) ([]byte, error)
+}
+
+// AData is the data of an A record.
+type AData struct {
+	IP net.IP
+}
+
+func (a *AData) String() string { return a.IP.String() }
+
+func (a *AData) pack(b []byte) ([]byte, error) {
+	ip := a.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%v is not an IPv4 address", a.IP)
+	}
+	return append(b, ip...), nil
+}
+
+// AAAAData is the data of an AAAA record.
+type AAAAData struct {
+	IP net.IP
+}
+
+func (a *AAAAData) String() string { return a.IP.String() }
+
+func (a *AAAAData) pack(b []byte) ([]byte, error) {
+	ip := a.IP.To16()
+	if ip == nil {
+		return nil, fmt.Errorf("%v is not an IPv6 address", a.IP)
+	}
+	return append(b, ip...), nil
+}
+
+// NameData is the data of NS, CNAME and PTR records.
+type NameData struct {
+	Name string
+}
+
+func (n *NameData) String() string { return n.Name }
+
+func (n *NameData) pack(b []byte) ([]byte, error) {
+	return packName(b, n.Name)
+}
+
+// MXData is the data of an MX record.
+type MXData struct {
+	Pref uint16
+	Host string
+}
+
+func (m *MXData) String() string { return fmt.Sprintf("%d %s", m.Pref, m.Host) }
+
+func (m *MXData) pack(b []byte) ([]byte, error) {
+	return packName(append16(b, m.Pref), m.Host)
+}
+
+// SRVData is the data of an SRV record.
+type SRVData struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+func (s *SRVData) String() string {
+	return fmt.Sprintf("%d %d %d %s", s.Priority, s.Weight, s.Port, s.Target)
+}
+
+func (s *SRVData) pack(b []byte) ([]byte, error) {
+	b = append16(append16(append16(b, s.Priority), s.Weight), s.Port)
+	return packName(b, s.Target)
+}
+
+// TXTData is the data of a TXT record.
+type TXTData struct {
+	TXT []string
+}
+
+func (t *TXTData) String() string {
+	var q []string
+	for _, s := range t.TXT {
+		q = append(q, strconv.Quote(s))
+	}
+	return strings.Join(q, " ")
+}
+
+func (t *TXTData) pack(b []byte) ([]byte, error) {
+	for _, s := range t.TXT {
+		if len(s) > 255 {
+			return nil, errors.New("TXT string too long")
+		}
+		b = append(append(b, byte(len(s))), s...)
+	}
+	return b, nil
+}
+
+// SOAData is the data of an SOA record.
+type SOAData struct {
+	NS      string
+	MBox    string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+
+	// MinTTL is how long negative answers may be cached, RFC 2308.
+	MinTTL uint32
+}
+
+func (s *SOAData) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", s.NS, s.MBox, s.Serial, s.Refresh, s.Retry, s.Expire, s.MinTTL)
+}
+
+func (s *SOAData) pack(b []byte) ([]byte, error) {
+	b, err := packName(b, s.NS)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = packName(b, s.MBox); err != nil {
+		return nil, err
+	}
+	for _, v := range []uint32{s.Serial, s.Refresh, s.Retry, s.Expire, s.MinTTL} {
+		b = append32(b, v)
+	}
+	return b, nil
+}
+
+// RawData is the data of records of other types. It is passed through
+// unchanged.
+type RawData struct {
+	Data []byte
+}
+
+// String formats the data as in RFC 3597.
+func (r *RawData) String() string {
+	return fmt.Sprintf("\\# %d %s", len(r.Data), hex.EncodeToString(r.Data))
+}
+
+func (r *RawData) pack(b []byte) ([]byte, error) {
+	return append(b, r.Data...), nil
+}
+
+// unpackData unpacks the data of a record of type t at msg[off:off+length].
+// Names in the data may point anywhere into msg.
+func unpackData(t Type, msg []byte, off, length int) (RData, error) {
+	data := msg[off : off+length]
+	end := off + length
+
+	// name unpacks a name that must end within the data.
+	name := func(off int) (string, int, error) {
+		n, next, err := unpackName(msg, off)
+		if err == nil && next > end {
+			err = ErrShort
+		}
+		return n, next, err
+	}
+
+	switch t {
+	case TypeA:
+		if length != net.IPv4len {
+			return nil, fmt.Errorf("invalid length %d", length)
+		}
+		return &AData{IP: net.IP(append([]byte{}, data...))}, nil
+
+	case TypeAAAA:
+		if length != net.IPv6len {
+			return nil, fmt.Errorf("invalid length %d", length)
+		}
+		return &AAAAData{IP: net.IP(append([]byte{}, data...))}, nil
+
+	case TypeNS, TypeCNAME, TypePTR:
+		n, _, err := name(off)
+		if err != nil {
+			return nil, err
+		}
+		return &NameData{Name: n}, nil
+
+	case TypeMX:
+		if length < 3 {
+			return nil, ErrShort
+		}
+		n, _, err := name(off + 2)
+		if err != nil {
+			return nil, err
+		}
+		return &MXData{Pref: binary.BigEndian.Uint16(data), Host: n}, nil
+
+	case TypeSRV:
+		if length < 7 {
+			return nil, ErrShort
+		}
+		n, _, err := name(off + 6)
+		if err != nil {
+			return nil, err
+		}
+		return &SRVData{
+			Priority: binary.BigEndian.Uint16(data[0:]),
+			Weight:   binary.BigEndian.Uint16(data[2:]),
+			Port:     binary.BigEndian.Uint16(data[4:]),
+			Target:   n,
+		}, nil
+
+	case TypeTXT:
+		t := &TXTData{}
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return nil, ErrShort
+			}
+			t.TXT = append(t.TXT, string(data[i+1:i+1+n]))
+			i += 1 + n
+		}
+		return t, nil
+
+	case TypeSOA:
+		ns, next, err := name(off)
+		if err != nil {
+			return nil, err
+		}
+		mbox, next, err := name(next)
+		if err != nil {
+			return nil, err
+		}
+		if next+20 > end {
+			return nil, ErrShort
+		}
+		v := msg[next:]
+		return &SOAData{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  binary.BigEndian.Uint32(v[0:]),
+			Refresh: binary.BigEndian.Uint32(v[4:]),
+			Retry:   binary.BigEndian.Uint32(v[8:]),
+			Expire:  binary.BigEndian.Uint32(v[12:]),
+			MinTTL:  binary.BigEndian.Uint32(v[16:]),
+		}, nil
+	}
+	return &RawData{Data: append([]byte{}, data...)}, nil
+}
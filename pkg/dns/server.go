@@ -0,0 +1,190 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// maxUDPSize is the largest response sent over UDP without EDNS.
+const maxUDPSize = 512
+
+// Server is a stub resolver. It forwards queries to upstream servers and
+// caches their responses.
+//
+// If no upstream server answers, an expired cached response is returned
+// rather than an error, which keeps flaky networks usable.
+type Server struct {
+	// Upstreams are the servers queries are forwarded to, in order.
+	Upstreams []string
+
+	// Client sends the queries to Upstreams.
+	Client Client
+
+	// Cache caches responses. nil means no caching.
+	Cache *Cache
+
+	// Log logs failed queries if set.
+	Log *log.Logger
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Log != nil {
+		s.Log.Printf(format, v...)
+	}
+}
+
+// reply returns the response r to q with the header of q.
+func reply(q, r *Message) *Message {
+	out := *r
+	out.ID = q.ID
+	out.Response = true
+	out.Opcode = q.Opcode
+	out.RecursionDesired = q.RecursionDesired
+	out.RecursionAvailable = true
+	out.Questions = q.Questions
+	return &out
+}
+
+// errorReply returns a response to q with no records.
+func errorReply(q *Message, rcode RCode) *Message {
+	return reply(q, &Message{Header: Header{RCode: rcode}})
+}
+
+// Resolve answers the query q.
+func (s *Server) Resolve(ctx context.Context, q *Message) *Message {
+	if q.Response || q.Opcode != 0 {
+		return errorReply(q, RCodeNotImplemented)
+	}
+	if len(q.Questions) != 1 {
+		return errorReply(q, RCodeFormatError)
+	}
+	question := q.Questions[0]
+
+	var stale *Message
+	if s.Cache != nil {
+		m, expired := s.Cache.Get(question)
+		if m != nil && !expired {
+			return reply(q, m)
+		}
+		stale = m
+	}
+
+	var last *Message
+	for _, server := range s.Upstreams {
+		r, _, err := s.Client.Exchange(ctx, NewQuery(NewID(), question.Name, question.Type), server)
+		if err != nil {
+			s.logf("%v: %s: %v", question, server, err)
+			continue
+		}
+		last = r
+		if r.RCode != RCodeSuccess && r.RCode != RCodeNameError {
+			s.logf("%v: %s: %v", question, server, r.RCode)
+			continue
+		}
+		if s.Cache != nil {
+			s.Cache.Put(r)
+		}
+		return reply(q, r)
+	}
+
+	switch {
+	case stale != nil:
+		return reply(q, stale)
+	case last != nil:
+		return reply(q, last)
+	}
+	return errorReply(q, RCodeServerFailure)
+}
+
+// handle returns the packed response to the packed query b, or nil if b is
+// not worth a response.
+func (s *Server) handle(ctx context.Context, b []byte, maxSize int) []byte {
+	q, err := Unpack(b)
+	if err != nil {
+		return nil
+	}
+	r := s.Resolve(ctx, q)
+	rb, err := r.Pack()
+	if err == nil && len(rb) > maxSize {
+		// Let the client retry over TCP.
+		t := errorReply(q, r.RCode)
+		t.Truncated = true
+		rb, err = t.Pack()
+	}
+	if err != nil {
+		s.logf("%v: %v", q.Questions, err)
+		return nil
+	}
+	return rb
+}
+
+// ServeUDP answers queries on conn until reading from it fails.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		b := append([]byte{}, buf[:n]...)
+		go func() {
+			if r := s.handle(ctx, b, maxUDPSize); r != nil {
+				conn.WriteTo(r, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP answers queries on connections accepted from l until accepting
+// fails.
+func (s *Server) ServeTCP(ctx context.Context, l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				b, err := readTCP(conn)
+				if err != nil {
+					return
+				}
+				r := s.handle(ctx, b, 0xffff)
+				if r == nil || writeTCP(conn, r) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// ListenAndServe answers queries on addr over UDP and TCP until ctx is
+// done.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(ctx, pc) }()
+	go func() { errs <- s.ServeTCP(ctx, l) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func answer(name string, ttl uint32, ip net.IP) Resource {
+	return Resource{Name: name, Type: TypeA, Class: ClassINET, TTL: ttl, Data: &AData{IP: ip}}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewCache(2)
+	c.now = func() time.Time { return now }
+
+	q := Question{Name: "a.example.com.", Type: TypeA, Class: ClassINET}
+	m := &Message{
+		Header:    Header{Response: true},
+		Questions: []Question{q},
+		Answers:   []Resource{answer(q.Name, 300, net.IP{10, 0, 0, 1}), answer(q.Name, 100, net.IP{10, 0, 0, 2})},
+	}
+	c.Put(m)
+
+	now = now.Add(40 * time.Second)
+	got, expired := c.Get(Question{Name: "A.Example.COM.", Type: TypeA, Class: ClassINET})
+	if got == nil || expired {
+		t.Fatalf("Get() = %v, %v, want fresh response", got, expired)
+	}
+	if got.Answers[0].TTL != 260 || got.Answers[1].TTL != 60 {
+		t.Errorf("Get() TTLs = %d, %d, want 260, 60", got.Answers[0].TTL, got.Answers[1].TTL)
+	}
+	if m.Answers[0].TTL != 300 {
+		t.Errorf("Get() changed the cached response")
+	}
+
+	// The least TTL is 100s.
+	now = now.Add(60 * time.Second)
+	if got, expired := c.Get(q); got == nil || !expired || got.Answers[1].TTL != 0 {
+		t.Errorf("Get() = %v, %v, want expired response", got, expired)
+	}
+
+	// Negative responses are cached for the SOA minimum.
+	nx := Question{Name: "nx.example.com.", Type: TypeA, Class: ClassINET}
+	c.Put(&Message{
+		Header:    Header{Response: true, RCode: RCodeNameError},
+		Questions: []Question{nx},
+		Authorities: []Resource{{Name: "example.com.", Type: TypeSOA, Class: ClassINET, TTL: 3600, Data: &SOAData{
+			NS: "ns.example.com.", MBox: "root.example.com.", MinTTL: 30,
+		}}},
+	})
+	if got, expired := c.Get(nx); got == nil || expired {
+		t.Errorf("Get(%v) = %v, %v, want fresh response", nx, got, expired)
+	}
+	now = now.Add(31 * time.Second)
+	if _, expired := c.Get(nx); !expired {
+		t.Errorf("Get(%v) not expired after SOA minimum", nx)
+	}
+
+	// Failures are not cached.
+	fail := Question{Name: "fail.example.com.", Type: TypeA, Class: ClassINET}
+	c.Put(&Message{Header: Header{Response: true, RCode: RCodeServerFailure}, Questions: []Question{fail}})
+	if got, _ := c.Get(fail); got != nil {
+		t.Errorf("Get(%v) = %v, want nil", fail, got)
+	}
+
+	// A third response evicts the expired ones.
+	b := Question{Name: "b.example.com.", Type: TypeA, Class: ClassINET}
+	c.Put(&Message{Questions: []Question{b}, Answers: []Resource{answer(b.Name, 60, net.IP{10, 0, 0, 3})}})
+	if got, _ := c.Get(q); got != nil {
+		t.Errorf("Get(%v) = %v after eviction, want nil", q, got)
+	}
+	if got, _ := c.Get(b); got == nil {
+		t.Errorf("Get(%v) = nil, want response", b)
+	}
+}
+
+// upstream is a DNS server that answers A queries with 10.0.0.1, or
+// answers truncated over UDP if truncate is set.
+type upstream struct {
+	addr     string
+	queries  int32
+	truncate bool
+	pc       net.PacketConn
+	l        net.Listener
+}
+
+func (u *upstream) answer(b []byte, udp bool) []byte {
+	atomic.AddInt32(&u.queries, 1)
+	q, err := Unpack(b)
+	if err != nil {
+		return nil
+	}
+	r := &Message{Header: Header{ID: q.ID, Response: true}, Questions: q.Questions}
+	if udp && u.truncate {
+		r.Truncated = true
+	} else {
+		r.Answers = []Resource{answer(q.Questions[0].Name, 60, net.IP{10, 0, 0, 1})}
+	}
+	rb, _ := r.Pack()
+	return rb
+}
+
+func newUpstream(t *testing.T, truncate bool) *upstream {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{addr: pc.LocalAddr().String(), truncate: truncate, pc: pc, l: l}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(u.answer(buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if b, err := readTCP(c); err == nil {
+				writeTCP(c, u.answer(b, false))
+			}
+			c.Close()
+		}
+	}()
+	return u
+}
+
+func (u *upstream) Close() {
+	u.pc.Close()
+	u.l.Close()
+}
+
+func TestClientTCPFallback(t *testing.T) {
+	u := newUpstream(t, true)
+	defer u.Close()
+
+	c := &Client{Timeout: time.Second}
+	r, _, err := c.Exchange(context.Background(), NewQuery(1, "example.com", TypeA), u.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Truncated || len(r.Answers) != 1 {
+		t.Errorf("Exchange() = %+v, want the TCP response", r)
+	}
+	if n := atomic.LoadInt32(&u.queries); n != 2 {
+		t.Errorf("upstream got %d queries, want 2", n)
+	}
+}
+
+func TestServer(t *testing.T) {
+	u := newUpstream(t, false)
+	defer u.Close()
+
+	// The clock is read concurrently by the server.
+	var offset int64
+	cache := NewCache(10)
+	cache.now = func() time.Time { return time.Now().Add(time.Duration(atomic.LoadInt64(&offset))) }
+	s := &Server{
+		// The first server does not answer.
+		Upstreams: []string{"127.0.0.1:1", u.addr},
+		Client:    Client{Timeout: 100 * time.Millisecond},
+		Cache:     cache,
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go s.ServeUDP(context.Background(), pc)
+
+	c := &Client{Timeout: time.Second}
+	want := []Resource{answer("example.com.", 60, net.IP{10, 0, 0, 1})}
+	for i := 0; i < 2; i++ {
+		r, _, err := c.Exchange(context.Background(), NewQuery(uint16(i), "example.com", TypeA), pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.RecursionAvailable || !reflect.DeepEqual(r.Answers, want) {
+			t.Errorf("response %d = %+v, want %v", i, r, want)
+		}
+	}
+	if n := atomic.LoadInt32(&u.queries); n != 1 {
+		t.Errorf("upstream got %d queries, want 1", n)
+	}
+
+	// Expired responses are used if no upstream answers.
+	atomic.StoreInt64(&offset, int64(time.Hour))
+	u.Close()
+	r := s.Resolve(context.Background(), NewQuery(3, "example.com", TypeA))
+	if r.RCode != RCodeSuccess || len(r.Answers) != 1 || r.Answers[0].TTL != 0 {
+		t.Errorf("Resolve() = %+v, want the expired response", r)
+	}
+	r = s.Resolve(context.Background(), NewQuery(4, "other.example.com", TypeA))
+	if r.RCode != RCodeServerFailure {
+		t.Errorf("Resolve() = %v, want %v", r.RCode, RCodeServerFailure)
+	}
+}
+
+func TestReadResolvConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	data := "# comment\ndomain example.org\nnameserver 10.0.0.53\nsearch example.com example.net\nnameserver 2001:db8::53\noptions ndots:2\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := ReadResolvConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ResolvConf{
+		Nameservers: []string{"10.0.0.53", "2001:db8::53"},
+		Search:      []string{"example.com", "example.net"},
+	}
+	if !reflect.DeepEqual(rc, want) {
+		t.Errorf("ReadResolvConf() = %+v, want %+v", rc, want)
+	}
+}
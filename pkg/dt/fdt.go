@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"unsafe"
 )
 
@@ -101,7 +102,7 @@ func (fdt *FDT) readHeader(f io.ReadSeeker) error {
 	}
 	if h.Magic != Magic {
 		return fmt.Errorf("invalid FDT magic, got %#08x, expected %#08x",
-			h.Magic, Magic)
+			h.Magic, uint32(Magic))
 	}
 	if !(h.Version == 16 || h.Version == 17 ||
 		(h.LastCompVersion <= 17 && h.Version > 17)) {
@@ -297,8 +298,99 @@ func (fdt *FDT) readStructBlock(f io.ReadSeeker, strs []byte) error {
 }
 
 // Write marshals the FDT to an io.Writer and returns the size.
-// TODO: implement
+//
+// The blocks are laid out in the order recommended by the spec: header,
+// memory reservation block, struct block and strings block, without any
+// free space. The header is recomputed, except for BootCpuidPhys, and the
+// version is always 17.
 func (fdt *FDT) Write(f io.Writer) (int, error) {
-	// TODO: implement
-	return -1, errors.New("not yet implemented")
+	if fdt.RootNode == nil {
+		return 0, errors.New("no root node")
+	}
+
+	strs := &stringsBlock{offsets: map[string]uint32{}}
+	structBlock := &bytes.Buffer{}
+	if err := fdt.RootNode.writeStruct(structBlock, strs); err != nil {
+		return 0, err
+	}
+	binary.Write(structBlock, binary.BigEndian, uint32(tokenEnd))
+
+	h := fdt.Header
+	h.Magic = Magic
+	h.Version = 17
+	h.LastCompVersion = 16
+	// The header is 40 bytes, which is a multiple of 8 as the memory
+	// reservation block must be.
+	h.OffMemRsvmap = uint32(unsafe.Sizeof(h))
+	h.OffDtStruct = h.OffMemRsvmap +
+		uint32(len(fdt.ReserveEntries)+1)*uint32(unsafe.Sizeof(ReserveEntry{}))
+	h.SizeDtStruct = uint32(structBlock.Len())
+	h.OffDtStrings = h.OffDtStruct + h.SizeDtStruct
+	h.SizeDtStrings = uint32(strs.buf.Len())
+	h.TotalSize = h.OffDtStrings + h.SizeDtStrings
+
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, &h)
+	for _, e := range fdt.ReserveEntries {
+		binary.Write(b, binary.BigEndian, &e)
+	}
+	binary.Write(b, binary.BigEndian, &ReserveEntry{})
+	b.Write(structBlock.Bytes())
+	b.Write(strs.buf.Bytes())
+	return f.Write(b.Bytes())
+}
+
+// stringsBlock builds the strings block, in which every property name is
+// stored once.
+type stringsBlock struct {
+	buf     bytes.Buffer
+	offsets map[string]uint32
+}
+
+// offset returns the offset of str in the strings block, adding it if it is
+// not there yet.
+func (s *stringsBlock) offset(str string) uint32 {
+	if off, ok := s.offsets[str]; ok {
+		return off
+	}
+	off := uint32(s.buf.Len())
+	s.buf.WriteString(str)
+	s.buf.WriteByte(0)
+	s.offsets[str] = off
+	return off
+}
+
+// pad4 pads b with zeros to a multiple of four bytes.
+func pad4(b *bytes.Buffer) {
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+}
+
+// writeStruct writes the node, its properties and its children to the
+// struct block b.
+func (n *Node) writeStruct(b *bytes.Buffer, strs *stringsBlock) error {
+	if strings.IndexByte(n.Name, 0) != -1 {
+		return fmt.Errorf("node name %q contains a null character", n.Name)
+	}
+	binary.Write(b, binary.BigEndian, uint32(tokenBeginNode))
+	b.WriteString(n.Name)
+	b.WriteByte(0)
+	pad4(b)
+
+	for _, p := range n.Properties {
+		binary.Write(b, binary.BigEndian, uint32(tokenProp))
+		binary.Write(b, binary.BigEndian, struct {
+			Len, Nameoff uint32
+		}{uint32(len(p.Value)), strs.offset(p.Name)})
+		b.Write(p.Value)
+		pad4(b)
+	}
+	for _, c := range n.Children {
+		if err := c.writeStruct(b, strs); err != nil {
+			return err
+		}
+	}
+	binary.Write(b, binary.BigEndian, uint32(tokenEndNode))
+	return nil
 }
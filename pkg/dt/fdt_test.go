@@ -5,6 +5,7 @@
 package dt
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -42,3 +43,51 @@ func TestRead(t *testing.T) {
 		t.Errorf(`want %s`, jsonData)
 	}
 }
+
+func TestWrite(t *testing.T) {
+	f, err := os.Open("testdata/fdt.dtb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fdt, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdt.ReserveEntries = append(fdt.ReserveEntries, ReserveEntry{Address: 0x40000000, Size: 0x10000})
+
+	chosen, ok := fdt.RootNode.Child("chosen")
+	if !ok {
+		t.Fatal("no /chosen node")
+	}
+	chosen.UpdateProperty("bootargs", []byte("console=ttyAMA0\x00"))
+	chosen.UpdateProperty("linux,initrd-start", []byte{0, 0, 0, 0, 0x48, 0, 0, 0})
+	if !chosen.RemoveProperty("linux,initrd-start") {
+		t.Errorf("RemoveProperty() of an existing property = false")
+	}
+	if chosen.RemoveProperty("linux,initrd-start") {
+		t.Errorf("RemoveProperty() of a removed property = true")
+	}
+	if p, ok := chosen.LookProperty("bootargs"); !ok || string(p.Value) != "console=ttyAMA0\x00" {
+		t.Errorf("LookProperty(bootargs) = %v, %t", p, ok)
+	}
+
+	var b bytes.Buffer
+	n, err := fdt.Write(&b)
+	if err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if n != b.Len() {
+		t.Errorf("Write() = %d, but wrote %d bytes", n, b.Len())
+	}
+	got, err := Read(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("Read() of the written FDT = %v", err)
+	}
+	if int(got.Header.TotalSize) != b.Len() {
+		t.Errorf("total size is %d, want %d", got.Header.TotalSize, b.Len())
+	}
+	if !reflect.DeepEqual(got.RootNode, fdt.RootNode) || !reflect.DeepEqual(got.ReserveEntries, fdt.ReserveEntries) {
+		t.Errorf("Read(Write(fdt)) differs from fdt")
+	}
+}
@@ -61,6 +61,48 @@ type Property struct {
 	Value []byte
 }
 
+// Child returns the child of n named name.
+func (n *Node) Child(name string) (*Node, bool) {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// LookProperty returns the property of n named name.
+func (n *Node) LookProperty(name string) (*Property, bool) {
+	for i := range n.Properties {
+		if n.Properties[i].Name == name {
+			return &n.Properties[i], true
+		}
+	}
+	return nil, false
+}
+
+// UpdateProperty sets the value of the property of n named name, adding
+// the property if n does not have it.
+func (n *Node) UpdateProperty(name string, value []byte) {
+	if p, ok := n.LookProperty(name); ok {
+		p.Value = value
+		return
+	}
+	n.Properties = append(n.Properties, Property{Name: name, Value: value})
+}
+
+// RemoveProperty removes the property of n named name. It returns false if
+// n does not have it.
+func (n *Node) RemoveProperty(name string) bool {
+	for i := range n.Properties {
+		if n.Properties[i].Name == name {
+			n.Properties = append(n.Properties[:i], n.Properties[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // PredictType makes a prediction on what value the property contains based on
 // its name and data. The data types are not encoded in the data structure, so
 // some heuristics are used.
@@ -0,0 +1,157 @@
+// Copyright 2016-2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gpgv verifies detached OpenPGP signatures, like gpgv(1).
+//
+// The openpgp package ReadKeyRing function does not completely implement
+// RFC4880 in that it can't use a PublicSigningKey with 0 signatures, so keys
+// are read packet by packet instead.
+package gpgv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"io"
+
+	// Hashes signatures commonly use.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// armorPrefix starts ASCII armored data.
+var armorPrefix = []byte("-----BEGIN PGP")
+
+// dearmor returns r, decoded if it is ASCII armored.
+func dearmor(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(armorPrefix))
+	if !bytes.Equal(head, armorPrefix) {
+		return br, nil
+	}
+	block, err := armor.Decode(br)
+	if err != nil {
+		return nil, err
+	}
+	return block.Body, nil
+}
+
+// ReadPublicSigningKey reads the first packet of keyf, which must be a
+// public key.
+func ReadPublicSigningKey(keyf io.Reader) (*packet.PublicKey, error) {
+	r, err := dearmor(keyf)
+	if err != nil {
+		return nil, err
+	}
+	p, err := packet.NewReader(r).Next()
+	if err != nil {
+		return nil, err
+	}
+	if pkt, ok := p.(*packet.PublicKey); ok {
+		return pkt, nil
+	}
+	return nil, errors.StructuralError("expected first packet to be PublicKey")
+}
+
+// ReadPublicSigningKeys reads all public keys and subkeys of keyf, which is
+// a binary or ASCII armored OpenPGP key file, as gpg --export writes.
+func ReadPublicSigningKeys(keyf io.Reader) ([]*packet.PublicKey, error) {
+	r, err := dearmor(keyf)
+	if err != nil {
+		return nil, err
+	}
+	packets := packet.NewReader(r)
+	var keys []*packet.PublicKey
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(errors.UnsupportedError); ok {
+			// Such as packets of a newer version.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pkt, ok := p.(*packet.PublicKey); ok {
+			keys = append(keys, pkt)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.StructuralError("no public keys")
+	}
+	return keys, nil
+}
+
+// readSignature reads the signature packet of sigf, which may be ASCII
+// armored.
+func readSignature(sigf io.Reader) (packet.Packet, crypto.Hash, uint64, error) {
+	r, err := dearmor(sigf)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	p, err := packet.NewReader(r).Next()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	switch sig := p.(type) {
+	case *packet.Signature:
+		var issuer uint64
+		if sig.IssuerKeyId != nil {
+			issuer = *sig.IssuerKeyId
+		}
+		return p, sig.Hash, issuer, nil
+	case *packet.SignatureV3:
+		return p, sig.Hash, sig.IssuerKeyId, nil
+	}
+	return nil, 0, 0, errors.UnsupportedError("unrecognized signature")
+}
+
+// verify checks the signature p of the content hashed with h.
+func verify(key *packet.PublicKey, h crypto.Hash, p packet.Packet, contentf io.Reader) error {
+	if !h.Available() {
+		return errors.UnsupportedError("hash function " + h.String())
+	}
+	hh := h.New()
+	if _, err := io.Copy(hh, contentf); err != nil {
+		return err
+	}
+	switch sig := p.(type) {
+	case *packet.Signature:
+		return key.VerifySignature(hh, sig)
+	case *packet.SignatureV3:
+		return key.VerifySignatureV3(hh, sig)
+	}
+	panic("unreachable")
+}
+
+// VerifyDetachedSignature checks that sigf is a signature of contentf made
+// with key.
+func VerifyDetachedSignature(key *packet.PublicKey, contentf, sigf io.Reader) error {
+	p, h, _, err := readSignature(sigf)
+	if err != nil {
+		return err
+	}
+	return verify(key, h, p, contentf)
+}
+
+// VerifyDetachedSignatureKeys checks that sigf is a signature of contentf
+// made with one of keys, chosen by the key ID in the signature.
+func VerifyDetachedSignatureKeys(keys []*packet.PublicKey, contentf, sigf io.Reader) error {
+	p, h, issuer, err := readSignature(sigf)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.KeyId == issuer {
+			return verify(key, h, p, contentf)
+		}
+	}
+	return errors.ErrUnknownIssuer
+}
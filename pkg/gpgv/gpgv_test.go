@@ -0,0 +1,84 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gpgv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/errors"
+)
+
+func TestVerify(t *testing.T) {
+	signer, err := openpgp.NewEntity("signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An ASCII armored key file with both keys, as gpg --export -a writes.
+	var keyFile bytes.Buffer
+	w, err := armor.Encode(&keyFile, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	const content = "signed content"
+	var sig, asc bytes.Buffer
+	if err := openpgp.DetachSign(&sig, signer, strings.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := openpgp.ArmoredDetachSign(&asc, signer, strings.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ReadPublicSigningKeys(bytes.NewReader(keyFile.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadPublicSigningKeys() = %v", err)
+	}
+	// Each entity has a primary key and an encryption subkey.
+	if len(keys) != 4 {
+		t.Errorf("ReadPublicSigningKeys() read %d keys, want 4", len(keys))
+	}
+	first, err := ReadPublicSigningKey(bytes.NewReader(keyFile.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadPublicSigningKey() = %v", err)
+	}
+	if first.KeyId != other.PrimaryKey.KeyId {
+		t.Errorf("ReadPublicSigningKey() read key %X, want %X", first.KeyId, other.PrimaryKey.KeyId)
+	}
+
+	for _, s := range [][]byte{sig.Bytes(), asc.Bytes()} {
+		if err := VerifyDetachedSignatureKeys(keys, strings.NewReader(content), bytes.NewReader(s)); err != nil {
+			t.Errorf("VerifyDetachedSignatureKeys() = %v, want nil", err)
+		}
+		if err := VerifyDetachedSignature(signer.PrimaryKey, strings.NewReader(content), bytes.NewReader(s)); err != nil {
+			t.Errorf("VerifyDetachedSignature() = %v, want nil", err)
+		}
+		if err := VerifyDetachedSignatureKeys(keys, strings.NewReader("other content"), bytes.NewReader(s)); err == nil {
+			t.Errorf("VerifyDetachedSignatureKeys() of other content succeeded")
+		}
+		if err := VerifyDetachedSignatureKeys(keys[:2], strings.NewReader(content), bytes.NewReader(s)); err != errors.ErrUnknownIssuer {
+			t.Errorf("VerifyDetachedSignatureKeys() without the key = %v, want %v", err, errors.ErrUnknownIssuer)
+		}
+	}
+
+	if _, err := ReadPublicSigningKeys(strings.NewReader("")); err == nil {
+		t.Errorf("ReadPublicSigningKeys() of nothing succeeded")
+	}
+}
@@ -5,10 +5,16 @@
 package kexec
 
 import (
+	"errors"
 	"fmt"
 	"syscall"
 )
 
+// ErrFileLoadUnsupported is returned by FileLoad if the architecture or the
+// running kernel does not have the kexec_file_load(2) syscall. Load, which
+// uses kexec_load(2), may work instead.
+var ErrFileLoadUnsupported = errors.New("kexec_file_load is not supported")
+
 // Reboot executes a kernel previously loaded with FileInit.
 func Reboot() error {
 	if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_KEXEC); err != nil {
@@ -30,7 +30,10 @@ func FileLoad(kernel, ramfs *os.File, cmdline string) error {
 		cmdline = fmt.Sprintf("acpi_rsdp=%s %s", rsdp, cmdline)
 	}
 
-	if err := unix.KexecFileLoad(int(kernel.Fd()), ramfsfd, cmdline, flags); err != nil {
+	if err := unix.KexecFileLoad(int(kernel.Fd()), ramfsfd, cmdline, flags); err == unix.ENOSYS {
+		// CONFIG_KEXEC_FILE is off.
+		return ErrFileLoadUnsupported
+	} else if err != nil {
 		return fmt.Errorf("sys_kexec(%d, %d, %s, %x) = %v", kernel.Fd(), ramfsfd, cmdline, flags, err)
 	}
 	return nil
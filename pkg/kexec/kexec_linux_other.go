@@ -8,9 +8,10 @@ package kexec
 
 import (
 	"os"
-	"syscall"
 )
 
+// FileLoad returns ErrFileLoadUnsupported: kexec_file_load(2) is x86-64 bit
+// only.
 func FileLoad(kernel, ramfs *os.File, cmdline string) error {
-	return syscall.ENOSYS
+	return ErrFileLoadUnsupported
 }
@@ -399,6 +399,32 @@ func (m Memory) FindSpace(sz uint) (start uintptr, err error) {
 	return 0, ErrNotEnoughSpace
 }
 
+// FindSpaceIn returns the lowest address in [min, max) where sz bytes of RAM
+// are available, and which is a multiple of align. An align smaller than the
+// page size means page-aligned.
+func (m Memory) FindSpaceIn(sz, align uint, min, max uintptr) (uintptr, error) {
+	sz = alignUp(sz)
+	if align < pageMask+1 {
+		align = pageMask + 1
+	}
+	alignMask := uintptr(align - 1)
+	for _, r := range m.availableRAM() {
+		start := r.Start
+		if start < min {
+			start = min
+		}
+		start = (start + alignMask) &^ alignMask
+		end := r.Start + uintptr(r.Size)
+		if end > max {
+			end = max
+		}
+		if start < end && uint(end-start) >= sz {
+			return start, nil
+		}
+	}
+	return 0, ErrNotEnoughSpace
+}
+
 func (m *Memory) addKexecSegment(addr uintptr, d []byte) {
 	s := NewSegment(d, Range{
 		Start: addr,
@@ -258,3 +258,41 @@ func TestDedup(t *testing.T) {
 	}
 
 }
+
+func TestFindSpaceIn(t *testing.T) {
+	old := pageMask
+	defer func() {
+		pageMask = old
+	}()
+	pageMask = 4095
+
+	var mem Memory
+	mem.Phys = []TypedAddressRange{
+		{Range: Range{Start: 0, Size: 0x9f000}, Type: RangeRAM},
+		{Range: Range{Start: 0xf0000, Size: 0x10000}, Type: RangeNVS},
+		{Range: Range{Start: 0x100000, Size: 0x3ff00000}, Type: RangeRAM},
+	}
+	mem.Segments = []Segment{
+		{Phys: Range{Start: 0x1000000, Size: 0x1000}},
+	}
+
+	for _, tt := range []struct {
+		sz, align uint
+		min, max  uintptr
+		want      uintptr
+		wantErr   error
+	}{
+		{sz: 0x1000, min: 0, max: 0x80000000, want: 0},
+		{sz: 0x1000, min: 0x100000, max: 0x80000000, want: 0x100000},
+		{sz: 0xa0000, min: 0, max: 0x80000000, want: 0x100000},
+		{sz: 0x100, align: 0x1000000, min: 0x100000, max: 0x80000000, want: 0x2000000},
+		{sz: 0x1000, align: 0x100000, min: 0x1000000, max: 0x80000000, want: 0x1100000},
+		{sz: 0x1000, min: 0x50000000, max: 0x80000000, wantErr: ErrNotEnoughSpace},
+		{sz: 0x1000000, min: 0x100000, max: 0x1000000, wantErr: ErrNotEnoughSpace},
+	} {
+		got, err := mem.FindSpaceIn(tt.sz, tt.align, tt.min, tt.max)
+		if err != tt.wantErr || got != tt.want {
+			t.Errorf("FindSpaceIn(%#x, %#x, %#x, %#x) = %#x, %v, want %#x, %v", tt.sz, tt.align, tt.min, tt.max, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -35,7 +35,7 @@ type Module struct {
 type modules []Module
 
 func (m *Multiboot) addModules() (uintptr, error) {
-	loaded, data, err := loadModules(m.modules)
+	loaded, data, err := loadModules(m.modules, m.moduleFiles)
 	if err != nil {
 		return 0, err
 	}
@@ -72,7 +72,10 @@ func (m *Multiboot) addModules() (uintptr, error) {
 //			modules_n
 //
 // <padding> aligns the start of each module to a page beginning.
-func loadModules(cmds []string) (loaded modules, data []byte, err error) {
+//
+// Module i is read from files[i] if files is non-nil, and from the first word
+// of cmds[i] otherwise.
+func loadModules(cmds, files []string) (loaded modules, data []byte, err error) {
 	loaded = make(modules, len(cmds))
 	buf := bytes.Buffer{}
 
@@ -83,7 +86,12 @@ func loadModules(cmds []string) (loaded modules, data []byte, err error) {
 	}
 
 	for i, cmd := range cmds {
-		name := strings.Fields(cmd)[0]
+		var name string
+		if files != nil {
+			name = files[i]
+		} else {
+			name = strings.Fields(cmd)[0]
+		}
 		if err := loaded[i].loadModule(&buf, name); err != nil {
 			return nil, nil, fmt.Errorf("error adding module %v: %v", name, err)
 		}
@@ -29,6 +29,10 @@ type Multiboot struct {
 	file    string
 	modules []string
 
+	// moduleFiles, if set, are the files to load modules from. Otherwise
+	// the first word of each entry in modules is used.
+	moduleFiles []string
+
 	cmdLine    string
 	bootloader string
 
@@ -97,6 +101,14 @@ func New(file, cmdLine, trampoline string, modules []string) *Multiboot {
 	}
 }
 
+// NewWithModuleFiles is like New, but the content of modules[i] is read from
+// files[i]. modules[i] is only used as the module command line.
+func NewWithModuleFiles(file, cmdLine, trampoline string, modules, files []string) *Multiboot {
+	m := New(file, cmdLine, trampoline, modules)
+	m.moduleFiles = files
+	return m
+}
+
 // Load loads and parses multiboot information from m.file.
 func (m *Multiboot) Load(debug bool) error {
 	log.Printf("Parsing file %v", m.file)
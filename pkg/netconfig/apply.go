@@ -0,0 +1,342 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// dhcpTimeout and dhcpRetries bound each DHCP request.
+	dhcpTimeout = 10 * time.Second
+	dhcpRetries = 3
+
+	resolvConf = "/etc/resolv.conf"
+)
+
+// Apply configures the system as described by c: it creates bonds, bridges
+// and VLANs, each after the devices it is built on, configures the
+// interfaces, and writes /etc/resolv.conf if c has nameservers or search
+// domains. Devices that already exist are not created again, and addresses
+// and routes are replaced, so Apply may run more than once.
+func (c *Config) Apply(ctx context.Context) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	devs, err := c.devices()
+	if err != nil {
+		return err
+	}
+	for _, d := range devs {
+		if err := d.apply(); err != nil {
+			return fmt.Errorf("%s %s: %v", d.kind, d.name, err)
+		}
+	}
+	for i := range c.Interfaces {
+		iface := &c.Interfaces[i]
+		if err := iface.apply(ctx); err != nil {
+			if iface.Name == "" {
+				return err
+			}
+			return fmt.Errorf("interface %s: %v", iface.Name, err)
+		}
+	}
+	if len(c.Nameservers) > 0 || len(c.Search) > 0 {
+		return ioutil.WriteFile(resolvConf, c.ResolvConf(), 0644)
+	}
+	return nil
+}
+
+// ResolvConf returns the contents of resolv.conf for c.
+func (c *Config) ResolvConf() []byte {
+	var b bytes.Buffer
+	if len(c.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(c.Search, " "))
+	}
+	for _, ns := range c.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", parseIP(ns))
+	}
+	return b.Bytes()
+}
+
+// device is a bond, bridge or VLAN to create.
+type device struct {
+	kind, name string
+
+	// lower are the devices it is built on.
+	lower []string
+
+	apply func() error
+}
+
+// devices returns the bonds, bridges and VLANs of c in the order they are
+// created in: every device after the devices of c it is built on, and
+// otherwise bonds, then VLANs, then bridges.
+func (c *Config) devices() ([]device, error) {
+	var all []device
+	for i := range c.Bonds {
+		b := &c.Bonds[i]
+		all = append(all, device{"bond", b.Name, b.Slaves, b.apply})
+	}
+	for i := range c.VLANs {
+		v := &c.VLANs[i]
+		all = append(all, device{"vlan", v.Name, []string{v.Link}, v.apply})
+	}
+	for i := range c.Bridges {
+		b := &c.Bridges[i]
+		all = append(all, device{"bridge", b.Name, b.Ports, b.apply})
+	}
+	byName := make(map[string]int)
+	for i, d := range all {
+		byName[d.name] = i
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make([]int, len(all))
+	var sorted []device
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("%s %s: device is built on itself", all[i].kind, all[i].name)
+		case done:
+			return nil
+		}
+		state[i] = visiting
+		for _, l := range all[i].lower {
+			if j, ok := byName[l]; ok {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = done
+		sorted = append(sorted, all[i])
+		return nil
+	}
+	for i := range all {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// create adds link unless a link of its name exists, and returns the link
+// of that name.
+func create(link netlink.Link) (netlink.Link, error) {
+	name := link.Attrs().Name
+	if l, err := netlink.LinkByName(name); err == nil {
+		return l, nil
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return nil, err
+	}
+	return netlink.LinkByName(name)
+}
+
+// enslave makes the links named ports slaves of master and brings them up.
+func enslave(master netlink.Link, ports []string) error {
+	for _, p := range ports {
+		l, err := netlink.LinkByName(p)
+		if err != nil {
+			return err
+		}
+		if l.Attrs().MasterIndex == master.Attrs().Index {
+			continue
+		}
+		// Bonds only take slaves that are down.
+		if err := netlink.LinkSetDown(l); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		if err := netlink.LinkSetMasterByIndex(l, master.Attrs().Index); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		if err := netlink.LinkSetUp(l); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func (b *Bond) apply() error {
+	bond := netlink.NewLinkBond(netlink.LinkAttrs{Name: b.Name, MTU: b.MTU, TxQLen: -1})
+	if b.Mode != "" {
+		bond.Mode = netlink.StringToBondMode(b.Mode)
+	}
+	if b.Miimon != 0 {
+		bond.Miimon = b.Miimon
+	}
+	l, err := create(bond)
+	if err != nil {
+		return err
+	}
+	if err := enslave(l, b.Slaves); err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(l)
+}
+
+func (b *Bridge) apply() error {
+	l, err := create(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: b.Name, TxQLen: -1}})
+	if err != nil {
+		return err
+	}
+	if err := enslave(l, b.Ports); err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(l)
+}
+
+func (v *VLAN) apply() error {
+	parent, err := netlink.LinkByName(v.Link)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(parent); err != nil {
+		return fmt.Errorf("%s: %v", v.Link, err)
+	}
+	l, err := create(&netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: v.Name, ParentIndex: parent.Attrs().Index, TxQLen: -1},
+		VlanId:    v.ID,
+	})
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(l)
+}
+
+// defaultRoute returns the default route of the family of gw.
+func defaultRoute(gw net.IP) *net.IPNet {
+	if gw.To4() != nil {
+		return &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+	}
+	return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+}
+
+func (i *Interface) apply(ctx context.Context) error {
+	if i.Name == "" {
+		// Validate made sure this is DHCP on any interface.
+		return i.dhcp(ctx)
+	}
+
+	l, err := netlink.LinkByName(i.Name)
+	if err != nil {
+		return err
+	}
+	if i.MAC != "" {
+		mac, err := net.ParseMAC(i.MAC)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetHardwareAddr(l, mac); err != nil {
+			return fmt.Errorf("setting MAC address: %v", err)
+		}
+	}
+	if i.MTU != 0 {
+		if err := netlink.LinkSetMTU(l, i.MTU); err != nil {
+			return fmt.Errorf("setting MTU: %v", err)
+		}
+	}
+	if err := netlink.LinkSetUp(l); err != nil {
+		return err
+	}
+
+	for _, a := range i.Addresses {
+		addr, err := netlink.ParseAddr(a)
+		if err != nil {
+			return err
+		}
+		if err := netlink.AddrReplace(l, addr); err != nil {
+			return fmt.Errorf("adding %s: %v", a, err)
+		}
+	}
+	if i.Gateway != "" {
+		gw := parseIP(i.Gateway)
+		r := &netlink.Route{LinkIndex: l.Attrs().Index, Dst: defaultRoute(gw), Gw: gw}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("adding default route via %s: %v", gw, err)
+		}
+	}
+	for _, rt := range i.Routes {
+		r := &netlink.Route{LinkIndex: l.Attrs().Index, Priority: rt.Metric}
+		if rt.Via != "" {
+			r.Gw = parseIP(rt.Via)
+		} else {
+			r.Scope = netlink.SCOPE_LINK
+		}
+		if rt.To == "default" {
+			r.Dst = defaultRoute(r.Gw)
+		} else if _, r.Dst, err = net.ParseCIDR(rt.To); err != nil {
+			return err
+		}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("adding route to %s: %v", rt.To, err)
+		}
+	}
+
+	switch i.Autoconf {
+	case DHCP, DHCP6, Any:
+		if err := i.dhcp(ctx); err != nil {
+			return err
+		}
+	}
+	if i.Hostname != "" {
+		if err := unix.Sethostname([]byte(i.Hostname)); err != nil {
+			return fmt.Errorf("setting hostname: %v", err)
+		}
+	}
+	return nil
+}
+
+// dhcp requests a lease on the interface, or on any interface if it has no
+// name, and configures it.
+func (i *Interface) dhcp(ctx context.Context) error {
+	var links []netlink.Link
+	if i.Name != "" {
+		l, err := netlink.LinkByName(i.Name)
+		if err != nil {
+			return err
+		}
+		links = append(links, l)
+	} else {
+		all, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+		for _, l := range all {
+			if l.Attrs().Flags&net.FlagLoopback == 0 {
+				links = append(links, l)
+			}
+		}
+	}
+
+	lease, err := dhclient.RequestAny(ctx, links, dhclient.Config{
+		Timeout: dhcpTimeout,
+		Retries: dhcpRetries,
+		IPv4:    i.Autoconf != DHCP6,
+		IPv6:    i.Autoconf != DHCP,
+	})
+	if err != nil {
+		return err
+	}
+	if err := lease.Configure(); err != nil {
+		return fmt.Errorf("configuring %v: %v", lease, err)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconfig
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+)
+
+// autoconfs maps the autoconf values of ip= to ours.
+var autoconfs = map[string]Autoconf{
+	"":      None,
+	"none":  None,
+	"off":   None,
+	"dhcp":  DHCP,
+	"on":    DHCP,
+	"dhcp6": DHCP6,
+	"auto6": Auto6,
+	"any":   Any,
+}
+
+// splitIP splits an ip= value at colons, but not at colons of bracketed
+// IPv6 addresses, and joins a trailing MAC address back together.
+func splitIP(s string) []string {
+	var fields []string
+	var field strings.Builder
+	var bracket bool
+	for _, c := range s {
+		switch {
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case c == ':' && !bracket:
+			fields = append(fields, field.String())
+			field.Reset()
+			continue
+		}
+		field.WriteRune(c)
+	}
+	fields = append(fields, field.String())
+
+	if n := len(fields) - 6; n >= 0 {
+		if _, err := net.ParseMAC(strings.Join(fields[n:], ":")); err == nil {
+			fields = append(fields[:n], strings.Join(fields[n:], ":"))
+		}
+	}
+	return fields
+}
+
+func parseAutoconf(s string) (Autoconf, error) {
+	a, ok := autoconfs[s]
+	if !ok {
+		return "", fmt.Errorf("unsupported autoconf %q", s)
+	}
+	return a, nil
+}
+
+// parseMTUAndMAC parses the optional trailing [mtu][:mac] fields of ip=.
+func parseMTUAndMAC(i *Interface, fields []string) error {
+	if len(fields) > 2 {
+		return fmt.Errorf("too many fields")
+	}
+	if len(fields) > 0 && fields[0] != "" {
+		mtu, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid MTU %q", fields[0])
+		}
+		i.MTU = mtu
+	}
+	if len(fields) > 1 {
+		i.MAC = fields[1]
+	}
+	return nil
+}
+
+// parseNetmask returns the prefix of ip with netmask, which is a dotted
+// netmask or a prefix length. An empty netmask means the classful one for
+// IPv4 and /64 for IPv6.
+func parseNetmask(ip net.IP, netmask string) (string, error) {
+	if netmask == "" {
+		if ip.To4() != nil {
+			ones, _ := ip.DefaultMask().Size()
+			return fmt.Sprintf("%s/%d", ip, ones), nil
+		}
+		return fmt.Sprintf("%s/64", ip), nil
+	}
+	if _, err := strconv.Atoi(netmask); err == nil {
+		return fmt.Sprintf("%s/%s", ip, netmask), nil
+	}
+	mask := net.ParseIP(netmask).To4()
+	if mask == nil {
+		return "", fmt.Errorf("invalid netmask %q", netmask)
+	}
+	ones, bits := net.IPMask(mask).Size()
+	if bits == 0 {
+		return "", fmt.Errorf("invalid netmask %q", netmask)
+	}
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+// ParseIP parses the value of an ip= parameter, like dracut, in one of
+// these forms:
+//
+//	ip=<autoconf>
+//	ip=<interface>:<autoconf>[:[<mtu>][:<macaddr>]]
+//	ip=<client-IP>:[<peer>]:<gateway-IP>:<netmask>:<client_hostname>:<interface>:<autoconf>[:[<mtu>][:<macaddr>]]
+//	ip=<client-IP>:[<peer>]:<gateway-IP>:<netmask>:<client_hostname>:<interface>:<autoconf>[:[<dns1>][:<dns2>]]
+//
+// autoconf is one of none, off, dhcp, on, dhcp6, auto6 or any. IPv6
+// addresses are in brackets. DNS servers are returned separately.
+func ParseIP(s string) (*Interface, []string, error) {
+	fields := splitIP(s)
+	i := &Interface{}
+	var err error
+	switch {
+	case len(fields) == 1:
+		if i.Autoconf, err = parseAutoconf(fields[0]); err != nil {
+			return nil, nil, fmt.Errorf("ip=%s: %v", s, err)
+		}
+		if i.Autoconf == None {
+			return nil, nil, fmt.Errorf("ip=%s: no interface", s)
+		}
+		return i, nil, nil
+
+	case len(fields) <= 4:
+		i.Name = fields[0]
+		if i.Autoconf, err = parseAutoconf(fields[1]); err != nil {
+			return nil, nil, fmt.Errorf("ip=%s: %v", s, err)
+		}
+		if err := parseMTUAndMAC(i, fields[2:]); err != nil {
+			return nil, nil, fmt.Errorf("ip=%s: %v", s, err)
+		}
+		return i, nil, nil
+
+	case len(fields) < 7:
+		return nil, nil, fmt.Errorf("ip=%s: too few fields", s)
+	}
+
+	i.Name, i.Hostname = fields[5], fields[4]
+	if i.Autoconf, err = parseAutoconf(fields[6]); err != nil {
+		return nil, nil, fmt.Errorf("ip=%s: %v", s, err)
+	}
+	if client := fields[0]; client != "" {
+		if strings.Contains(client, "/") {
+			i.Addresses = []string{strings.Trim(client, "[]")}
+		} else {
+			ip := parseIP(client)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("ip=%s: invalid address %q", s, client)
+			}
+			a, err := parseNetmask(ip, fields[3])
+			if err != nil {
+				return nil, nil, fmt.Errorf("ip=%s: %v", s, err)
+			}
+			i.Addresses = []string{a}
+		}
+	}
+	if gw := fields[2]; gw != "" {
+		i.Gateway = strings.Trim(gw, "[]")
+	}
+
+	// The trailing fields are either DNS servers or MTU and MAC.
+	var dns []string
+	rest := fields[7:]
+	if len(rest) > 0 && (rest[0] == "" || parseIP(rest[0]) == nil) {
+		err = parseMTUAndMAC(i, rest)
+	} else {
+		if len(rest) > 2 {
+			return nil, nil, fmt.Errorf("ip=%s: too many fields", s)
+		}
+		for _, d := range rest {
+			if d != "" {
+				dns = append(dns, strings.Trim(d, "[]"))
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("ip=%s: %v", s, err)
+	}
+	return i, dns, nil
+}
+
+// ParseBond parses the value of a bond= parameter:
+//
+//	bond=<bondname>[:<bondslaves>[:<options>[:<mtu>]]]
+//
+// bondslaves are separated by commas, as are options like
+// mode=active-backup,miimon=100. A bare bond is bond0 of eth0 and eth1.
+func ParseBond(s string) (*Bond, error) {
+	if s == "" || s == "1" {
+		return &Bond{Name: "bond0", Slaves: []string{"eth0", "eth1"}}, nil
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) > 4 {
+		return nil, fmt.Errorf("bond=%s: too many fields", s)
+	}
+	b := &Bond{Name: fields[0], Slaves: []string{"eth0", "eth1"}}
+	if len(fields) > 1 && fields[1] != "" {
+		b.Slaves = strings.Split(fields[1], ",")
+	}
+	if len(fields) > 2 && fields[2] != "" {
+		for _, o := range strings.Split(fields[2], ",") {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("bond=%s: invalid option %q", s, o)
+			}
+			switch kv[0] {
+			case "mode":
+				b.Mode = kv[1]
+			case "miimon":
+				n, err := strconv.Atoi(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("bond=%s: invalid miimon %q", s, kv[1])
+				}
+				b.Miimon = n
+			default:
+				return nil, fmt.Errorf("bond=%s: unsupported option %q", s, kv[0])
+			}
+		}
+	}
+	if len(fields) > 3 && fields[3] != "" {
+		n, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("bond=%s: invalid MTU %q", s, fields[3])
+		}
+		b.MTU = n
+	}
+	return b, nil
+}
+
+// ParseBridge parses the value of a bridge= parameter:
+//
+//	bridge=<bridgename>:<ethnames>
+//
+// ethnames are separated by commas. A bare bridge is br0 of eth0.
+func ParseBridge(s string) (*Bridge, error) {
+	if s == "" || s == "1" {
+		return &Bridge{Name: "br0", Ports: []string{"eth0"}}, nil
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("bridge=%s: want <bridgename>:<ethnames>", s)
+	}
+	return &Bridge{Name: fields[0], Ports: strings.Split(fields[1], ",")}, nil
+}
+
+// vlanName matches the VLAN names dracut supports: vlan0005, vlan5,
+// eth0.0005 and eth0.5.
+var vlanName = regexp.MustCompile(`^(?:vlan|.+\.)0*([0-9]+)$`)
+
+// ParseVLAN parses the value of a vlan= parameter:
+//
+//	vlan=<vlanname>:<phys>
+//
+// The VLAN ID is taken from the name.
+func ParseVLAN(s string) (*VLAN, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("vlan=%s: want <vlanname>:<phys>", s)
+	}
+	m := vlanName.FindStringSubmatch(fields[0])
+	if m == nil {
+		return nil, fmt.Errorf("vlan=%s: no VLAN ID in name %q", s, fields[0])
+	}
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("vlan=%s: %v", s, err)
+	}
+	return &VLAN{Name: fields[0], Link: fields[1], ID: id}, nil
+}
+
+// ParseCmdline returns the network configuration of the ip=, nameserver=,
+// bond=, bridge= and vlan= parameters of c. All parameters may be given
+// more than once.
+func ParseCmdline(c cmdline.CmdLine) (*Config, error) {
+	conf := &Config{}
+	for _, s := range c.FlagValues("ip") {
+		i, dns, err := ParseIP(s)
+		if err != nil {
+			return nil, err
+		}
+		conf.Interfaces = append(conf.Interfaces, *i)
+		conf.Nameservers = append(conf.Nameservers, dns...)
+	}
+	for _, s := range c.FlagValues("nameserver") {
+		conf.Nameservers = append(conf.Nameservers, strings.Trim(s, "[]"))
+	}
+	for _, s := range c.FlagValues("bond") {
+		b, err := ParseBond(s)
+		if err != nil {
+			return nil, err
+		}
+		conf.Bonds = append(conf.Bonds, *b)
+	}
+	for _, s := range c.FlagValues("bridge") {
+		b, err := ParseBridge(s)
+		if err != nil {
+			return nil, err
+		}
+		conf.Bridges = append(conf.Bridges, *b)
+	}
+	for _, s := range c.FlagValues("vlan") {
+		v, err := ParseVLAN(s)
+		if err != nil {
+			return nil, err
+		}
+		conf.VLANs = append(conf.VLANs, *v)
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// FromCmdline returns the network configuration of the kernel command
+// line.
+func FromCmdline() (*Config, error) {
+	c := cmdline.NewCmdLine()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return ParseCmdline(c)
+}
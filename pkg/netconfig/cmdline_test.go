@@ -0,0 +1,184 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+)
+
+func TestParseIP(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want *Interface
+		dns  []string
+	}{
+		{
+			s:    "dhcp",
+			want: &Interface{Autoconf: DHCP},
+		},
+		{
+			s:    "eth0:on",
+			want: &Interface{Name: "eth0", Autoconf: DHCP},
+		},
+		{
+			s:    "eth0:dhcp6:9000:52:54:00:12:34:56",
+			want: &Interface{Name: "eth0", Autoconf: DHCP6, MTU: 9000, MAC: "52:54:00:12:34:56"},
+		},
+		{
+			s: "10.0.0.5::10.0.0.1:255.255.255.0:host:eth0:none",
+			want: &Interface{
+				Name:      "eth0",
+				Autoconf:  None,
+				Addresses: []string{"10.0.0.5/24"},
+				Gateway:   "10.0.0.1",
+				Hostname:  "host",
+			},
+		},
+		{
+			s: "10.0.0.5::10.0.0.1:16::eth1:off:8.8.8.8:8.8.4.4",
+			want: &Interface{
+				Name:      "eth1",
+				Autoconf:  None,
+				Addresses: []string{"10.0.0.5/16"},
+				Gateway:   "10.0.0.1",
+			},
+			dns: []string{"8.8.8.8", "8.8.4.4"},
+		},
+		{
+			s: "[2001:db8::5]::[2001:db8::1]:64::eth0:none:1500:52:54:00:12:34:56",
+			want: &Interface{
+				Name:      "eth0",
+				Autoconf:  None,
+				Addresses: []string{"2001:db8::5/64"},
+				Gateway:   "2001:db8::1",
+				MTU:       1500,
+				MAC:       "52:54:00:12:34:56",
+			},
+		},
+		{
+			s: "10.1.2.3:::::eth0:any",
+			want: &Interface{
+				Name:      "eth0",
+				Autoconf:  Any,
+				Addresses: []string{"10.1.2.3/8"},
+			},
+		},
+	} {
+		got, dns, err := ParseIP(tt.s)
+		if err != nil {
+			t.Errorf("ParseIP(%q) = %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(dns, tt.dns) {
+			t.Errorf("ParseIP(%q) = %+v, %v, want %+v, %v", tt.s, got, dns, tt.want, tt.dns)
+		}
+	}
+
+	for _, s := range []string{
+		"none",
+		"bogus",
+		"eth0:bogus",
+		"eth0:dhcp:mtu",
+		"10.0.0.5::10.0.0.1:255.255.255.0:host",
+		"10.0.0.5::10.0.0.1:255.0.255.0:host:eth0:none",
+		"10.0.0.5::10.0.0.1:24:host:eth0:none:8.8.8.8:8.8.4.4:1.1.1.1",
+	} {
+		if _, _, err := ParseIP(s); err == nil {
+			t.Errorf("ParseIP(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestParseBond(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want *Bond
+	}{
+		{"1", &Bond{Name: "bond0", Slaves: []string{"eth0", "eth1"}}},
+		{"bond1", &Bond{Name: "bond1", Slaves: []string{"eth0", "eth1"}}},
+		{
+			"bond0:eth2,eth3:mode=active-backup,miimon=100:9000",
+			&Bond{Name: "bond0", Slaves: []string{"eth2", "eth3"}, Mode: "active-backup", Miimon: 100, MTU: 9000},
+		},
+	} {
+		got, err := ParseBond(tt.s)
+		if err != nil {
+			t.Errorf("ParseBond(%q) = %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseBond(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"bond0:eth0:mode", "bond0:eth0:lacp_rate=1", "bond0:eth0::mtu"} {
+		if _, err := ParseBond(s); err == nil {
+			t.Errorf("ParseBond(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestParseVLAN(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want *VLAN
+	}{
+		{"vlan0005:eth0", &VLAN{Name: "vlan0005", Link: "eth0", ID: 5}},
+		{"vlan12:eth0", &VLAN{Name: "vlan12", Link: "eth0", ID: 12}},
+		{"eth1.0100:eth1", &VLAN{Name: "eth1.0100", Link: "eth1", ID: 100}},
+	} {
+		got, err := ParseVLAN(tt.s)
+		if err != nil {
+			t.Errorf("ParseVLAN(%q) = %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVLAN(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"vlan5", "foo:eth0"} {
+		if _, err := ParseVLAN(s); err == nil {
+			t.Errorf("ParseVLAN(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestParseCmdline(t *testing.T) {
+	c := cmdline.CmdLine{Raw: strings.Join([]string{
+		"console=ttyS0",
+		"bond=bond0:eth0,eth1:mode=802.3ad",
+		"bridge=br0:bond0",
+		"vlan=br0.7:br0",
+		"ip=10.0.0.5::10.0.0.1:24::br0.7:none",
+		"ip=eth2:dhcp",
+		"nameserver=10.0.0.53",
+		"nameserver=[2001:db8::53]",
+	}, " ")}
+	got, err := ParseCmdline(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Bonds:   []Bond{{Name: "bond0", Slaves: []string{"eth0", "eth1"}, Mode: "802.3ad"}},
+		Bridges: []Bridge{{Name: "br0", Ports: []string{"bond0"}}},
+		VLANs:   []VLAN{{Name: "br0.7", Link: "br0", ID: 7}},
+		Interfaces: []Interface{
+			{Name: "br0.7", Autoconf: None, Addresses: []string{"10.0.0.5/24"}, Gateway: "10.0.0.1"},
+			{Name: "eth2", Autoconf: DHCP},
+		},
+		Nameservers: []string{"10.0.0.53", "2001:db8::53"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCmdline() = %+v, want %+v", got, want)
+	}
+
+	c = cmdline.CmdLine{Raw: "bond=bond0:eth0:mode=bogus"}
+	if _, err := ParseCmdline(c); err == nil {
+		t.Errorf("ParseCmdline(%q) succeeded, want error", c.Raw)
+	}
+}
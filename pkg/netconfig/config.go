@@ -0,0 +1,291 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netconfig configures networking declaratively.
+//
+// A Config describes interfaces, bonds, bridges, VLANs and DNS servers. It
+// is read from dracut-style ip=, nameserver=, bond=, vlan= and bridge=
+// kernel parameters with ParseCmdline, or from a YAML or JSON file with
+// Load, and applied with Apply:
+//
+//	c, err := netconfig.FromCmdline()
+//	if err != nil {
+//		return err
+//	}
+//	if err := c.Apply(ctx); err != nil {
+//		return err
+//	}
+package netconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"gopkg.in/yaml.v2"
+)
+
+// Autoconf is how an interface gets its addresses.
+type Autoconf string
+
+// Autoconf values, as in dracut's ip= parameter.
+const (
+	// None means only the static addresses are configured.
+	None Autoconf = "none"
+
+	// DHCP requests a DHCPv4 lease.
+	DHCP Autoconf = "dhcp"
+
+	// DHCP6 requests a DHCPv6 lease.
+	DHCP6 Autoconf = "dhcp6"
+
+	// Auto6 leaves IPv6 addresses to the kernel's SLAAC.
+	Auto6 Autoconf = "auto6"
+
+	// Any requests a DHCPv4 and a DHCPv6 lease, and takes the first.
+	Any Autoconf = "any"
+)
+
+// Route is a static route.
+type Route struct {
+	// To is the destination prefix, or "default".
+	To string `json:"to" yaml:"to"`
+
+	// Via is the gateway.
+	Via string `json:"via,omitempty" yaml:"via,omitempty"`
+
+	Metric int `json:"metric,omitempty" yaml:"metric,omitempty"`
+}
+
+// Interface is the configuration of a network interface.
+type Interface struct {
+	// Name is the interface name. It may only be empty for DHCP, which
+	// then takes the first lease of any interface.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// Autoconf is how addresses are obtained. Empty means None.
+	Autoconf Autoconf `json:"autoconf,omitempty" yaml:"autoconf,omitempty"`
+
+	// Addresses are static addresses in CIDR notation.
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+
+	// Gateway is the default gateway.
+	Gateway string `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+
+	Routes []Route `json:"routes,omitempty" yaml:"routes,omitempty"`
+
+	// Hostname is the host name to set, if any.
+	Hostname string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+
+	// MTU and MAC are set if not zero.
+	MTU int    `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+	MAC string `json:"mac,omitempty" yaml:"mac,omitempty"`
+}
+
+// Bond is a bonding device.
+type Bond struct {
+	Name   string   `json:"name" yaml:"name"`
+	Slaves []string `json:"slaves" yaml:"slaves"`
+
+	// Mode is the bonding mode, like active-backup. Empty means
+	// balance-rr.
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+
+	// Miimon is the link monitoring interval in milliseconds.
+	Miimon int `json:"miimon,omitempty" yaml:"miimon,omitempty"`
+
+	MTU int `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+}
+
+// Bridge is a bridge device.
+type Bridge struct {
+	Name  string   `json:"name" yaml:"name"`
+	Ports []string `json:"ports" yaml:"ports"`
+}
+
+// VLAN is an 802.1Q VLAN device.
+type VLAN struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Link is the underlying device.
+	Link string `json:"link" yaml:"link"`
+
+	ID int `json:"id" yaml:"id"`
+}
+
+// Config is a network configuration.
+type Config struct {
+	// Bonds, Bridges and VLANs are created before Interfaces are
+	// configured, so that interfaces may refer to them. Each is created
+	// after the devices it is built on.
+	Bonds   []Bond   `json:"bonds,omitempty" yaml:"bonds,omitempty"`
+	Bridges []Bridge `json:"bridges,omitempty" yaml:"bridges,omitempty"`
+	VLANs   []VLAN   `json:"vlans,omitempty" yaml:"vlans,omitempty"`
+
+	Interfaces []Interface `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+
+	// Nameservers and Search go into /etc/resolv.conf. They replace DNS
+	// servers obtained with DHCP.
+	Nameservers []string `json:"nameservers,omitempty" yaml:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty" yaml:"search,omitempty"`
+}
+
+// Merge appends the configuration in o to c.
+func (c *Config) Merge(o *Config) {
+	c.Bonds = append(c.Bonds, o.Bonds...)
+	c.Bridges = append(c.Bridges, o.Bridges...)
+	c.VLANs = append(c.VLANs, o.VLANs...)
+	c.Interfaces = append(c.Interfaces, o.Interfaces...)
+	c.Nameservers = append(c.Nameservers, o.Nameservers...)
+	c.Search = append(c.Search, o.Search...)
+}
+
+// parseIP parses an address, which may be in brackets, as IPv6 addresses
+// are on the kernel command line.
+func parseIP(s string) net.IP {
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+}
+
+func (r *Route) validate() error {
+	if r.To != "default" {
+		if _, _, err := net.ParseCIDR(r.To); err != nil {
+			return fmt.Errorf("route to %q: %v", r.To, err)
+		}
+	}
+	if r.Via != "" && parseIP(r.Via) == nil {
+		return fmt.Errorf("route to %s: invalid gateway %q", r.To, r.Via)
+	}
+	if r.Via == "" && r.To == "default" {
+		return fmt.Errorf("default route without gateway")
+	}
+	return nil
+}
+
+func (i *Interface) validate() error {
+	switch i.Autoconf {
+	case "", None, Auto6:
+		if i.Name == "" {
+			return fmt.Errorf("interface without name")
+		}
+	case DHCP, DHCP6, Any:
+	default:
+		return fmt.Errorf("interface %s: invalid autoconf %q", i.Name, i.Autoconf)
+	}
+	for _, a := range i.Addresses {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return fmt.Errorf("interface %s: %v", i.Name, err)
+		}
+	}
+	if i.Gateway != "" && parseIP(i.Gateway) == nil {
+		return fmt.Errorf("interface %s: invalid gateway %q", i.Name, i.Gateway)
+	}
+	for _, r := range i.Routes {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("interface %s: %v", i.Name, err)
+		}
+	}
+	if i.MAC != "" {
+		if _, err := net.ParseMAC(i.MAC); err != nil {
+			return fmt.Errorf("interface %s: %v", i.Name, err)
+		}
+	}
+	if i.MTU < 0 {
+		return fmt.Errorf("interface %s: invalid MTU %d", i.Name, i.MTU)
+	}
+	return nil
+}
+
+// Validate checks that c is complete and well-formed.
+func (c *Config) Validate() error {
+	devices := make(map[string]bool)
+	newDevice := func(kind, name string) error {
+		if name == "" {
+			return fmt.Errorf("%s without name", kind)
+		}
+		if devices[name] {
+			return fmt.Errorf("%s %s: device defined twice", kind, name)
+		}
+		devices[name] = true
+		return nil
+	}
+
+	for _, b := range c.Bonds {
+		if err := newDevice("bond", b.Name); err != nil {
+			return err
+		}
+		if len(b.Slaves) == 0 {
+			return fmt.Errorf("bond %s: no slaves", b.Name)
+		}
+		if _, ok := netlink.StringToBondModeMap[b.Mode]; b.Mode != "" && !ok {
+			return fmt.Errorf("bond %s: invalid mode %q", b.Name, b.Mode)
+		}
+	}
+	for _, b := range c.Bridges {
+		if err := newDevice("bridge", b.Name); err != nil {
+			return err
+		}
+	}
+	for _, v := range c.VLANs {
+		if err := newDevice("vlan", v.Name); err != nil {
+			return err
+		}
+		if v.Link == "" {
+			return fmt.Errorf("vlan %s: no link", v.Name)
+		}
+		if v.ID < 1 || v.ID > 4094 {
+			return fmt.Errorf("vlan %s: invalid ID %d", v.Name, v.ID)
+		}
+	}
+	if _, err := c.devices(); err != nil {
+		return err
+	}
+	for i := range c.Interfaces {
+		if err := c.Interfaces[i].validate(); err != nil {
+			return err
+		}
+	}
+	for _, ns := range c.Nameservers {
+		if parseIP(ns) == nil {
+			return fmt.Errorf("invalid nameserver %q", ns)
+		}
+	}
+	return nil
+}
+
+// Parse parses a YAML or JSON configuration. JSON is YAML, too, but JSON
+// is decoded strictly as JSON if it looks like it.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err := d.Decode(c); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads the configuration in the YAML or JSON file path.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return c, nil
+}
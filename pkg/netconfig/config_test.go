@@ -0,0 +1,173 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var want = &Config{
+	Bonds: []Bond{{Name: "bond0", Slaves: []string{"eth0", "eth1"}, Mode: "active-backup", Miimon: 100}},
+	Interfaces: []Interface{
+		{
+			Name:      "bond0",
+			Addresses: []string{"10.0.0.5/24", "2001:db8::5/64"},
+			Gateway:   "10.0.0.1",
+			Routes:    []Route{{To: "192.168.0.0/16", Via: "10.0.0.2", Metric: 10}},
+		},
+		{Name: "eth2", Autoconf: DHCP},
+	},
+	Nameservers: []string{"10.0.0.53"},
+	Search:      []string{"example.com"},
+}
+
+const yamlConfig = `
+bonds:
+- name: bond0
+  slaves: [eth0, eth1]
+  mode: active-backup
+  miimon: 100
+interfaces:
+- name: bond0
+  addresses: [10.0.0.5/24, 2001:db8::5/64]
+  gateway: 10.0.0.1
+  routes:
+  - to: 192.168.0.0/16
+    via: 10.0.0.2
+    metric: 10
+- name: eth2
+  autoconf: dhcp
+nameservers: [10.0.0.53]
+search: [example.com]
+`
+
+const jsonConfig = `{
+	"bonds": [{"name": "bond0", "slaves": ["eth0", "eth1"], "mode": "active-backup", "miimon": 100}],
+	"interfaces": [
+		{
+			"name": "bond0",
+			"addresses": ["10.0.0.5/24", "2001:db8::5/64"],
+			"gateway": "10.0.0.1",
+			"routes": [{"to": "192.168.0.0/16", "via": "10.0.0.2", "metric": 10}]
+		},
+		{"name": "eth2", "autoconf": "dhcp"}
+	],
+	"nameservers": ["10.0.0.53"],
+	"search": ["example.com"]
+}`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"net.yaml": yamlConfig,
+		"net.json": jsonConfig,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Load(path)
+		if err != nil {
+			t.Errorf("Load(%s) = %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Load(%s) = %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		// Unknown fields.
+		"interface: []",
+		`{"interface": []}`,
+
+		"interfaces: [{autoconf: none}]",
+		"interfaces: [{name: eth0, autoconf: bogus}]",
+		"interfaces: [{name: eth0, addresses: [10.0.0.5]}]",
+		"interfaces: [{name: eth0, gateway: bogus}]",
+		"interfaces: [{name: eth0, routes: [{to: default}]}]",
+		"interfaces: [{name: eth0, mac: bogus}]",
+		"bonds: [{name: bond0}]",
+		"bonds: [{name: bond0, slaves: [eth0], mode: bogus}]",
+		"bridges: [{name: br0}, {name: br0}]",
+		"vlans: [{name: vlan0, link: eth0}]",
+		"bridges: [{name: br0, ports: [br1]}, {name: br1, ports: [br0]}]",
+		"nameservers: [bogus]",
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestDeviceOrder(t *testing.T) {
+	for _, tt := range []struct {
+		c    Config
+		want []string
+	}{
+		{
+			// vlan=eth0.100:eth0 bridge=br0:eth0.100
+			c: Config{
+				Bridges: []Bridge{{Name: "br0", Ports: []string{"eth0.100"}}},
+				VLANs:   []VLAN{{Name: "eth0.100", Link: "eth0", ID: 100}},
+			},
+			want: []string{"eth0.100", "br0"},
+		},
+		{
+			// A VLAN on a bridge of a bond.
+			c: Config{
+				Bonds:   []Bond{{Name: "bond0", Slaves: []string{"eth0", "eth1"}}},
+				Bridges: []Bridge{{Name: "br0", Ports: []string{"bond0"}}},
+				VLANs:   []VLAN{{Name: "br0.7", Link: "br0", ID: 7}},
+			},
+			want: []string{"bond0", "br0", "br0.7"},
+		},
+		{
+			// A bond of VLANs.
+			c: Config{
+				Bonds: []Bond{{Name: "bond0", Slaves: []string{"eth0.5", "eth1.5"}}},
+				VLANs: []VLAN{
+					{Name: "eth0.5", Link: "eth0", ID: 5},
+					{Name: "eth1.5", Link: "eth1", ID: 5},
+				},
+			},
+			want: []string{"eth0.5", "eth1.5", "bond0"},
+		},
+	} {
+		devs, err := tt.c.devices()
+		if err != nil {
+			t.Fatalf("devices() = %v", err)
+		}
+		var got []string
+		for _, d := range devs {
+			got = append(got, d.name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("devices() of %+v are created in order %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestResolvConf(t *testing.T) {
+	c := &Config{
+		Nameservers: []string{"10.0.0.53", "[2001:db8::53]"},
+		Search:      []string{"example.com", "example.org"},
+	}
+	wantConf := "search example.com example.org\nnameserver 10.0.0.53\nnameserver 2001:db8::53\n"
+	if got := string(c.ResolvConf()); got != wantConf {
+		t.Errorf("ResolvConf() = %q, want %q", got, wantConf)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Owner is a process that has a socket open.
+type Owner struct {
+	PID int
+	Cmd string
+	FD  int
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("(%q,pid=%d,fd=%d)", o.Cmd, o.PID, o.FD)
+}
+
+// SocketOwners returns the processes under root that have sockets open,
+// by socket inode. Processes and file descriptors that vanish or that may
+// not be read are skipped.
+func SocketOwners(root string) (map[uint64][]Owner, error) {
+	pids, err := PIDs(root)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[uint64][]Owner)
+	for _, pid := range pids {
+		dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+		d, err := os.Open(dir)
+		if err != nil {
+			continue
+		}
+		fds, _ := d.Readdirnames(-1)
+		d.Close()
+
+		var cmd string
+		for _, name := range fds {
+			fd, err := strconv.Atoi(name)
+			if err != nil {
+				continue
+			}
+			link, err := os.Readlink(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			var inode uint64
+			if _, err := fmt.Sscanf(link, "socket:[%d]", &inode); err != nil {
+				continue
+			}
+			if cmd == "" {
+				st, _ := ReadStat(root, pid)
+				cmd = st.Cmd
+			}
+			owners[inode] = append(owners[inode], Owner{PID: pid, Cmd: cmd, FD: fd})
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package procfs
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+// Socket states, as in the kernel's include/net/tcp_states.h. They are
+// used for all sockets: unconnected UDP and UNIX sockets are TCPClose.
+const (
+	TCPEstablished = 1
+	TCPSynSent     = 2
+	TCPSynRecv     = 3
+	TCPFinWait1    = 4
+	TCPFinWait2    = 5
+	TCPTimeWait    = 6
+	TCPClose       = 7
+	TCPCloseWait   = 8
+	TCPLastAck     = 9
+	TCPListen      = 10
+	TCPClosing     = 11
+)
+
+// NetSocket is a line of /proc/net/tcp, tcp6, udp, udp6, raw or raw6.
+type NetSocket struct {
+	LocalIP    net.IP
+	LocalPort  int
+	RemoteIP   net.IP
+	RemotePort int
+	State      uint8
+	TxQueue    uint32
+	RxQueue    uint32
+	UID        int
+	Inode      uint64
+}
+
+// parseAddr parses an address like 0100007F:0035. The kernel prints the
+// address as 32-bit words in native byte order.
+func parseAddr(s string) (net.IP, int, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	ip := make(net.IP, len(b))
+	for w := 0; w < len(b); w += 4 {
+		v := uint32(b[w])<<24 | uint32(b[w+1])<<16 | uint32(b[w+2])<<8 | uint32(b[w+3])
+		ubinary.NativeEndian.PutUint32(ip[w:], v)
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port in %q", s)
+	}
+	return ip, int(port), nil
+}
+
+// ParseNetIP parses /proc/net/tcp and the like.
+func ParseNetIP(r io.Reader) ([]NetSocket, error) {
+	var socks []NetSocket
+	s := bufio.NewScanner(r)
+	// Skip the header.
+	s.Scan()
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 10 {
+			return nil, fmt.Errorf("short line %q", s.Text())
+		}
+		var sock NetSocket
+		var err error
+		if sock.LocalIP, sock.LocalPort, err = parseAddr(f[1]); err != nil {
+			return nil, err
+		}
+		if sock.RemoteIP, sock.RemotePort, err = parseAddr(f[2]); err != nil {
+			return nil, err
+		}
+		st, err := strconv.ParseUint(f[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state in %q", s.Text())
+		}
+		sock.State = uint8(st)
+		if _, err := fmt.Sscanf(f[4], "%x:%x", &sock.TxQueue, &sock.RxQueue); err != nil {
+			return nil, fmt.Errorf("invalid queues in %q", s.Text())
+		}
+		if sock.UID, err = strconv.Atoi(f[7]); err != nil {
+			return nil, fmt.Errorf("invalid uid in %q", s.Text())
+		}
+		if sock.Inode, err = strconv.ParseUint(f[9], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid inode in %q", s.Text())
+		}
+		socks = append(socks, sock)
+	}
+	return socks, s.Err()
+}
+
+// UnixSocket is a line of /proc/net/unix.
+type UnixSocket struct {
+	// Type is the socket type, like syscall.SOCK_STREAM.
+	Type uint16
+
+	// State is a TCP state: TCPListen, TCPEstablished, or TCPClose for
+	// unconnected sockets.
+	State uint8
+
+	Inode uint64
+
+	// Path is the bound path. Abstract names start with @.
+	Path string
+}
+
+const (
+	// soAcceptCon is the flag of listening sockets.
+	soAcceptCon = 1 << 16
+
+	ssUnconnected   = 1
+	ssConnecting    = 2
+	ssConnected     = 3
+	ssDisconnecting = 4
+)
+
+// ParseNetUnix parses /proc/net/unix.
+func ParseNetUnix(r io.Reader) ([]UnixSocket, error) {
+	var socks []UnixSocket
+	s := bufio.NewScanner(r)
+	s.Scan()
+	for s.Scan() {
+		f := strings.SplitN(strings.Join(strings.Fields(s.Text()), " "), " ", 8)
+		if len(f) < 7 {
+			return nil, fmt.Errorf("short line %q", s.Text())
+		}
+		flags, err := strconv.ParseUint(f[3], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flags in %q", s.Text())
+		}
+		typ, err := strconv.ParseUint(f[4], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type in %q", s.Text())
+		}
+		st, err := strconv.ParseUint(f[5], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state in %q", s.Text())
+		}
+		sock := UnixSocket{Type: uint16(typ)}
+		if sock.Inode, err = strconv.ParseUint(f[6], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid inode in %q", s.Text())
+		}
+		if len(f) == 8 {
+			sock.Path = f[7]
+		}
+		switch {
+		case flags&soAcceptCon != 0:
+			sock.State = TCPListen
+		case st == ssConnected:
+			sock.State = TCPEstablished
+		case st == ssConnecting:
+			sock.State = TCPSynSent
+		case st == ssDisconnecting:
+			sock.State = TCPClosing
+		default:
+			sock.State = TCPClose
+		}
+		socks = append(socks, sock)
+	}
+	return socks, s.Err()
+}
+
+// ReadNetIP reads /proc/net/name under root, like tcp or udp6.
+func ReadNetIP(root, name string) ([]NetSocket, error) {
+	f, err := os.Open(filepath.Join(root, "net", name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	socks, err := ParseNetIP(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Name(), err)
+	}
+	return socks, nil
+}
+
+// ReadNetUnix reads /proc/net/unix under root.
+func ReadNetUnix(root string) ([]UnixSocket, error) {
+	f, err := os.Open(filepath.Join(root, "net", "unix"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	socks, err := ParseNetUnix(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Name(), err)
+	}
+	return socks, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package procfs
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/ubinary"
+)
+
+// hexAddr formats ip as the kernel does in /proc/net.
+func hexAddr(ip net.IP) string {
+	var s string
+	for w := 0; w < len(ip); w += 4 {
+		s += fmt.Sprintf("%08X", ubinary.NativeEndian.Uint32(ip[w:]))
+	}
+	return s
+}
+
+func TestParseNetIP(t *testing.T) {
+	lo := net.IPv4(127, 0, 0, 1).To4()
+	v6 := net.ParseIP("fe80::1")
+	in := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+		"   0: " + hexAddr(lo) + ":0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1234 1 0000000000000000 100 0 0 10 0\n" +
+		"   1: " + hexAddr(v6) + ":C350 " + hexAddr(net.IPv6zero) + ":01BB 01 0000000A:00000002 00:00000000 00000000  1000        0 5678 1 0000000000000000 20 4 30 10 -1\n"
+	got, err := ParseNetIP(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NetSocket{
+		{LocalIP: lo, LocalPort: 53, RemoteIP: net.IPv4zero.To4(), State: TCPListen, Inode: 1234},
+		{LocalIP: v6, LocalPort: 50000, RemoteIP: net.IPv6zero, RemotePort: 443, State: TCPEstablished, TxQueue: 10, RxQueue: 2, UID: 1000, Inode: 5678},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNetIP = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{
+		"   0: 0100007F:0035 00000000:0000 0A\n",
+		"   0: 0100007F 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1234\n",
+		"   0: 0100007F:0035 00000000:0000 ZZ 00000000:00000000 00:00000000 00000000     0        0 1234\n",
+	} {
+		if _, err := ParseNetIP(strings.NewReader("header\n" + bad)); err == nil {
+			t.Errorf("ParseNetIP(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseNetUnix(t *testing.T) {
+	in := `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 100 /run/a b.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 101
+0000000000000000: 00000002 00000000 00000000 0002 01 102 @abstract
+0000000000000000: 00000002 00000000 00000000 0005 02 103
+`
+	got, err := ParseNetUnix(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UnixSocket{
+		{Type: 1, State: TCPListen, Inode: 100, Path: "/run/a b.sock"},
+		{Type: 1, State: TCPEstablished, Inode: 101},
+		{Type: 2, State: TCPClose, Inode: 102, Path: "@abstract"},
+		{Type: 5, State: TCPSynSent, Inode: 103},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNetUnix = %+v, want %+v", got, want)
+	}
+}
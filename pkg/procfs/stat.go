@@ -0,0 +1,132 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package procfs parses files of the Linux /proc file system.
+//
+// Functions take the root of the file system, usually "/proc", so that
+// they work on copies or on /proc of other machines mounted elsewhere.
+package procfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stat is the content of /proc/PID/stat, as defined by
+// https://www.kernel.org/doc/Documentation/filesystems/proc.txt (2009)
+// Section (ctrl + f) : Table 1-4: Contents of the stat files (as of 2.6.30-rc7)
+type Stat struct {
+	Pid         string // process id name
+	Cmd         string // filename of the executable
+	State       string // state (R is running, S is sleeping, D is sleeping in an uninterruptible wait, Z is zombie, T is traced or stopped)
+	Ppid        string // process id of the parent process
+	Pgrp        string // pgrp of the process
+	Sid         string // session id
+	TTYNr       string // tty the process uses
+	TTYPgrp     string // pgrp of the tty
+	Flags       string // task flags
+	MinFlt      string // number of minor faults
+	CminFlt     string // number of minor faults with child's
+	MajFlt      string // number of major faults
+	CmajFlt     string // number of major faults with child's
+	Utime       string // user mode jiffies
+	Stime       string // kernel mode jiffies
+	Cutime      string // user mode jiffies with child's
+	Cstime      string // kernel mode jiffies with child's
+	Priority    string // priority level
+	Nice        string // nice level
+	NumThreads  string // number of threads
+	ItRealValue string // (obsolete, always 0)
+	StartTime   string // time the process started after system boot
+	Vsize       string // virtual memory size
+	Rss         string // resident set memory size
+	Rsslim      string // current limit in bytes on the rss
+	StartCode   string // address above which program text can run
+	EndCode     string // address below which program text can run
+	StartStack  string // address of the start of the main process stack
+	Esp         string // current value of ESP
+	Eip         string // current value of EIP
+	Pending     string // bitmap of pending signals
+	Blocked     string // bitmap of blocked signals
+	Sigign      string // bitmap of ignored signals
+	Sigcatch    string // bitmap of caught signals
+	Wchan       string // place holder, used to be the wchan address, use /proc/PID/wchan
+	Zero1       string // ignored
+	Zero2       string // ignored
+	ExitSignal  string // signal to send to parent thread on exit
+	TaskCPU     string // which CPU the task is scheduled on
+	RtPriority  string // realtime priority
+	Policy      string // scheduling policy (man sched_setscheduler)
+	BlkioTicks  string // time spent waiting for block IO
+	Gtime       string // guest time of the task in jiffies
+	Cgtime      string // guest time of the task children in jiffies
+	StartData   string // address above which program data+bss is placed
+	EndData     string // address below which program data+bss is placed
+	StartBrk    string // address above which program heap can be expanded with brk()
+	ArgStart    string // address above which program command line is placed
+	ArgEnd      string // address below which program command line is placed
+	EnvStart    string // address above which program environment is placed
+	EnvEnd      string // address below which program environment is placed
+	ExitCode    string // the thread's exit_code in the form reported by the waitpid system call (end of stat)
+}
+
+// ParseStat parses the content of /proc/PID/stat.
+//
+// The filename of the executable is in parentheses and may contain spaces
+// and parentheses itself. Fields missing at the end are left empty, and
+// fields the kernel added since are ignored.
+func ParseStat(s string) Stat {
+	s = strings.TrimSuffix(s, "\n")
+	var fields []string
+	open, end := strings.Index(s, "("), strings.LastIndex(s, ")")
+	if open >= 0 && end > open {
+		fields = append(strings.Fields(s[:open]), s[open+1:end])
+		fields = append(fields, strings.Fields(s[end+1:])...)
+	} else {
+		fields = strings.Split(s, " ")
+	}
+
+	var st Stat
+	v := reflect.ValueOf(&st).Elem()
+	for i := 0; i < len(fields) && i < v.NumField(); i++ {
+		v.Field(i).SetString(fields[i])
+	}
+	return st
+}
+
+// ReadStat reads /proc/PID/stat of pid under root.
+func ReadStat(root string, pid int) (Stat, error) {
+	b, err := ioutil.ReadFile(filepath.Join(root, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return Stat{}, err
+	}
+	return ParseStat(string(b)), nil
+}
+
+// PIDs returns the IDs of all processes under root, in order.
+func PIDs(root string) ([]int, error) {
+	d, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, n := range names {
+		if pid, err := strconv.Atoi(n); err == nil && pid > 0 {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
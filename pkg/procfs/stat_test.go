@@ -0,0 +1,97 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package procfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseStat(t *testing.T) {
+	for _, tt := range []struct {
+		in                    string
+		pid, cmd, state, ppid string
+		exitCode, numThreads  string
+	}{
+		{
+			// The 52 fields of Linux 3.5 and later.
+			in:  "1 (init) S 0 1 1 0 -1 4194560 9 9 9 9 9 9 9 9 20 0 1 0 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 0\n",
+			pid: "1", cmd: "init", state: "S", ppid: "0", numThreads: "1", exitCode: "0",
+		},
+		{
+			in:  "42 (a b) c)) R 7 42",
+			pid: "42", cmd: "a b) c)", state: "R", ppid: "7",
+		},
+		{
+			in:  "3 bad",
+			pid: "3", cmd: "bad",
+		},
+	} {
+		st := ParseStat(tt.in)
+		got := []string{st.Pid, st.Cmd, st.State, st.Ppid, st.NumThreads, st.ExitCode}
+		want := []string{tt.pid, tt.cmd, tt.state, tt.ppid, tt.numThreads, tt.exitCode}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseStat(%q) = %q, want %q", tt.in, got, want)
+		}
+	}
+}
+
+func TestPIDsAndSocketOwners(t *testing.T) {
+	root, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, pid := range []string{"10", "2"} {
+		if err := os.MkdirAll(filepath.Join(root, pid, "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, pid, "stat"), []byte(pid+" (cmd"+pid+") S 1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, d := range []string{"self", "net"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []struct{ fd, target string }{
+		{"2/fd/3", "socket:[100]"},
+		{"10/fd/0", "/dev/null"},
+		{"10/fd/4", "socket:[100]"},
+		{"10/fd/5", "socket:[200]"},
+	} {
+		if err := os.Symlink(l.target, filepath.Join(root, l.fd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pids, err := PIDs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 10}; !reflect.DeepEqual(pids, want) {
+		t.Errorf("PIDs = %v, want %v", pids, want)
+	}
+
+	owners, err := SocketOwners(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64][]Owner{
+		100: {{PID: 2, Cmd: "cmd2", FD: 3}, {PID: 10, Cmd: "cmd10", FD: 4}},
+		200: {{PID: 10, Cmd: "cmd10", FD: 5}},
+	}
+	if !reflect.DeepEqual(owners, want) {
+		t.Errorf("SocketOwners = %v, want %v", owners, want)
+	}
+	if got, want := owners[200][0].String(), `("cmd10",pid=10,fd=5)`; got != want {
+		t.Errorf("Owner.String() = %s, want %s", got, want)
+	}
+}
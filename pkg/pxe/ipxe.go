@@ -0,0 +1,661 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+// ipxeMagic is the first line of every iPXE script.
+const ipxeMagic = "#!ipxe"
+
+const (
+	// maxIPXEDepth limits how deeply scripts can chain other scripts.
+	maxIPXEDepth = 8
+
+	// maxIPXEGotos bounds the jumps of a script, so that retry loops
+	// end.
+	maxIPXEGotos = 64
+)
+
+var (
+	// ErrIPXENoKernel is returned when an iPXE script ends without having
+	// loaded a kernel.
+	ErrIPXENoKernel = errors.New("iPXE script did not load a kernel")
+
+	// errIPXEBoot and errIPXEExit stop the script being run.
+	errIPXEBoot = errors.New("boot")
+	errIPXEExit = errors.New("exit")
+)
+
+// ipxeGoto jumps to a label of the script being run.
+type ipxeGoto string
+
+func (g ipxeGoto) Error() string {
+	return fmt.Sprintf("no such label %q", string(g))
+}
+
+// IsIPXEScript returns whether b is the start of an iPXE script.
+func IsIPXEScript(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(ipxeMagic))
+}
+
+// IPXE interprets iPXE scripts to find the kernel, initrds and command line
+// they boot.
+//
+// See https://ipxe.org/scripting and https://ipxe.org/cmd. Commands that
+// configure the network or interact with the user are accepted but do
+// nothing; menus behave as if their timeout expired.
+type IPXE struct {
+	// Vars holds iPXE settings by name, e.g. "net0/mac" or
+	// "next-server". Unqualified names are also looked up in net0.
+	Vars map[string]string
+
+	// Scripts holds the scripts run, in order, so they can be measured.
+	Scripts []File
+
+	wd      *url.URL
+	schemes Schemes
+	depth   int
+
+	kernel     io.ReaderAt
+	kernelName string
+	cmdline    string
+	initrds    []io.ReaderAt
+
+	// menuItems are the item labels of the current menu.
+	menuItems []string
+}
+
+// NewIPXE returns an iPXE interpreter that resolves relative URLs against
+// wd and fetches files using schemes s.
+func NewIPXE(wd *url.URL, s Schemes) *IPXE {
+	x := &IPXE{
+		Vars:    make(map[string]string),
+		wd:      wd,
+		schemes: s,
+	}
+	x.Vars["buildarch"] = map[string]string{
+		"386":   "i386",
+		"amd64": "x86_64",
+		"arm":   "arm32",
+		"arm64": "arm64",
+	}[runtime.GOARCH]
+	x.Vars["platform"] = "pcbios"
+	if _, err := os.Stat("/sys/firmware/efi"); err == nil {
+		x.Vars["platform"] = "efi"
+	}
+	return x
+}
+
+// SetInterface sets the net0 settings and next-server from iface.
+//
+// The lease settings filename, dns, hostname and domain are set in net0,
+// where unqualified names are looked up too.
+func (x *IPXE) SetInterface(iface *Interface) {
+	if iface.MAC != nil {
+		x.Vars["net0/mac"] = iface.MAC.String()
+	}
+	if iface.IP != nil {
+		x.Vars["net0/ip"] = iface.IP.String()
+	}
+	if iface.Netmask != nil {
+		x.Vars["net0/netmask"] = net.IP(iface.Netmask).String()
+	}
+	if iface.Gateway != nil {
+		x.Vars["net0/gateway"] = iface.Gateway.String()
+	}
+	if iface.Server != nil {
+		x.Vars["next-server"] = iface.Server.String()
+	}
+	if iface.BootFile != "" {
+		x.Vars["net0/filename"] = iface.BootFile
+	}
+	if len(iface.DNS) > 0 {
+		x.Vars["net0/dns"] = iface.DNS[0].String()
+	}
+	if iface.Hostname != "" {
+		x.Vars["net0/hostname"] = iface.Hostname
+	}
+	if iface.Domain != "" {
+		x.Vars["net0/domain"] = iface.Domain
+	}
+}
+
+// RunFile fetches and runs the script at url.
+func (x *IPXE) RunFile(url string) (*boot.LinuxImage, error) {
+	script, err := x.fetchScript(url)
+	if err != nil {
+		return nil, err
+	}
+	return x.Run(script)
+}
+
+// RunScript runs the script at u that was fetched already as r.
+func (x *IPXE) RunScript(u *url.URL, r io.ReaderAt) (*boot.LinuxImage, error) {
+	script, err := x.readScript(u, r)
+	if err != nil {
+		return nil, err
+	}
+	return x.Run(script)
+}
+
+// Run runs script and returns the image it boots.
+func (x *IPXE) Run(script string) (*boot.LinuxImage, error) {
+	if err := x.run(script); err != nil && err != errIPXEBoot && err != errIPXEExit {
+		return nil, err
+	}
+	if x.kernel == nil {
+		return nil, ErrIPXENoKernel
+	}
+	li := &boot.LinuxImage{
+		Kernel:  x.kernel,
+		Cmdline: x.cmdline,
+	}
+	switch len(x.initrds) {
+	case 0:
+	case 1:
+		li.Initrd = x.initrds[0]
+	default:
+		li.Initrd = boot.CatInitrds(x.initrds...)
+	}
+	return li, nil
+}
+
+// fetchScript returns the iPXE script at url, and makes its directory the
+// working directory for relative URLs.
+func (x *IPXE) fetchScript(surl string) (string, error) {
+	u, err := parseURL(surl, x.wd)
+	if err != nil {
+		return "", err
+	}
+	r, err := x.schemes.GetFile(u)
+	if err != nil {
+		return "", err
+	}
+	return x.readScript(u, r)
+}
+
+// readScript returns the iPXE script at u read from r, and makes its
+// directory the working directory for relative URLs.
+func (x *IPXE) readScript(u *url.URL, r io.ReaderAt) (string, error) {
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if !IsIPXEScript(b) {
+		return "", fmt.Errorf("%s is not an iPXE script", u)
+	}
+	x.Scripts = append(x.Scripts, File{URL: u.String(), Content: b})
+	x.wd = &url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   path.Dir(u.Path),
+	}
+	return string(b), nil
+}
+
+// ipxeLines splits a script into lines, joining lines ending in a backslash.
+func ipxeLines(script string) []string {
+	var lines []string
+	var cur string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasSuffix(line, "\\") {
+			cur += strings.TrimSuffix(line, "\\")
+			continue
+		}
+		lines = append(lines, cur+line)
+		cur = ""
+	}
+	if cur != "" {
+		lines = append(lines, cur)
+	}
+	return lines
+}
+
+func (x *IPXE) run(script string) error {
+	lines := ipxeLines(script)
+	labels := make(map[string]int)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ":") {
+			labels[strings.TrimSpace(line[1:])] = i
+		}
+	}
+
+	gotos := 0
+	for pc := 0; pc < len(lines); pc++ {
+		line := strings.TrimSpace(lines[pc])
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ":") {
+			continue
+		}
+		err := x.execLine(line)
+		if g, ok := err.(ipxeGoto); ok {
+			i, ok := labels[string(g)]
+			if !ok {
+				// Labels are local to a script, so the caller must
+				// not see a goto.
+				return errors.New(g.Error())
+			}
+			if gotos++; gotos > maxIPXEGotos {
+				return fmt.Errorf("more than %d gotos, giving up at goto %s", maxIPXEGotos, string(g))
+			}
+			pc = i
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipxeWord is a word of a command line.
+type ipxeWord struct {
+	s string
+	// op is set for the operators || and &&, which must not be quoted.
+	op bool
+}
+
+// ipxeWords splits line into words at blanks. Single and double quotes
+// group words with blanks, and are removed; "" is an empty word.
+func ipxeWords(line string) []ipxeWord {
+	var words []ipxeWord
+	var cur strings.Builder
+	var quote rune
+	inWord, quoted := false, false
+	end := func() {
+		if inWord {
+			s := cur.String()
+			words = append(words, ipxeWord{s: s, op: !quoted && (s == "||" || s == "&&")})
+		}
+		cur.Reset()
+		inWord, quoted = false, false
+	}
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inWord, quoted = true, true
+		case c == ' ' || c == '\t':
+			end()
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	end()
+	return words
+}
+
+// execLine runs the commands of a line, which are separated by || and &&. A
+// failure that is not handled with || ends the script. A trailing || ignores
+// failures.
+//
+// Like iPXE, settings are expanded just before each command runs, so
+// "choose target && goto ${target}" works. A setting that is not set
+// expands to an empty argument, so "iseq ${unset} foo" compares "" and
+// "foo".
+func (x *IPXE) execLine(line string) error {
+	var cmds [][]string
+	var ops []string
+	var cur []string
+	for _, w := range ipxeWords(line) {
+		if w.op {
+			cmds = append(cmds, cur)
+			ops = append(ops, w.s)
+			cur = nil
+		} else {
+			cur = append(cur, w.s)
+		}
+	}
+	cmds = append(cmds, cur)
+
+	var err error
+	for i, cmd := range cmds {
+		if i > 0 {
+			if ops[i-1] == "||" && err == nil {
+				continue
+			}
+			if ops[i-1] == "&&" && err != nil {
+				continue
+			}
+		}
+		args := make([]string, 0, len(cmd))
+		for _, arg := range cmd {
+			args = append(args, x.expand(arg))
+		}
+		err = x.exec(args)
+		if _, ok := err.(ipxeGoto); ok || err == errIPXEBoot || err == errIPXEExit {
+			return err
+		}
+	}
+	return err
+}
+
+// joinArgs returns the non-empty args joined by spaces, as a command line.
+func joinArgs(args []string) string {
+	var s []string
+	for _, a := range args {
+		if a != "" {
+			s = append(s, a)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+// lookup returns the value of a setting.
+func (x *IPXE) lookup(name string) string {
+	name = strings.Replace(name, "netX/", "net0/", 1)
+	if v, ok := x.Vars[name]; ok {
+		return v
+	}
+	if !strings.Contains(name, "/") {
+		return x.Vars["net0/"+name]
+	}
+	return ""
+}
+
+// expand replaces ${name} and ${name:type} with the value of the setting.
+// Types only change how MAC addresses are formatted.
+func (x *IPXE) expand(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		j := strings.Index(s[i:], "}")
+		if j < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		name, typ := s[i+2:i+j], ""
+		if k := strings.LastIndex(name, ":"); k >= 0 {
+			name, typ = name[:k], name[k+1:]
+		}
+		v := x.lookup(name)
+		switch typ {
+		case "hexhyp":
+			v = strings.Replace(v, ":", "-", -1)
+		case "hexraw":
+			v = strings.Replace(v, ":", "", -1)
+		case "uristring":
+			v = url.QueryEscape(v)
+		}
+		b.WriteString(v)
+		s = s[i+j+1:]
+	}
+}
+
+// imageArgs strips the options of an image command and returns the image
+// name given with --name, if any.
+func imageArgs(args []string) (name string, rest []string) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		opt := args[0]
+		args = args[1:]
+		switch opt {
+		case "--name", "-n", "--timeout", "-t":
+			if len(args) > 0 {
+				if opt == "--name" || opt == "-n" {
+					name = args[0]
+				}
+				args = args[1:]
+			}
+		default:
+			if v := strings.TrimPrefix(opt, "--name="); v != opt {
+				name = v
+			}
+		}
+	}
+	return name, args
+}
+
+// getFile returns a lazy reader for url, relative to the working directory.
+func (x *IPXE) getFile(url string) (io.ReaderAt, error) {
+	u, err := parseURL(url, x.wd)
+	if err != nil {
+		return nil, err
+	}
+	return x.schemes.LazyGetFile(u)
+}
+
+// loadKernel implements kernel and the first half of chain.
+func (x *IPXE) loadKernel(args []string) error {
+	name, args := imageArgs(args)
+	if len(args) == 0 {
+		return errors.New("missing image URL")
+	}
+	k, err := x.getFile(args[0])
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = path.Base(args[0])
+	}
+	x.kernel = k
+	x.kernelName = name
+	x.cmdline = joinArgs(args[1:])
+	return nil
+}
+
+// chain implements chain: chained scripts are run, and anything else is
+// booted.
+func (x *IPXE) chain(args []string) error {
+	_, rest := imageArgs(args)
+	if len(rest) == 0 {
+		return errors.New("missing image URL")
+	}
+	u, err := parseURL(rest[0], x.wd)
+	if err != nil {
+		return err
+	}
+	r, err := x.schemes.LazyGetFile(u)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, len(ipxeMagic))
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		// Like iPXE, fail if the image cannot be fetched, so that
+		// "chain ... || goto retry" retries.
+		return err
+	}
+	if n == len(head) && IsIPXEScript(head) {
+		if x.depth >= maxIPXEDepth {
+			return fmt.Errorf("iPXE scripts chained more than %d deep", maxIPXEDepth)
+		}
+		script, err := x.fetchScript(rest[0])
+		if err != nil {
+			return err
+		}
+		wd := x.wd
+		x.depth++
+		defer func() {
+			x.wd = wd
+			x.depth--
+		}()
+		if err := x.run(script); err != nil && err != errIPXEExit {
+			return err
+		}
+		return nil
+	}
+
+	if err := x.loadKernel(args); err != nil {
+		return err
+	}
+	return errIPXEBoot
+}
+
+// exec runs a single command.
+func (x *IPXE) exec(cmd []string) error {
+	if len(cmd) == 0 || cmd[0] == "" {
+		return nil
+	}
+	args := cmd[1:]
+	switch cmd[0] {
+	case "set":
+		if len(args) == 0 {
+			return errors.New("set: missing setting name")
+		}
+		x.Vars[args[0]] = joinArgs(args[1:])
+
+	case "clear":
+		if len(args) == 0 {
+			return errors.New("clear: missing setting name")
+		}
+		delete(x.Vars, args[0])
+
+	case "isset":
+		if len(args) == 0 || args[0] == "" {
+			return errors.New("isset: not set")
+		}
+
+	case "iseq":
+		var a, b string
+		if len(args) > 0 {
+			a = args[0]
+		}
+		if len(args) > 1 {
+			b = args[1]
+		}
+		if a != b {
+			return errors.New("iseq: not equal")
+		}
+
+	case "goto":
+		if len(args) == 0 {
+			return errors.New("goto: missing label")
+		}
+		return ipxeGoto(args[0])
+
+	case "exit":
+		return errIPXEExit
+
+	case "cpuid":
+		// Scripts use "cpuid --ext 29" to check for 64-bit CPUs.
+		if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+			return errors.New("cpuid: feature not present")
+		}
+
+	case "kernel", "imgselect", "imgload":
+		return x.loadKernel(args)
+
+	case "chain", "imgexec":
+		return x.chain(args)
+
+	case "boot":
+		if x.kernel == nil {
+			return ErrIPXENoKernel
+		}
+		return errIPXEBoot
+
+	case "initrd", "module", "imgfetch":
+		_, args = imageArgs(args)
+		if len(args) == 0 {
+			return errors.New("initrd: missing image URL")
+		}
+		i, err := x.getFile(args[0])
+		if err != nil {
+			return err
+		}
+		x.initrds = append(x.initrds, i)
+
+	case "imgargs":
+		if len(args) == 0 || x.kernel == nil || args[0] != x.kernelName {
+			return errors.New("imgargs: no such image")
+		}
+		x.cmdline = joinArgs(args[1:])
+
+	case "imgfree":
+		x.kernel = nil
+		x.kernelName = ""
+		x.cmdline = ""
+		x.initrds = nil
+
+	case "menu":
+		x.menuItems = nil
+
+	case "item":
+		// item [--key k] [--gap] [label [text]]
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			if args[0] == "--gap" {
+				return nil
+			}
+			if args[0] == "--key" || args[0] == "-k" {
+				args = args[1:]
+			}
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		}
+		if len(args) > 0 {
+			x.menuItems = append(x.menuItems, args[0])
+		}
+
+	case "choose":
+		// There is no one to choose, so act as if the menu timed out.
+		var def, setting string
+		for len(args) > 0 {
+			switch args[0] {
+			case "--default", "-d":
+				if len(args) > 1 {
+					def = args[1]
+					args = args[1:]
+				}
+			case "--timeout", "-t":
+				if len(args) > 1 {
+					args = args[1:]
+				}
+			default:
+				if !strings.HasPrefix(args[0], "-") {
+					setting = args[0]
+				}
+			}
+			args = args[1:]
+		}
+		if def == "" && len(x.menuItems) > 0 {
+			def = x.menuItems[0]
+		}
+		if def == "" || setting == "" {
+			return errors.New("choose: nothing to choose")
+		}
+		x.Vars[setting] = def
+
+	case "echo", "sleep", "prompt", "dhcp", "ifopen", "ifclose", "ifconf",
+		"ifstat", "route", "ntp", "sync", "console", "colour", "cpair",
+		"imgstat", "params", "param", "show",
+		"login", "shell":
+		// Nothing to do.
+
+	case "imgverify", "imgtrust":
+		// Scripts that check signatures must not boot unchecked
+		// images.
+		return fmt.Errorf("%s: signature verification is not supported", cmd[0])
+
+	default:
+		return fmt.Errorf("unsupported iPXE command %q", cmd[0])
+	}
+	return nil
+}
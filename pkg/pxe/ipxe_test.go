@@ -0,0 +1,222 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/uio"
+)
+
+func TestIPXE(t *testing.T) {
+	type want struct {
+		kernel  string
+		initrd  string
+		cmdline string
+	}
+	for _, tt := range []struct {
+		desc   string
+		script string
+		files  map[string]string
+		want   *want
+		err    bool
+	}{
+		{
+			desc: "kernel, initrd and variables",
+			script: `#!ipxe
+kernel --name vmlinuz tftp://1.2.3.4/boot/kernel console=ttyS0 BOOTIF=01-${net0/mac:hexhyp} ip=${ip}
+initrd initrd
+boot vmlinuz
+kernel never-reached
+`,
+			want: &want{
+				kernel:  "kernel",
+				initrd:  "initrd",
+				cmdline: "console=ttyS0 BOOTIF=01-aa-bb-cc-dd-ee-ff ip=192.168.0.2",
+			},
+		},
+		{
+			desc: "goto, isset, iseq and imgargs",
+			script: `#!ipxe
+set base http://5.6.7.8/images
+isset ${unset} && goto wrong ||
+iseq ${next-server} 192.168.0.1 || goto wrong
+goto right
+:wrong
+kernel ${base}/wrong
+boot
+:right
+kernel ${base}/right
+imgargs right quiet
+initrd ${base}/a
+initrd ${base}/b
+boot || shell
+`,
+			want: &want{
+				kernel:  "right",
+				initrd:  "a\x00\x00\x00b\x00\x00\x00",
+				cmdline: "quiet",
+			},
+		},
+		{
+			desc: "empty and quoted arguments, lease settings",
+			script: `#!ipxe
+set words a b
+iseq ${unset} foo && goto wrong ||
+iseq ${unset} "" || goto wrong
+iseq "a b" ${words} || goto wrong
+iseq ${filename} pxe.ipxe || goto wrong
+iseq ${net0/dns} 192.168.0.53 || goto wrong
+iseq ${hostname}.${domain} client.example.com || goto wrong
+kernel http://5.6.7.8/images/right "a=b c" ${unset} quiet
+boot
+:wrong
+exit
+`,
+			want: &want{
+				kernel:  "right",
+				cmdline: "a=b c quiet",
+			},
+		},
+		{
+			desc: "chained script in another directory",
+			script: `#!ipxe
+chain http://5.6.7.8/scripts/next.ipxe
+`,
+			want: &want{
+				kernel:  "chained",
+				cmdline: "from=next",
+			},
+		},
+		{
+			desc: "menu",
+			script: `#!ipxe
+menu Pick one
+item --gap Operating systems
+item wrong Wrong
+item --key r right Right
+choose --timeout 3000 --default right target && goto ${target}
+:wrong
+exit
+:right
+chain http://5.6.7.8/images/right
+`,
+			want: &want{
+				kernel: "right",
+			},
+		},
+		{
+			desc: "unhandled failure",
+			script: `#!ipxe
+kernel tftp://1.2.3.4/boot/kernel
+isset ${unset}
+boot
+`,
+			err: true,
+		},
+		{
+			desc: "endless retry",
+			script: `#!ipxe
+:retry
+chain http://5.6.7.8/images/none || goto retry
+`,
+			err: true,
+		},
+		{
+			desc: "signature check",
+			script: `#!ipxe
+kernel tftp://1.2.3.4/boot/kernel
+imgverify kernel tftp://1.2.3.4/boot/kernel.sig
+boot
+`,
+			err: true,
+		},
+		{
+			desc: "unknown label",
+			script: `#!ipxe
+goto nowhere
+`,
+			err: true,
+		},
+		{
+			desc: "no kernel",
+			script: `#!ipxe
+echo hello
+exit
+`,
+			err: true,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			tftp := NewMockScheme("tftp")
+			tftp.Add("1.2.3.4", "/boot/kernel", "kernel")
+			tftp.Add("1.2.3.4", "/boot/initrd", "initrd")
+			http := NewMockScheme("http")
+			http.Add("5.6.7.8", "/images/right", "right")
+			http.Add("5.6.7.8", "/images/wrong", "wrong")
+			http.Add("5.6.7.8", "/images/a", "a")
+			http.Add("5.6.7.8", "/images/b", "b")
+			http.Add("5.6.7.8", "/scripts/next.ipxe", "#!ipxe\nkernel ../images/chained from=next\nboot\n")
+			http.Add("5.6.7.8", "/images/chained", "chained")
+			s := make(Schemes)
+			s.Register(tftp.scheme, tftp)
+			s.Register(http.scheme, http)
+
+			x := NewIPXE(&url.URL{Scheme: "tftp", Host: "1.2.3.4", Path: "/boot"}, s)
+			x.SetInterface(&Interface{
+				MAC:    net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+				IP:     net.IP{192, 168, 0, 2},
+				Server: net.IP{192, 168, 0, 1},
+
+				BootFile: "pxe.ipxe",
+				DNS:      []net.IP{{192, 168, 0, 53}},
+				Hostname: "client",
+				Domain:   "example.com",
+			})
+			li, err := x.Run(tt.script)
+			if tt.err {
+				if err == nil {
+					t.Errorf("Run() = %v, want error", li)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+
+			k, err := uio.ReadAll(li.Kernel)
+			if err != nil || string(k) != tt.want.kernel {
+				t.Errorf("kernel = %q, %v, want %q", k, err, tt.want.kernel)
+			}
+			var initrd []byte
+			if li.Initrd != nil {
+				if initrd, err = uio.ReadAll(li.Initrd); err != nil {
+					t.Errorf("reading initrd: %v", err)
+				}
+			}
+			if string(initrd) != tt.want.initrd {
+				t.Errorf("initrd = %q, want %q", initrd, tt.want.initrd)
+			}
+			if li.Cmdline != tt.want.cmdline {
+				t.Errorf("cmdline = %q, want %q", li.Cmdline, tt.want.cmdline)
+			}
+		})
+	}
+}
+
+func TestIsIPXEScript(t *testing.T) {
+	for s, want := range map[string]bool{
+		"#!ipxe\nkernel foo": true,
+		"#!ipxe":             true,
+		"default foo":        false,
+		"":                   false,
+	} {
+		if got := IsIPXEScript([]byte(s)); got != want {
+			t.Errorf("IsIPXEScript(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -16,7 +16,9 @@ import (
 	"net/url"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/uio"
@@ -41,17 +43,93 @@ type Config struct {
 	// DefaultEntry is the default label key to use.
 	//
 	// If DefaultEntry is non-empty, the label is guaranteed to exist in
-	// `Entries`.
+	// `Entries` or `LocalBoot`.
 	DefaultEntry string
 
+	// MenuLabels maps label names to the text a menu shows for them, as
+	// set by MENU LABEL.
+	MenuLabels map[string]string
+
+	// LocalBoot is the set of labels that boot from the local disk
+	// (LOCALBOOT) rather than a kernel. They are not part of Entries.
+	LocalBoot map[string]bool
+
+	// OnTimeout is the label to boot when a menu times out (ONTIMEOUT). If
+	// it is empty, DefaultEntry is booted.
+	OnTimeout string
+
+	// UI is the menu module, like vesamenu.c32, of UI or of a DEFAULT
+	// that names a module rather than a label. If it is set, the
+	// configuration is for a menu, and booting without user interaction
+	// falls back to ONTIMEOUT or the first label if there is no default
+	// label.
+	UI string
+
+	// Timeout is how long a menu waits for input before booting
+	// (TIMEOUT). Zero means to wait forever.
+	Timeout time.Duration
+
+	// Say holds the messages of SAY directives, in order.
+	Say []string
+
+	// Interface describes the network interface the configuration was
+	// fetched on. IPAPPEND and SYSAPPEND add it to kernel command lines.
+	// FindConfigFile fills in the MAC and IP address if it is nil.
+	Interface *Interface
+
+	// FDTFile is the name of the device tree to use from an FDTDIR
+	// directory, like U-Boot's fdtfile variable. FDTDIR is ignored if it
+	// is empty.
+	FDTFile string
+
+	// Files holds the configuration files read, in order, so they can be
+	// measured.
+	Files []File
+
 	// Parser internals.
-	globalAppend string
-	scope        scope
-	curEntry     string
-	wd           *url.URL
-	schemes      Schemes
+	globalAppend   string
+	globalIPAppend int
+	ipAppend       map[string]int
+	ipAppended     map[*boot.LinuxImage]bool
+	inText         bool
+	scope          scope
+	curEntry       string
+	firstEntry     string
+	wd             *url.URL
+	schemes        Schemes
+}
+
+// File is a configuration file or script that was read.
+type File struct {
+	URL     string
+	Content []byte
+}
+
+// Interface is the configuration of the network interface used to boot.
+type Interface struct {
+	MAC     net.HardwareAddr
+	IP      net.IP
+	Server  net.IP
+	Gateway net.IP
+	Netmask net.IPMask
+
+	// BootFile is the URL of the boot file the lease gave.
+	BootFile string
+	DNS      []net.IP
+	Hostname string
+	Domain   string
 }
 
+// IPAPPEND and SYSAPPEND flags.
+const (
+	// ipAppendIP adds ip=<client>:<server>:<gateway>:<netmask>.
+	ipAppendIP = 1 << iota
+
+	// ipAppendBootIF adds BOOTIF=01-<mac> with the MAC of the boot
+	// interface.
+	ipAppendBootIF
+)
+
 type scope uint8
 
 const (
@@ -77,15 +155,22 @@ func NewConfig(wd *url.URL) *Config {
 // `s` is used to get files referred to by URLs.
 func NewConfigWithSchemes(wd *url.URL, s Schemes) *Config {
 	return &Config{
-		Entries: make(map[string]*boot.LinuxImage),
-		scope:   scopeGlobal,
-		wd:      wd,
-		schemes: s,
+		Entries:    make(map[string]*boot.LinuxImage),
+		MenuLabels: make(map[string]string),
+		LocalBoot:  make(map[string]bool),
+		ipAppend:   make(map[string]int),
+		ipAppended: make(map[*boot.LinuxImage]bool),
+		scope:      scopeGlobal,
+		wd:         wd,
+		schemes:    s,
 	}
 }
 
 // FindConfigFile probes for config files based on the Mac and IP given.
 func (c *Config) FindConfigFile(mac net.HardwareAddr, ip net.IP) error {
+	if c.Interface == nil {
+		c.Interface = &Interface{MAC: mac, IP: ip}
+	}
 	for _, relname := range probeFiles(mac, ip) {
 		err := c.AppendFile(path.Join("pxelinux.cfg", relname))
 		if IsURLError(err) {
@@ -101,8 +186,7 @@ func (c *Config) FindConfigFile(mac net.HardwareAddr, ip net.IP) error {
 // ParseConfigFile parses a PXE/Syslinux configuration as specified in
 // http://www.syslinux.org/wiki/index.php?title=Config
 //
-// Currently, only the APPEND, INCLUDE, KERNEL, LABEL, DEFAULT, and INITRD
-// directives are partially supported.
+// See Config.Append for the supported directives.
 //
 // `wd` is the default scheme, host, and path for any files named as a
 // relative path. The default path for config files is assumed to be
@@ -150,6 +234,13 @@ func (c *Config) GetFile(url string) (io.ReaderAt, error) {
 
 // AppendFile parses the config file downloaded from `url` and adds it to `c`.
 func (c *Config) AppendFile(url string) error {
+	if err := c.appendFile(url); err != nil {
+		return err
+	}
+	return c.finish()
+}
+
+func (c *Config) appendFile(url string) error {
 	r, err := c.GetFile(url)
 	if err != nil {
 		return err
@@ -158,19 +249,89 @@ func (c *Config) AppendFile(url string) error {
 	if err != nil {
 		return err
 	}
-	return c.Append(string(config))
+	c.Files = append(c.Files, File{URL: url, Content: config})
+	return c.append(string(config))
 }
 
 // Append parses `config` and adds the respective configuration to `c`.
+//
+// The supported directives are DEFAULT, INCLUDE, LABEL, KERNEL and its alias
+// LINUX, INITRD, APPEND, LOCALBOOT, IPAPPEND, SYSAPPEND, FDT (or DEVICETREE),
+// FDTDIR, TIMEOUT, ONTIMEOUT, SAY, MENU LABEL, MENU DEFAULT and MENU INCLUDE.
+// Directives are case-insensitive, and so are label names when they are
+// referred to.
+//
+// INITRD and initrd= take a comma-separated list of files, which are
+// concatenated into one initramfs.
 func (c *Config) Append(config string) error {
+	if err := c.append(config); err != nil {
+		return err
+	}
+	return c.finish()
+}
+
+// label returns the label being defined, or nil outside of a label or in a
+// LOCALBOOT label.
+func (c *Config) label() *boot.LinuxImage {
+	if c.scope != scopeEntry {
+		return nil
+	}
+	return c.Entries[c.curEntry]
+}
+
+// initrd returns the concatenation of the files in the comma-separated list.
+func (c *Config) initrd(list string) (io.ReaderAt, error) {
+	var initrds []io.ReaderAt
+	for _, name := range strings.Split(list, ",") {
+		if name == "" {
+			continue
+		}
+		i, err := c.GetFile(name)
+		if err != nil {
+			return nil, err
+		}
+		initrds = append(initrds, i)
+	}
+	switch len(initrds) {
+	case 0:
+		return nil, fmt.Errorf("empty initrd list %q", list)
+	case 1:
+		return initrds[0], nil
+	}
+	return boot.CatInitrds(initrds...), nil
+}
+
+func (c *Config) append(config string) error {
 	// Here's a shitty parser.
 	for _, line := range strings.Split(config, "\n") {
 		// This is stupid. There should be a FieldsN(...).
 		kv := strings.Fields(line)
-		if len(kv) <= 1 {
+		if len(kv) == 0 || strings.HasPrefix(kv[0], "#") {
 			continue
 		}
 		directive := strings.ToLower(kv[0])
+
+		// Help text is free-form and must not be parsed.
+		if c.inText {
+			if directive == "endtext" {
+				c.inText = false
+			}
+			continue
+		}
+
+		if directive == "menu" && len(kv) > 1 {
+			if err := c.menu(strings.ToLower(kv[1]), strings.Join(kv[2:], " ")); err != nil {
+				return err
+			}
+			continue
+		}
+		if directive == "text" {
+			c.inText = true
+			continue
+		}
+		if len(kv) <= 1 {
+			continue
+		}
 		var arg string
 		if len(kv) == 2 {
 			arg = kv[1]
@@ -180,15 +341,30 @@ func (c *Config) Append(config string) error {
 
 		switch directive {
 		case "default":
-			c.DefaultEntry = arg
+			// Without UI, DEFAULT may run a menu module itself.
+			if isModule(kv[1]) {
+				c.UI = kv[1]
+			} else {
+				c.DefaultEntry = arg
+			}
+
+		case "ui":
+			c.UI = kv[1]
+
+		case "ontimeout":
+			c.OnTimeout = arg
+
+		case "timeout":
+			// In units of 1/10s.
+			if t, err := strconv.Atoi(arg); err == nil && t >= 0 {
+				c.Timeout = time.Duration(t) * time.Second / 10
+			}
+
+		case "say":
+			c.Say = append(c.Say, arg)
 
 		case "include":
-			if err := c.AppendFile(arg); IsURLError(err) {
-				// Means we didn't find the file. Just ignore
-				// it.
-				// TODO(hugelgupf): plumb a logger through here.
-				continue
-			} else if err != nil {
+			if err := c.include(arg); err != nil {
 				return err
 			}
 
@@ -196,22 +372,66 @@ func (c *Config) Append(config string) error {
 			// We forever enter label scope.
 			c.scope = scopeEntry
 			c.curEntry = arg
+			if c.firstEntry == "" {
+				c.firstEntry = arg
+			}
+			delete(c.LocalBoot, arg)
 			c.Entries[c.curEntry] = &boot.LinuxImage{}
 			c.Entries[c.curEntry].Cmdline = c.globalAppend
 
-		case "kernel":
-			k, err := c.GetFile(arg)
-			if err != nil {
-				return err
+		case "localboot":
+			if c.scope == scopeEntry {
+				delete(c.Entries, c.curEntry)
+				c.LocalBoot[c.curEntry] = true
+			}
+
+		case "kernel", "linux":
+			if label := c.label(); label != nil {
+				k, err := c.GetFile(arg)
+				if err != nil {
+					return err
+				}
+				label.Kernel = k
 			}
-			c.Entries[c.curEntry].Kernel = k
 
 		case "initrd":
-			i, err := c.GetFile(arg)
+			if label := c.label(); label != nil {
+				i, err := c.initrd(arg)
+				if err != nil {
+					return err
+				}
+				label.Initrd = i
+			}
+
+		case "fdt", "devicetree":
+			if label := c.label(); label != nil {
+				d, err := c.GetFile(arg)
+				if err != nil {
+					return err
+				}
+				label.DTB = d
+			}
+
+		case "fdtdir":
+			if label := c.label(); label != nil && c.FDTFile != "" {
+				d, err := c.GetFile(path.Join(arg, c.FDTFile))
+				if err != nil {
+					return err
+				}
+				label.DTB = d
+			}
+
+		case "ipappend", "sysappend":
+			flags, err := strconv.Atoi(arg)
 			if err != nil {
-				return err
+				continue
+			}
+			switch c.scope {
+			case scopeGlobal:
+				c.globalIPAppend = flags
+			case scopeEntry:
+				c.ipAppend[c.curEntry] = flags
 			}
-			c.Entries[c.curEntry].Initrd = i
 
 		case "append":
 			switch c.scope {
@@ -219,15 +439,121 @@ func (c *Config) Append(config string) error {
 				c.globalAppend = arg
 
 			case scopeEntry:
-				if arg == "-" {
-					c.Entries[c.curEntry].Cmdline = ""
+				if label := c.label(); label == nil {
+					continue
+				} else if arg == "-" {
+					label.Cmdline = ""
 				} else {
-					c.Entries[c.curEntry].Cmdline = arg
+					label.Cmdline = arg
 				}
 			}
 		}
 	}
+	return nil
+}
+
+// menu handles MENU directives. Only those that affect booting are
+// interpreted; the rest only change how a menu looks.
+func (c *Config) menu(directive, arg string) error {
+	switch directive {
+	case "label":
+		if c.scope == scopeEntry {
+			c.MenuLabels[c.curEntry] = arg
+		}
+	case "default":
+		if c.scope == scopeEntry {
+			c.DefaultEntry = c.curEntry
+		}
+	case "include":
+		// MENU INCLUDE file [tagname]
+		if f := strings.Fields(arg); len(f) > 0 {
+			return c.include(f[0])
+		}
+	}
+	return nil
+}
+
+// isModule returns whether name is a syslinux module rather than a label.
+func isModule(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".c32")
+}
+
+func (c *Config) include(url string) error {
+	if err := c.appendFile(url); IsURLError(err) {
+		// Means we didn't find the file. Just ignore
+		// it.
+		// TODO(hugelgupf): plumb a logger through here.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FindLabel returns the key of the label called name, in Entries or
+// LocalBoot. Label names are matched case-insensitively if there is no
+// exact match.
+func (c *Config) FindLabel(name string) (string, bool) {
+	if _, ok := c.Entries[name]; ok {
+		return name, true
+	}
+	if c.LocalBoot[name] {
+		return name, true
+	}
+	for label := range c.Entries {
+		if strings.EqualFold(label, name) {
+			return label, true
+		}
+	}
+	for label := range c.LocalBoot {
+		if strings.EqualFold(label, name) {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// BootEntry returns the label to boot without user interaction: ONTIMEOUT if
+// set, and DEFAULT otherwise.
+func (c *Config) BootEntry() string {
+	if c.OnTimeout != "" {
+		if label, ok := c.FindLabel(c.OnTimeout); ok {
+			return label
+		}
+	}
+	return c.DefaultEntry
+}
+
+// ipAppendArgs returns the kernel parameters that IPAPPEND flags add.
+func (c *Config) ipAppendArgs(flags int) []string {
+	var args []string
+	if c.Interface == nil {
+		return nil
+	}
+	if flags&ipAppendIP != 0 && c.Interface.IP != nil {
+		var mask string
+		if c.Interface.Netmask != nil {
+			mask = net.IP(c.Interface.Netmask).String()
+		}
+		ipString := func(ip net.IP) string {
+			if ip == nil {
+				return ""
+			}
+			return ip.String()
+		}
+		args = append(args, fmt.Sprintf("ip=%s:%s:%s:%s",
+			c.Interface.IP, ipString(c.Interface.Server), ipString(c.Interface.Gateway), mask))
+	}
+	if flags&ipAppendBootIF != 0 && c.Interface.MAC != nil {
+		// 01 is the ARP hardware type of Ethernet.
+		args = append(args, "BOOTIF=01-"+strings.Replace(c.Interface.MAC.String(), ":", "-", -1))
+	}
+	return args
+}
 
+// finish resolves what can only be resolved once the whole configuration has
+// been read.
+func (c *Config) finish() error {
 	// Go through all labels and download the initrds.
 	for _, label := range c.Entries {
 		// If the initrd was set via the INITRD directive, don't
@@ -242,12 +568,12 @@ func (c *Config) Append(config string) error {
 		}
 
 		for _, opt := range strings.Fields(label.Cmdline) {
-			optkv := strings.Split(opt, "=")
-			if optkv[0] != "initrd" {
+			optkv := strings.SplitN(opt, "=", 2)
+			if optkv[0] != "initrd" || len(optkv) != 2 {
 				continue
 			}
 
-			i, err := c.GetFile(optkv[1])
+			i, err := c.initrd(optkv[1])
 			if err != nil {
 				return err
 			}
@@ -255,13 +581,41 @@ func (c *Config) Append(config string) error {
 		}
 	}
 
+	for name, label := range c.Entries {
+		if c.ipAppended[label] {
+			continue
+		}
+		flags, ok := c.ipAppend[name]
+		if !ok {
+			flags = c.globalIPAppend
+		}
+		if args := c.ipAppendArgs(flags); len(args) > 0 {
+			label.Cmdline = strings.TrimSpace(label.Cmdline + " " + strings.Join(args, " "))
+		}
+		c.ipAppended[label] = true
+	}
+
 	if len(c.DefaultEntry) > 0 {
-		if _, ok := c.Entries[c.DefaultEntry]; !ok {
+		if label, ok := c.FindLabel(c.DefaultEntry); ok {
+			c.DefaultEntry = label
+			return nil
+		}
+		if c.UI == "" {
 			return ErrDefaultEntryNotFound
 		}
+		c.DefaultEntry = ""
+	}
+	if c.UI != "" {
+		// A menu without a default label, from MENU DEFAULT or a
+		// DEFAULT with UI, boots ONTIMEOUT or else the first label.
+		for _, name := range []string{c.OnTimeout, c.firstEntry} {
+			if label, ok := c.FindLabel(name); ok && name != "" {
+				c.DefaultEntry = label
+				break
+			}
+		}
 	}
 	return nil
-
 }
 
 func probeFiles(ethernetMac net.HardwareAddr, ip net.IP) []string {
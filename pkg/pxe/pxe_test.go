@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/u-root/u-root/pkg/uio"
 )
@@ -606,3 +607,198 @@ func TestAppendFile(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendDirectives(t *testing.T) {
+	conf := `# A typical menu.
+say Booting from the lab
+timeout 50
+ontimeout LOCAL
+default foo
+ipappend 2
+menu title Lab boot menu
+
+label Foo
+  menu label ^Foo Linux
+  linux ./k
+  initrd ./a,./b
+  append console=ttyS0
+  fdt ./board.dtb
+
+text help
+label bogus
+  kernel ./nonexistent
+endtext
+
+label bar
+  menu default
+  kernel ./k
+  append initrd=./a,./b quiet
+  ipappend 3
+  fdtdir ./dtbs/
+
+label local
+  localboot 0
+`
+	fs := NewMockScheme("tftp")
+	fs.Add("1.2.3.4", "/foobar/pxelinux.cfg/default", conf)
+	fs.Add("1.2.3.4", "/foobar/k", "kernel")
+	fs.Add("1.2.3.4", "/foobar/a", "abc")
+	fs.Add("1.2.3.4", "/foobar/b", "defg")
+	fs.Add("1.2.3.4", "/foobar/board.dtb", "dtb")
+	fs.Add("1.2.3.4", "/foobar/dtbs/vendor/board.dtb", "dtbdir")
+	s := make(Schemes)
+	s.Register(fs.scheme, fs)
+
+	c := NewConfigWithSchemes(&url.URL{Scheme: "tftp", Host: "1.2.3.4", Path: "/foobar"}, s)
+	c.FDTFile = "vendor/board.dtb"
+	c.Interface = &Interface{
+		MAC:     net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		IP:      net.IP{192, 168, 0, 2},
+		Server:  net.IP{192, 168, 0, 1},
+		Gateway: net.IP{192, 168, 0, 254},
+		Netmask: net.CIDRMask(24, 32),
+	}
+	if err := c.AppendFile("pxelinux.cfg/default"); err != nil {
+		t.Fatalf("AppendFile() = %v", err)
+	}
+
+	if c.DefaultEntry != "bar" {
+		t.Errorf("DefaultEntry = %q, want %q", c.DefaultEntry, "bar")
+	}
+	if got := c.BootEntry(); got != "local" {
+		t.Errorf("BootEntry() = %q, want %q", got, "local")
+	}
+	if c.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want 5s", c.Timeout)
+	}
+	if want := []string{"Booting from the lab"}; !reflect.DeepEqual(c.Say, want) {
+		t.Errorf("Say = %q, want %q", c.Say, want)
+	}
+	if want := map[string]string{"Foo": "^Foo Linux"}; !reflect.DeepEqual(c.MenuLabels, want) {
+		t.Errorf("MenuLabels = %q, want %q", c.MenuLabels, want)
+	}
+	if want := map[string]bool{"local": true}; !reflect.DeepEqual(c.LocalBoot, want) {
+		t.Errorf("LocalBoot = %v, want %v", c.LocalBoot, want)
+	}
+	if len(c.Entries) != 2 {
+		t.Errorf("Entries = %v, want labels Foo and bar", c.Entries)
+	}
+
+	for _, tt := range []struct {
+		label   string
+		cmdline string
+		dtb     string
+	}{
+		{
+			label:   "foo",
+			cmdline: "console=ttyS0 BOOTIF=01-aa-bb-cc-dd-ee-ff",
+			dtb:     "dtb",
+		},
+		{
+			label:   "BAR",
+			cmdline: "initrd=./a,./b quiet ip=192.168.0.2:192.168.0.1:192.168.0.254:255.255.255.0 BOOTIF=01-aa-bb-cc-dd-ee-ff",
+			dtb:     "dtbdir",
+		},
+	} {
+		name, ok := c.FindLabel(tt.label)
+		if !ok {
+			t.Errorf("FindLabel(%q) found nothing", tt.label)
+			continue
+		}
+		label := c.Entries[name]
+		if label.Cmdline != tt.cmdline {
+			t.Errorf("label %s: cmdline = %q, want %q", name, label.Cmdline, tt.cmdline)
+		}
+		if i, err := uio.ReadAll(label.Initrd); err != nil || string(i) != "abc\x00defg" {
+			t.Errorf("label %s: initrd = %q, %v, want the concatenation of a and b", name, i, err)
+		}
+		if d, err := uio.ReadAll(label.DTB); err != nil || string(d) != tt.dtb {
+			t.Errorf("label %s: DTB = %q, %v, want %q", name, d, err, tt.dtb)
+		}
+	}
+
+	// Appending again must not add the IPAPPEND parameters twice.
+	if err := c.Append("say again"); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+	if got, want := c.Entries["Foo"].Cmdline, "console=ttyS0 BOOTIF=01-aa-bb-cc-dd-ee-ff"; got != want {
+		t.Errorf("cmdline after Append = %q, want %q", got, want)
+	}
+}
+
+func TestMenuConfigs(t *testing.T) {
+	const labels = `
+label a
+  kernel ./k
+label b
+  kernel ./k
+`
+	for _, tt := range []struct {
+		desc    string
+		conf    string
+		ui      string
+		want    string
+		wantErr error
+	}{
+		{
+			desc: "DEFAULT module with MENU DEFAULT",
+			conf: "default vesamenu.c32\nlabel a\n kernel ./k\nlabel b\n menu default\n kernel ./k\n",
+			ui:   "vesamenu.c32",
+			want: "b",
+		},
+		{
+			desc: "DEFAULT module with ONTIMEOUT",
+			conf: "default menu.c32\nontimeout B\n" + labels,
+			ui:   "menu.c32",
+			want: "b",
+		},
+		{
+			desc: "DEFAULT module only",
+			conf: "DEFAULT menu.c32\nprompt 0\n" + labels,
+			ui:   "menu.c32",
+			want: "a",
+		},
+		{
+			desc: "UI with DEFAULT label",
+			conf: "ui vesamenu.c32\ndefault b\n" + labels,
+			ui:   "vesamenu.c32",
+			want: "b",
+		},
+		{
+			desc: "UI with missing DEFAULT label",
+			conf: "ui menu.c32\ndefault missing\n" + labels,
+			ui:   "menu.c32",
+			want: "a",
+		},
+		{
+			desc:    "missing DEFAULT label without menu",
+			conf:    "default missing\n" + labels,
+			wantErr: ErrDefaultEntryNotFound,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			fs := NewMockScheme("tftp")
+			fs.Add("1.2.3.4", "/foobar/pxelinux.cfg/default", tt.conf)
+			fs.Add("1.2.3.4", "/foobar/k", "kernel")
+			s := make(Schemes)
+			s.Register(fs.scheme, fs)
+
+			c := NewConfigWithSchemes(&url.URL{Scheme: "tftp", Host: "1.2.3.4", Path: "/foobar"}, s)
+			if err := c.AppendFile("pxelinux.cfg/default"); err != tt.wantErr {
+				t.Fatalf("AppendFile() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if c.UI != tt.ui {
+				t.Errorf("UI = %q, want %q", c.UI, tt.ui)
+			}
+			if got := c.BootEntry(); got != tt.want {
+				t.Errorf("BootEntry() = %q, want %q", got, tt.want)
+			}
+			if _, ok := c.Entries[c.DefaultEntry]; !ok {
+				t.Errorf("DefaultEntry %q is not a label", c.DefaultEntry)
+			}
+		})
+	}
+}
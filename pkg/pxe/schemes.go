@@ -8,10 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/u-root/u-root/pkg/uio"
 	"pack.ag/tftp"
@@ -162,28 +168,220 @@ func (t *TFTPClient) GetFile(u *url.URL) (io.ReaderAt, error) {
 }
 
 // HTTPClient implements FileScheme for HTTP files.
+//
+// Failed requests are retried with exponential backoff, and downloads that
+// break off are resumed with Range requests.
 type HTTPClient struct {
 	c *http.Client
+
+	// Header is added to every request, e.g. for authentication.
+	Header http.Header
+
+	// Retries is how many times a request is retried or a download is
+	// resumed without progress in between.
+	Retries int
+
+	// RetryDelay is the delay before the first retry. It doubles with
+	// every retry.
+	RetryDelay time.Duration
 }
 
 // NewHTTPClient returns a new HTTP FileScheme based on the given http.Client.
+//
+// The http.Client follows redirects; its transport determines the proxy,
+// which CAs are trusted for HTTPS and the client certificates.
 func NewHTTPClient(c *http.Client) *HTTPClient {
 	return &HTTPClient{
-		c: c,
+		c:          c,
+		Retries:    3,
+		RetryDelay: time.Second,
 	}
 }
 
 // GetFile implements FileScheme.GetFile.
 func (h HTTPClient) GetFile(u *url.URL) (io.ReaderAt, error) {
-	resp, err := h.c.Get(u.String())
+	f := &httpFile{h: &h, url: u.String(), size: -1}
+	err := f.open(0)
+	for err != nil && temporary(err) && f.retry() {
+		err = f.open(0)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return uio.NewCachingReader(io.NewSectionReader(f, 0, math.MaxInt64)), nil
+}
+
+// OpenFile returns the file at u. Nothing is requested until it is read.
+//
+// Unlike that of GetFile, the file is not cached: every read past where
+// the last one stopped makes a Range request. It suits large files that
+// are read once, from any offset on. Its Size method returns the size of
+// the file, or -1 while it is not known.
+func (h *HTTPClient) OpenFile(u *url.URL) io.ReaderAt {
+	return &httpFile{h: h, url: u.String(), size: -1}
+}
+
+// httpStatusError is returned for unexpected HTTP responses.
+type httpStatusError struct {
+	status string
+	code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP server responded with %q", e.status)
+}
+
+// temporary returns whether a request that failed with err is worth
+// retrying: it timed out or failed temporarily in transport, the server had
+// an internal error, or the connection broke before the whole file came.
+func temporary(err error) bool {
+	switch e := err.(type) {
+	case *httpStatusError:
+		return e.code >= 500
+	case net.Error:
+		return e.Timeout() || e.Temporary()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// httpFile is a file on an HTTP server. It is meant to be read in order;
+// reading elsewhere makes a new request.
+type httpFile struct {
+	h   *HTTPClient
+	url string
+
+	body io.ReadCloser
+	// pos is the offset body is at.
+	pos int64
+	// size is the size of the file, or -1 if it is not known yet.
+	size int64
+
+	tries int
+	delay time.Duration
+}
+
+// Size returns the size of the file, or -1 if it is not known yet.
+func (f *httpFile) Size() int64 {
+	return f.size
+}
+
+// retry waits before the next attempt. It returns false if there are no
+// retries left.
+func (f *httpFile) retry() bool {
+	if f.tries >= f.h.Retries {
+		return false
+	}
+	if f.tries == 0 {
+		f.delay = f.h.RetryDelay
+	}
+	f.tries++
+	time.Sleep(f.delay)
+	f.delay *= 2
+	return true
+}
+
+func (f *httpFile) close() {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+}
+
+// contentRange returns the size in a Content-Range header like
+// "bytes 100-199/200", or -1.
+func contentRange(s string) int64 {
+	i := strings.LastIndexByte(s, '/')
+	if !strings.HasPrefix(s, "bytes ") || i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// open requests the file from off on. It returns io.EOF if off is the end
+// of the file.
+func (f *httpFile) open(off int64) error {
+	f.close()
+	req, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range f.h.Header {
+		req.Header[k] = v
+	}
+	if off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := f.h.c.Do(req)
+	if err != nil {
+		return err
+	}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP server responded with code %d, want 200: response %v", resp.StatusCode, resp)
+	switch {
+	case off > 0 && resp.StatusCode == http.StatusPartialContent:
+		f.size = contentRange(resp.Header.Get("Content-Range"))
+	case off > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		if size := contentRange(resp.Header.Get("Content-Range")); size == off {
+			// Everything was read already.
+			f.size = size
+			return io.EOF
+		}
+		return &httpStatusError{resp.Status, resp.StatusCode}
+	case resp.StatusCode == http.StatusOK:
+		f.size = resp.ContentLength
+		if off > 0 {
+			// The server ignored the range, so skip what was
+			// read already.
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err != nil {
+				resp.Body.Close()
+				return err
+			}
+		}
+	default:
+		resp.Body.Close()
+		return &httpStatusError{resp.Status, resp.StatusCode}
+	}
+	f.body, f.pos = resp.Body, off
+	return nil
+}
+
+// ReadAt implements io.ReaderAt. Failed requests and downloads that break
+// off are retried.
+func (f *httpFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		var err error
+		if f.body == nil || f.pos != off+int64(n) {
+			err = f.open(off + int64(n))
+		}
+		if err == nil {
+			var m int
+			m, err = f.body.Read(p[n:])
+			n += m
+			f.pos += int64(m)
+			if m > 0 {
+				f.tries = 0
+			}
+			if err == io.EOF && f.size >= 0 && f.pos < f.size {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err == io.EOF {
+			f.close()
+			return n, io.EOF
+		}
+		if err != nil {
+			f.close()
+			if !temporary(err) || !f.retry() {
+				return n, err
+			}
+		}
 	}
-	return uio.NewCachingReader(resp.Body), nil
+	return n, nil
 }
 
 // LocalFileClient implements FileScheme for files on disk.
@@ -9,11 +9,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"path"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/u-root/u-root/pkg/uio"
 )
@@ -171,6 +174,192 @@ func TestGetFile(t *testing.T) {
 	}
 }
 
+func TestHTTPClient(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	// breakOff sends the first half of content and drops the connection.
+	breakOff := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		conn.Close()
+	}
+	serveRange := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader(content))
+	}
+	ignoreRange := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}
+	status := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		path     string
+		handlers []http.HandlerFunc
+		want     string
+		wantErr  bool
+		requests int
+	}{
+		{
+			name:     "plain",
+			handlers: []http.HandlerFunc{serveRange},
+			want:     content,
+			requests: 1,
+		},
+		{
+			name: "redirect",
+			handlers: []http.HandlerFunc{
+				func(w http.ResponseWriter, r *http.Request) {
+					http.Redirect(w, r, "/moved", http.StatusFound)
+				},
+				serveRange,
+			},
+			want:     content,
+			requests: 2,
+		},
+		{
+			name:     "resume with range",
+			handlers: []http.HandlerFunc{breakOff, serveRange},
+			want:     content,
+			requests: 2,
+		},
+		{
+			name:     "resume without range",
+			handlers: []http.HandlerFunc{breakOff, ignoreRange},
+			want:     content,
+			requests: 2,
+		},
+		{
+			name:     "resume twice",
+			handlers: []http.HandlerFunc{breakOff, breakOff, serveRange},
+			want:     content,
+			requests: 3,
+		},
+		{
+			name:     "server error is retried",
+			handlers: []http.HandlerFunc{status(http.StatusServiceUnavailable), serveRange},
+			want:     content,
+			requests: 2,
+		},
+		{
+			name: "malformed response is not retried",
+			handlers: []http.HandlerFunc{
+				func(w http.ResponseWriter, r *http.Request) {
+					conn, _, err := w.(http.Hijacker).Hijack()
+					if err != nil {
+						panic(err)
+					}
+					io.WriteString(conn, "HTTP/1.1 bogus\r\n\r\n")
+					conn.Close()
+				},
+				serveRange,
+			},
+			wantErr:  true,
+			requests: 1,
+		},
+		{
+			name:     "not found is not retried",
+			handlers: []http.HandlerFunc{status(http.StatusNotFound), serveRange},
+			wantErr:  true,
+			requests: 1,
+		},
+		{
+			name: "out of retries",
+			handlers: []http.HandlerFunc{
+				status(http.StatusInternalServerError),
+				status(http.StatusInternalServerError),
+				status(http.StatusInternalServerError),
+				status(http.StatusInternalServerError),
+				serveRange,
+			},
+			wantErr:  true,
+			requests: 4,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h := tt.handlers[requests]
+				requests++
+				h(w, r)
+			}))
+			defer s.Close()
+
+			u, err := url.Parse(s.URL + "/file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := NewHTTPClient(s.Client())
+			c.RetryDelay = time.Millisecond
+
+			r, err := c.GetFile(u)
+			if err == nil {
+				var b []byte
+				b, err = ioutil.ReadAll(uio.Reader(r))
+				if got := string(b); err == nil && got != tt.want {
+					t.Errorf("GetFile() = %d bytes, want %d bytes", len(got), len(tt.want))
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetFile() = %v, want error %t", err, tt.wantErr)
+			}
+			if requests != tt.requests {
+				t.Errorf("server got %d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestHTTPClientOpenFile(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var ranges []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test") != "yes" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader(content))
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewHTTPClient(s.Client())
+	c.Header = http.Header{"X-Test": []string{"yes"}}
+	f := c.OpenFile(u)
+	if len(ranges) != 0 {
+		t.Errorf("OpenFile() requested %q before the file was read", ranges)
+	}
+
+	b, err := ioutil.ReadAll(io.NewSectionReader(f, 5000, 5000))
+	if err != nil || string(b) != content[5000:] {
+		t.Errorf("reading from 5000 = %d bytes, %v, want %d bytes", len(b), err, len(content)-5000)
+	}
+	if got := f.(interface{ Size() int64 }).Size(); got != int64(len(content)) {
+		t.Errorf("Size() = %d, want %d", got, len(content))
+	}
+	if want := []string{"bytes=5000-"}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("requested ranges %q, want %q", ranges, want)
+	}
+
+	c.Header = nil
+	if _, err := c.OpenFile(u).ReadAt(make([]byte, 1), 0); err == nil {
+		t.Errorf("ReadAt() without header succeeded, want error")
+	}
+}
+
 func TestParseURL(t *testing.T) {
 	for i, tt := range []struct {
 		url  string
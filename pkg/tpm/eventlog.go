@@ -0,0 +1,288 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Event types, from the TCG PC Client Platform Firmware Profile.
+const (
+	EvNoAction  = 0x03
+	EvSeparator = 0x04
+	EvIPL       = 0x0d
+)
+
+// specIDSignature starts the first event of crypto agile logs.
+var specIDSignature = []byte("Spec ID Event03\x00")
+
+// Event is an event of an event log: a measurement and what was measured.
+type Event struct {
+	PCR  uint32
+	Type uint32
+	// Digests has the digest of the measurement for every hash of the
+	// log.
+	Digests map[crypto.Hash][]byte
+	// Data describes what was measured.
+	Data []byte
+}
+
+// EventLog is a TCG event log, in the format of the TCG PC Client Platform
+// Firmware Profile.
+//
+// Logs of TPM 1.2 chips have SHA-1 digests only. Logs of TPM 2.0 chips are
+// in the crypto agile format, which starts with a Spec ID event listing the
+// hashes the events have digests of.
+type EventLog struct {
+	// Hashes are the hashes of the digests of events.
+	Hashes []crypto.Hash
+	Events []Event
+
+	agile bool
+	// specID is the data of the Spec ID event.
+	specID []byte
+}
+
+// NewEventLog returns an empty event log for the banks of t.
+func NewEventLog(t TPM) *EventLog {
+	l := &EventLog{
+		Hashes: t.Banks(),
+		agile:  t.Version() == 2,
+	}
+	if l.agile {
+		var b bytes.Buffer
+		b.Write(specIDSignature)
+		// Platform class, spec version 2.0 errata 0 and 64-bit UINTN.
+		binary.Write(&b, binary.LittleEndian, uint32(0))
+		b.Write([]byte{0, 2, 0, 2})
+		binary.Write(&b, binary.LittleEndian, uint32(len(l.Hashes)))
+		for _, h := range l.Hashes {
+			binary.Write(&b, binary.LittleEndian, []uint16{algIDs[h], uint16(h.Size())})
+		}
+		// No vendor info.
+		b.WriteByte(0)
+		l.specID = b.Bytes()
+	}
+	return l
+}
+
+// legacyEvent is the header of events in SHA-1 logs, and of the Spec ID
+// event.
+type legacyEvent struct {
+	PCR    uint32
+	Type   uint32
+	Digest [sha1.Size]byte
+	Size   uint32
+}
+
+// maxEventSize limits the data of events, so corrupt logs do not make us
+// allocate without bounds.
+const maxEventSize = 1 << 20
+
+func readData(r io.Reader, size uint32) ([]byte, error) {
+	if size > maxEventSize {
+		return nil, fmt.Errorf("event of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// parseSpecID returns the hashes of a Spec ID event.
+func parseSpecID(data []byte) ([]crypto.Hash, error) {
+	r := bytes.NewReader(data[len(specIDSignature):])
+	var head struct {
+		PlatformClass uint32
+		Minor, Major  uint8
+		Errata        uint8
+		UintnSize     uint8
+		NumAlgs       uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &head); err != nil {
+		return nil, err
+	}
+	var hashes []crypto.Hash
+	for i := uint32(0); i < head.NumAlgs; i++ {
+		var alg struct{ ID, Size uint16 }
+		if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+			return nil, err
+		}
+		h, ok := hashAlg(alg.ID)
+		if !ok || h.Size() != int(alg.Size) {
+			return nil, fmt.Errorf("unsupported hash algorithm %#x in event log", alg.ID)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// ParseEventLog parses a binary event log, such as the firmware's in
+// /sys/kernel/security/tpm0/binary_bios_measurements.
+func ParseEventLog(b []byte) (*EventLog, error) {
+	r := bytes.NewReader(b)
+	l := &EventLog{Hashes: []crypto.Hash{crypto.SHA1}}
+
+	var first legacyEvent
+	if err := binary.Read(r, binary.LittleEndian, &first); err != nil {
+		return nil, fmt.Errorf("invalid event log: %v", err)
+	}
+	data, err := readData(r, first.Size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event log: %v", err)
+	}
+	if first.Type == EvNoAction && bytes.HasPrefix(data, specIDSignature) {
+		l.agile, l.specID = true, data
+		if l.Hashes, err = parseSpecID(data); err != nil {
+			return nil, err
+		}
+	} else {
+		l.Events = append(l.Events, Event{
+			PCR:     first.PCR,
+			Type:    first.Type,
+			Digests: map[crypto.Hash][]byte{crypto.SHA1: first.Digest[:]},
+			Data:    data,
+		})
+	}
+
+	for r.Len() > 0 {
+		e, err := l.readEvent(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event %d in event log: %v", len(l.Events), err)
+		}
+		l.Events = append(l.Events, e)
+	}
+	return l, nil
+}
+
+func (l *EventLog) readEvent(r io.Reader) (Event, error) {
+	if !l.agile {
+		var le legacyEvent
+		if err := binary.Read(r, binary.LittleEndian, &le); err != nil {
+			return Event{}, err
+		}
+		data, err := readData(r, le.Size)
+		return Event{
+			PCR:     le.PCR,
+			Type:    le.Type,
+			Digests: map[crypto.Hash][]byte{crypto.SHA1: le.Digest[:]},
+			Data:    data,
+		}, err
+	}
+
+	var head struct{ PCR, Type, Count uint32 }
+	if err := binary.Read(r, binary.LittleEndian, &head); err != nil {
+		return Event{}, err
+	}
+	e := Event{PCR: head.PCR, Type: head.Type, Digests: make(map[crypto.Hash][]byte)}
+	for i := uint32(0); i < head.Count; i++ {
+		var id uint16
+		if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return Event{}, err
+		}
+		h, ok := hashAlg(id)
+		if !ok {
+			return Event{}, fmt.Errorf("unsupported hash algorithm %#x", id)
+		}
+		d := make([]byte, h.Size())
+		if _, err := io.ReadFull(r, d); err != nil {
+			return Event{}, err
+		}
+		e.Digests[h] = d
+	}
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return Event{}, err
+	}
+	var err error
+	e.Data, err = readData(r, size)
+	return e, err
+}
+
+// MarshalBinary returns the log in binary form.
+func (l *EventLog) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	if l.agile {
+		binary.Write(&b, binary.LittleEndian, legacyEvent{Type: EvNoAction, Size: uint32(len(l.specID))})
+		b.Write(l.specID)
+	}
+	for i, e := range l.Events {
+		if !l.agile {
+			le := legacyEvent{PCR: e.PCR, Type: e.Type, Size: uint32(len(e.Data))}
+			if len(e.Digests[crypto.SHA1]) != sha1.Size {
+				return nil, fmt.Errorf("event %d has no SHA-1 digest", i)
+			}
+			copy(le.Digest[:], e.Digests[crypto.SHA1])
+			binary.Write(&b, binary.LittleEndian, le)
+			b.Write(e.Data)
+			continue
+		}
+
+		binary.Write(&b, binary.LittleEndian, []uint32{e.PCR, e.Type, uint32(len(l.Hashes))})
+		for _, h := range l.Hashes {
+			d := e.Digests[h]
+			if len(d) != h.Size() {
+				return nil, fmt.Errorf("event %d has no %v digest", i, h)
+			}
+			binary.Write(&b, binary.LittleEndian, algIDs[h])
+			b.Write(d)
+		}
+		binary.Write(&b, binary.LittleEndian, uint32(len(e.Data)))
+		b.Write(e.Data)
+	}
+	return b.Bytes(), nil
+}
+
+// Replay returns the PCR values the events of l result in, by hash and PCR.
+// PCRs start out as zeroes.
+func (l *EventLog) Replay() map[crypto.Hash]map[uint32][]byte {
+	pcrs := make(map[crypto.Hash]map[uint32][]byte)
+	for _, h := range l.Hashes {
+		pcrs[h] = make(map[uint32][]byte)
+	}
+	for _, e := range l.Events {
+		if e.Type == EvNoAction {
+			continue
+		}
+		for _, h := range l.Hashes {
+			v, ok := pcrs[h][e.PCR]
+			if !ok {
+				v = make([]byte, h.Size())
+			}
+			hh := h.New()
+			hh.Write(v)
+			hh.Write(e.Digests[h])
+			pcrs[h][e.PCR] = hh.Sum(nil)
+		}
+	}
+	return pcrs
+}
+
+// errHashes is returned when a log does not have digests for the banks of
+// a TPM.
+var errHashes = errors.New("event log hashes do not match the PCR banks")
+
+// matches returns whether l has digests for exactly the banks of t, in the
+// format of t.
+func (l *EventLog) matches(t TPM) bool {
+	if l.agile != (t.Version() == 2) || len(l.Hashes) != len(t.Banks()) {
+		return false
+	}
+	for _, h := range t.Banks() {
+		found := false
+		for _, lh := range l.Hashes {
+			found = found || lh == h
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"io/ioutil"
+)
+
+// FirmwareEventLog is where Linux shows the event log of the firmware.
+const FirmwareEventLog = "/sys/kernel/security/tpm0/binary_bios_measurements"
+
+// Measurer extends measurements into the PCRs of a TPM and logs them.
+type Measurer struct {
+	TPM TPM
+	Log *EventLog
+}
+
+// NewMeasurer returns a measurer for t.
+//
+// The log continues the firmware's event log if it is readable and has
+// digests for the banks of t, so that verifiers can replay all PCRs from
+// the start. Otherwise, the log starts empty.
+func NewMeasurer(t TPM) *Measurer {
+	m := &Measurer{TPM: t}
+	if b, err := ioutil.ReadFile(FirmwareEventLog); err == nil {
+		if l, err := ParseEventLog(b); err == nil && l.matches(t) {
+			m.Log = l
+		}
+	}
+	if m.Log == nil {
+		m.Log = NewEventLog(t)
+	}
+	return m
+}
+
+// Measure extends pcr with the digests of content and logs an event of
+// type typ with the description data.
+func (m *Measurer) Measure(pcr uint32, typ uint32, data, content []byte) error {
+	if !m.Log.matches(m.TPM) {
+		return errHashes
+	}
+	digests := Digests(m.TPM, content)
+	if err := m.TPM.Extend(pcr, digests); err != nil {
+		return err
+	}
+	m.Log.Events = append(m.Log.Events, Event{
+		PCR:     pcr,
+		Type:    typ,
+		Digests: digests,
+		Data:    data,
+	})
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tpm measures into the PCRs of TPM 1.2 and 2.0 chips and keeps a
+// TCG event log of the measurements.
+//
+// TPM 1.2 chips have one bank of SHA-1 PCRs. TPM 2.0 chips can have several
+// banks, e.g. SHA-1 and SHA-256; measurements are extended into all of them.
+package tpm
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpmutil"
+
+	// Register the hashes of PCR banks.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// DefaultDevice is the TPM device Linux makes.
+const DefaultDevice = "/dev/tpm0"
+
+// TPM is a TPM 1.2 or 2.0 chip.
+type TPM interface {
+	// Version returns 1 for TPM 1.2 chips and 2 for TPM 2.0 chips.
+	Version() int
+
+	// Banks returns the hashes of the active PCR banks, in the order the
+	// TPM lists them. Banks of hashes Go does not have are left out.
+	Banks() []crypto.Hash
+
+	// Extend extends pcr with digests, which maps hashes to digests of
+	// the measured data. There must be a digest for every bank.
+	Extend(pcr uint32, digests map[crypto.Hash][]byte) error
+
+	// ReadPCR returns the value of pcr in the bank of hash h.
+	ReadPCR(pcr uint32, h crypto.Hash) ([]byte, error)
+
+	// Close closes the connection to the TPM.
+	Close() error
+}
+
+// ErrNoBank is returned for hashes the TPM has no PCR bank for.
+var ErrNoBank = errors.New("no PCR bank for hash")
+
+// Open opens the TPM at path, which is a TPM device or the Unix socket of a
+// TPM simulator such as swtpm.
+func Open(path string) (TPM, error) {
+	rwc, err := tpmutil.OpenTPM(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := New(rwc)
+	if err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// New returns the TPM rwc talks to. Whether it is TPM 1.2 or 2.0 is found
+// out by sending a TPM 2.0 command, which TPM 1.2 chips refuse.
+func New(rwc io.ReadWriteCloser) (TPM, error) {
+	banks, err := pcrBanks(rwc)
+	if err == errNotTPM2 {
+		return &tpm12{rwc}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tpm20{rwc: rwc, banks: banks}, nil
+}
+
+// Digests returns the digests of data for every bank of t.
+func Digests(t TPM, data []byte) map[crypto.Hash][]byte {
+	digests := make(map[crypto.Hash][]byte)
+	for _, h := range t.Banks() {
+		hh := h.New()
+		hh.Write(data)
+		digests[h] = hh.Sum(nil)
+	}
+	return digests
+}
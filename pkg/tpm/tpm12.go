@@ -0,0 +1,49 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"fmt"
+	"io"
+
+	tpm1 "github.com/google/go-tpm/tpm"
+)
+
+// tpm12 is a TPM 1.2 chip, with one SHA-1 PCR bank.
+type tpm12 struct {
+	rwc io.ReadWriteCloser
+}
+
+func (t *tpm12) Version() int {
+	return 1
+}
+
+func (t *tpm12) Banks() []crypto.Hash {
+	return []crypto.Hash{crypto.SHA1}
+}
+
+func (t *tpm12) Extend(pcr uint32, digests map[crypto.Hash][]byte) error {
+	d, ok := digests[crypto.SHA1]
+	if !ok || len(d) != sha1.Size {
+		return fmt.Errorf("TPM 1.2 needs a SHA-1 digest to extend PCR %d", pcr)
+	}
+	var v [sha1.Size]byte
+	copy(v[:], d)
+	_, err := tpm1.PcrExtend(t.rwc, pcr, v)
+	return err
+}
+
+func (t *tpm12) ReadPCR(pcr uint32, h crypto.Hash) ([]byte, error) {
+	if h != crypto.SHA1 {
+		return nil, ErrNoBank
+	}
+	return tpm1.ReadPCR(t.rwc, pcr)
+}
+
+func (t *tpm12) Close() error {
+	return t.rwc.Close()
+}
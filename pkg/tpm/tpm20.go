@@ -0,0 +1,255 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TPM 2.0 constants, from the TPM 2.0 Library specification, part 2.
+const (
+	tagNoSessions = 0x8001
+	tagSessions   = 0x8002
+	// tagRspCommand is the tag of TPM 1.2 responses.
+	tagRspCommand = 0x00c4
+
+	ccGetCapability = 0x017a
+	ccPCRRead       = 0x017e
+	ccPCRExtend     = 0x0182
+
+	capPCRs = 5
+
+	// rsPW is the handle of the password session, which is used with
+	// an empty password to extend PCRs.
+	rsPW = 0x40000009
+
+	// maxPCR is the number of PCRs every TPM 2.0 chip has.
+	maxPCR = 24
+)
+
+// algIDs are the TPM 2.0 algorithm IDs of hashes.
+var algIDs = map[crypto.Hash]uint16{
+	crypto.SHA1:   0x0004,
+	crypto.SHA256: 0x000b,
+	crypto.SHA384: 0x000c,
+	crypto.SHA512: 0x000d,
+}
+
+// hashAlg returns the hash of a TPM 2.0 algorithm ID.
+func hashAlg(id uint16) (crypto.Hash, bool) {
+	for h, i := range algIDs {
+		if i == id {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// errNotTPM2 is returned for commands a TPM 1.2 chip answered.
+var errNotTPM2 = errors.New("not a TPM 2.0")
+
+// ResponseError is returned when a TPM 2.0 command fails.
+type ResponseError struct {
+	Command uint32
+	Code    uint32
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("TPM command %#x failed with response code %#x", e.Command, e.Code)
+}
+
+// run sends the command cc with body to the TPM and returns the body of the
+// response.
+func run(rw io.ReadWriter, tag uint16, cc uint32, body []byte) ([]byte, error) {
+	cmd := make([]byte, 10, 10+len(body))
+	binary.BigEndian.PutUint16(cmd[0:], tag)
+	binary.BigEndian.PutUint32(cmd[2:], uint32(10+len(body)))
+	binary.BigEndian.PutUint32(cmd[6:], cc)
+	if _, err := rw.Write(append(cmd, body...)); err != nil {
+		return nil, err
+	}
+
+	// TPM devices return the whole response in one read; sockets may
+	// not.
+	resp := make([]byte, 4096)
+	n, err := rw.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	if n < 10 {
+		return nil, fmt.Errorf("TPM response of %d bytes is too short", n)
+	}
+	rtag := binary.BigEndian.Uint16(resp[0:])
+	size := int(binary.BigEndian.Uint32(resp[2:]))
+	code := binary.BigEndian.Uint32(resp[6:])
+	if rtag == tagRspCommand {
+		return nil, errNotTPM2
+	}
+	if rtag != tagNoSessions && rtag != tagSessions {
+		return nil, fmt.Errorf("TPM response has unknown tag %#x", rtag)
+	}
+	if size < 10 || size > len(resp) {
+		return nil, fmt.Errorf("TPM response has invalid size %d", size)
+	}
+	if n < size {
+		if _, err := io.ReadFull(rw, resp[n:size]); err != nil {
+			return nil, err
+		}
+	}
+	if code != 0 {
+		return nil, &ResponseError{Command: cc, Code: code}
+	}
+	return resp[10:size], nil
+}
+
+// pcrSelection reads a TPML_PCR_SELECTION and returns the hashes of the
+// banks with PCRs selected.
+func pcrSelection(r *bytes.Reader) ([]crypto.Hash, error) {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	var banks []crypto.Hash
+	for i := uint32(0); i < count; i++ {
+		var sel struct {
+			Alg  uint16
+			Size uint8
+		}
+		if err := binary.Read(r, binary.BigEndian, &sel); err != nil {
+			return nil, err
+		}
+		bits := make([]byte, sel.Size)
+		if _, err := io.ReadFull(r, bits); err != nil {
+			return nil, err
+		}
+		h, ok := hashAlg(sel.Alg)
+		if !ok || bytes.Count(bits, []byte{0}) == len(bits) {
+			continue
+		}
+		banks = append(banks, h)
+	}
+	return banks, nil
+}
+
+// pcrBanks returns the active PCR banks of the TPM 2.0 rw, or errNotTPM2.
+func pcrBanks(rw io.ReadWriter) ([]crypto.Hash, error) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, []uint32{capPCRs, 0, 1})
+	resp, err := run(rw, tagNoSessions, ccGetCapability, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(resp)
+	var head struct {
+		MoreData   uint8
+		Capability uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &head); err != nil {
+		return nil, err
+	}
+	if head.Capability != capPCRs {
+		return nil, fmt.Errorf("TPM returned capability %d, want %d", head.Capability, capPCRs)
+	}
+	banks, err := pcrSelection(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PCR banks: %v", err)
+	}
+	if len(banks) == 0 {
+		return nil, errors.New("TPM has no active PCR banks of known hashes")
+	}
+	return banks, nil
+}
+
+// tpm20 is a TPM 2.0 chip.
+type tpm20 struct {
+	rwc   io.ReadWriteCloser
+	banks []crypto.Hash
+}
+
+func (t *tpm20) Version() int {
+	return 2
+}
+
+func (t *tpm20) Banks() []crypto.Hash {
+	return t.banks
+}
+
+func (t *tpm20) Extend(pcr uint32, digests map[crypto.Hash][]byte) error {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, pcr)
+	// The authorization area: the password session with an empty
+	// password.
+	binary.Write(&body, binary.BigEndian, uint32(9))
+	binary.Write(&body, binary.BigEndian, uint32(rsPW))
+	body.Write([]byte{0, 0, 0, 0, 0})
+
+	binary.Write(&body, binary.BigEndian, uint32(len(t.banks)))
+	for _, h := range t.banks {
+		d, ok := digests[h]
+		if !ok || len(d) != h.Size() {
+			return fmt.Errorf("no %v digest to extend PCR %d", h, pcr)
+		}
+		binary.Write(&body, binary.BigEndian, algIDs[h])
+		body.Write(d)
+	}
+	_, err := run(t.rwc, tagSessions, ccPCRExtend, body.Bytes())
+	return err
+}
+
+func (t *tpm20) ReadPCR(pcr uint32, h crypto.Hash) ([]byte, error) {
+	id, ok := algIDs[h]
+	if !ok {
+		return nil, ErrNoBank
+	}
+	if pcr >= maxPCR {
+		return nil, fmt.Errorf("invalid PCR %d", pcr)
+	}
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, uint32(1))
+	binary.Write(&body, binary.BigEndian, id)
+	bits := make([]byte, maxPCR/8)
+	bits[pcr/8] |= 1 << (pcr % 8)
+	body.WriteByte(byte(len(bits)))
+	body.Write(bits)
+	resp, err := run(t.rwc, tagNoSessions, ccPCRRead, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(resp)
+	var updates uint32
+	if err := binary.Read(r, binary.BigEndian, &updates); err != nil {
+		return nil, err
+	}
+	if _, err := pcrSelection(r); err != nil {
+		return nil, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNoBank
+	}
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	d := make([]byte, size)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (t *tpm20) Close() error {
+	return t.rwc.Close()
+}
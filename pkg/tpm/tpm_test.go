@@ -0,0 +1,203 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/tpm/tpmtest"
+)
+
+// testMeasure measures a few things with t and checks that its PCRs are
+// what the event log says.
+func testMeasure(t *testing.T, tp TPM) {
+	m := &Measurer{TPM: tp, Log: NewEventLog(tp)}
+	for _, e := range []struct {
+		pcr           uint32
+		data, content string
+	}{
+		{8, "kernel_cmdline: console=ttyS0", "console=ttyS0"},
+		{9, "kernel", "a kernel"},
+		{9, "initrd", "an initrd"},
+	} {
+		if err := m.Measure(e.pcr, EvIPL, []byte(e.data), []byte(e.content)); err != nil {
+			t.Fatalf("Measure(%d, %q) = %v", e.pcr, e.data, err)
+		}
+	}
+
+	b, err := m.Log.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() = %v", err)
+	}
+	l, err := ParseEventLog(b)
+	if err != nil {
+		t.Fatalf("ParseEventLog() = %v", err)
+	}
+	if len(l.Events) != 3 || !reflect.DeepEqual(l.Hashes, tp.Banks()) {
+		t.Fatalf("parsed log has %d events of %v, want 3 of %v", len(l.Events), l.Hashes, tp.Banks())
+	}
+	if b2, _ := l.MarshalBinary(); !bytes.Equal(b, b2) {
+		t.Errorf("log changed when parsed and marshaled again")
+	}
+
+	for h, pcrs := range l.Replay() {
+		for pcr, want := range pcrs {
+			got, err := tp.ReadPCR(pcr, h)
+			if err != nil {
+				t.Fatalf("ReadPCR(%d, %v) = %v", pcr, h, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("PCR %d of bank %v is %x, replaying the log gives %x", pcr, h, got, want)
+			}
+		}
+	}
+}
+
+func TestTPM20(t *testing.T) {
+	sim := tpmtest.NewSimulator(crypto.SHA1, crypto.SHA256, crypto.SHA384)
+	tp, err := New(sim)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	if tp.Version() != 2 {
+		t.Errorf("Version() = %d, want 2", tp.Version())
+	}
+	if want := []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384}; !reflect.DeepEqual(tp.Banks(), want) {
+		t.Errorf("Banks() = %v, want %v", tp.Banks(), want)
+	}
+	testMeasure(t, tp)
+
+	if _, err := tp.ReadPCR(0, crypto.SHA512); err != ErrNoBank {
+		t.Errorf("ReadPCR() of a missing bank = %v, want %v", err, ErrNoBank)
+	}
+	if err := tp.Extend(9, map[crypto.Hash][]byte{crypto.SHA1: make([]byte, 20)}); err == nil {
+		t.Errorf("Extend() without all digests succeeded")
+	}
+}
+
+func TestTPM12(t *testing.T) {
+	tp, err := New(tpmtest.NewSimulator12())
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	if tp.Version() != 1 {
+		t.Errorf("Version() = %d, want 1", tp.Version())
+	}
+	testMeasure(t, tp)
+}
+
+// startSwtpm starts swtpm, as a TPM 2.0 if tpm2 is set, and returns its Unix
+// socket and a function that stops it. It skips the test if there is no
+// swtpm.
+func startSwtpm(t *testing.T, tpm2 bool) (string, func()) {
+	swtpm, err := exec.LookPath("swtpm")
+	if err != nil {
+		t.Skip("swtpm is not installed")
+	}
+	dir, err := ioutil.TempDir("", "swtpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "sock")
+	args := []string{"socket",
+		"--server", "type=unixio,path=" + path,
+		"--tpmstate", "dir=" + dir,
+		"--flags", "not-need-init,startup-clear",
+	}
+	if tpm2 {
+		args = append(args, "--tpm2")
+	}
+	cmd := exec.Command(swtpm, args...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return path, stop
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	stop()
+	t.Fatalf("swtpm did not make %s", path)
+	return "", nil
+}
+
+// testSwtpm measures with a swtpm of TPM version.
+func testSwtpm(t *testing.T, version int) {
+	path, stop := startSwtpm(t, version == 2)
+	defer stop()
+	tp, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(%q) = %v", path, err)
+	}
+	defer tp.Close()
+	if tp.Version() != version {
+		t.Errorf("Version() = %d, want %d", tp.Version(), version)
+	}
+	testMeasure(t, tp)
+}
+
+func TestSwtpm20(t *testing.T) {
+	testSwtpm(t, 2)
+}
+
+func TestSwtpm12(t *testing.T) {
+	testSwtpm(t, 1)
+}
+
+// TestTPMSocket runs against the TPM, or TPM simulator, whose Unix socket is
+// $TPM_SOCKET.
+func TestTPMSocket(t *testing.T) {
+	path := os.Getenv("TPM_SOCKET")
+	if path == "" {
+		t.Skip("no $TPM_SOCKET")
+	}
+	tp, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close()
+	testMeasure(t, tp)
+}
+
+func TestParseEventLog(t *testing.T) {
+	// A Spec ID event with an SM3 bank.
+	var sm3 bytes.Buffer
+	sm3.Write(specIDSignature)
+	binary.Write(&sm3, binary.LittleEndian, uint32(0))
+	sm3.Write([]byte{0, 2, 0, 2})
+	binary.Write(&sm3, binary.LittleEndian, []uint32{1})
+	binary.Write(&sm3, binary.LittleEndian, []uint16{0x12, 32})
+	sm3.WriteByte(0)
+	var unknownHash bytes.Buffer
+	binary.Write(&unknownHash, binary.LittleEndian, legacyEvent{Type: EvNoAction, Size: uint32(sm3.Len())})
+	unknownHash.Write(sm3.Bytes())
+
+	for _, b := range [][]byte{
+		nil,
+		[]byte("short"),
+		unknownHash.Bytes(),
+	} {
+		if _, err := ParseEventLog(b); err == nil {
+			t.Errorf("ParseEventLog(%q) succeeded, want error", b)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tpmtest has a fake TPM for tests of code that measures with package
+// tpm.
+package tpmtest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	// Register the hashes of PCR banks.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// TPM 1.2 and 2.0 constants the simulator needs.
+const (
+	tagRquCommand = 0x00c1
+	tagRspCommand = 0x00c4
+	ordExtend     = 0x14
+	ordPCRRead    = 0x15
+	rcBadTag      = 0x1e
+	rcFailure     = 0x101
+
+	tagNoSessions   = 0x8001
+	tagSessions     = 0x8002
+	ccGetCapability = 0x017a
+	ccPCRRead       = 0x017e
+	ccPCRExtend     = 0x0182
+	capPCRs         = 5
+
+	maxPCR = 24
+)
+
+// algIDs are the TPM 2.0 algorithm IDs of hashes.
+var algIDs = map[crypto.Hash]uint16{
+	crypto.SHA1:   0x0004,
+	crypto.SHA256: 0x000b,
+	crypto.SHA384: 0x000c,
+	crypto.SHA512: 0x000d,
+}
+
+// hashAlg returns the hash of a TPM 2.0 algorithm ID.
+func hashAlg(id uint16) (crypto.Hash, bool) {
+	for h, i := range algIDs {
+		if i == id {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// Simulator is a software TPM with PCRs and nothing else. It answers the
+// TPM 1.2 or 2.0 commands package tpm sends, and is what tpm.New takes.
+//
+// It is written from the same reading of the specifications as package tpm,
+// so package tpm is also tested against swtpm, a full TPM simulator.
+type Simulator struct {
+	// PCRs holds the PCRs of every bank.
+	PCRs map[crypto.Hash][][]byte
+
+	tpm12 bool
+	resp  []byte
+}
+
+// NewSimulator returns a TPM 2.0 simulator with PCR banks of hashes.
+func NewSimulator(hashes ...crypto.Hash) *Simulator {
+	s := &Simulator{PCRs: make(map[crypto.Hash][][]byte)}
+	for _, h := range hashes {
+		pcrs := make([][]byte, maxPCR)
+		for i := range pcrs {
+			pcrs[i] = make([]byte, h.Size())
+		}
+		s.PCRs[h] = pcrs
+	}
+	return s
+}
+
+// NewSimulator12 returns a TPM 1.2 simulator.
+func NewSimulator12() *Simulator {
+	s := NewSimulator(crypto.SHA1)
+	s.tpm12 = true
+	return s
+}
+
+func (s *Simulator) extend(h crypto.Hash, pcr uint32, d []byte) {
+	hh := h.New()
+	hh.Write(s.PCRs[h][pcr])
+	hh.Write(d)
+	s.PCRs[h][pcr] = hh.Sum(nil)
+}
+
+func (s *Simulator) respond(tag uint16, code uint32, body []byte) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, tag)
+	binary.Write(&b, binary.BigEndian, uint32(10+len(body)))
+	binary.Write(&b, binary.BigEndian, code)
+	b.Write(body)
+	s.resp = b.Bytes()
+}
+
+// Write implements io.Writer. It runs the command in p.
+func (s *Simulator) Write(p []byte) (int, error) {
+	if len(p) < 10 || int(binary.BigEndian.Uint32(p[2:])) != len(p) {
+		return 0, errors.New("invalid TPM command")
+	}
+	tag := binary.BigEndian.Uint16(p)
+	cc := binary.BigEndian.Uint32(p[6:])
+	body := p[10:]
+
+	if s.tpm12 {
+		s.run12(tag, cc, body)
+	} else {
+		s.run20(tag, cc, body)
+	}
+	return len(p), nil
+}
+
+func (s *Simulator) run12(tag uint16, ord uint32, body []byte) {
+	if tag != tagRquCommand {
+		s.respond(tagRspCommand, rcBadTag, nil)
+		return
+	}
+	if len(body) < 4 {
+		s.respond(tagRspCommand, rcFailure, nil)
+		return
+	}
+	pcr := binary.BigEndian.Uint32(body)
+	if pcr >= maxPCR {
+		s.respond(tagRspCommand, rcFailure, nil)
+		return
+	}
+	switch {
+	case ord == ordExtend && len(body) == 4+sha1.Size:
+		s.extend(crypto.SHA1, pcr, body[4:])
+		s.respond(tagRspCommand, 0, s.PCRs[crypto.SHA1][pcr])
+	case ord == ordPCRRead:
+		s.respond(tagRspCommand, 0, s.PCRs[crypto.SHA1][pcr])
+	default:
+		s.respond(tagRspCommand, rcFailure, nil)
+	}
+}
+
+// pcrSelect returns a TPML_PCR_SELECTION of bits in every bank of s.
+func (s *Simulator) pcrSelect(b *bytes.Buffer, bits []byte) {
+	var hashes []crypto.Hash
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if _, ok := s.PCRs[h]; ok {
+			hashes = append(hashes, h)
+		}
+	}
+	binary.Write(b, binary.BigEndian, uint32(len(hashes)))
+	for _, h := range hashes {
+		binary.Write(b, binary.BigEndian, algIDs[h])
+		b.WriteByte(byte(len(bits)))
+		b.Write(bits)
+	}
+}
+
+func (s *Simulator) run20(tag uint16, cc uint32, body []byte) {
+	fail := func() { s.respond(tagNoSessions, rcFailure, nil) }
+	r := bytes.NewReader(body)
+
+	switch cc {
+	case ccGetCapability:
+		var in [3]uint32
+		if binary.Read(r, binary.BigEndian, &in) != nil || in[0] != capPCRs {
+			fail()
+			return
+		}
+		var b bytes.Buffer
+		b.WriteByte(0)
+		binary.Write(&b, binary.BigEndian, uint32(capPCRs))
+		s.pcrSelect(&b, []byte{0xff, 0xff, 0xff})
+		s.respond(tagNoSessions, 0, b.Bytes())
+
+	case ccPCRExtend:
+		var in struct {
+			PCR      uint32
+			AuthSize uint32
+		}
+		if tag != tagSessions || binary.Read(r, binary.BigEndian, &in) != nil || in.PCR >= maxPCR {
+			fail()
+			return
+		}
+		r.Seek(int64(in.AuthSize), io.SeekCurrent)
+		var count uint32
+		if binary.Read(r, binary.BigEndian, &count) != nil {
+			fail()
+			return
+		}
+		for i := uint32(0); i < count; i++ {
+			var id uint16
+			if binary.Read(r, binary.BigEndian, &id) != nil {
+				fail()
+				return
+			}
+			h, ok := hashAlg(id)
+			if !ok {
+				fail()
+				return
+			}
+			d := make([]byte, h.Size())
+			if _, err := io.ReadFull(r, d); err != nil {
+				fail()
+				return
+			}
+			if _, ok := s.PCRs[h]; ok {
+				s.extend(h, in.PCR, d)
+			}
+		}
+		// The parameter size and an empty password session.
+		s.respond(tagSessions, 0, []byte{0, 0, 0, 0, 0, 0, 1, 0, 0})
+
+	case ccPCRRead:
+		var in struct {
+			Count uint32
+			Alg   uint16
+			Size  uint8
+		}
+		if binary.Read(r, binary.BigEndian, &in) != nil || in.Count != 1 {
+			fail()
+			return
+		}
+		bits := make([]byte, in.Size)
+		r.Read(bits)
+		var b bytes.Buffer
+		binary.Write(&b, binary.BigEndian, uint32(0))
+		h, _ := hashAlg(in.Alg)
+		pcrs, ok := s.PCRs[h]
+		var values [][]byte
+		for pcr := 0; ok && pcr < maxPCR && pcr/8 < len(bits); pcr++ {
+			if bits[pcr/8]&(1<<uint(pcr%8)) != 0 {
+				values = append(values, pcrs[pcr])
+			}
+		}
+		binary.Write(&b, binary.BigEndian, uint32(1))
+		binary.Write(&b, binary.BigEndian, in.Alg)
+		b.WriteByte(in.Size)
+		b.Write(bits)
+		binary.Write(&b, binary.BigEndian, uint32(len(values)))
+		for _, v := range values {
+			binary.Write(&b, binary.BigEndian, uint16(len(v)))
+			b.Write(v)
+		}
+		s.respond(tagNoSessions, 0, b.Bytes())
+
+	default:
+		fail()
+	}
+}
+
+// Read implements io.Reader. It returns the response to the last command.
+func (s *Simulator) Read(p []byte) (int, error) {
+	if s.resp == nil {
+		return 0, errors.New("no TPM command to respond to")
+	}
+	n := copy(p, s.resp)
+	s.resp = s.resp[n:]
+	if len(s.resp) == 0 {
+		s.resp = nil
+	}
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (s *Simulator) Close() error {
+	return nil
+}
@@ -34,14 +34,12 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 
 	flag "github.com/spf13/pflag"
 
+	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/gpt"
-	"github.com/u-root/u-root/pkg/kexec"
-	"github.com/u-root/u-root/pkg/multiboot"
 )
 
 var cfg = flag.StringP("config", "c", "", "Set the ESXi config")
@@ -150,6 +148,31 @@ func parse(fname string) (options, error) {
 	return opt, err
 }
 
+// image returns the multiboot image described by the options.
+func (o *options) image() (*boot.MultibootImage, error) {
+	kernel, err := os.Open(o.kernel)
+	if err != nil {
+		return nil, err
+	}
+	img := &boot.MultibootImage{
+		Kernel:  kernel,
+		Cmdline: o.args,
+	}
+	for _, mod := range o.modules {
+		tokens := strings.SplitN(mod, " ", 2)
+		f, err := os.Open(tokens[0])
+		if err != nil {
+			return nil, err
+		}
+		m := boot.MultibootModule{Name: tokens[0], Content: f}
+		if len(tokens) == 2 {
+			m.Cmdline = strings.TrimSpace(tokens[1])
+		}
+		img.Modules = append(img.Modules, m)
+	}
+	return img, nil
+}
+
 func main() {
 	flag.Parse()
 	if *cfg == "" {
@@ -167,24 +190,11 @@ func main() {
 		}
 	}
 
-	p, err := os.Executable()
-	if err != nil {
-		log.Fatalf("Cannot find current executable path: %v", err)
-	}
-	trampoline, err := filepath.EvalSymlinks(p)
+	img, err := opts.image()
 	if err != nil {
-		log.Fatalf("Cannot eval symlinks for %v: %v", p, err)
-	}
-
-	m := multiboot.New(opts.kernel, opts.args, trampoline, opts.modules)
-	if err := m.Load(false); err != nil {
-		log.Fatalf("Load failed: %v", err)
-	}
-
-	if err := kexec.Load(m.EntryPoint, m.Segments(), 0); err != nil {
-		log.Fatalf("kexec.Load() error: %v", err)
+		log.Fatalf("Cannot open ESXi image: %v", err)
 	}
-	if err := kexec.Reboot(); err != nil {
-		log.Fatalf("kexec.Reboot() error: %v", err)
+	if err := img.Execute(); err != nil {
+		log.Fatalf("Cannot boot ESXi: %v", err)
 	}
 }